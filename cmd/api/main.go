@@ -1,15 +1,27 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"log"
+	"net"
+	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
+	_ "time/tzdata" // Website time zones without a system zoneinfo database
 
 	"github.com/gin-gonic/gin"
 	"github.com/joho/godotenv"
 	"aq3stat/internal/api"
+	"aq3stat/internal/service"
 	"aq3stat/migrations"
+	"aq3stat/pkg/cache"
 	"aq3stat/pkg/database"
+	"aq3stat/pkg/live"
 	"aq3stat/pkg/logger"
+	"aq3stat/pkg/sink"
 )
 
 func main() {
@@ -28,6 +40,24 @@ func main() {
 	// Run migrations
 	migrations.Migrate()
 
+	// Initialize stats cache
+	cache.Init()
+
+	// Initialize hit sinks
+	sink.InitSinks()
+
+	// Stream hits to live dashboards
+	live.Start()
+
+	// Keep the stat rollups up to date
+	service.NewRollupService().StartRollupWorker()
+
+	// Purge raw hits according to the retention policies
+	service.NewDataRetentionService().StartRetentionWorker()
+
+	// Detect traffic anomalies and notify them
+	service.NewAnomalyService().StartAnomalyWorker()
+
 	// Set Gin mode
 	if os.Getenv("ENV") == "production" {
 		gin.SetMode(gin.ReleaseMode)
@@ -48,9 +78,42 @@ func main() {
 	}
 
 	// Start server
-	log.Printf("Server starting on port %s", port)
-	err = router.Run(":" + port)
-	if err != nil {
-		log.Fatalf("Failed to start server: %v", err)
+	// Requests are canceled on shutdown so that live streams end instead of holding it up
+	baseCtx, cancelRequests := context.WithCancel(context.Background())
+	server := &http.Server{
+		Addr:        ":" + port,
+		Handler:     router,
+		BaseContext: func(net.Listener) context.Context { return baseCtx },
+	}
+	server.RegisterOnShutdown(cancelRequests)
+	go func() {
+		log.Printf("Server starting on port %s", port)
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatalf("Failed to start server: %v", err)
+		}
+	}()
+
+	// Shut down gracefully on SIGINT and SIGTERM, then deliver the hits queued for the sinks
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+	<-quit
+	log.Println("Shutting down server")
+
+	ctx, cancel := context.WithTimeout(context.Background(), getShutdownTimeout())
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		log.Printf("Failed to shut down server gracefully: %v", err)
+	}
+
+	sink.CloseSinks()
+	log.Println("Server stopped")
+}
+
+// getShutdownTimeout returns how long requests in flight are waited for on shutdown
+func getShutdownTimeout() time.Duration {
+	timeout, err := time.ParseDuration(os.Getenv("SHUTDOWN_TIMEOUT"))
+	if err != nil || timeout <= 0 {
+		return 30 * time.Second
 	}
+	return timeout
 }
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"time"
+	_ "time/tzdata" // Website time zones without a system zoneinfo database
+
+	"github.com/joho/godotenv"
+	"aq3stat/internal/service"
+	"aq3stat/pkg/database"
+	gormlogger "gorm.io/gorm/logger"
+)
+
+// export writes raw hits or a report of a website as CSV, NDJSON or Parquet, e.g.
+//
+//	export -website 1 -from 2024-01-01 -to 2024-01-31 -format parquet -out stats.parquet
+//	export -website 1 -report trend -columns date,pv
+func main() {
+	websiteID := flag.Int("website", 0, "ID of the website to export")
+	report := flag.String("report", "stats", "stats for raw hits, or trend, referers, devices, engagement or goals")
+	format := flag.String("format", "csv", "Output format: csv, ndjson or parquet")
+	columns := flag.String("columns", "", "Comma separated columns, all columns if empty")
+	from := flag.String("from", "", "First day (YYYY-MM-DD), defaults to 7 days ago")
+	to := flag.String("to", "", "Last day (YYYY-MM-DD), defaults to today")
+	out := flag.String("out", "", "Output file, standard output if empty")
+	flag.Parse()
+
+	if *websiteID == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	// Load environment variables
+	err := godotenv.Load("./configs/.env")
+	if err != nil {
+		log.Println("Error loading .env file, using environment variables")
+	}
+
+	// Initialize database, SQL logs would end up in the exported data
+	database.InitDB()
+	database.DB.Logger = gormlogger.Default.LogMode(gormlogger.Silent)
+
+	// Days are those of the website's time zone
+	website, err := service.NewWebsiteService().GetWebsiteByID(*websiteID)
+	if err != nil {
+		log.Fatalf("Website %d not found", *websiteID)
+	}
+	today := website.StartOfDay(website.Now())
+	fromTime := parseDay(*from, today.AddDate(0, 0, -6), website.Location())
+	toTime := parseDay(*to, today, website.Location()).AddDate(0, 0, 1)
+
+	req := &service.ExportRequest{
+		WebsiteID: *websiteID,
+		Report:    *report,
+		Format:    *format,
+		From:      fromTime,
+		To:        toTime,
+	}
+	if *columns != "" {
+		req.Columns = strings.Split(*columns, ",")
+	}
+
+	exportService := service.NewExportService()
+	if err := exportService.ValidateExport(req); err != nil {
+		log.Fatal(err)
+	}
+
+	var w io.Writer = os.Stdout
+	if *out != "" {
+		file, err := os.Create(*out)
+		if err != nil {
+			log.Fatalf("Failed to create output file: %v", err)
+		}
+		defer file.Close()
+		w = file
+	}
+
+	rows, err := exportService.WriteExport(req, w)
+	if err != nil {
+		log.Fatalf("Export failed: %v", err)
+	}
+	log.Printf("Exported %d rows", rows)
+}
+
+// parseDay parses a YYYY-MM-DD date in loc
+func parseDay(value string, defaultValue time.Time, loc *time.Location) time.Time {
+	if value == "" {
+		return defaultValue
+	}
+
+	day, err := time.ParseInLocation("2006-01-02", value, loc)
+	if err != nil {
+		log.Fatalf("Invalid date %q, expected YYYY-MM-DD", value)
+	}
+	return day
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	_ "time/tzdata" // Website time zones without a system zoneinfo database
+
+	"github.com/joho/godotenv"
+	"aq3stat/internal/model"
+	"aq3stat/internal/service"
+	"aq3stat/migrations"
+	"aq3stat/pkg/cache"
+	"aq3stat/pkg/database"
+	"aq3stat/pkg/logger"
+	gormlogger "gorm.io/gorm/logger"
+)
+
+// logimport imports a web server access log into a website, e.g.
+//
+//	logimport -website 1 -file /var/log/nginx/access.log.1.gz
+//	logimport -website 1 -file access.log -format '$remote_addr [$time_local] "$request" $status "$http_user_agent"'
+func main() {
+	websiteID := flag.Int("website", 0, "ID of the website to import into")
+	fileName := flag.String("file", "", "Access log file, plain or gzip compressed")
+	format := flag.String("format", "combined", "Log format: combined, common, vhost or a custom nginx log_format string")
+	flag.Parse()
+
+	if *websiteID == 0 || *fileName == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	// Load environment variables
+	err := godotenv.Load("./configs/.env")
+	if err != nil {
+		log.Println("Error loading .env file, using environment variables")
+	}
+
+	// Initialize logger
+	logger.InitLogger()
+
+	// Initialize database, without logging every statement of the import
+	database.InitDB()
+	database.DB.Logger = gormlogger.Default.LogMode(gormlogger.Silent)
+
+	// Run migrations
+	migrations.Migrate()
+
+	// Invalidate cached stats of the website, shared with the API when cached in Redis
+	cache.Init()
+
+	file, err := os.Open(*fileName)
+	if err != nil {
+		log.Fatalf("Failed to open log file: %v", err)
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		log.Fatalf("Failed to read log file: %v", err)
+	}
+
+	importService := service.NewImportService()
+	job, err := importService.CreateAccessLogImport(*websiteID, 0, *format, filepath.Base(*fileName), info.Size())
+	if err != nil {
+		log.Fatalf("Failed to create import job: %v", err)
+	}
+
+	log.Printf("Import job %d started", job.ID)
+	err = importService.RunAccessLogImport(job, file, func(job *model.ImportJob) {
+		fmt.Fprintf(os.Stderr, "\r%5.1f%% %d lines, %d imported, %d duplicates, %d filtered, %d failed",
+			job.Progress(), job.Lines, job.Imported, job.Duplicates, job.Filtered, job.Failed)
+	})
+	fmt.Fprintln(os.Stderr)
+
+	if err != nil {
+		log.Fatalf("Import failed: %v", err)
+	}
+	log.Printf("Import job %d finished", job.ID)
+}
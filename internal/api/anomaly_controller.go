@@ -0,0 +1,224 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"aq3stat/internal/model"
+	"aq3stat/internal/service"
+)
+
+// AnomalyController handles traffic anomaly related API endpoints
+type AnomalyController struct {
+	websiteService *service.WebsiteService
+	anomalyService *service.AnomalyService
+}
+
+// NewAnomalyController creates a new anomaly controller
+func NewAnomalyController() *AnomalyController {
+	return &AnomalyController{
+		websiteService: service.NewWebsiteService(),
+		anomalyService: service.NewAnomalyService(),
+	}
+}
+
+// AnomalyChannelRequest represents a create or update anomaly channel request
+type AnomalyChannelRequest struct {
+	Type      string  `json:"type" binding:"required"`
+	Target    string  `json:"target" binding:"required"`
+	Secret    *string `json:"secret"`     // Kept on update when omitted
+	IsEnabled *bool   `json:"is_enabled"` // Enabled on create when omitted, kept on update
+}
+
+// ListAnomalies lists the anomalies of a website with pagination, newest first,
+// e.g. /api/websites/1/anomalies?from=2024-01-01&to=2024-01-31&type=DROP&page=1&page_size=20
+func (c *AnomalyController) ListAnomalies(ctx *gin.Context) {
+	website := loadWebsite(ctx, c.websiteService, false)
+	if website == nil {
+		return
+	}
+
+	from, to, err := parseDateRange(ctx, website.Location())
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	anomalyType := ctx.Query("type")
+	switch anomalyType {
+	case "", model.AnomalyTypeSpike, model.AnomalyTypeDrop, model.AnomalyTypeZeroTraffic:
+	default:
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "type must be SPIKE, DROP or ZERO_TRAFFIC"})
+		return
+	}
+
+	page, err := strconv.Atoi(ctx.DefaultQuery("page", "1"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+
+	pageSize, err := strconv.Atoi(ctx.DefaultQuery("page_size", "20"))
+	if err != nil || pageSize < 1 || pageSize > 100 {
+		pageSize = 20
+	}
+
+	anomalies, err := c.anomalyService.ListAnomalies(website.ID, from, to, anomalyType, page, pageSize)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list anomalies"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, anomalies)
+}
+
+// ListChannels lists the anomaly notification channels of a website
+func (c *AnomalyController) ListChannels(ctx *gin.Context) {
+	website := loadWebsite(ctx, c.websiteService, true)
+	if website == nil {
+		return
+	}
+
+	channels, err := c.anomalyService.ListChannels(website.ID)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list anomaly channels"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, channels)
+}
+
+// CreateChannel creates an anomaly notification channel for a website
+func (c *AnomalyController) CreateChannel(ctx *gin.Context) {
+	website := loadWebsite(ctx, c.websiteService, true)
+	if website == nil {
+		return
+	}
+
+	var req AnomalyChannelRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	channel := &model.AnomalyChannel{
+		WebsiteID: website.ID,
+		Type:      req.Type,
+		Target:    req.Target,
+		IsEnabled: true,
+	}
+	if req.Secret != nil {
+		channel.Secret = *req.Secret
+	}
+
+	err := c.anomalyService.CreateChannel(channel)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Disabled channels are saved after creation, the column defaults to enabled
+	if req.IsEnabled != nil && !*req.IsEnabled {
+		channel.IsEnabled = false
+		if err := c.anomalyService.UpdateChannel(channel); err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to disable anomaly channel"})
+			return
+		}
+	}
+
+	ctx.JSON(http.StatusCreated, channel)
+}
+
+// UpdateChannel updates an anomaly notification channel of a website
+func (c *AnomalyController) UpdateChannel(ctx *gin.Context) {
+	website := loadWebsite(ctx, c.websiteService, true)
+	if website == nil {
+		return
+	}
+
+	channel := c.loadChannel(ctx, website)
+	if channel == nil {
+		return
+	}
+
+	var req AnomalyChannelRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	channel.Type = req.Type
+	channel.Target = req.Target
+	if req.Secret != nil {
+		channel.Secret = *req.Secret
+	}
+	if req.IsEnabled != nil {
+		channel.IsEnabled = *req.IsEnabled
+	}
+
+	err := c.anomalyService.UpdateChannel(channel)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, channel)
+}
+
+// DeleteChannel deletes an anomaly notification channel of a website
+func (c *AnomalyController) DeleteChannel(ctx *gin.Context) {
+	website := loadWebsite(ctx, c.websiteService, true)
+	if website == nil {
+		return
+	}
+
+	channel := c.loadChannel(ctx, website)
+	if channel == nil {
+		return
+	}
+
+	err := c.anomalyService.DeleteChannel(channel.ID)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete anomaly channel"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"message": "Anomaly channel deleted successfully"})
+}
+
+// TestChannel sends a test notification on an anomaly notification channel of a website
+func (c *AnomalyController) TestChannel(ctx *gin.Context) {
+	website := loadWebsite(ctx, c.websiteService, true)
+	if website == nil {
+		return
+	}
+
+	channel := c.loadChannel(ctx, website)
+	if channel == nil {
+		return
+	}
+
+	if err := c.anomalyService.TestChannel(website, channel); err != nil {
+		ctx.JSON(http.StatusBadGateway, gin.H{"error": "Failed to send test notification, check the channel target"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"message": "Test notification sent successfully"})
+}
+
+// loadChannel loads the anomaly channel of the :channelId path parameter and checks that it belongs to the website
+func (c *AnomalyController) loadChannel(ctx *gin.Context, website *model.Website) *model.AnomalyChannel {
+	channelID, err := strconv.Atoi(ctx.Param("channelId"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid anomaly channel ID"})
+		return nil
+	}
+
+	channel, err := c.anomalyService.GetChannelByID(channelID)
+	if err != nil || channel.WebsiteID != website.ID {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "Anomaly channel not found"})
+		return nil
+	}
+
+	return channel
+}
@@ -0,0 +1,176 @@
+// aq3stat tracker. The server minifies this file and calls it with the website configuration:
+// {id, base_url, icon, color, counters}
+(function(config) {
+  var aq3stat_base_url = config.base_url;
+  var aq3stat_id = config.id;
+
+  // Function to create elements (works for both sync and async)
+  function aq3stat_createElement(tag, attributes, text) {
+    var element = document.createElement(tag);
+    if (attributes) {
+      for (var attr in attributes) {
+        element.setAttribute(attr, attributes[attr]);
+      }
+    }
+    if (text) {
+      element.appendChild(document.createTextNode(text));
+    }
+    return element;
+  }
+
+  // Function to append element to document
+  function aq3stat_appendElement(element) {
+    // Try to find the script tag that loaded this code
+    var scripts = document.getElementsByTagName('script');
+    var currentScript = document.currentScript || scripts[scripts.length - 1];
+
+    // If we can find the current script, insert after it
+    if (currentScript && currentScript.parentNode) {
+      currentScript.parentNode.insertBefore(element, currentScript.nextSibling);
+    } else {
+      // Fallback: append to body or head
+      var target = document.body || document.head || document.documentElement;
+      if (target) {
+        target.appendChild(element);
+      }
+    }
+  }
+
+  // Link to the public stats page
+  function aq3stat_statsLink() {
+    return aq3stat_createElement('a', {
+      'href': aq3stat_base_url + '/stats/' + aq3stat_id,
+      'target': '_blank',
+      'title': 'aq3stat统计'
+    });
+  }
+
+  // Add icon display based on icon type
+  var icon = config.icon;
+  if (icon == 'no') {
+    aq3stat_appendElement(document.createTextNode('统计中'));
+  } else if (icon == 'text' || icon == 'mark') {
+    var statsLink = aq3stat_statsLink();
+    statsLink.appendChild(aq3stat_createElement('font', {'color': config.color}, '网站统计'));
+
+    var container = aq3stat_createElement('span');
+    container.appendChild(statsLink);
+
+    // Counters are only sent for public websites
+    var counters = config.counters;
+    if (icon == 'text' && counters) {
+      container.appendChild(aq3stat_createElement('font', {'color': config.color},
+        ' | 今日IP[' + counters.today_ip + '] | 今日PV[' + counters.today_pv +
+        '] | 昨日IP[' + counters.yesterday_ip + '] | 昨日PV[' + counters.yesterday_pv +
+        '] | 当前在线[' + counters.online + ']'));
+    }
+    aq3stat_appendElement(container);
+  } else if (icon == 'badge') {
+    var badgeLink = aq3stat_statsLink();
+    badgeLink.appendChild(aq3stat_createElement('img', {
+      'src': aq3stat_base_url + '/badge/' + aq3stat_id + '.svg',
+      'style': 'border-width:0'
+    }));
+    aq3stat_appendElement(badgeLink);
+  } else if (icon) {
+    var iconLink = aq3stat_statsLink();
+    iconLink.appendChild(aq3stat_createElement('img', {
+      'src': aq3stat_base_url + '/static/icons/' + icon + '.gif',
+      'style': 'border-width:0'
+    }));
+    aq3stat_appendElement(iconLink);
+  }
+
+  // Page view ID shared by the hit and its unload ping
+  var aq3stat_pvid = (new Date()).getTime().toString(36) + Math.random().toString(36).substring(2, 10);
+
+  // Create tracking iframe
+  var aq3stat_url = aq3stat_base_url + '/collect?id=' + aq3stat_id;
+  aq3stat_url += '&pvid=' + aq3stat_pvid;
+  aq3stat_url += '&referer=' + encodeURIComponent(document.referrer);
+  aq3stat_url += '&location=' + encodeURIComponent(document.location);
+  aq3stat_url += '&color=' + screen.colorDepth;
+  aq3stat_url += '&width=' + screen.width;
+  aq3stat_url += '&height=' + screen.height;
+  if (typeof(navigator.systemLanguage) != 'undefined') aq3stat_url += '&lang=' + encodeURIComponent(navigator.systemLanguage);
+
+  aq3stat_appendElement(aq3stat_createElement('iframe', {
+    'src': aq3stat_url,
+    'width': '0',
+    'height': '0',
+    'marginwidth': '0',
+    'marginheight': '0',
+    'hspace': '0',
+    'vspace': '0',
+    'frameborder': '0',
+    'scrolling': 'no',
+    'style': 'display:none;'
+  }));
+
+  // Track the maximum scroll depth reached (in percent of the page height)
+  var aq3stat_max_depth = 0;
+  function aq3stat_scroll() {
+    var doc = document.documentElement;
+    var body = document.body || doc;
+    var scrollTop = window.pageYOffset || doc.scrollTop || body.scrollTop || 0;
+    var viewport = window.innerHeight || doc.clientHeight;
+    var height = Math.max(body.scrollHeight, doc.scrollHeight, body.offsetHeight, doc.offsetHeight);
+    var depth = height > 0 ? Math.min(100, Math.round((scrollTop + viewport) * 100 / height)) : 100;
+    if (depth > aq3stat_max_depth) aq3stat_max_depth = depth;
+  }
+
+  // Send the scroll depth when the page is hidden or unloaded
+  function aq3stat_ping() {
+    aq3stat_scroll();
+    var ping_url = aq3stat_base_url + '/collect/ping?id=' + aq3stat_id + '&pvid=' + aq3stat_pvid + '&depth=' + aq3stat_max_depth;
+    if (navigator.sendBeacon) {
+      navigator.sendBeacon(ping_url);
+    } else {
+      (new Image()).src = ping_url;
+    }
+  }
+
+  // Send a custom event, e.g. _aq3q.push(['event', 'signup', 10])
+  function aq3stat_event(name, value) {
+    var event_url = aq3stat_base_url + '/collect/event?id=' + aq3stat_id + '&pvid=' + aq3stat_pvid + '&name=' + encodeURIComponent(name);
+    if (value) event_url += '&value=' + encodeURIComponent(value);
+    (new Image()).src = event_url;
+  }
+
+  // Send a purchase, e.g. _aq3q.push(['purchase', {order_id: 'A1001', total: 99.5, currency: 'CNY', items: [...]}])
+  function aq3stat_purchase(order) {
+    if (!order || typeof(JSON) == 'undefined') return;
+    var purchase_url = aq3stat_base_url + '/collect/purchase?id=' + aq3stat_id + '&pvid=' + aq3stat_pvid;
+    var body = JSON.stringify(order);
+    if (navigator.sendBeacon) {
+      navigator.sendBeacon(purchase_url, body);
+    } else {
+      var xhr = new XMLHttpRequest();
+      xhr.open('POST', purchase_url, true);
+      xhr.setRequestHeader('Content-Type', 'text/plain');
+      xhr.send(body);
+    }
+  }
+
+  // Replay the commands queued before the script was loaded and handle new ones directly
+  var aq3stat_queue = window._aq3q || [];
+  window._aq3q = {
+    push: function(command) {
+      if (!command) return;
+      if (command[0] == 'event') aq3stat_event(command[1], command[2]);
+      if (command[0] == 'purchase') aq3stat_purchase(command[1]);
+    }
+  };
+  for (var i = 0; i < aq3stat_queue.length; i++) {
+    window._aq3q.push(aq3stat_queue[i]);
+  }
+
+  if (window.addEventListener) {
+    window.addEventListener('scroll', aq3stat_scroll, false);
+    window.addEventListener('load', aq3stat_scroll, false);
+    window.addEventListener('pagehide', aq3stat_ping, false);
+    document.addEventListener('visibilitychange', function() {
+      if (document.visibilityState == 'hidden') aq3stat_ping();
+    }, false);
+  }
+})
@@ -1,161 +1,106 @@
 package api
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
 	"net/http"
+	"net/url"
+	"path"
 	"strconv"
+	"strings"
+	"unicode/utf8"
 
 	"github.com/gin-gonic/gin"
+	"aq3stat/internal/model"
 	"aq3stat/internal/service"
 )
 
 // CollectorController handles data collection related API endpoints
 type CollectorController struct {
 	collectorService *service.CollectorService
+	badgeService     *service.BadgeService
+	websiteService   *service.WebsiteService
 }
 
 // NewCollectorController creates a new collector controller
 func NewCollectorController() *CollectorController {
 	return &CollectorController{
 		collectorService: service.NewCollectorService(),
+		badgeService:     service.NewBadgeService(),
+		websiteService:   service.NewWebsiteService(),
 	}
 }
 
-// Counter generates the JavaScript tracking code
+// Counter serves the JavaScript tracker with the configuration of a website
 func (c *CollectorController) Counter(ctx *gin.Context) {
 	idStr := ctx.Query("id")
-	_, err := strconv.Atoi(idStr)
+	id, err := strconv.Atoi(idStr)
 	if err != nil {
 		ctx.String(http.StatusBadRequest, "Invalid website ID")
 		return
 	}
 
-	iconType := ctx.DefaultQuery("icon", "1")
-
-	// Set content type to JavaScript
-	ctx.Header("Content-Type", "application/javascript")
-
-	// Generate JavaScript code
-	baseURL := "http://" + ctx.Request.Host // Use actual host from request
-
-	// Generate JavaScript code that supports both sync and async loading
-	js := `
-(function() {
-  var aq3stat_base_url = "` + baseURL + `";
-
-  // Function to create and append elements (works for both sync and async)
-  function aq3stat_createElement(tag, attributes, content) {
-    var element = document.createElement(tag);
-    if (attributes) {
-      for (var attr in attributes) {
-        element.setAttribute(attr, attributes[attr]);
-      }
-    }
-    if (content) {
-      element.innerHTML = content;
-    }
-    return element;
-  }
-
-  // Function to append element to document
-  function aq3stat_appendElement(element) {
-    // Try to find the script tag that loaded this code
-    var scripts = document.getElementsByTagName('script');
-    var currentScript = scripts[scripts.length - 1];
-
-    // If we can find the current script, insert after it
-    if (currentScript && currentScript.parentNode) {
-      currentScript.parentNode.insertBefore(element, currentScript.nextSibling);
-    } else {
-      // Fallback: append to body or head
-      var target = document.body || document.head || document.documentElement;
-      if (target) {
-        target.appendChild(element);
-      }
-    }
-  }
-`
-
-	// Add icon display code based on icon type
-	if iconType == "no" {
-		js += `
-  // Create text node for "统计中"
-  var textNode = document.createTextNode("统计中");
-  aq3stat_appendElement(textNode);`
-	} else if iconType == "text" {
-		color := ctx.DefaultQuery("color", "#000000")
-		js += `
-  // Create text display with stats
-  var statsLink = aq3stat_createElement('a', {
-    'href': aq3stat_base_url + '/stats/` + idStr + `',
-    'target': '_blank',
-    'title': 'aq3stat统计'
-  });
-  var statsText = aq3stat_createElement('font', {'color': '` + color + `'}, '网站统计');
-  statsLink.appendChild(statsText);
-
-  var statsInfo = aq3stat_createElement('font', {'color': '` + color + `'}, ' | 今日IP[100] | 今日PV[500] | 昨日IP[80] | 昨日PV[400] | 当前在线[10]');
-
-  var container = aq3stat_createElement('span');
-  container.appendChild(statsLink);
-  container.appendChild(statsInfo);
-  aq3stat_appendElement(container);`
-	} else if iconType == "mark" {
-		color := ctx.DefaultQuery("color", "#000000")
-		js += `
-  // Create mark display
-  var markLink = aq3stat_createElement('a', {
-    'href': aq3stat_base_url + '/stats/` + idStr + `',
-    'target': '_blank',
-    'title': 'aq3stat统计'
-  });
-  var markText = aq3stat_createElement('font', {'color': '` + color + `'}, '网站统计');
-  markLink.appendChild(markText);
-  aq3stat_appendElement(markLink);`
-	} else {
-		// Use icon image
-		js += `
-  // Create icon image
-  var iconLink = aq3stat_createElement('a', {
-    'href': aq3stat_base_url + '/stats/` + idStr + `',
-    'target': '_blank',
-    'title': 'aq3stat统计'
-  });
-  var iconImg = aq3stat_createElement('img', {
-    'src': aq3stat_base_url + '/static/icons/` + iconType + `.gif',
-    'style': 'border-width:0'
-  });
-  iconLink.appendChild(iconImg);
-  aq3stat_appendElement(iconLink);`
-	}
-
-	// Add tracking code
-	js += `
-
-  // Create tracking iframe
-  var aq3stat_url = aq3stat_base_url + "/collect?id=` + idStr + `";
-  aq3stat_url += "&referer=" + encodeURIComponent(document.referrer);
-  aq3stat_url += "&location=" + encodeURIComponent(document.location);
-  aq3stat_url += "&color=" + screen.colorDepth;
-  aq3stat_url += "&width=" + screen.width;
-  aq3stat_url += "&height=" + screen.height;
-  if(typeof(navigator.systemLanguage) != "undefined") aq3stat_url += "&lang=" + navigator.systemLanguage;
-
-  var trackingFrame = aq3stat_createElement('iframe', {
-    'src': aq3stat_url,
-    'width': '0',
-    'height': '0',
-    'marginwidth': '0',
-    'marginheight': '0',
-    'hspace': '0',
-    'vspace': '0',
-    'frameborder': '0',
-    'scrolling': 'no',
-    'style': 'display:none;'
-  });
-  aq3stat_appendElement(trackingFrame);
-})();`
-
-	ctx.String(http.StatusOK, js)
+	config := CounterConfig{
+		ID:      id,
+		BaseURL: requestBaseURL(ctx),
+		Icon:    ctx.DefaultQuery("icon", "1"),
+		Color:   ctx.DefaultQuery("color", "#000000"),
+	}
+
+	// Only whitelisted values end up in the script
+	if !counterIconPattern.MatchString(config.Icon) {
+		config.Icon = "1"
+	}
+	if !counterColorPattern.MatchString(config.Color) {
+		config.Color = "#000000"
+	}
+
+	// Live counters, also used to check if the owner's group hides the icon
+	counters, err := c.badgeService.GetBadgeStats(id)
+	if err != nil || counters.HideIcon {
+		config.Icon = ""
+	} else if counters.IsPublic {
+		config.Counters = &CounterValues{
+			TodayIP:     counters.TodayIP,
+			TodayPV:     counters.TodayPV,
+			YesterdayIP: counters.YesterdayIP,
+			YesterdayPV: counters.YesterdayPV,
+			Online:      counters.Online,
+		}
+	}
+
+	// json.Marshal escapes <, > and &, so the config can't close the script
+	configJSON, err := json.Marshal(config)
+	if err != nil {
+		ctx.String(http.StatusInternalServerError, "Failed to generate counter")
+		return
+	}
+
+	body := counterScript + "(" + string(configJSON) + ");\n"
+	hash := sha256.Sum256([]byte(body))
+	etag := `"` + counterVersion + "-" + hex.EncodeToString(hash[:8]) + `"`
+
+	ctx.Header("ETag", etag)
+	ctx.Header("Cache-Control", "public, max-age=60")
+	ctx.Header("X-Counter-Version", counterVersion)
+
+	if match := ctx.GetHeader("If-None-Match"); match != "" && match == etag {
+		ctx.Status(http.StatusNotModified)
+		return
+	}
+
+	ctx.Data(http.StatusOK, "application/javascript; charset=utf-8", []byte(body))
+}
+
+// requestBaseURL returns the base URL the request was sent to
+func requestBaseURL(ctx *gin.Context) string {
+	scheme := "http"
+	if ctx.Request.TLS != nil || ctx.GetHeader("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	return scheme + "://" + ctx.Request.Host
 }
 
 // Collect collects visitor data
@@ -167,34 +112,21 @@ func (c *CollectorController) Collect(ctx *gin.Context) {
 		return
 	}
 
-	// Get client IP
-	clientIP := ctx.ClientIP()
-
-	// Get other parameters
-	referer := ctx.Query("referer")
-	location := ctx.Query("location")
-	screenColor := ctx.Query("color")
-	screenWidth := ctx.Query("width")
-	screenHeight := ctx.Query("height")
-	language := ctx.Query("lang")
-
 	// Combine width and height for screen size
-	screenSize := screenWidth + "X" + screenHeight
-
-	// Get user agent
-	userAgent := ctx.Request.UserAgent()
+	screenSize := ctx.Query("width") + "X" + ctx.Query("height")
 
 	// Collect data
-	err = c.collectorService.CollectData(
-		id,
-		clientIP,
-		referer,
-		location,
-		screenColor,
-		screenSize,
-		userAgent,
-		language,
-	)
+	err = c.collectorService.CollectData(&service.CollectRequest{
+		WebsiteID:   id,
+		ClientIP:    ctx.ClientIP(),
+		Referer:     ctx.Query("referer"),
+		Location:    ctx.Query("location"),
+		ScreenColor: ctx.Query("color"),
+		ScreenSize:  screenSize,
+		UserAgent:   ctx.Request.UserAgent(),
+		Language:    ctx.Query("lang"),
+		PageViewID:  ctx.Query("pvid"),
+	})
 
 	if err != nil {
 		// Just return a transparent 1x1 pixel GIF
@@ -206,6 +138,259 @@ func (c *CollectorController) Collect(ctx *gin.Context) {
 	ctx.Data(http.StatusOK, "image/gif", transparentGIF())
 }
 
+// Pixel records a hit from the HTTP headers alone, for visitors without JavaScript
+// and for email open tracking
+func (c *CollectorController) Pixel(ctx *gin.Context) {
+	// Every request must reach the server to be counted
+	ctx.Header("Cache-Control", "no-store, no-cache, must-revalidate, max-age=0")
+	ctx.Header("Pragma", "no-cache")
+
+	idStr := ctx.Query("id")
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		ctx.Data(http.StatusOK, "image/gif", transparentGIF())
+		return
+	}
+
+	req := &service.CollectRequest{
+		WebsiteID: id,
+		ClientIP:  ctx.ClientIP(),
+		UserAgent: ctx.Request.UserAgent(),
+		Language:  ctx.GetHeader("Accept-Language"),
+		Source:    model.PageViewSourcePixel,
+		Campaign:  ctx.Query("c"),
+	}
+
+	if ctx.Query("t") == "email" {
+		// Email clients don't send a meaningful Referer
+		req.Source = model.PageViewSourceEmail
+	} else {
+		// The Referer of the image request is the page embedding it
+		req.Location = ctx.GetHeader("Referer")
+	}
+
+	// Always return the image, failures are not reported to the client
+	c.collectorService.CollectData(req)
+
+	ctx.Data(http.StatusOK, "image/gif", transparentGIF())
+}
+
+// Badge renders a live counter badge as SVG or PNG, e.g. /badge/12.svg?metric=today_ip&theme=dark
+func (c *CollectorController) Badge(ctx *gin.Context) {
+	file := ctx.Param("file")
+	ext := path.Ext(file)
+	id, err := strconv.Atoi(strings.TrimSuffix(file, ext))
+	if err != nil || (ext != ".svg" && ext != ".png") {
+		ctx.String(http.StatusNotFound, "Badge not found")
+		return
+	}
+
+	metric := ctx.DefaultQuery("metric", "summary")
+	if !service.IsValidBadgeMetric(metric) {
+		ctx.String(http.StatusBadRequest, "Invalid metric")
+		return
+	}
+
+	label := ctx.Query("label")
+	if utf8.RuneCountInString(label) > 30 {
+		ctx.String(http.StatusBadRequest, "Label must be at most 30 characters")
+		return
+	}
+
+	options := &service.BadgeOptions{
+		Format: strings.TrimPrefix(ext, "."),
+		Metric: metric,
+		Theme:  ctx.DefaultQuery("theme", "flat"),
+		Color:  ctx.Query("color"),
+		Label:  label,
+	}
+
+	image, err := c.badgeService.RenderBadge(id, options)
+	if err != nil {
+		ctx.String(http.StatusNotFound, "Badge not found")
+		return
+	}
+
+	ctx.Header("Cache-Control", "public, max-age=60")
+	if options.Format == "png" {
+		ctx.Data(http.StatusOK, "image/png", image)
+	} else {
+		ctx.Data(http.StatusOK, "image/svg+xml; charset=utf-8", image)
+	}
+}
+
+// AMPConfig serves the amp-analytics configuration of a website
+func (c *CollectorController) AMPConfig(ctx *gin.Context) {
+	idStr := ctx.Query("id")
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid website ID"})
+		return
+	}
+
+	website, err := c.websiteService.GetWebsiteByID(id)
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "Website not found"})
+		return
+	}
+
+	// AMP requests the config from the page's origin or an AMP cache with the page's origin as
+	// __amp_source_origin, which must be the website's own origin
+	sourceOrigin := ctx.Query("__amp_source_origin")
+	if sourceOrigin != "" && !isWebsiteOrigin(website, sourceOrigin) {
+		ctx.JSON(http.StatusForbidden, gin.H{"error": "Source origin not allowed"})
+		return
+	}
+
+	// AMP fetches the config with credentials, so the origin must be echoed instead of *
+	ctx.Header("Vary", "Origin")
+	if origin := ctx.GetHeader("Origin"); origin != "" && (isWebsiteOrigin(website, origin) || isAMPCacheOrigin(origin)) {
+		ctx.Header("Access-Control-Allow-Origin", origin)
+		ctx.Header("Access-Control-Allow-Credentials", "true")
+	}
+	if sourceOrigin != "" {
+		ctx.Header("AMP-Access-Control-Allow-Source-Origin", sourceOrigin)
+		ctx.Header("Access-Control-Expose-Headers", "AMP-Access-Control-Allow-Source-Origin")
+	}
+	ctx.Header("Cache-Control", "public, max-age=300")
+
+	ctx.JSON(http.StatusOK, c.websiteService.GenerateAMPConfig(website))
+}
+
+// isWebsiteOrigin reports whether an origin is the origin of the website's URL, over http or https
+func isWebsiteOrigin(website *model.Website, origin string) bool {
+	siteURL := website.URL
+	if !strings.Contains(siteURL, "://") {
+		siteURL = "https://" + siteURL
+	}
+
+	site, err := url.Parse(siteURL)
+	if err != nil || site.Host == "" {
+		return false
+	}
+	parsed, err := url.Parse(origin)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Path != "" {
+		return false
+	}
+	return strings.EqualFold(parsed.Host, site.Host)
+}
+
+// isAMPCacheOrigin reports whether an origin is the Google AMP cache, which serves AMP pages from
+// subdomains of ampproject.org such as example-com.cdn.ampproject.org
+func isAMPCacheOrigin(origin string) bool {
+	parsed, err := url.Parse(origin)
+	if err != nil || parsed.Scheme != "https" || parsed.Path != "" {
+		return false
+	}
+	return strings.HasSuffix(strings.ToLower(parsed.Host), ".ampproject.org")
+}
+
+// AMPCollect collects a page view sent by amp-analytics
+func (c *CollectorController) AMPCollect(ctx *gin.Context) {
+	idStr := ctx.Query("id")
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		ctx.String(http.StatusBadRequest, "Invalid website ID")
+		return
+	}
+
+	// Prefer the real screen size and fall back to the viewport
+	width, height := ctx.Query("sw"), ctx.Query("sh")
+	if width == "" || height == "" {
+		width, height = ctx.Query("vw"), ctx.Query("vh")
+	}
+
+	language := ctx.Query("lang")
+	if language == "" {
+		language = ctx.GetHeader("Accept-Language")
+	}
+
+	// The canonical URL is used because AMP pages are usually served from an AMP cache
+	c.collectorService.CollectData(&service.CollectRequest{
+		WebsiteID:   id,
+		ClientIP:    ctx.ClientIP(),
+		Referer:     ctx.Query("referer"),
+		Location:    ctx.Query("url"),
+		ScreenColor: ctx.Query("color"),
+		ScreenSize:  width + "X" + height,
+		UserAgent:   ctx.Request.UserAgent(),
+		Language:    language,
+		PageViewID:  ctx.Query("pvid"),
+		Source:      model.PageViewSourceAMP,
+		ClientID:    ctx.Query("cid"),
+	})
+
+	ctx.Data(http.StatusOK, "image/gif", transparentGIF())
+}
+
+// Ping records the unload ping of a page view sent by counter.js
+func (c *CollectorController) Ping(ctx *gin.Context) {
+	idStr := ctx.Query("id")
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		ctx.String(http.StatusBadRequest, "Invalid website ID")
+		return
+	}
+
+	scrollDepth, _ := strconv.Atoi(ctx.Query("depth"))
+
+	// The ping is fire-and-forget, failures are not reported to the browser
+	c.collectorService.RecordPageLeave(id, ctx.Query("pvid"), scrollDepth)
+
+	ctx.Status(http.StatusNoContent)
+}
+
+// Event records a custom event fired by a page
+func (c *CollectorController) Event(ctx *gin.Context) {
+	idStr := ctx.Query("id")
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		ctx.String(http.StatusBadRequest, "Invalid website ID")
+		return
+	}
+
+	value, _ := strconv.ParseFloat(ctx.Query("value"), 64)
+
+	// Events are fire-and-forget, failures are not reported to the browser
+	c.collectorService.CollectEvent(id, ctx.Query("pvid"), ctx.Query("name"), value)
+
+	ctx.Data(http.StatusOK, "image/gif", transparentGIF())
+}
+
+// maxPurchaseBodySize limits the size of a purchase event body
+const maxPurchaseBodySize = 64 << 10
+
+// Purchase records an e-commerce purchase sent as JSON by counter.js
+func (c *CollectorController) Purchase(ctx *gin.Context) {
+	idStr := ctx.Query("id")
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		ctx.String(http.StatusBadRequest, "Invalid website ID")
+		return
+	}
+
+	// Beacons are sent as text/plain, so the body is decoded regardless of the content type
+	body, err := io.ReadAll(io.LimitReader(ctx.Request.Body, maxPurchaseBodySize))
+	if err != nil {
+		ctx.String(http.StatusBadRequest, "Invalid purchase")
+		return
+	}
+
+	var req service.PurchaseRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		ctx.String(http.StatusBadRequest, "Invalid purchase")
+		return
+	}
+
+	err = c.collectorService.CollectPurchase(id, ctx.Query("pvid"), &req)
+	if err != nil {
+		ctx.String(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	ctx.Status(http.StatusNoContent)
+}
+
 // transparentGIF returns a transparent 1x1 pixel GIF
 func transparentGIF() []byte {
 	return []byte{
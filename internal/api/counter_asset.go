@@ -0,0 +1,56 @@
+package api
+
+import (
+	"crypto/sha256"
+	_ "embed"
+	"encoding/hex"
+	"regexp"
+	"strings"
+)
+
+//go:embed assets/counter.js
+var counterSource string
+
+// counterScript is the minified tracker, counterVersion the hash of its content
+var counterScript, counterVersion = minifyCounter(counterSource)
+
+// Allowed counter.js query values, everything else is rejected or replaced by the default
+var (
+	counterIconPattern  = regexp.MustCompile(`^([0-9]{1,2}|no|text|mark|badge)$`)
+	counterColorPattern = regexp.MustCompile(`^(#[0-9a-fA-F]{3}|#[0-9a-fA-F]{6}|[a-zA-Z]{1,20})$`)
+)
+
+// minifyCounter strips comments, indentation and blank lines from the tracker source and
+// returns it with the hash of the result. Lines are kept so that semicolon insertion still works.
+func minifyCounter(source string) (string, string) {
+	var lines []string
+	for _, line := range strings.Split(source, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "//") {
+			continue
+		}
+		lines = append(lines, line)
+	}
+
+	script := strings.Join(lines, "\n")
+	hash := sha256.Sum256([]byte(script))
+	return script, hex.EncodeToString(hash[:])[:12]
+}
+
+// CounterConfig is the per-website configuration passed to the tracker
+type CounterConfig struct {
+	ID       int            `json:"id"`
+	BaseURL  string         `json:"base_url"`
+	Icon     string         `json:"icon"` // Empty when no icon is displayed
+	Color    string         `json:"color"`
+	Counters *CounterValues `json:"counters"` // Only set for public websites
+}
+
+// CounterValues are the live counters shown by the text icon mode
+type CounterValues struct {
+	TodayIP     int64 `json:"today_ip"`
+	TodayPV     int64 `json:"today_pv"`
+	YesterdayIP int64 `json:"yesterday_ip"`
+	YesterdayPV int64 `json:"yesterday_pv"`
+	Online      int64 `json:"online"`
+}
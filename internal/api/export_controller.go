@@ -0,0 +1,197 @@
+package api
+
+import (
+	"net/http"
+	"os"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"aq3stat/internal/model"
+	"aq3stat/internal/service"
+	"aq3stat/pkg/export"
+)
+
+// ExportController handles data export API endpoints
+type ExportController struct {
+	websiteService *service.WebsiteService
+	exportService  *service.ExportService
+}
+
+// NewExportController creates a new export controller
+func NewExportController() *ExportController {
+	return &ExportController{
+		websiteService: service.NewWebsiteService(),
+		exportService:  service.NewExportService(),
+	}
+}
+
+// ExportJobRequest represents a create export job request
+type ExportJobRequest struct {
+	Report  string   `json:"report" binding:"required"`
+	Format  string   `json:"format" binding:"required"`
+	Columns []string `json:"columns"`
+	From    string   `json:"from"`
+	To      string   `json:"to"`
+}
+
+// Export streams raw hits or a report of a website,
+// e.g. /api/websites/1/export?report=stats&format=csv&columns=time,ip,location&from=2024-01-01&to=2024-01-31
+func (c *ExportController) Export(ctx *gin.Context) {
+	website := loadWebsite(ctx, c.websiteService, true)
+	if website == nil {
+		return
+	}
+
+	from, to, err := parseDateRange(ctx, website.Location())
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	req := &service.ExportRequest{
+		WebsiteID: website.ID,
+		Report:    ctx.DefaultQuery("report", "stats"),
+		Format:    ctx.DefaultQuery("format", export.FormatCSV),
+		Columns:   splitList(ctx.Query("columns")),
+		From:      from,
+		To:        to,
+	}
+
+	if err := c.exportService.ValidateExport(req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	count, err := c.exportService.CountExportRows(req)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to count export rows"})
+		return
+	}
+	if count > service.ExportStreamMaxRows {
+		ctx.JSON(http.StatusRequestEntityTooLarge, gin.H{
+			"error": "Export has " + strconv.FormatInt(count, 10) + " rows, create an export job for more than " +
+				strconv.Itoa(service.ExportStreamMaxRows) + " rows",
+		})
+		return
+	}
+
+	fileName := service.ExportFileName(website.ID, req.Report, req.Format, from, to)
+	ctx.Header("Content-Type", export.ContentType(req.Format))
+	ctx.Header("Content-Disposition", `attachment; filename="`+fileName+`"`)
+	ctx.Status(http.StatusOK)
+
+	// Headers are sent with the first row, a failure can only cut the stream short
+	if _, err := c.exportService.WriteExport(req, ctx.Writer); err != nil {
+		ctx.Error(err)
+	}
+}
+
+// CreateExportJob starts a background export of a website into a downloadable file
+func (c *ExportController) CreateExportJob(ctx *gin.Context) {
+	website := loadWebsite(ctx, c.websiteService, true)
+	if website == nil {
+		return
+	}
+
+	var req ExportJobRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	from, to, err := parseDateRangeValues(req.From, req.To, website.Location())
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	userID, _ := ctx.Get("userID")
+	job, err := c.exportService.CreateExportJob(&service.ExportRequest{
+		WebsiteID: website.ID,
+		Report:    req.Report,
+		Format:    req.Format,
+		Columns:   req.Columns,
+		From:      from,
+		To:        to,
+	}, userID.(int))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusAccepted, job)
+}
+
+// ListExportJobs lists the export jobs of a website
+func (c *ExportController) ListExportJobs(ctx *gin.Context) {
+	website := loadWebsite(ctx, c.websiteService, true)
+	if website == nil {
+		return
+	}
+
+	jobs, err := c.exportService.ListExportJobs(website.ID)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list export jobs"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, jobs)
+}
+
+// GetExportJob gets the status of an export job
+func (c *ExportController) GetExportJob(ctx *gin.Context) {
+	job := c.loadExportJob(ctx)
+	if job == nil {
+		return
+	}
+
+	ctx.JSON(http.StatusOK, job)
+}
+
+// DownloadExport downloads the file of a finished export job
+func (c *ExportController) DownloadExport(ctx *gin.Context) {
+	job := c.loadExportJob(ctx)
+	if job == nil {
+		return
+	}
+
+	if job.Status != model.ExportStatusDone {
+		ctx.JSON(http.StatusConflict, gin.H{"error": "Export is not finished"})
+		return
+	}
+
+	if _, err := os.Stat(job.FilePath); err != nil {
+		ctx.JSON(http.StatusGone, gin.H{"error": "Export file no longer exists"})
+		return
+	}
+
+	ctx.Header("Content-Type", export.ContentType(job.Format))
+	ctx.FileAttachment(job.FilePath, service.ExportFileName(job.WebsiteID, job.Report, job.Format, job.StartTime, job.EndTime))
+}
+
+// loadExportJob loads the export job of the :exportId path parameter for the website of the :id parameter.
+// The error response is written and nil is returned when it can't be accessed.
+func (c *ExportController) loadExportJob(ctx *gin.Context) *model.ExportJob {
+	website := loadWebsite(ctx, c.websiteService, true)
+	if website == nil {
+		return nil
+	}
+
+	id, err := strconv.Atoi(ctx.Param("exportId"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid export ID"})
+		return nil
+	}
+
+	job, err := c.exportService.GetExportJob(id)
+	if err != nil || job.WebsiteID != website.ID {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "Export not found"})
+		return nil
+	}
+
+	// The range is stored in UTC, show it in the days of the website
+	job.StartTime = job.StartTime.In(website.Location())
+	job.EndTime = job.EndTime.In(website.Location())
+
+	return job
+}
@@ -0,0 +1,201 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"aq3stat/internal/model"
+	"aq3stat/internal/service"
+)
+
+// FunnelController handles funnel related API endpoints
+type FunnelController struct {
+	websiteService *service.WebsiteService
+	funnelService  *service.FunnelService
+	segmentService *service.SegmentService
+}
+
+// NewFunnelController creates a new funnel controller
+func NewFunnelController() *FunnelController {
+	return &FunnelController{
+		websiteService: service.NewWebsiteService(),
+		funnelService:  service.NewFunnelService(),
+		segmentService: service.NewSegmentService(),
+	}
+}
+
+// FunnelStepRequest represents a step of a funnel request
+type FunnelStepRequest struct {
+	Name    string `json:"name"`
+	Type    string `json:"type" binding:"required"`
+	Pattern string `json:"pattern" binding:"required"`
+}
+
+// FunnelRequest represents a create or update funnel request
+type FunnelRequest struct {
+	Name  string              `json:"name" binding:"required"`
+	Steps []FunnelStepRequest `json:"steps" binding:"required"`
+}
+
+// toSteps converts the requested steps into funnel steps
+func (req *FunnelRequest) toSteps() []model.FunnelStep {
+	steps := make([]model.FunnelStep, len(req.Steps))
+	for i, step := range req.Steps {
+		steps[i] = model.FunnelStep{
+			Name:    step.Name,
+			Type:    step.Type,
+			Pattern: step.Pattern,
+		}
+	}
+	return steps
+}
+
+// ListFunnels lists the funnels of a website
+func (c *FunnelController) ListFunnels(ctx *gin.Context) {
+	website := loadWebsite(ctx, c.websiteService, true)
+	if website == nil {
+		return
+	}
+
+	funnels, err := c.funnelService.ListFunnels(website.ID)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list funnels"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, funnels)
+}
+
+// CreateFunnel creates a funnel for a website
+func (c *FunnelController) CreateFunnel(ctx *gin.Context) {
+	website := loadWebsite(ctx, c.websiteService, true)
+	if website == nil {
+		return
+	}
+
+	var req FunnelRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	funnel := &model.Funnel{
+		WebsiteID: website.ID,
+		Name:      req.Name,
+		Steps:     req.toSteps(),
+	}
+
+	err := c.funnelService.CreateFunnel(funnel)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, funnel)
+}
+
+// UpdateFunnel updates a funnel of a website
+func (c *FunnelController) UpdateFunnel(ctx *gin.Context) {
+	website := loadWebsite(ctx, c.websiteService, true)
+	if website == nil {
+		return
+	}
+
+	funnel := c.loadFunnel(ctx, website)
+	if funnel == nil {
+		return
+	}
+
+	var req FunnelRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	funnel.Name = req.Name
+	funnel.Steps = req.toSteps()
+
+	err := c.funnelService.UpdateFunnel(funnel)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, funnel)
+}
+
+// DeleteFunnel deletes a funnel of a website
+func (c *FunnelController) DeleteFunnel(ctx *gin.Context) {
+	website := loadWebsite(ctx, c.websiteService, true)
+	if website == nil {
+		return
+	}
+
+	funnel := c.loadFunnel(ctx, website)
+	if funnel == nil {
+		return
+	}
+
+	err := c.funnelService.DeleteFunnel(funnel.ID)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete funnel"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"message": "Funnel deleted successfully"})
+}
+
+// GetFunnelReport gets the step-by-step visitor counts of a funnel over a date range of at most 366 days
+func (c *FunnelController) GetFunnelReport(ctx *gin.Context) {
+	website := loadWebsite(ctx, c.websiteService, false)
+	if website == nil {
+		return
+	}
+
+	funnel := c.loadFunnel(ctx, website)
+	if funnel == nil {
+		return
+	}
+
+	from, to, err := parseDateRange(ctx, website.Location())
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if to.Sub(from) > 366*24*time.Hour {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Date range must not exceed 366 days"})
+		return
+	}
+
+	filters, ok := loadSegmentFilters(ctx, c.segmentService, website)
+	if !ok {
+		return
+	}
+
+	report, err := c.funnelService.GetFunnelReport(funnel, from, to, filters)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get funnel report"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, report)
+}
+
+// loadFunnel loads the funnel of the :funnelId path parameter and checks that it belongs to the website
+func (c *FunnelController) loadFunnel(ctx *gin.Context, website *model.Website) *model.Funnel {
+	funnelID, err := strconv.Atoi(ctx.Param("funnelId"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid funnel ID"})
+		return nil
+	}
+
+	funnel, err := c.funnelService.GetFunnelByID(funnelID)
+	if err != nil || funnel.WebsiteID != website.ID {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "Funnel not found"})
+		return nil
+	}
+
+	return funnel
+}
@@ -0,0 +1,185 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"aq3stat/internal/model"
+	"aq3stat/internal/service"
+)
+
+// GoalController handles goal related API endpoints
+type GoalController struct {
+	websiteService *service.WebsiteService
+	goalService    *service.GoalService
+	segmentService *service.SegmentService
+}
+
+// NewGoalController creates a new goal controller
+func NewGoalController() *GoalController {
+	return &GoalController{
+		websiteService: service.NewWebsiteService(),
+		goalService:    service.NewGoalService(),
+		segmentService: service.NewSegmentService(),
+	}
+}
+
+// GoalRequest represents a create or update goal request
+type GoalRequest struct {
+	Name      string  `json:"name" binding:"required"`
+	Type      string  `json:"type" binding:"required"`
+	Pattern   string  `json:"pattern"`
+	Threshold int     `json:"threshold"`
+	Value     float64 `json:"value"`
+	IsEnabled *bool   `json:"is_enabled"`
+}
+
+// ListGoals lists the goals of a website
+func (c *GoalController) ListGoals(ctx *gin.Context) {
+	website := loadWebsite(ctx, c.websiteService, true)
+	if website == nil {
+		return
+	}
+
+	goals, err := c.goalService.ListGoals(website.ID)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list goals"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, goals)
+}
+
+// CreateGoal creates a goal for a website
+func (c *GoalController) CreateGoal(ctx *gin.Context) {
+	website := loadWebsite(ctx, c.websiteService, true)
+	if website == nil {
+		return
+	}
+
+	var req GoalRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	goal := &model.Goal{
+		WebsiteID: website.ID,
+		Name:      req.Name,
+		Type:      req.Type,
+		Pattern:   req.Pattern,
+		Threshold: req.Threshold,
+		Value:     req.Value,
+		IsEnabled: req.IsEnabled == nil || *req.IsEnabled,
+	}
+
+	err := c.goalService.CreateGoal(goal)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, goal)
+}
+
+// UpdateGoal updates a goal of a website
+func (c *GoalController) UpdateGoal(ctx *gin.Context) {
+	website := loadWebsite(ctx, c.websiteService, true)
+	if website == nil {
+		return
+	}
+
+	goal := c.loadGoal(ctx, website)
+	if goal == nil {
+		return
+	}
+
+	var req GoalRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	goal.Name = req.Name
+	goal.Type = req.Type
+	goal.Pattern = req.Pattern
+	goal.Threshold = req.Threshold
+	goal.Value = req.Value
+	if req.IsEnabled != nil {
+		goal.IsEnabled = *req.IsEnabled
+	}
+
+	err := c.goalService.UpdateGoal(goal)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, goal)
+}
+
+// DeleteGoal deletes a goal of a website
+func (c *GoalController) DeleteGoal(ctx *gin.Context) {
+	website := loadWebsite(ctx, c.websiteService, true)
+	if website == nil {
+		return
+	}
+
+	goal := c.loadGoal(ctx, website)
+	if goal == nil {
+		return
+	}
+
+	err := c.goalService.DeleteGoal(goal.ID)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete goal"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"message": "Goal deleted successfully"})
+}
+
+// GetGoalReport gets conversions, conversion rate and revenue per goal over a date range
+func (c *GoalController) GetGoalReport(ctx *gin.Context) {
+	website := loadWebsite(ctx, c.websiteService, false)
+	if website == nil {
+		return
+	}
+
+	from, to, err := parseDateRange(ctx, website.Location())
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	filters, ok := loadSegmentFilters(ctx, c.segmentService, website)
+	if !ok {
+		return
+	}
+
+	report, err := c.goalService.GetGoalReport(website.ID, from, to, filters)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get goal report"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, report)
+}
+
+// loadGoal loads the goal of the :goalId path parameter and checks that it belongs to the website
+func (c *GoalController) loadGoal(ctx *gin.Context, website *model.Website) *model.Goal {
+	goalID, err := strconv.Atoi(ctx.Param("goalId"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid goal ID"})
+		return nil
+	}
+
+	goal, err := c.goalService.GetGoalByID(goalID)
+	if err != nil || goal.WebsiteID != website.ID {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "Goal not found"})
+		return nil
+	}
+
+	return goal
+}
@@ -0,0 +1,122 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"aq3stat/internal/model"
+	"aq3stat/internal/service"
+)
+
+// loadWebsite loads the website of the :id path parameter and checks that the current user
+// may access it. Public websites are readable by everyone when ownerOnly is false.
+// The error response is written and nil is returned when access is denied.
+func loadWebsite(ctx *gin.Context, websiteService *service.WebsiteService, ownerOnly bool) *model.Website {
+	idStr := ctx.Param("id")
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid website ID"})
+		return nil
+	}
+
+	website, err := websiteService.GetWebsiteByID(id)
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "Website not found"})
+		return nil
+	}
+
+	userID, exists := ctx.Get("userID")
+	if !exists {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return nil
+	}
+
+	// Check if user is the owner or if website is public
+	if website.UserID != userID.(int) && (ownerOnly || !website.IsPublic) {
+		// Check if user has admin rights (from context)
+		isAdmin, exists := ctx.Get("isAdmin")
+		if !exists || !isAdmin.(bool) {
+			ctx.JSON(http.StatusForbidden, gin.H{"error": "You don't have permission to access this website"})
+			return nil
+		}
+	}
+
+	return website
+}
+
+// parseDateRange parses the from and to query parameters (YYYY-MM-DD, both inclusive)
+// into a half-open time range of days in loc. It defaults to the last 7 days.
+func parseDateRange(ctx *gin.Context, loc *time.Location) (time.Time, time.Time, error) {
+	return parseDateRangeValues(ctx.Query("from"), ctx.Query("to"), loc)
+}
+
+// parseDateRangeValues parses from and to dates (YYYY-MM-DD, both inclusive, empty for the
+// default) into a half-open time range of days in loc. It defaults to the last 7 days.
+func parseDateRangeValues(fromStr, toStr string, loc *time.Location) (time.Time, time.Time, error) {
+	now := time.Now().In(loc)
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+
+	from := today.AddDate(0, 0, -6)
+	if fromStr != "" {
+		date, err := time.ParseInLocation("2006-01-02", fromStr, now.Location())
+		if err != nil {
+			return time.Time{}, time.Time{}, errors.New("invalid from date, expected YYYY-MM-DD")
+		}
+		from = date
+	}
+
+	to := today
+	if toStr != "" {
+		date, err := time.ParseInLocation("2006-01-02", toStr, now.Location())
+		if err != nil {
+			return time.Time{}, time.Time{}, errors.New("invalid to date, expected YYYY-MM-DD")
+		}
+		to = date
+	}
+
+	if to.Before(from) {
+		return time.Time{}, time.Time{}, errors.New("to date must not be before from date")
+	}
+
+	return from, to.AddDate(0, 0, 1), nil
+}
+
+// parseComparison parses the compare query parameter into the comparison of a half-open range
+// of days in loc, nil without compare. The compared range of the custom mode is given by the
+// compare_from and compare_to dates, both inclusive.
+func parseComparison(ctx *gin.Context, from, to time.Time, loc *time.Location) (*service.Comparison, error) {
+	mode := ctx.Query("compare")
+	if mode == "" {
+		return nil, nil
+	}
+
+	var customFrom, customTo time.Time
+	if mode == service.CompareCustom {
+		if ctx.Query("compare_from") == "" || ctx.Query("compare_to") == "" {
+			return nil, errors.New("compare_from and compare_to are required to compare to a custom range")
+		}
+
+		var err error
+		customFrom, customTo, err = parseDateRangeValues(ctx.Query("compare_from"), ctx.Query("compare_to"), loc)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return service.NewComparison(mode, from, to, customFrom, customTo)
+}
+
+// splitList splits a comma separated query parameter, ignoring empty items
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
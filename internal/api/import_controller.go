@@ -0,0 +1,139 @@
+package api
+
+import (
+	"mime/multipart"
+	"net/http"
+	"os"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"aq3stat/internal/service"
+)
+
+// ImportController handles admin endpoints for importing historical data
+type ImportController struct {
+	websiteService *service.WebsiteService
+	importService  *service.ImportService
+}
+
+// NewImportController creates a new import controller
+func NewImportController() *ImportController {
+	return &ImportController{
+		websiteService: service.NewWebsiteService(),
+		importService:  service.NewImportService(),
+	}
+}
+
+// ImportAccessLog uploads an access log (plain or gzip) and imports it in the background.
+// The format form field is combined, common, vhost or a custom nginx log_format string.
+func (c *ImportController) ImportAccessLog(ctx *gin.Context) {
+	website := loadWebsite(ctx, c.websiteService, false)
+	if website == nil {
+		return
+	}
+
+	header, path := saveUpload(ctx)
+	if header == nil {
+		return
+	}
+
+	userID, _ := ctx.Get("userID")
+	job, err := c.importService.CreateAccessLogImport(website.ID, userID.(int), ctx.PostForm("format"), header.Filename, header.Size)
+	if err != nil {
+		os.Remove(path)
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.importService.StartAccessLogImport(job, path)
+
+	ctx.JSON(http.StatusAccepted, job)
+}
+
+// ImportCSV uploads a daily aggregate CSV export of Google Analytics, Matomo, Umami or Plausible
+// and imports it in the background. The source form field names the tool; the optional dimension
+// field names the breakdown of the file when its header doesn't tell.
+func (c *ImportController) ImportCSV(ctx *gin.Context) {
+	website := loadWebsite(ctx, c.websiteService, false)
+	if website == nil {
+		return
+	}
+
+	header, path := saveUpload(ctx)
+	if header == nil {
+		return
+	}
+
+	userID, _ := ctx.Get("userID")
+	job, err := c.importService.CreateCSVImport(website.ID, userID.(int), ctx.PostForm("source"), header.Filename, header.Size)
+	if err != nil {
+		os.Remove(path)
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.importService.StartCSVImport(job, path, ctx.PostForm("dimension"))
+
+	ctx.JSON(http.StatusAccepted, job)
+}
+
+// ListImportJobs lists the import jobs of a website
+func (c *ImportController) ListImportJobs(ctx *gin.Context) {
+	website := loadWebsite(ctx, c.websiteService, false)
+	if website == nil {
+		return
+	}
+
+	jobs, err := c.importService.ListImportJobs(website.ID)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list import jobs"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, jobs)
+}
+
+// GetImportJob gets the status and progress of an import job
+func (c *ImportController) GetImportJob(ctx *gin.Context) {
+	id, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid import job ID"})
+		return
+	}
+
+	job, err := c.importService.GetImportJob(id)
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "Import job not found"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"job":      job,
+		"progress": job.Progress(),
+	})
+}
+
+// saveUpload stores the uploaded file field in a temporary file that is kept until the
+// background import has finished. The error response is written and nil is returned on failure.
+func saveUpload(ctx *gin.Context) (*multipart.FileHeader, string) {
+	header, err := ctx.FormFile("file")
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "File is required"})
+		return nil, ""
+	}
+
+	file, err := os.CreateTemp("", "aq3stat-import-*")
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store uploaded file"})
+		return nil, ""
+	}
+	file.Close()
+
+	if err := ctx.SaveUploadedFile(header, file.Name()); err != nil {
+		os.Remove(file.Name())
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store uploaded file"})
+		return nil, ""
+	}
+
+	return header, file.Name()
+}
@@ -0,0 +1,71 @@
+package api
+
+import (
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"aq3stat/internal/service"
+	"aq3stat/pkg/live"
+)
+
+// liveKeepAlive is the interval of the comments keeping idle live streams open through proxies
+const liveKeepAlive = 15 * time.Second
+
+// LiveController handles the live visitor stream
+type LiveController struct {
+	websiteService *service.WebsiteService
+}
+
+// NewLiveController creates a new live controller
+func NewLiveController() *LiveController {
+	return &LiveController{
+		websiteService: service.NewWebsiteService(),
+	}
+}
+
+// Stream streams the hits and the online count of a website as Server-Sent Events. hit events
+// carry a hit without personal data, online events the visitors seen within the online window,
+// and dropped events the number of hits skipped because the client didn't keep up. Clients that
+// stay behind are disconnected after an error event.
+func (c *LiveController) Stream(ctx *gin.Context) {
+	website := loadWebsite(ctx, c.websiteService, false)
+	if website == nil {
+		return
+	}
+
+	subscriber, err := live.Subscribe(website.ID)
+	if err != nil {
+		ctx.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+		return
+	}
+	defer live.Unsubscribe(subscriber)
+
+	ctx.Header("Content-Type", "text/event-stream")
+	ctx.Header("Cache-Control", "no-cache")
+	ctx.Header("Connection", "keep-alive")
+	ctx.Header("X-Accel-Buffering", "no") // Disable nginx response buffering
+
+	keepAlive := time.NewTicker(liveKeepAlive)
+	defer keepAlive.Stop()
+
+	ctx.Stream(func(w io.Writer) bool {
+		select {
+		case <-ctx.Request.Context().Done():
+			return false
+		case <-subscriber.Done():
+			ctx.SSEvent("error", gin.H{"error": "Client is too slow to keep up"})
+			return false
+		case event := <-subscriber.Events():
+			if dropped := subscriber.Dropped(); dropped > 0 {
+				ctx.SSEvent("dropped", gin.H{"count": dropped})
+			}
+			ctx.SSEvent(event.Type, event.Data)
+			return true
+		case <-keepAlive.C:
+			_, err := io.WriteString(w, ": keep-alive\n\n")
+			return err == nil
+		}
+	})
+}
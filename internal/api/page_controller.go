@@ -0,0 +1,195 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"aq3stat/internal/model"
+	"aq3stat/internal/service"
+)
+
+// PageController handles page report and page rule related API endpoints
+type PageController struct {
+	websiteService *service.WebsiteService
+	pageService    *service.PageService
+	segmentService *service.SegmentService
+}
+
+// NewPageController creates a new page controller
+func NewPageController() *PageController {
+	return &PageController{
+		websiteService: service.NewWebsiteService(),
+		pageService:    service.NewPageService(),
+		segmentService: service.NewSegmentService(),
+	}
+}
+
+// PageRuleRequest represents a create or update page rule request
+type PageRuleRequest struct {
+	Type        string `json:"type" binding:"required"`
+	Pattern     string `json:"pattern"`
+	Replacement string `json:"replacement"`
+	Position    int    `json:"position"`
+}
+
+// ListPageRules lists the page rules of a website
+func (c *PageController) ListPageRules(ctx *gin.Context) {
+	website := loadWebsite(ctx, c.websiteService, true)
+	if website == nil {
+		return
+	}
+
+	rules, err := c.pageService.ListPageRules(website.ID)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list page rules"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, rules)
+}
+
+// CreatePageRule creates a page rule for a website
+func (c *PageController) CreatePageRule(ctx *gin.Context) {
+	website := loadWebsite(ctx, c.websiteService, true)
+	if website == nil {
+		return
+	}
+
+	var req PageRuleRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	rule := &model.PageRule{
+		WebsiteID:   website.ID,
+		Type:        req.Type,
+		Pattern:     req.Pattern,
+		Replacement: req.Replacement,
+		Position:    req.Position,
+	}
+
+	err := c.pageService.CreatePageRule(rule)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, rule)
+}
+
+// UpdatePageRule updates a page rule of a website
+func (c *PageController) UpdatePageRule(ctx *gin.Context) {
+	website := loadWebsite(ctx, c.websiteService, true)
+	if website == nil {
+		return
+	}
+
+	rule := c.loadPageRule(ctx, website)
+	if rule == nil {
+		return
+	}
+
+	var req PageRuleRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	rule.Type = req.Type
+	rule.Pattern = req.Pattern
+	rule.Replacement = req.Replacement
+	rule.Position = req.Position
+
+	err := c.pageService.UpdatePageRule(rule)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, rule)
+}
+
+// DeletePageRule deletes a page rule of a website
+func (c *PageController) DeletePageRule(ctx *gin.Context) {
+	website := loadWebsite(ctx, c.websiteService, true)
+	if website == nil {
+		return
+	}
+
+	rule := c.loadPageRule(ctx, website)
+	if rule == nil {
+		return
+	}
+
+	err := c.pageService.DeletePageRule(rule.ID)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete page rule"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"message": "Page rule deleted successfully"})
+}
+
+// GetPageReport gets the top, entry and exit pages of a website over a date range, optionally compared
+// to another range, e.g. /api/websites/1/pages?from=2024-01-01&to=2024-01-31&limit=20&compare=previous_period
+func (c *PageController) GetPageReport(ctx *gin.Context) {
+	website := loadWebsite(ctx, c.websiteService, false)
+	if website == nil {
+		return
+	}
+
+	from, to, err := parseDateRange(ctx, website.Location())
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if to.Sub(from) > 366*24*time.Hour {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Date range must not exceed 366 days"})
+		return
+	}
+
+	limit, err := strconv.Atoi(ctx.DefaultQuery("limit", "20"))
+	if err != nil || limit < 1 || limit > 100 {
+		limit = 20
+	}
+
+	comparison, err := parseComparison(ctx, from, to, website.Location())
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	filters, ok := loadSegmentFilters(ctx, c.segmentService, website)
+	if !ok {
+		return
+	}
+
+	report, err := c.pageService.GetPageReport(website.ID, from, to, limit, filters, comparison)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get page report"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, report)
+}
+
+// loadPageRule loads the page rule of the :ruleId path parameter and checks that it belongs to the website
+func (c *PageController) loadPageRule(ctx *gin.Context, website *model.Website) *model.PageRule {
+	ruleID, err := strconv.Atoi(ctx.Param("ruleId"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid page rule ID"})
+		return nil
+	}
+
+	rule, err := c.pageService.GetPageRuleByID(ruleID)
+	if err != nil || rule.WebsiteID != website.ID {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "Page rule not found"})
+		return nil
+	}
+
+	return rule
+}
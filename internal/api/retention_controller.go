@@ -0,0 +1,144 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"aq3stat/internal/model"
+	"aq3stat/internal/service"
+)
+
+// RetentionController handles admin endpoints for raw hit retention policies
+type RetentionController struct {
+	retentionService *service.DataRetentionService
+}
+
+// NewRetentionController creates a new retention controller
+func NewRetentionController() *RetentionController {
+	return &RetentionController{
+		retentionService: service.NewDataRetentionService(),
+	}
+}
+
+// ListPolicies lists all retention policies
+func (c *RetentionController) ListPolicies(ctx *gin.Context) {
+	policies, err := c.retentionService.ListPolicies()
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get retention policies"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, policies)
+}
+
+// CreatePolicy creates a global, group or website retention policy
+func (c *RetentionController) CreatePolicy(ctx *gin.Context) {
+	var policy model.RetentionPolicy
+	if err := ctx.ShouldBindJSON(&policy); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	policy.ID = 0
+	if err := c.retentionService.CreatePolicy(&policy); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, policy)
+}
+
+// UpdatePolicy updates a retention policy
+func (c *RetentionController) UpdatePolicy(ctx *gin.Context) {
+	policy := c.loadPolicy(ctx)
+	if policy == nil {
+		return
+	}
+
+	id, createdAt := policy.ID, policy.CreatedAt
+	if err := ctx.ShouldBindJSON(policy); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	policy.ID, policy.CreatedAt = id, createdAt
+
+	if err := c.retentionService.UpdatePolicy(policy); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, policy)
+}
+
+// DeletePolicy deletes a retention policy
+func (c *RetentionController) DeletePolicy(ctx *gin.Context) {
+	policy := c.loadPolicy(ctx)
+	if policy == nil {
+		return
+	}
+
+	if err := c.retentionService.DeletePolicy(policy.ID); err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete retention policy"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"message": "Retention policy deleted successfully"})
+}
+
+// PreviewPolicies shows how many raw hits every retention policy deletes, disabled ones included
+func (c *RetentionController) PreviewPolicies(ctx *gin.Context) {
+	previews, err := c.retentionService.PreviewPolicies()
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to preview retention policies"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, previews)
+}
+
+// PreviewPolicy shows how many raw hits of which websites a retention policy deletes
+func (c *RetentionController) PreviewPolicy(ctx *gin.Context) {
+	policy := c.loadPolicy(ctx)
+	if policy == nil {
+		return
+	}
+
+	preview, err := c.retentionService.PreviewPolicy(policy.ID)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to preview retention policy"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, preview)
+}
+
+// ApplyPolicies applies the enabled retention policies right away instead of waiting for the
+// scheduled run
+func (c *RetentionController) ApplyPolicies(ctx *gin.Context) {
+	results, err := c.retentionService.ApplyPolicies()
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to apply retention policies"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, results)
+}
+
+// loadPolicy loads the retention policy of the :id path parameter.
+// The error response is written and nil is returned when it doesn't exist.
+func (c *RetentionController) loadPolicy(ctx *gin.Context) *model.RetentionPolicy {
+	id, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid retention policy ID"})
+		return nil
+	}
+
+	policy, err := c.retentionService.GetPolicy(id)
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "Retention policy not found"})
+		return nil
+	}
+
+	return policy
+}
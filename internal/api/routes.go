@@ -16,6 +16,15 @@ func SetupRoutes(router *gin.Engine) {
 	userController := NewUserController()
 	websiteController := NewWebsiteController()
 	collectorController := NewCollectorController()
+	goalController := NewGoalController()
+	funnelController := NewFunnelController()
+	importController := NewImportController()
+	exportController := NewExportController()
+	retentionController := NewRetentionController()
+	segmentController := NewSegmentController()
+	pageController := NewPageController()
+	liveController := NewLiveController()
+	anomalyController := NewAnomalyController()
 
 	// Health check endpoint
 	router.GET("/api/health", func(c *gin.Context) {
@@ -32,6 +41,15 @@ func SetupRoutes(router *gin.Engine) {
 	// Tracking routes (no authentication required)
 	router.GET("/counter.js", collectorController.Counter)
 	router.GET("/collect", collectorController.Collect)
+	router.GET("/pixel.gif", collectorController.Pixel)
+	router.GET("/badge/:file", collectorController.Badge)
+	router.GET("/amp/config.json", collectorController.AMPConfig)
+	router.GET("/amp/collect", collectorController.AMPCollect)
+	router.POST("/amp/collect", collectorController.AMPCollect)
+	router.GET("/collect/ping", collectorController.Ping)
+	router.POST("/collect/ping", collectorController.Ping)
+	router.GET("/collect/event", collectorController.Event)
+	router.POST("/collect/purchase", collectorController.Purchase)
 
 	// Public website stats
 	router.GET("/api/websites/public", websiteController.ListPublicWebsites)
@@ -54,8 +72,57 @@ func SetupRoutes(router *gin.Engine) {
 		api.DELETE("/websites/:id", websiteController.DeleteWebsite)
 		api.GET("/websites/:id/tracking-code", websiteController.GetTrackingCode)
 		api.GET("/websites/:id/stats", websiteController.GetWebsiteStats)
+		api.GET("/websites/:id/trend", websiteController.GetWebsiteTrend)
+		api.GET("/websites/:id/timeseries", websiteController.GetWebsiteTimeSeries)
+		api.GET("/websites/:id/breakdown", websiteController.GetWebsiteBreakdown)
 		api.GET("/websites/:id/referer-stats", websiteController.GetWebsiteRefererStats)
 		api.GET("/websites/:id/device-stats", websiteController.GetWebsiteDeviceStats)
+		api.GET("/websites/:id/engagement-stats", websiteController.GetWebsiteEngagementStats)
+		api.GET("/websites/:id/retention", websiteController.GetWebsiteRetention)
+		api.GET("/websites/:id/ecommerce-stats", websiteController.GetWebsiteEcommerceStats)
+		api.GET("/websites/:id/live", liveController.Stream)
+
+		// Goal routes
+		api.GET("/websites/:id/goals", goalController.ListGoals)
+		api.POST("/websites/:id/goals", goalController.CreateGoal)
+		api.GET("/websites/:id/goals/report", goalController.GetGoalReport)
+		api.PUT("/websites/:id/goals/:goalId", goalController.UpdateGoal)
+		api.DELETE("/websites/:id/goals/:goalId", goalController.DeleteGoal)
+
+		// Funnel routes
+		api.GET("/websites/:id/funnels", funnelController.ListFunnels)
+		api.POST("/websites/:id/funnels", funnelController.CreateFunnel)
+		api.PUT("/websites/:id/funnels/:funnelId", funnelController.UpdateFunnel)
+		api.DELETE("/websites/:id/funnels/:funnelId", funnelController.DeleteFunnel)
+		api.GET("/websites/:id/funnels/:funnelId/report", funnelController.GetFunnelReport)
+
+		// Segment routes
+		api.GET("/websites/:id/segments", segmentController.ListSegments)
+		api.POST("/websites/:id/segments", segmentController.CreateSegment)
+		api.PUT("/websites/:id/segments/:segmentId", segmentController.UpdateSegment)
+		api.DELETE("/websites/:id/segments/:segmentId", segmentController.DeleteSegment)
+
+		// Page routes
+		api.GET("/websites/:id/pages", pageController.GetPageReport)
+		api.GET("/websites/:id/page-rules", pageController.ListPageRules)
+		api.POST("/websites/:id/page-rules", pageController.CreatePageRule)
+		api.PUT("/websites/:id/page-rules/:ruleId", pageController.UpdatePageRule)
+		api.DELETE("/websites/:id/page-rules/:ruleId", pageController.DeletePageRule)
+
+		// Anomaly routes
+		api.GET("/websites/:id/anomalies", anomalyController.ListAnomalies)
+		api.GET("/websites/:id/anomaly-channels", anomalyController.ListChannels)
+		api.POST("/websites/:id/anomaly-channels", anomalyController.CreateChannel)
+		api.PUT("/websites/:id/anomaly-channels/:channelId", anomalyController.UpdateChannel)
+		api.DELETE("/websites/:id/anomaly-channels/:channelId", anomalyController.DeleteChannel)
+		api.POST("/websites/:id/anomaly-channels/:channelId/test", anomalyController.TestChannel)
+
+		// Export routes
+		api.GET("/websites/:id/export", exportController.Export)
+		api.GET("/websites/:id/exports", exportController.ListExportJobs)
+		api.POST("/websites/:id/exports", exportController.CreateExportJob)
+		api.GET("/websites/:id/exports/:exportId", exportController.GetExportJob)
+		api.GET("/websites/:id/exports/:exportId/download", exportController.DownloadExport)
 	}
 
 	// Admin routes
@@ -77,6 +144,21 @@ func SetupRoutes(router *gin.Engine) {
 		admin.POST("/groups", userController.CreateGroup)
 		admin.PUT("/groups/:id", userController.UpdateGroup)
 		admin.DELETE("/groups/:id", userController.DeleteGroup)
+
+		// Historical data imports
+		admin.POST("/websites/:id/imports/access-log", importController.ImportAccessLog)
+		admin.POST("/websites/:id/imports/csv", importController.ImportCSV)
+		admin.GET("/websites/:id/imports", importController.ListImportJobs)
+		admin.GET("/imports/:id", importController.GetImportJob)
+
+		// Raw hit retention policies
+		admin.GET("/retention-policies", retentionController.ListPolicies)
+		admin.POST("/retention-policies", retentionController.CreatePolicy)
+		admin.GET("/retention-policies/preview", retentionController.PreviewPolicies)
+		admin.POST("/retention-policies/apply", retentionController.ApplyPolicies)
+		admin.PUT("/retention-policies/:id", retentionController.UpdatePolicy)
+		admin.DELETE("/retention-policies/:id", retentionController.DeletePolicy)
+		admin.GET("/retention-policies/:id/preview", retentionController.PreviewPolicy)
 	}
 
 	// Serve static files
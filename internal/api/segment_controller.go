@@ -0,0 +1,187 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"aq3stat/internal/model"
+	"aq3stat/internal/repository"
+	"aq3stat/internal/service"
+)
+
+// SegmentController handles segment related API endpoints
+type SegmentController struct {
+	websiteService *service.WebsiteService
+	segmentService *service.SegmentService
+}
+
+// NewSegmentController creates a new segment controller
+func NewSegmentController() *SegmentController {
+	return &SegmentController{
+		websiteService: service.NewWebsiteService(),
+		segmentService: service.NewSegmentService(),
+	}
+}
+
+// SegmentRequest represents a create or update segment request
+type SegmentRequest struct {
+	Name    string `json:"name" binding:"required"`
+	Filters string `json:"filters" binding:"required"` // Filter expression, e.g. province=~北京;visitor_type==returning
+}
+
+// ListSegments lists the segments of a website
+func (c *SegmentController) ListSegments(ctx *gin.Context) {
+	website := loadWebsite(ctx, c.websiteService, true)
+	if website == nil {
+		return
+	}
+
+	segments, err := c.segmentService.ListSegments(website.ID)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list segments"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, segments)
+}
+
+// CreateSegment creates a segment for a website
+func (c *SegmentController) CreateSegment(ctx *gin.Context) {
+	website := loadWebsite(ctx, c.websiteService, true)
+	if website == nil {
+		return
+	}
+
+	var req SegmentRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	segment := &model.Segment{
+		WebsiteID: website.ID,
+		Name:      req.Name,
+		Filters:   req.Filters,
+	}
+
+	err := c.segmentService.CreateSegment(segment)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, segment)
+}
+
+// UpdateSegment updates a segment of a website
+func (c *SegmentController) UpdateSegment(ctx *gin.Context) {
+	website := loadWebsite(ctx, c.websiteService, true)
+	if website == nil {
+		return
+	}
+
+	segment := loadSegment(ctx, c.segmentService, website, ctx.Param("segmentId"))
+	if segment == nil {
+		return
+	}
+
+	var req SegmentRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	segment.Name = req.Name
+	segment.Filters = req.Filters
+
+	err := c.segmentService.UpdateSegment(segment)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, segment)
+}
+
+// DeleteSegment deletes a segment of a website
+func (c *SegmentController) DeleteSegment(ctx *gin.Context) {
+	website := loadWebsite(ctx, c.websiteService, true)
+	if website == nil {
+		return
+	}
+
+	segment := loadSegment(ctx, c.segmentService, website, ctx.Param("segmentId"))
+	if segment == nil {
+		return
+	}
+
+	err := c.segmentService.DeleteSegment(segment.ID)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete segment"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"message": "Segment deleted successfully"})
+}
+
+// loadSegment loads a segment by ID and checks that it belongs to the website.
+// The error response is written and nil is returned when it doesn't.
+func loadSegment(ctx *gin.Context, segmentService *service.SegmentService, website *model.Website, idStr string) *model.Segment {
+	segmentID, err := strconv.Atoi(idStr)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid segment ID"})
+		return nil
+	}
+
+	segment, err := segmentService.GetSegmentByID(segmentID)
+	if err != nil || segment.WebsiteID != website.ID {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "Segment not found"})
+		return nil
+	}
+
+	return segment
+}
+
+// loadSegmentFilters loads the filters of the segment of the segment query parameter, nil when
+// there is none. The error response is written and false is returned when it can't be used.
+func loadSegmentFilters(ctx *gin.Context, segmentService *service.SegmentService, website *model.Website) ([]repository.StatFilter, bool) {
+	idStr := ctx.Query("segment")
+	if idStr == "" {
+		return nil, true
+	}
+
+	segment := loadSegment(ctx, segmentService, website, idStr)
+	if segment == nil {
+		return nil, false
+	}
+
+	filters, err := service.SegmentFilters(segment)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return nil, false
+	}
+
+	return filters, true
+}
+
+// loadComparedSegments loads the segments of the comma separated segments query parameter,
+// compared side by side in a report. The error response is written and false is returned when
+// one of them can't be used.
+func loadComparedSegments(ctx *gin.Context, segmentService *service.SegmentService, website *model.Website) ([]model.Segment, bool) {
+	var segments []model.Segment
+	for _, idStr := range splitList(ctx.Query("segments")) {
+		segment := loadSegment(ctx, segmentService, website, idStr)
+		if segment == nil {
+			return nil, false
+		}
+		segments = append(segments, *segment)
+	}
+
+	if err := service.ValidateComparedSegments(segments); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return nil, false
+	}
+
+	return segments, true
+}
@@ -3,6 +3,8 @@ package api
 import (
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"aq3stat/internal/model"
@@ -11,24 +13,30 @@ import (
 
 // WebsiteController handles website related API endpoints
 type WebsiteController struct {
-	websiteService *service.WebsiteService
-	statService    *service.StatService
+	websiteService   *service.WebsiteService
+	statService      *service.StatService
+	ecommerceService *service.EcommerceService
+	segmentService   *service.SegmentService
 }
 
 // NewWebsiteController creates a new website controller
 func NewWebsiteController() *WebsiteController {
 	return &WebsiteController{
-		websiteService: service.NewWebsiteService(),
-		statService:    service.NewStatService(),
+		websiteService:   service.NewWebsiteService(),
+		statService:      service.NewStatService(),
+		ecommerceService: service.NewEcommerceService(),
+		segmentService:   service.NewSegmentService(),
 	}
 }
 
 // CreateWebsiteRequest represents a create website request
 type CreateWebsiteRequest struct {
-	Name        string `json:"name" binding:"required"`
-	URL         string `json:"url" binding:"required"`
-	Description string `json:"description"`
-	IsPublic    bool   `json:"is_public"`
+	Name           string `json:"name" binding:"required"`
+	URL            string `json:"url" binding:"required"`
+	Description    string `json:"description"`
+	IsPublic       bool   `json:"is_public"`
+	TimeZone       string `json:"time_zone"`         // IANA time zone name, e.g. Asia/Shanghai
+	FirstDayOfWeek int    `json:"first_day_of_week"` // 1 (Monday) to 7 (Sunday)
 }
 
 // CreateWebsite creates a new website
@@ -47,11 +55,13 @@ func (c *WebsiteController) CreateWebsite(ctx *gin.Context) {
 	}
 
 	website := &model.Website{
-		UserID:      userID.(int),
-		Name:        req.Name,
-		URL:         req.URL,
-		Description: req.Description,
-		IsPublic:    req.IsPublic,
+		UserID:         userID.(int),
+		Name:           req.Name,
+		URL:            req.URL,
+		Description:    req.Description,
+		IsPublic:       req.IsPublic,
+		TimeZone:       req.TimeZone,
+		FirstDayOfWeek: req.FirstDayOfWeek,
 	}
 
 	err := c.websiteService.CreateWebsite(website)
@@ -140,6 +150,8 @@ func (c *WebsiteController) UpdateWebsite(ctx *gin.Context) {
 	website.URL = req.URL
 	website.Description = req.Description
 	website.IsPublic = req.IsPublic
+	website.TimeZone = req.TimeZone
+	website.FirstDayOfWeek = req.FirstDayOfWeek
 
 	err = c.websiteService.UpdateWebsite(website)
 	if err != nil {
@@ -272,7 +284,15 @@ func (c *WebsiteController) GetTrackingCode(ctx *gin.Context) {
 		}
 	}
 
-	trackingCode := c.websiteService.GenerateTrackingCode(website, iconType)
+	var trackingCode string
+	switch ctx.Query("type") {
+	case "email":
+		trackingCode = c.websiteService.GenerateEmailTrackingCode(website, ctx.Query("campaign"))
+	case "amp":
+		trackingCode = c.websiteService.GenerateAMPTrackingCode(website)
+	default:
+		trackingCode = c.websiteService.GenerateTrackingCode(website, iconType)
+	}
 
 	ctx.JSON(http.StatusOK, gin.H{"tracking_code": trackingCode})
 }
@@ -309,11 +329,17 @@ func (c *WebsiteController) GetWebsiteStats(ctx *gin.Context) {
 		}
 	}
 
+	// Restrict the stats to a segment
+	filters, ok := loadSegmentFilters(ctx, c.segmentService, website)
+	if !ok {
+		return
+	}
+
 	// Update click-in time
 	c.websiteService.UpdateClickInTime(id)
 
 	// Get stats
-	stats, err := c.statService.GetWebsiteStats(id)
+	stats, err := c.statService.GetWebsiteStats(id, filters)
 	if err != nil {
 		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get website stats"})
 		return
@@ -322,7 +348,8 @@ func (c *WebsiteController) GetWebsiteStats(ctx *gin.Context) {
 	ctx.JSON(http.StatusOK, stats)
 }
 
-// GetWebsiteRefererStats gets referer stats for a website
+// GetWebsiteRefererStats gets referer stats for a website, of all time or of a range of days compared to
+// another range, e.g. /api/websites/1/referer-stats?from=2024-01-01&to=2024-01-31&compare=previous_period
 func (c *WebsiteController) GetWebsiteRefererStats(ctx *gin.Context) {
 	idStr := ctx.Param("id")
 	id, err := strconv.Atoi(idStr)
@@ -354,8 +381,38 @@ func (c *WebsiteController) GetWebsiteRefererStats(ctx *gin.Context) {
 		}
 	}
 
+	// Restrict the stats to a segment
+	filters, ok := loadSegmentFilters(ctx, c.segmentService, website)
+	if !ok {
+		return
+	}
+
+	// Compare a range of days to another range
+	if ctx.Query("compare") != "" {
+		from, to, err := parseDateRange(ctx, website.Location())
+		if err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		comparison, err := parseComparison(ctx, from, to, website.Location())
+		if err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		stats, err := c.statService.CompareWebsiteRefererStats(id, from, to, filters, comparison)
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get website referer stats"})
+			return
+		}
+
+		ctx.JSON(http.StatusOK, stats)
+		return
+	}
+
 	// Get referer stats
-	stats, err := c.statService.GetWebsiteRefererStats(id)
+	stats, err := c.statService.GetWebsiteRefererStats(id, filters)
 	if err != nil {
 		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get website referer stats"})
 		return
@@ -364,7 +421,8 @@ func (c *WebsiteController) GetWebsiteRefererStats(ctx *gin.Context) {
 	ctx.JSON(http.StatusOK, stats)
 }
 
-// GetWebsiteDeviceStats gets device stats for a website
+// GetWebsiteDeviceStats gets device stats for a website, of all time or of a range of days compared to
+// another range, e.g. /api/websites/1/device-stats?from=2024-01-01&to=2024-01-31&compare=previous_year
 func (c *WebsiteController) GetWebsiteDeviceStats(ctx *gin.Context) {
 	idStr := ctx.Param("id")
 	id, err := strconv.Atoi(idStr)
@@ -396,8 +454,38 @@ func (c *WebsiteController) GetWebsiteDeviceStats(ctx *gin.Context) {
 		}
 	}
 
+	// Restrict the stats to a segment
+	filters, ok := loadSegmentFilters(ctx, c.segmentService, website)
+	if !ok {
+		return
+	}
+
+	// Compare a range of days to another range
+	if ctx.Query("compare") != "" {
+		from, to, err := parseDateRange(ctx, website.Location())
+		if err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		comparison, err := parseComparison(ctx, from, to, website.Location())
+		if err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		stats, err := c.statService.CompareWebsiteDeviceStats(id, from, to, filters, comparison)
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get website device stats"})
+			return
+		}
+
+		ctx.JSON(http.StatusOK, stats)
+		return
+	}
+
 	// Get device stats
-	stats, err := c.statService.GetWebsiteDeviceStats(id)
+	stats, err := c.statService.GetWebsiteDeviceStats(id, filters)
 	if err != nil {
 		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get website device stats"})
 		return
@@ -405,3 +493,259 @@ func (c *WebsiteController) GetWebsiteDeviceStats(ctx *gin.Context) {
 
 	ctx.JSON(http.StatusOK, stats)
 }
+
+// GetWebsiteTrend gets the daily IP and PV counts of a website, including imported history, optionally
+// compared to another range, e.g. /api/websites/1/trend?from=2024-01-01&to=2024-01-31&compare=previous_year
+func (c *WebsiteController) GetWebsiteTrend(ctx *gin.Context) {
+	website := loadWebsite(ctx, c.websiteService, false)
+	if website == nil {
+		return
+	}
+
+	from, to, err := parseDateRange(ctx, website.Location())
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if to.Sub(from) > 366*24*time.Hour {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Date range must not exceed 366 days"})
+		return
+	}
+
+	comparison, err := parseComparison(ctx, from, to, website.Location())
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	filters, ok := loadSegmentFilters(ctx, c.segmentService, website)
+	if !ok {
+		return
+	}
+
+	if comparison != nil {
+		trend, err := c.statService.CompareWebsiteTrendData(website.ID, from, to, filters, comparison)
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get website trend"})
+			return
+		}
+
+		ctx.JSON(http.StatusOK, trend)
+		return
+	}
+
+	trend, err := c.statService.GetWebsiteTrendData(website.ID, from, to, filters)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get website trend"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, trend)
+}
+
+// GetWebsiteTimeSeries gets metrics of a website per time bucket, optionally comparing up to three segments
+// and another range, e.g. /api/websites/1/timeseries?from=2024-01-01&to=2024-01-31&interval=day&metrics=ip,pv&segments=1,2
+// or ...&compare=custom&compare_from=2023-12-01&compare_to=2023-12-31
+func (c *WebsiteController) GetWebsiteTimeSeries(ctx *gin.Context) {
+	website := loadWebsite(ctx, c.websiteService, false)
+	if website == nil {
+		return
+	}
+
+	from, to, err := parseDateRange(ctx, website.Location())
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	interval := ctx.DefaultQuery("interval", "day")
+	metrics := splitList(ctx.DefaultQuery("metrics", "ip,pv"))
+	if err := service.ValidateTimeSeries(from, to, interval, metrics); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	comparison, err := parseComparison(ctx, from, to, website.Location())
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	filters, ok := loadSegmentFilters(ctx, c.segmentService, website)
+	if !ok {
+		return
+	}
+
+	segments, ok := loadComparedSegments(ctx, c.segmentService, website)
+	if !ok {
+		return
+	}
+
+	series, err := c.statService.GetTimeSeries(website.ID, from, to, interval, metrics, filters, segments, comparison)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get website time series"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, series)
+}
+
+// GetWebsiteBreakdown gets IP, PV and visits of a website per value of a dimension, optionally comparing up to
+// three segments and another range, e.g. /api/websites/1/breakdown?dimension=browser&filters=os==Windows;province=~广东&sort=-pv&limit=20&page=1&compare=previous_period
+func (c *WebsiteController) GetWebsiteBreakdown(ctx *gin.Context) {
+	website := loadWebsite(ctx, c.websiteService, false)
+	if website == nil {
+		return
+	}
+
+	from, to, err := parseDateRange(ctx, website.Location())
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	dimension := ctx.Query("dimension")
+	sort := ctx.DefaultQuery("sort", "-pv")
+	if err := service.ValidateBreakdown(dimension, from, to, sort); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	comparison, err := parseComparison(ctx, from, to, website.Location())
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	filters, ok := loadSegmentFilters(ctx, c.segmentService, website)
+	if !ok {
+		return
+	}
+
+	queryFilters, err := service.ParseStatFilters(ctx.Query("filters"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	filters = append(filters, queryFilters...)
+
+	segments, ok := loadComparedSegments(ctx, c.segmentService, website)
+	if !ok {
+		return
+	}
+
+	limit, err := strconv.Atoi(ctx.DefaultQuery("limit", "20"))
+	if err != nil || limit < 1 || limit > 100 {
+		limit = 20
+	}
+
+	page, err := strconv.Atoi(ctx.DefaultQuery("page", "1"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+
+	breakdown, err := c.statService.GetBreakdown(website.ID, dimension, from, to, filters, sort, page, limit, segments, comparison)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get website breakdown"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, breakdown)
+}
+
+// GetWebsiteEngagementStats gets scroll depth and read-through stats per page path for a website
+func (c *WebsiteController) GetWebsiteEngagementStats(ctx *gin.Context) {
+	website := loadWebsite(ctx, c.websiteService, false)
+	if website == nil {
+		return
+	}
+
+	from, to, err := parseDateRange(ctx, website.Location())
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	limit, err := strconv.Atoi(ctx.DefaultQuery("limit", "20"))
+	if err != nil || limit < 1 || limit > 100 {
+		limit = 20
+	}
+
+	filters, ok := loadSegmentFilters(ctx, c.segmentService, website)
+	if !ok {
+		return
+	}
+
+	stats, err := c.statService.GetWebsiteEngagementStats(website.ID, from, to, limit, filters)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get website engagement stats"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, stats)
+}
+
+// GetWebsiteRetention gets the weekly or monthly cohort retention table for a website
+func (c *WebsiteController) GetWebsiteRetention(ctx *gin.Context) {
+	website := loadWebsite(ctx, c.websiteService, false)
+	if website == nil {
+		return
+	}
+
+	period := ctx.DefaultQuery("period", service.RetentionPeriodWeek)
+	if period != service.RetentionPeriodWeek && period != service.RetentionPeriodMonth {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Period must be week or month"})
+		return
+	}
+
+	cohorts, err := strconv.Atoi(ctx.DefaultQuery("cohorts", "8"))
+	if err != nil || cohorts < 1 || cohorts > 24 {
+		cohorts = 8
+	}
+
+	filters, ok := loadSegmentFilters(ctx, c.segmentService, website)
+	if !ok {
+		return
+	}
+
+	report, err := c.statService.GetRetentionReport(website.ID, period, cohorts, filters)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get website retention"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, report)
+}
+
+// GetWebsiteEcommerceStats gets revenue, orders, top products and revenue by source for a website
+func (c *WebsiteController) GetWebsiteEcommerceStats(ctx *gin.Context) {
+	website := loadWebsite(ctx, c.websiteService, false)
+	if website == nil {
+		return
+	}
+
+	from, to, err := parseDateRange(ctx, website.Location())
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	limit, err := strconv.Atoi(ctx.DefaultQuery("limit", "10"))
+	if err != nil || limit < 1 || limit > 100 {
+		limit = 10
+	}
+
+	filters, ok := loadSegmentFilters(ctx, c.segmentService, website)
+	if !ok {
+		return
+	}
+
+	report, err := c.ecommerceService.GetEcommerceReport(website.ID, from, to, strings.ToUpper(ctx.Query("currency")), limit, filters)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get website e-commerce stats"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, report)
+}
@@ -0,0 +1,62 @@
+package model
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Anomaly types
+const (
+	AnomalyTypeSpike       = "SPIKE"        // Far more traffic than expected
+	AnomalyTypeDrop        = "DROP"         // Far less traffic than expected
+	AnomalyTypeZeroTraffic = "ZERO_TRAFFIC" // No traffic at all when some was expected, e.g. tracking broke
+)
+
+// Anomaly metrics
+const (
+	AnomalyMetricPV = "pv"
+	AnomalyMetricIP = "ip" // Visitors active in the hour
+)
+
+// Anomaly represents an hour of a website whose traffic deviated from its seasonal baseline,
+// the same hour of the week in the previous weeks
+type Anomaly struct {
+	ID         int        `gorm:"primaryKey;type:int" json:"id"`
+	WebsiteID  int        `gorm:"not null;type:int;uniqueIndex:idx_anomaly_key,priority:1" json:"website_id"`
+	Hour       time.Time  `gorm:"not null;uniqueIndex:idx_anomaly_key,priority:2" json:"hour"` // Start of the hour
+	Metric     string     `gorm:"size:10;not null;uniqueIndex:idx_anomaly_key,priority:3" json:"metric"`
+	Type       string     `gorm:"size:20;not null;index" json:"type"`
+	Value      int64      `json:"value"`
+	Expected   float64    `json:"expected"`  // Baseline of the hour
+	Deviation  float64    `json:"deviation"` // Usual deviation from the baseline
+	Score      float64    `json:"score"`     // Deviations of the value from the baseline, negative below it
+	NotifiedAt *time.Time `json:"notified_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// Anomaly notification channel types
+const (
+	AnomalyChannelWebhook = "WEBHOOK" // JSON POST to the URL in Target
+	AnomalyChannelEmail   = "EMAIL"   // Email to the comma separated addresses in Target
+)
+
+// AnomalyChannel represents a channel the anomalies of a website are notified on
+type AnomalyChannel struct {
+	ID        int            `gorm:"primaryKey;type:int" json:"id"`
+	WebsiteID int            `gorm:"not null;index;type:int" json:"website_id"`
+	Type      string         `gorm:"size:20;not null" json:"type"`
+	Target    string         `gorm:"size:1000;not null" json:"target"`
+	Secret    string         `gorm:"size:255" json:"-"` // Signs webhook requests, see the sink package
+	IsEnabled bool           `gorm:"default:true" json:"is_enabled"`
+	CreatedAt time.Time      `json:"created_at"`
+	UpdatedAt time.Time      `json:"updated_at"`
+	DeletedAt gorm.DeletedAt `gorm:"index" json:"-"`
+}
+
+// AnomalyState records up to which hour the traffic of a website is analyzed
+type AnomalyState struct {
+	WebsiteID     int       `gorm:"primaryKey;type:int;autoIncrement:false" json:"website_id"`
+	AnalyzedUntil time.Time `json:"analyzed_until"` // Start of the first hour that is not analyzed
+	UpdatedAt     time.Time `json:"updated_at"`
+}
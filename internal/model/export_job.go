@@ -0,0 +1,36 @@
+package model
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Export job statuses
+const (
+	ExportStatusPending = "PENDING"
+	ExportStatusRunning = "RUNNING"
+	ExportStatusDone    = "DONE"
+	ExportStatusFailed  = "FAILED"
+)
+
+// ExportJob represents a background export of a website's data into a downloadable file
+type ExportJob struct {
+	ID         int            `gorm:"primaryKey;type:int" json:"id"`
+	WebsiteID  int            `gorm:"not null;index;type:int" json:"website_id"`
+	UserID     int            `gorm:"type:int" json:"user_id"`        // User who requested the export
+	Report     string         `gorm:"size:20;not null" json:"report"` // stats for raw hits, or a report name
+	Format     string         `gorm:"size:10;not null" json:"format"`
+	Columns    string         `gorm:"size:1000" json:"columns"` // Comma separated, empty for all columns
+	StartTime  time.Time      `json:"from"`
+	EndTime    time.Time      `json:"to"` // Exclusive
+	Status     string         `gorm:"size:20;not null;index" json:"status"`
+	Rows       int64          `json:"rows"`
+	FilePath   string         `gorm:"size:255" json:"-"`
+	FileSize   int64          `json:"file_size"`
+	Error      string         `gorm:"size:500" json:"error"`
+	FinishedAt *time.Time     `json:"finished_at"`
+	CreatedAt  time.Time      `json:"created_at"`
+	UpdatedAt  time.Time      `json:"updated_at"`
+	DeletedAt  gorm.DeletedAt `gorm:"index" json:"-"`
+}
@@ -0,0 +1,36 @@
+package model
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Funnel step types
+const (
+	FunnelStepPage  = "PAGE"  // Page view whose path matches Pattern
+	FunnelStepEvent = "EVENT" // Custom event named Pattern
+)
+
+// Funnel represents an ordered list of steps visitors are expected to go through
+type Funnel struct {
+	ID        int            `gorm:"primaryKey;type:int" json:"id"`
+	WebsiteID int            `gorm:"not null;index;type:int" json:"website_id"`
+	Name      string         `gorm:"size:100;not null" json:"name"`
+	Steps     []FunnelStep   `gorm:"constraint:OnDelete:CASCADE" json:"steps"`
+	CreatedAt time.Time      `json:"created_at"`
+	UpdatedAt time.Time      `json:"updated_at"`
+	DeletedAt gorm.DeletedAt `gorm:"index" json:"-"`
+}
+
+// FunnelStep represents a single step of a funnel
+type FunnelStep struct {
+	ID        int       `gorm:"primaryKey;type:int" json:"id"`
+	FunnelID  int       `gorm:"not null;index;type:int" json:"funnel_id"`
+	Position  int       `gorm:"not null" json:"position"`
+	Name      string    `gorm:"size:100" json:"name"`
+	Type      string    `gorm:"size:20;not null" json:"type"`
+	Pattern   string    `gorm:"size:255;not null" json:"pattern"` // Path pattern (* wildcard) or event name
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
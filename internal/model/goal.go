@@ -0,0 +1,57 @@
+package model
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Goal types
+const (
+	GoalTypeURL      = "URL"      // Visit reaches a page matching Pattern
+	GoalTypeEvent    = "EVENT"    // Visit fires the custom event named Pattern
+	GoalTypeDuration = "DURATION" // Visit lasts at least Threshold seconds
+	GoalTypePages    = "PAGES"    // Visit views at least Threshold pages
+)
+
+// Goal represents a conversion goal defined for a website
+type Goal struct {
+	ID        int            `gorm:"primaryKey;type:int" json:"id"`
+	WebsiteID int            `gorm:"not null;index;type:int" json:"website_id"`
+	Name      string         `gorm:"size:100;not null" json:"name"`
+	Type      string         `gorm:"size:20;not null" json:"type"`
+	Pattern   string         `gorm:"size:255" json:"pattern"` // URL pattern (* wildcard) or event name
+	Threshold int            `gorm:"default:0" json:"threshold"`
+	Value     float64        `gorm:"default:0" json:"value"` // Revenue of a conversion in CNY when the event carries no value
+	IsEnabled bool           `gorm:"default:true" json:"is_enabled"`
+	CreatedAt time.Time      `json:"created_at"`
+	UpdatedAt time.Time      `json:"updated_at"`
+	DeletedAt gorm.DeletedAt `gorm:"index" json:"-"`
+}
+
+// Conversion represents a visit that reached a goal
+type Conversion struct {
+	ID        int            `gorm:"primaryKey;type:int" json:"id"`
+	GoalID    int            `gorm:"not null;uniqueIndex:idx_conversion_goal_stat;type:int" json:"goal_id"`
+	WebsiteID int            `gorm:"not null;index;type:int" json:"website_id"`
+	StatID    int            `gorm:"not null;uniqueIndex:idx_conversion_goal_stat;type:int" json:"stat_id"`
+	Time      time.Time      `gorm:"index" json:"time"`
+	Revenue   float64        `gorm:"default:0" json:"revenue"` // In CNY, see Goal.Value
+	CreatedAt time.Time      `json:"created_at"`
+	UpdatedAt time.Time      `json:"updated_at"`
+	DeletedAt gorm.DeletedAt `gorm:"index" json:"-"`
+}
+
+// Event represents a named custom event fired by a page
+type Event struct {
+	ID         int            `gorm:"primaryKey;type:int" json:"id"`
+	WebsiteID  int            `gorm:"not null;index;type:int" json:"website_id"`
+	StatID     int            `gorm:"index;type:int" json:"stat_id"`
+	PageViewID int            `gorm:"index;type:int" json:"page_view_id"`
+	Time       time.Time      `gorm:"index" json:"time"`
+	Name       string         `gorm:"size:100;not null;index" json:"name"`
+	Value      float64        `gorm:"default:0" json:"value"`
+	CreatedAt  time.Time      `json:"created_at"`
+	UpdatedAt  time.Time      `json:"updated_at"`
+	DeletedAt  gorm.DeletedAt `gorm:"index" json:"-"`
+}
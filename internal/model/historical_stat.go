@@ -0,0 +1,44 @@
+package model
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Historical stat sources, the analytics tool the data was exported from
+const (
+	HistoricalSourceGA        = "GA"
+	HistoricalSourceMatomo    = "MATOMO"
+	HistoricalSourceUmami     = "UMAMI"
+	HistoricalSourcePlausible = "PLAUSIBLE"
+)
+
+// Historical stat dimensions. Rows with an empty dimension hold the daily totals.
+const (
+	HistoricalDimensionTotal   = ""
+	HistoricalDimensionReferer = "referer" // Referer domain, like Stat.BaseReferer
+	HistoricalDimensionPage    = "page"    // Page path
+	HistoricalDimensionCountry = "country"
+	HistoricalDimensionDevice  = "device" // Desktop, mobile or tablet
+	HistoricalDimensionBrowser = "browser"
+	HistoricalDimensionOS      = "os"
+)
+
+// HistoricalStat represents pre-aggregated daily data imported from another analytics tool,
+// covering the period before tracking started
+type HistoricalStat struct {
+	ID          int            `gorm:"primaryKey;type:int" json:"id"`
+	WebsiteID   int            `gorm:"not null;type:int;uniqueIndex:idx_historical_stat_key,priority:1" json:"website_id"`
+	Date        time.Time      `gorm:"type:date;not null;uniqueIndex:idx_historical_stat_key,priority:2" json:"date"`
+	Dimension   string         `gorm:"size:20;not null;default:'';uniqueIndex:idx_historical_stat_key,priority:3" json:"dimension"`
+	Value       string         `gorm:"size:255;not null;default:'';uniqueIndex:idx_historical_stat_key,priority:4" json:"value"`
+	Source      string         `gorm:"size:20" json:"source"`
+	ImportJobID int            `gorm:"index;type:int" json:"import_job_id"`
+	Visitors    int64          `json:"visitors"`   // Counted as IP
+	PageViews   int64          `json:"page_views"` // Counted as PV
+	Visits      int64          `json:"visits"`
+	CreatedAt   time.Time      `json:"created_at"`
+	UpdatedAt   time.Time      `json:"updated_at"`
+	DeletedAt   gorm.DeletedAt `gorm:"index" json:"-"`
+}
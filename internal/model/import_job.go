@@ -0,0 +1,53 @@
+package model
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Import job types
+const (
+	ImportTypeAccessLog = "ACCESS_LOG" // Web server access log
+	ImportTypeCSV       = "CSV"        // Daily aggregate CSV export of another analytics tool
+)
+
+// Import job statuses
+const (
+	ImportStatusPending = "PENDING"
+	ImportStatusRunning = "RUNNING"
+	ImportStatusDone    = "DONE"
+	ImportStatusFailed  = "FAILED"
+)
+
+// ImportJob represents an import of historical data into a website
+type ImportJob struct {
+	ID         int            `gorm:"primaryKey;type:int" json:"id"`
+	WebsiteID  int            `gorm:"not null;index;type:int" json:"website_id"`
+	UserID     int            `gorm:"type:int" json:"user_id"` // User who started the import
+	Type       string         `gorm:"size:20;not null" json:"type"`
+	Format     string         `gorm:"size:500" json:"format"` // Log format, or source tool of CSV imports
+	FileName   string         `gorm:"size:255" json:"file_name"`
+	Status     string         `gorm:"size:20;not null;index" json:"status"`
+	TotalBytes int64          `json:"total_bytes"`
+	ReadBytes  int64          `json:"read_bytes"`
+	Lines      int            `json:"lines"`
+	Imported   int            `json:"imported"`
+	Duplicates int            `json:"duplicates"` // Lines imported by an earlier run
+	Filtered   int            `json:"filtered"`   // Static assets, bots, failed requests and CSV rows after tracking started
+	Failed     int            `json:"failed"`     // Lines that could not be parsed or collected
+	Error      string         `gorm:"size:500" json:"error"`
+	StartedAt  *time.Time     `json:"started_at"`
+	FinishedAt *time.Time     `json:"finished_at"`
+	CreatedAt  time.Time      `json:"created_at"`
+	UpdatedAt  time.Time      `json:"updated_at"`
+	DeletedAt  gorm.DeletedAt `gorm:"index" json:"-"`
+}
+
+// Progress returns the share of the file that has been read, between 0 and 100
+func (j *ImportJob) Progress() float64 {
+	if j.TotalBytes <= 0 {
+		return 0
+	}
+	return float64(j.ReadBytes) * 100 / float64(j.TotalBytes)
+}
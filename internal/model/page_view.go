@@ -0,0 +1,53 @@
+package model
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Page view sources
+const (
+	PageViewSourcePixel = "PIXEL" // <noscript> tracking pixel
+	PageViewSourceEmail = "EMAIL" // Email open tracking pixel
+	PageViewSourceAMP   = "AMP"   // amp-analytics on an AMP page
+)
+
+// PageView represents a single page view within a visit
+type PageView struct {
+	ID          int            `gorm:"primaryKey;type:int" json:"id"`
+	WebsiteID   int            `gorm:"not null;index;type:int" json:"website_id"`
+	StatID      int            `gorm:"index;type:int" json:"stat_id"`
+	PVID        string         `gorm:"column:pvid;size:32;not null;uniqueIndex" json:"pvid"` // Page view ID generated by counter.js
+	Time        time.Time      `gorm:"index" json:"time"`
+	LeaveTime   time.Time      `json:"leave_time"`
+	Path        string         `gorm:"size:255;index" json:"path"`
+	ScrollDepth int            `gorm:"default:0" json:"scroll_depth"` // Maximum scroll depth reached: 0, 25, 50, 75 or 100
+	Source      string         `gorm:"size:20" json:"source"`         // Empty for counter.js hits, PIXEL or EMAIL for image hits
+	Campaign    string         `gorm:"size:100;index" json:"campaign"`
+	ClientID    string         `gorm:"size:64;index" json:"client_id"` // Client ID of amp-analytics
+	CreatedAt   time.Time      `json:"created_at"`
+	UpdatedAt   time.Time      `json:"updated_at"`
+	DeletedAt   gorm.DeletedAt `gorm:"index" json:"-"`
+}
+
+// Page rule types
+const (
+	PageRuleTypeRegex         = "REGEX"          // Paths matching the regular expression Pattern are rewritten to Replacement
+	PageRuleTypeStripQuery    = "STRIP_QUERY"    // Strips the query parameters listed in Pattern (comma separated, * wildcard), all when empty
+	PageRuleTypeTrailingSlash = "TRAILING_SLASH" // Removes the trailing slash of paths other than /
+)
+
+// PageRule represents a page path normalization rule of a website, applied in ascending
+// position to the page paths when collected and when reported
+type PageRule struct {
+	ID          int            `gorm:"primaryKey;type:int" json:"id"`
+	WebsiteID   int            `gorm:"not null;index;type:int" json:"website_id"`
+	Type        string         `gorm:"size:20;not null" json:"type"`
+	Pattern     string         `gorm:"size:255" json:"pattern"`
+	Replacement string         `gorm:"size:255" json:"replacement"` // May refer to groups of Pattern as $1
+	Position    int            `gorm:"default:0" json:"position"`
+	CreatedAt   time.Time      `json:"created_at"`
+	UpdatedAt   time.Time      `json:"updated_at"`
+	DeletedAt   gorm.DeletedAt `gorm:"index" json:"-"`
+}
@@ -0,0 +1,35 @@
+package model
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Purchase represents an e-commerce order placed during a visit
+type Purchase struct {
+	ID        int            `gorm:"primaryKey;type:int" json:"id"`
+	WebsiteID int            `gorm:"not null;uniqueIndex:idx_purchase_website_order;type:int" json:"website_id"`
+	StatID    int            `gorm:"index;type:int" json:"stat_id"`
+	OrderID   string         `gorm:"size:100;not null;uniqueIndex:idx_purchase_website_order" json:"order_id"` // Order ID of the shop, used for deduplication
+	Time      time.Time      `gorm:"index" json:"time"`
+	Total     float64        `gorm:"default:0" json:"total"`
+	Currency  string         `gorm:"size:3" json:"currency"`
+	Items     []PurchaseItem `json:"items,omitempty"`
+	CreatedAt time.Time      `json:"created_at"`
+	UpdatedAt time.Time      `json:"updated_at"`
+	DeletedAt gorm.DeletedAt `gorm:"index" json:"-"`
+}
+
+// PurchaseItem represents a line item of a purchase
+type PurchaseItem struct {
+	ID         int       `gorm:"primaryKey;type:int" json:"id"`
+	PurchaseID int       `gorm:"not null;index;type:int" json:"purchase_id"`
+	SKU        string    `gorm:"column:sku;size:100;index" json:"sku"`
+	Name       string    `gorm:"size:255" json:"name"`
+	Category   string    `gorm:"size:100" json:"category"`
+	Price      float64   `gorm:"default:0" json:"price"`
+	Quantity   int       `gorm:"default:1" json:"quantity"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
@@ -0,0 +1,26 @@
+package model
+
+import (
+	"time"
+)
+
+// Retention policy scopes. The website policy of a website applies before the policy of its
+// owner's group, which applies before the global policy.
+const (
+	RetentionScopeGlobal  = "global"
+	RetentionScopeGroup   = "group"
+	RetentionScopeWebsite = "website"
+)
+
+// RetentionPolicy limits how long the raw hits (stats and page views) of websites are kept.
+// Rolled up stats are kept forever, raw hits are only purged once their days are rolled up.
+type RetentionPolicy struct {
+	ID        int       `gorm:"primaryKey;type:int" json:"id"`
+	Scope     string    `gorm:"size:10;not null;uniqueIndex:idx_retention_policy_scope,priority:1" json:"scope"`
+	ScopeID   int       `gorm:"not null;default:0;type:int;uniqueIndex:idx_retention_policy_scope,priority:2" json:"scope_id"` // Group or website ID, 0 for the global policy
+	RawDays   int       `gorm:"not null" json:"raw_days"`                                                                      // Closed days of raw hits kept, 0 keeps them forever
+	Archive   bool      `gorm:"default:false" json:"archive"`                                                                  // Archive raw hits to compressed files before purging them
+	Enabled   bool      `gorm:"default:false" json:"enabled"`                                                                  // Disabled policies are only previewed, never applied
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
@@ -0,0 +1,51 @@
+package model
+
+import (
+	"time"
+)
+
+// Rollup periods
+const (
+	RollupPeriodHour = "hour"
+	RollupPeriodDay  = "day"
+)
+
+// Rollup dimensions. Rows with an empty dimension hold the totals, hourly rollups only hold totals.
+const (
+	RollupDimensionTotal        = ""
+	RollupDimensionChannel      = "channel" // Referer channel, see the referer stats
+	RollupDimensionBaseReferer  = "base_referer"
+	RollupDimensionSearchEngine = "search_engine"
+	RollupDimensionKeyword      = "keyword"
+	RollupDimensionBrowser      = "browser"
+	RollupDimensionOS           = "os"
+	RollupDimensionOSLang       = "os_lang"
+	RollupDimensionScreenSize   = "screen_size"
+	RollupDimensionScreenColor  = "screen_color"
+	RollupDimensionProvince     = "province"
+	RollupDimensionISP          = "isp"
+)
+
+// StatRollup represents the pre-aggregated stats of a website for an hour or a day of the
+// website's time zone. Rollups only exist for closed days, see RollupState.
+type StatRollup struct {
+	ID        int       `gorm:"primaryKey;type:int" json:"id"`
+	WebsiteID int       `gorm:"not null;type:int;uniqueIndex:idx_stat_rollup_key,priority:1" json:"website_id"`
+	Period    string    `gorm:"size:10;not null;uniqueIndex:idx_stat_rollup_key,priority:2" json:"period"`
+	Bucket    string    `gorm:"size:19;not null;uniqueIndex:idx_stat_rollup_key,priority:3" json:"bucket"` // Local start, YYYY-MM-DD HH:00:00 for hours and YYYY-MM-DD for days
+	Dimension string    `gorm:"size:20;not null;default:'';uniqueIndex:idx_stat_rollup_key,priority:4" json:"dimension"`
+	Value     string    `gorm:"size:255;not null;default:'';uniqueIndex:idx_stat_rollup_key,priority:5" json:"value"`
+	Visitors  int64     `json:"visitors"`   // Stats rows, counted as IP
+	PageViews int64     `json:"page_views"` // Counted as PV
+	Bounces   int64     `json:"bounces"`    // Visits with a single page view
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// RollupState records up to which day the stats of a website are rolled up, and up to which
+// day its raw stats are purged by the retention policies
+type RollupState struct {
+	WebsiteID   int        `gorm:"primaryKey;type:int;autoIncrement:false" json:"website_id"`
+	RolledUntil time.Time  `json:"rolled_until"` // Start of the first day that is not rolled up
+	PurgedUntil *time.Time `json:"purged_until"` // Start of the first day whose raw stats are not purged, nil if none are
+	UpdatedAt   time.Time  `json:"updated_at"`
+}
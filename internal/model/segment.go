@@ -0,0 +1,19 @@
+package model
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Segment represents a named set of visit filters saved for a website, e.g. the mobile visitors
+// from Baidu. Any stats report of the website can be restricted to the visits of a segment.
+type Segment struct {
+	ID        int            `gorm:"primaryKey;type:int" json:"id"`
+	WebsiteID int            `gorm:"not null;index;type:int" json:"website_id"`
+	Name      string         `gorm:"size:100;not null" json:"name"`
+	Filters   string         `gorm:"size:1000;not null" json:"filters"` // Filter expression, e.g. device==移动设备;search_engine==Baidu
+	CreatedAt time.Time      `json:"created_at"`
+	UpdatedAt time.Time      `json:"updated_at"`
+	DeletedAt gorm.DeletedAt `gorm:"index" json:"-"`
+}
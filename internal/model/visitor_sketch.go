@@ -0,0 +1,20 @@
+package model
+
+import (
+	"time"
+)
+
+// SystemSketchWebsiteID is the website ID of the sketches of all websites
+const SystemSketchWebsiteID = 0
+
+// VisitorSketch represents the HyperLogLog sketch of the IPs of a website on a day of the
+// website's time zone, see the hll package. Sketches are built along with the rollups, so they
+// only exist for closed days. The sketches of all websites merge the sketches of the websites
+// for the same date.
+type VisitorSketch struct {
+	ID        int       `gorm:"primaryKey;type:int" json:"id"`
+	WebsiteID int       `gorm:"not null;type:int;uniqueIndex:idx_visitor_sketch_key,priority:1" json:"website_id"`
+	Date      string    `gorm:"size:10;not null;uniqueIndex:idx_visitor_sketch_key,priority:2" json:"date"` // YYYY-MM-DD
+	Sketch    []byte    `gorm:"type:blob;not null" json:"-"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
@@ -1,6 +1,7 @@
 package model
 
 import (
+	"sync"
 	"time"
 
 	"gorm.io/gorm"
@@ -8,19 +9,80 @@ import (
 
 // Website represents a website being tracked
 type Website struct {
-	ID          int            `gorm:"primaryKey;type:int" json:"id"`
-	UserID      int            `gorm:"not null;type:int" json:"user_id"`
-	User        *User          `json:"user,omitempty"`
-	Name        string         `gorm:"size:100;not null" json:"name"`
-	URL         string         `gorm:"size:255;not null" json:"url"`
-	Description string         `gorm:"size:255" json:"description"`
-	IsPublic    bool           `gorm:"default:false" json:"is_public"`
-	StartTime   time.Time      `json:"start_time"`
-	ClickInTime *time.Time     `json:"click_in_time"`
-	Stats       []Stat         `json:"stats,omitempty"`
-	CreatedAt   time.Time      `json:"created_at"`
-	UpdatedAt   time.Time      `json:"updated_at"`
-	DeletedAt   gorm.DeletedAt `gorm:"index" json:"-"`
+	ID             int            `gorm:"primaryKey;type:int" json:"id"`
+	UserID         int            `gorm:"not null;type:int" json:"user_id"`
+	User           *User          `json:"user,omitempty"`
+	Name           string         `gorm:"size:100;not null" json:"name"`
+	URL            string         `gorm:"size:255;not null" json:"url"`
+	Description    string         `gorm:"size:255" json:"description"`
+	IsPublic       bool           `gorm:"default:false" json:"is_public"`
+	StartTime      time.Time      `json:"start_time"`
+	ClickInTime    *time.Time     `json:"click_in_time"`
+	TimeZone       string         `gorm:"size:50" json:"time_zone"` // IANA time zone of days, weeks and months, empty for the server zone
+	FirstDayOfWeek int            `json:"first_day_of_week"`        // ISO weekday weeks start on, 1 = Monday ... 7 = Sunday, 0 for Monday
+	Stats          []Stat         `json:"stats,omitempty"`
+	CreatedAt      time.Time      `json:"created_at"`
+	UpdatedAt      time.Time      `json:"updated_at"`
+	DeletedAt      gorm.DeletedAt `gorm:"index" json:"-"`
+}
+
+// locations caches loaded time zones by name
+var locations sync.Map
+
+// Location returns the time zone of the website's days, weeks and months
+func (w *Website) Location() *time.Location {
+	if w.TimeZone == "" {
+		return time.Local
+	}
+
+	if loc, ok := locations.Load(w.TimeZone); ok {
+		return loc.(*time.Location)
+	}
+
+	loc, err := time.LoadLocation(w.TimeZone)
+	if err != nil {
+		return time.Local
+	}
+	locations.Store(w.TimeZone, loc)
+	return loc
+}
+
+// WeekStart returns the weekday the website's weeks start on
+func (w *Website) WeekStart() time.Weekday {
+	if w.FirstDayOfWeek < 1 || w.FirstDayOfWeek > 7 {
+		return time.Monday
+	}
+	return time.Weekday(w.FirstDayOfWeek % 7)
+}
+
+// Now returns the current time in the website's time zone
+func (w *Website) Now() time.Time {
+	return time.Now().In(w.Location())
+}
+
+// StartOfHour returns the start of the website's hour containing t, which differs from the UTC
+// hour in zones with a fractional offset
+func (w *Website) StartOfHour(t time.Time) time.Time {
+	t = t.In(w.Location())
+	return t.Add(-time.Duration(t.Minute())*time.Minute - time.Duration(t.Second())*time.Second - time.Duration(t.Nanosecond()))
+}
+
+// StartOfDay returns the start of the website's day containing t
+func (w *Website) StartOfDay(t time.Time) time.Time {
+	t = t.In(w.Location())
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+}
+
+// StartOfWeek returns the start of the website's week containing t
+func (w *Website) StartOfWeek(t time.Time) time.Time {
+	day := w.StartOfDay(t)
+	return day.AddDate(0, 0, -((int(day.Weekday()) - int(w.WeekStart()) + 7) % 7))
+}
+
+// StartOfMonth returns the start of the website's month containing t
+func (w *Website) StartOfMonth(t time.Time) time.Time {
+	day := w.StartOfDay(t)
+	return day.AddDate(0, 0, -(day.Day() - 1))
 }
 
 // Stat represents a single visit statistic record
@@ -0,0 +1,166 @@
+package repository
+
+import (
+	"time"
+
+	"aq3stat/internal/model"
+	"aq3stat/pkg/database"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// AnomalyRepository handles database operations for traffic anomalies
+type AnomalyRepository struct {
+	db *gorm.DB
+}
+
+// NewAnomalyRepository creates a new anomaly repository
+func NewAnomalyRepository() *AnomalyRepository {
+	return &AnomalyRepository{
+		db: database.DB,
+	}
+}
+
+// Create creates an anomaly unless one of the same hour and metric exists. It returns whether
+// the anomaly was created.
+func (r *AnomalyRepository) Create(anomaly *model.Anomaly) (bool, error) {
+	result := r.db.Clauses(clause.OnConflict{DoNothing: true}).Create(anomaly)
+	return result.RowsAffected > 0, result.Error
+}
+
+// Exists checks whether a website has an anomaly of a type and metric in an hour
+func (r *AnomalyRepository) Exists(websiteID int, hour time.Time, metric, anomalyType string) (bool, error) {
+	var count int64
+	err := r.db.Model(&model.Anomaly{}).
+		Where("website_id = ? AND hour = ? AND metric = ? AND type = ?", websiteID, hour, metric, anomalyType).
+		Count(&count).Error
+	return count > 0, err
+}
+
+// List lists the anomalies of a website in a time range with pagination, newest first and
+// optionally of a type only
+func (r *AnomalyRepository) List(websiteID int, from, to time.Time, anomalyType string, page, pageSize int) ([]model.Anomaly, int64, error) {
+	query := func() *gorm.DB {
+		query := r.db.Model(&model.Anomaly{}).Where("website_id = ? AND hour >= ? AND hour < ?", websiteID, from, to)
+		if anomalyType != "" {
+			query = query.Where("type = ?", anomalyType)
+		}
+		return query
+	}
+
+	var total int64
+	if err := query().Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	var anomalies []model.Anomaly
+	offset := (page - 1) * pageSize
+	err := query().Order("hour DESC, metric ASC").Offset(offset).Limit(pageSize).Find(&anomalies).Error
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return anomalies, total, nil
+}
+
+// ListUnnotified lists the anomalies of a website since an hour that weren't notified yet, oldest first
+func (r *AnomalyRepository) ListUnnotified(websiteID int, since time.Time) ([]model.Anomaly, error) {
+	var anomalies []model.Anomaly
+	err := r.db.Where("website_id = ? AND hour >= ? AND notified_at IS NULL", websiteID, since).
+		Order("hour ASC, metric ASC").Find(&anomalies).Error
+	if err != nil {
+		return nil, err
+	}
+	return anomalies, nil
+}
+
+// MarkNotified records the time anomalies were notified
+func (r *AnomalyRepository) MarkNotified(ids []int, notifiedAt time.Time) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	return r.db.Model(&model.Anomaly{}).Where("id IN ?", ids).Update("notified_at", notifiedAt).Error
+}
+
+// GetState gets the analysis state of a website, with a zero AnalyzedUntil if nothing is analyzed yet
+func (r *AnomalyRepository) GetState(websiteID int) (*model.AnomalyState, error) {
+	state := model.AnomalyState{WebsiteID: websiteID}
+	err := r.db.Where("website_id = ?", websiteID).Limit(1).Find(&state).Error
+	if err != nil {
+		return nil, err
+	}
+	return &state, nil
+}
+
+// SetAnalyzedUntil records that the hours of a website before until are analyzed
+func (r *AnomalyRepository) SetAnalyzedUntil(websiteID int, until time.Time) error {
+	return r.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "website_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"analyzed_until", "updated_at"}),
+	}).Create(&model.AnomalyState{WebsiteID: websiteID, AnalyzedUntil: until}).Error
+}
+
+// GetFirstPageViewTime gets the time of the first page view of a website, nil if there is none
+func (r *AnomalyRepository) GetFirstPageViewTime(websiteID int) (*time.Time, error) {
+	var first *time.Time
+	err := r.db.Model(&model.PageView{}).Where("website_id = ?", websiteID).Select("MIN(time)").Row().Scan(&first)
+	return first, err
+}
+
+// GetHourCounts gets the page views and the visitors active in a time range of a website,
+// counted from the page views so that hits are counted in the hour they happened
+func (r *AnomalyRepository) GetHourCounts(websiteID int, from, to time.Time) (int64, int64, error) {
+	var pv, ip int64
+	err := r.db.Model(&model.PageView{}).
+		Where("website_id = ? AND time >= ? AND time < ?", websiteID, from, to).
+		Select("COUNT(*), COUNT(DISTINCT stat_id)").
+		Row().Scan(&pv, &ip)
+	return pv, ip, err
+}
+
+// AnomalyChannelRepository handles database operations for anomaly notification channels
+type AnomalyChannelRepository struct {
+	db *gorm.DB
+}
+
+// NewAnomalyChannelRepository creates a new anomaly channel repository
+func NewAnomalyChannelRepository() *AnomalyChannelRepository {
+	return &AnomalyChannelRepository{
+		db: database.DB,
+	}
+}
+
+// Create creates a new anomaly channel
+func (r *AnomalyChannelRepository) Create(channel *model.AnomalyChannel) error {
+	return r.db.Create(channel).Error
+}
+
+// FindByID finds an anomaly channel by ID
+func (r *AnomalyChannelRepository) FindByID(id int) (*model.AnomalyChannel, error) {
+	var channel model.AnomalyChannel
+	err := r.db.First(&channel, id).Error
+	if err != nil {
+		return nil, err
+	}
+	return &channel, nil
+}
+
+// Update updates an anomaly channel
+func (r *AnomalyChannelRepository) Update(channel *model.AnomalyChannel) error {
+	return r.db.Save(channel).Error
+}
+
+// Delete deletes an anomaly channel
+func (r *AnomalyChannelRepository) Delete(id int) error {
+	return r.db.Delete(&model.AnomalyChannel{}, id).Error
+}
+
+// ListByWebsiteID lists the anomaly channels of a website
+func (r *AnomalyChannelRepository) ListByWebsiteID(websiteID int) ([]model.AnomalyChannel, error) {
+	var channels []model.AnomalyChannel
+	err := r.db.Where("website_id = ?", websiteID).Order("id ASC").Find(&channels).Error
+	if err != nil {
+		return nil, err
+	}
+	return channels, nil
+}
@@ -0,0 +1,49 @@
+package repository
+
+import (
+	"aq3stat/internal/model"
+	"aq3stat/pkg/database"
+	"gorm.io/gorm"
+)
+
+// ExportJobRepository handles database operations for export jobs
+type ExportJobRepository struct {
+	db *gorm.DB
+}
+
+// NewExportJobRepository creates a new export job repository
+func NewExportJobRepository() *ExportJobRepository {
+	return &ExportJobRepository{
+		db: database.DB,
+	}
+}
+
+// Create creates a new export job
+func (r *ExportJobRepository) Create(job *model.ExportJob) error {
+	return r.db.Create(job).Error
+}
+
+// FindByID finds an export job by ID
+func (r *ExportJobRepository) FindByID(id int) (*model.ExportJob, error) {
+	var job model.ExportJob
+	err := r.db.First(&job, id).Error
+	if err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// Update updates an export job
+func (r *ExportJobRepository) Update(job *model.ExportJob) error {
+	return r.db.Save(job).Error
+}
+
+// ListByWebsiteID lists the export jobs of a website, newest first
+func (r *ExportJobRepository) ListByWebsiteID(websiteID int) ([]model.ExportJob, error) {
+	var jobs []model.ExportJob
+	err := r.db.Where("website_id = ?", websiteID).Order("id DESC").Find(&jobs).Error
+	if err != nil {
+		return nil, err
+	}
+	return jobs, nil
+}
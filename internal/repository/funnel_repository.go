@@ -0,0 +1,75 @@
+package repository
+
+import (
+	"aq3stat/internal/model"
+	"aq3stat/pkg/database"
+	"gorm.io/gorm"
+)
+
+// FunnelRepository handles database operations for funnels
+type FunnelRepository struct {
+	db *gorm.DB
+}
+
+// NewFunnelRepository creates a new funnel repository
+func NewFunnelRepository() *FunnelRepository {
+	return &FunnelRepository{
+		db: database.DB,
+	}
+}
+
+// Create creates a new funnel with its steps
+func (r *FunnelRepository) Create(funnel *model.Funnel) error {
+	return r.db.Create(funnel).Error
+}
+
+// FindByID finds a funnel by ID with its steps in order
+func (r *FunnelRepository) FindByID(id int) (*model.Funnel, error) {
+	var funnel model.Funnel
+	err := r.db.Preload("Steps", func(db *gorm.DB) *gorm.DB {
+		return db.Order("position ASC")
+	}).First(&funnel, id).Error
+	if err != nil {
+		return nil, err
+	}
+	return &funnel, nil
+}
+
+// Update updates a funnel and replaces its steps
+func (r *FunnelRepository) Update(funnel *model.Funnel) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Where("funnel_id = ?", funnel.ID).Delete(&model.FunnelStep{}).Error
+		if err != nil {
+			return err
+		}
+
+		for i := range funnel.Steps {
+			funnel.Steps[i].ID = 0
+			funnel.Steps[i].FunnelID = funnel.ID
+		}
+
+		return tx.Session(&gorm.Session{FullSaveAssociations: true}).Save(funnel).Error
+	})
+}
+
+// Delete deletes a funnel and its steps
+func (r *FunnelRepository) Delete(id int) error {
+	err := r.db.Where("funnel_id = ?", id).Delete(&model.FunnelStep{}).Error
+	if err != nil {
+		return err
+	}
+
+	return r.db.Delete(&model.Funnel{}, id).Error
+}
+
+// ListByWebsiteID returns all funnels of a website with their steps
+func (r *FunnelRepository) ListByWebsiteID(websiteID int) ([]model.Funnel, error) {
+	var funnels []model.Funnel
+	err := r.db.Preload("Steps", func(db *gorm.DB) *gorm.DB {
+		return db.Order("position ASC")
+	}).Where("website_id = ?", websiteID).Order("id ASC").Find(&funnels).Error
+	if err != nil {
+		return nil, err
+	}
+	return funnels, nil
+}
@@ -0,0 +1,110 @@
+package repository
+
+import (
+	"aq3stat/internal/model"
+	"aq3stat/pkg/database"
+	"gorm.io/gorm"
+)
+
+// GoalRepository handles database operations for goals
+type GoalRepository struct {
+	db *gorm.DB
+}
+
+// NewGoalRepository creates a new goal repository
+func NewGoalRepository() *GoalRepository {
+	return &GoalRepository{
+		db: database.DB,
+	}
+}
+
+// Create creates a new goal
+func (r *GoalRepository) Create(goal *model.Goal) error {
+	return r.db.Create(goal).Error
+}
+
+// FindByID finds a goal by ID
+func (r *GoalRepository) FindByID(id int) (*model.Goal, error) {
+	var goal model.Goal
+	err := r.db.First(&goal, id).Error
+	if err != nil {
+		return nil, err
+	}
+	return &goal, nil
+}
+
+// Update updates a goal
+func (r *GoalRepository) Update(goal *model.Goal) error {
+	return r.db.Save(goal).Error
+}
+
+// Delete deletes a goal and its conversions
+func (r *GoalRepository) Delete(id int) error {
+	err := r.db.Where("goal_id = ?", id).Delete(&model.Conversion{}).Error
+	if err != nil {
+		return err
+	}
+
+	return r.db.Delete(&model.Goal{}, id).Error
+}
+
+// ListByWebsiteID returns all goals of a website
+func (r *GoalRepository) ListByWebsiteID(websiteID int) ([]model.Goal, error) {
+	var goals []model.Goal
+	err := r.db.Where("website_id = ?", websiteID).Order("id ASC").Find(&goals).Error
+	if err != nil {
+		return nil, err
+	}
+	return goals, nil
+}
+
+// ListEnabledByWebsiteID returns the enabled goals of a website
+func (r *GoalRepository) ListEnabledByWebsiteID(websiteID int) ([]model.Goal, error) {
+	var goals []model.Goal
+	err := r.db.Where("website_id = ? AND is_enabled = ?", websiteID, true).Find(&goals).Error
+	if err != nil {
+		return nil, err
+	}
+	return goals, nil
+}
+
+// ConversionRepository handles database operations for conversions
+type ConversionRepository struct {
+	db *gorm.DB
+}
+
+// NewConversionRepository creates a new conversion repository
+func NewConversionRepository() *ConversionRepository {
+	return &ConversionRepository{
+		db: database.DB,
+	}
+}
+
+// Create creates a new conversion
+func (r *ConversionRepository) Create(conversion *model.Conversion) error {
+	return r.db.Create(conversion).Error
+}
+
+// Exists checks if a visit already converted for a goal
+func (r *ConversionRepository) Exists(goalID, statID int) bool {
+	var count int64
+	r.db.Model(&model.Conversion{}).Where("goal_id = ? AND stat_id = ?", goalID, statID).Count(&count)
+	return count > 0
+}
+
+// EventRepository handles database operations for custom events
+type EventRepository struct {
+	db *gorm.DB
+}
+
+// NewEventRepository creates a new event repository
+func NewEventRepository() *EventRepository {
+	return &EventRepository{
+		db: database.DB,
+	}
+}
+
+// Create creates a new event
+func (r *EventRepository) Create(event *model.Event) error {
+	return r.db.Create(event).Error
+}
@@ -0,0 +1,39 @@
+package repository
+
+import (
+	"aq3stat/internal/model"
+	"aq3stat/pkg/database"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// HistoricalStatRepository handles database operations for imported historical stats
+type HistoricalStatRepository struct {
+	db *gorm.DB
+}
+
+// NewHistoricalStatRepository creates a new historical stat repository
+func NewHistoricalStatRepository() *HistoricalStatRepository {
+	return &HistoricalStatRepository{
+		db: database.DB,
+	}
+}
+
+// Upsert stores historical stats, replacing the rows of the same website, date, dimension and value
+func (r *HistoricalStatRepository) Upsert(stats []model.HistoricalStat) error {
+	if len(stats) == 0 {
+		return nil
+	}
+
+	return r.db.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "website_id"}, {Name: "date"}, {Name: "dimension"}, {Name: "value"}},
+		DoUpdates: clause.AssignmentColumns([]string{
+			"source", "import_job_id", "visitors", "page_views", "visits", "updated_at", "deleted_at",
+		}),
+	}).CreateInBatches(stats, 500).Error
+}
+
+// DeleteByWebsiteID deletes all historical stats of a website
+func (r *HistoricalStatRepository) DeleteByWebsiteID(websiteID int) error {
+	return r.db.Unscoped().Where("website_id = ?", websiteID).Delete(&model.HistoricalStat{}).Error
+}
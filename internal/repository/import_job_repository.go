@@ -0,0 +1,49 @@
+package repository
+
+import (
+	"aq3stat/internal/model"
+	"aq3stat/pkg/database"
+	"gorm.io/gorm"
+)
+
+// ImportJobRepository handles database operations for import jobs
+type ImportJobRepository struct {
+	db *gorm.DB
+}
+
+// NewImportJobRepository creates a new import job repository
+func NewImportJobRepository() *ImportJobRepository {
+	return &ImportJobRepository{
+		db: database.DB,
+	}
+}
+
+// Create creates a new import job
+func (r *ImportJobRepository) Create(job *model.ImportJob) error {
+	return r.db.Create(job).Error
+}
+
+// FindByID finds an import job by ID
+func (r *ImportJobRepository) FindByID(id int) (*model.ImportJob, error) {
+	var job model.ImportJob
+	err := r.db.First(&job, id).Error
+	if err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// Update updates an import job
+func (r *ImportJobRepository) Update(job *model.ImportJob) error {
+	return r.db.Save(job).Error
+}
+
+// ListByWebsiteID lists the import jobs of a website, newest first
+func (r *ImportJobRepository) ListByWebsiteID(websiteID int) ([]model.ImportJob, error) {
+	var jobs []model.ImportJob
+	err := r.db.Where("website_id = ?", websiteID).Order("id DESC").Find(&jobs).Error
+	if err != nil {
+		return nil, err
+	}
+	return jobs, nil
+}
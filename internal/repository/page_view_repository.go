@@ -0,0 +1,86 @@
+package repository
+
+import (
+	"aq3stat/internal/model"
+	"aq3stat/pkg/database"
+	"gorm.io/gorm"
+)
+
+// PageViewRepository handles database operations for page views
+type PageViewRepository struct {
+	db *gorm.DB
+}
+
+// NewPageViewRepository creates a new page view repository
+func NewPageViewRepository() *PageViewRepository {
+	return &PageViewRepository{
+		db: database.DB,
+	}
+}
+
+// Create creates a new page view record
+func (r *PageViewRepository) Create(pageView *model.PageView) error {
+	return r.db.Create(pageView).Error
+}
+
+// FindByPVID finds a page view of a website by its client generated ID
+func (r *PageViewRepository) FindByPVID(websiteID int, pvid string) (*model.PageView, error) {
+	var pageView model.PageView
+	err := r.db.Where("website_id = ? AND pvid = ?", websiteID, pvid).First(&pageView).Error
+	if err != nil {
+		return nil, err
+	}
+	return &pageView, nil
+}
+
+// Update updates a page view
+func (r *PageViewRepository) Update(pageView *model.PageView) error {
+	return r.db.Save(pageView).Error
+}
+
+// PageRuleRepository handles database operations for page path normalization rules
+type PageRuleRepository struct {
+	db *gorm.DB
+}
+
+// NewPageRuleRepository creates a new page rule repository
+func NewPageRuleRepository() *PageRuleRepository {
+	return &PageRuleRepository{
+		db: database.DB,
+	}
+}
+
+// Create creates a new page rule
+func (r *PageRuleRepository) Create(rule *model.PageRule) error {
+	return r.db.Create(rule).Error
+}
+
+// FindByID finds a page rule by ID
+func (r *PageRuleRepository) FindByID(id int) (*model.PageRule, error) {
+	var rule model.PageRule
+	err := r.db.First(&rule, id).Error
+	if err != nil {
+		return nil, err
+	}
+	return &rule, nil
+}
+
+// Update updates a page rule
+func (r *PageRuleRepository) Update(rule *model.PageRule) error {
+	return r.db.Save(rule).Error
+}
+
+// Delete deletes a page rule
+func (r *PageRuleRepository) Delete(id int) error {
+	return r.db.Delete(&model.PageRule{}, id).Error
+}
+
+// ListByWebsiteID returns the page rules of a website in the order they are applied
+func (r *PageRuleRepository) ListByWebsiteID(websiteID int) ([]model.PageRule, error) {
+	var rules []model.PageRule
+	err := r.db.Where("website_id = ?", websiteID).Order("position ASC, id ASC").Find(&rules).Error
+	if err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
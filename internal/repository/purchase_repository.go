@@ -0,0 +1,31 @@
+package repository
+
+import (
+	"aq3stat/internal/model"
+	"aq3stat/pkg/database"
+	"gorm.io/gorm"
+)
+
+// PurchaseRepository handles database operations for purchases
+type PurchaseRepository struct {
+	db *gorm.DB
+}
+
+// NewPurchaseRepository creates a new purchase repository
+func NewPurchaseRepository() *PurchaseRepository {
+	return &PurchaseRepository{
+		db: database.DB,
+	}
+}
+
+// Create creates a new purchase with its items
+func (r *PurchaseRepository) Create(purchase *model.Purchase) error {
+	return r.db.Create(purchase).Error
+}
+
+// ExistsByOrderID checks if an order of a website has already been recorded
+func (r *PurchaseRepository) ExistsByOrderID(websiteID int, orderID string) bool {
+	var count int64
+	r.db.Model(&model.Purchase{}).Where("website_id = ? AND order_id = ?", websiteID, orderID).Count(&count)
+	return count > 0
+}
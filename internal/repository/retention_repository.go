@@ -0,0 +1,147 @@
+package repository
+
+import (
+	"time"
+
+	"aq3stat/internal/model"
+	"aq3stat/pkg/database"
+	"gorm.io/gorm"
+)
+
+// purgeBatchSize is the number of raw hits deleted per statement
+const purgeBatchSize = 10000
+
+// unreferencedStatSQL limits stats to those without conversions, purchases or events, which
+// are kept with their stat for the goal, funnel and e-commerce reports
+const unreferencedStatSQL = ` AND NOT EXISTS (SELECT 1 FROM conversions WHERE conversions.stat_id = stats.id)
+	AND NOT EXISTS (SELECT 1 FROM purchases WHERE purchases.stat_id = stats.id)
+	AND NOT EXISTS (SELECT 1 FROM events WHERE events.stat_id = stats.id)`
+
+// RetentionRepository handles database operations for retention policies and raw hit purges
+type RetentionRepository struct {
+	db *gorm.DB
+}
+
+// NewRetentionRepository creates a new retention repository
+func NewRetentionRepository() *RetentionRepository {
+	return &RetentionRepository{
+		db: database.DB,
+	}
+}
+
+// Create creates a new retention policy
+func (r *RetentionRepository) Create(policy *model.RetentionPolicy) error {
+	return r.db.Create(policy).Error
+}
+
+// FindByID finds a retention policy by ID
+func (r *RetentionRepository) FindByID(id int) (*model.RetentionPolicy, error) {
+	var policy model.RetentionPolicy
+	err := r.db.First(&policy, id).Error
+	if err != nil {
+		return nil, err
+	}
+	return &policy, nil
+}
+
+// FindByScope finds the retention policy of a scope
+func (r *RetentionRepository) FindByScope(scope string, scopeID int) (*model.RetentionPolicy, error) {
+	var policy model.RetentionPolicy
+	err := r.db.Where("scope = ? AND scope_id = ?", scope, scopeID).First(&policy).Error
+	if err != nil {
+		return nil, err
+	}
+	return &policy, nil
+}
+
+// Update updates a retention policy
+func (r *RetentionRepository) Update(policy *model.RetentionPolicy) error {
+	return r.db.Save(policy).Error
+}
+
+// Delete deletes a retention policy
+func (r *RetentionRepository) Delete(id int) error {
+	return r.db.Delete(&model.RetentionPolicy{}, id).Error
+}
+
+// List returns all retention policies
+func (r *RetentionRepository) List() ([]model.RetentionPolicy, error) {
+	var policies []model.RetentionPolicy
+	err := r.db.Order("scope, scope_id").Find(&policies).Error
+	if err != nil {
+		return nil, err
+	}
+	return policies, nil
+}
+
+// CountRawHits counts the stats and page views of a website before a time that PurgeRawHits deletes
+func (r *RetentionRepository) CountRawHits(websiteID int, before time.Time) (int64, int64, error) {
+	var stats, pageViews int64
+
+	err := r.db.Unscoped().Model(&model.Stat{}).Where("website_id = ? AND time < ?"+unreferencedStatSQL, websiteID, before).Count(&stats).Error
+	if err != nil {
+		return 0, 0, err
+	}
+
+	err = r.db.Unscoped().Model(&model.PageView{}).Where("website_id = ? AND time < ?", websiteID, before).Count(&pageViews).Error
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return stats, pageViews, nil
+}
+
+// EachPageView calls fn for every page view of a website before a time in time order,
+// without loading them all into memory
+func (r *RetentionRepository) EachPageView(websiteID int, before time.Time, fn func(pageView *model.PageView) error) error {
+	rows, err := r.db.Model(&model.PageView{}).
+		Where("website_id = ? AND time < ?", websiteID, before).
+		Order("time, id").
+		Rows()
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var pageView model.PageView
+		if err := r.db.ScanRows(rows, &pageView); err != nil {
+			return err
+		}
+		if err := fn(&pageView); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// PurgeRawHits permanently deletes the stats and page views of a website before a time, in
+// batches to keep the locks short. Stats with conversions, purchases or events are kept. It
+// returns the number of deleted stats and page views.
+func (r *RetentionRepository) PurgeRawHits(websiteID int, before time.Time) (int64, int64, error) {
+	stats, err := r.purge("stats", unreferencedStatSQL, websiteID, before)
+	if err != nil {
+		return stats, 0, err
+	}
+
+	pageViews, err := r.purge("page_views", "", websiteID, before)
+	return stats, pageViews, err
+}
+
+// purge deletes the rows of a website before a time from a table with website_id and time
+// columns, limited by an extra condition
+func (r *RetentionRepository) purge(table, condition string, websiteID int, before time.Time) (int64, error) {
+	var total int64
+	for {
+		result := r.db.Exec("DELETE FROM "+table+" WHERE website_id = ? AND time < ?"+condition+" LIMIT ?", websiteID, before, purgeBatchSize)
+		if result.Error != nil {
+			return total, result.Error
+		}
+
+		total += result.RowsAffected
+		if result.RowsAffected < purgeBatchSize {
+			return total, nil
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package repository
+
+import (
+	"time"
+
+	"aq3stat/internal/model"
+	"aq3stat/pkg/database"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// rollupDimensions maps the rolled up dimensions to the stats column expression they group by
+var rollupDimensions = []struct {
+	dimension string
+	column    string
+}{
+	{model.RollupDimensionChannel, refererChannelExpr("referer")},
+	{model.RollupDimensionBaseReferer, "base_referer"},
+	{model.RollupDimensionSearchEngine, "search_engine"},
+	{model.RollupDimensionKeyword, "keyword"},
+	{model.RollupDimensionBrowser, "browser"},
+	{model.RollupDimensionOS, "os"},
+	{model.RollupDimensionOSLang, "os_lang"},
+	{model.RollupDimensionScreenSize, "screen_size"},
+	{model.RollupDimensionScreenColor, "CAST(screen_color AS CHAR)"},
+	{model.RollupDimensionProvince, "province"},
+	{model.RollupDimensionISP, "isp"},
+}
+
+// RollupRepository handles database operations for stat rollups
+type RollupRepository struct {
+	db *gorm.DB
+}
+
+// NewRollupRepository creates a new rollup repository
+func NewRollupRepository() *RollupRepository {
+	return &RollupRepository{
+		db: database.DB,
+	}
+}
+
+// GetState gets the rollup state of a website, with a zero RolledUntil if nothing is rolled up yet
+func (r *RollupRepository) GetState(websiteID int) (*model.RollupState, error) {
+	state := model.RollupState{WebsiteID: websiteID}
+	err := r.db.Where("website_id = ?", websiteID).Limit(1).Find(&state).Error
+	if err != nil {
+		return nil, err
+	}
+	return &state, nil
+}
+
+// GetFirstStatTime gets the time of the first stat of a website, nil if there is none
+func (r *RollupRepository) GetFirstStatTime(websiteID int) (*time.Time, error) {
+	var first *time.Time
+	err := r.db.Model(&model.Stat{}).Where("website_id = ?", websiteID).Select("MIN(time)").Row().Scan(&first)
+	return first, err
+}
+
+// Rollup replaces the rollups and visitor sketches of a website for the days in a half-open
+// range and marks the days as rolled up. The range must be given as day starts in the website's
+// time zone.
+func (r *RollupRepository) Rollup(websiteID int, from, to time.Time) error {
+	loc := from.Location()
+	fromDay := dateOf(from)
+	toDay := dateOf(to.In(loc))
+	localTime := localTimeExpr("time", loc, from, to)
+	now := time.Now()
+
+	insert := func(tx *gorm.DB, period, bucketFormat, dimension, column string) error {
+		return tx.Exec(`
+			INSERT INTO stat_rollups (website_id, period, bucket, dimension, value, visitors, page_views, bounces, created_at)
+			SELECT ?, ?, DATE_FORMAT(`+localTime+`, '`+bucketFormat+`') as rollup_bucket, ?, LEFT(COALESCE(`+column+`, ''), 255) as rollup_value,
+				COUNT(*), SUM(count), SUM(CASE WHEN count = 1 THEN 1 ELSE 0 END), ?
+			FROM stats
+			WHERE website_id = ? AND time >= ? AND time < ? AND deleted_at IS NULL
+			GROUP BY rollup_bucket, rollup_value
+		`, websiteID, period, dimension, now, websiteID, from, to).Error
+	}
+
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		// Hour and day buckets of the range both sort between its first and last day
+		err := tx.Where("website_id = ? AND bucket >= ? AND bucket < ?", websiteID, fromDay, toDay).
+			Delete(&model.StatRollup{}).Error
+		if err != nil {
+			return err
+		}
+
+		if err := insert(tx, model.RollupPeriodHour, "%Y-%m-%d %H:00:00", model.RollupDimensionTotal, "''"); err != nil {
+			return err
+		}
+		if err := insert(tx, model.RollupPeriodDay, "%Y-%m-%d", model.RollupDimensionTotal, "''"); err != nil {
+			return err
+		}
+		for _, d := range rollupDimensions {
+			if err := insert(tx, model.RollupPeriodDay, "%Y-%m-%d", d.dimension, d.column); err != nil {
+				return err
+			}
+		}
+
+		sketches, err := buildSketches(tx, websiteID, from, to)
+		if err != nil {
+			return err
+		}
+		if err := saveSketches(tx, websiteID, fromDay, toDay, sketches); err != nil {
+			return err
+		}
+
+		return tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "website_id"}},
+			DoUpdates: clause.AssignmentColumns([]string{"rolled_until", "updated_at"}),
+		}).Create(&model.RollupState{WebsiteID: websiteID, RolledUntil: to}).Error
+	})
+}
+
+// Invalidate marks the days of a website from the day starting at from as not rolled up,
+// so that they are rolled up again with the stats written to them since. Purged days stay
+// rolled up, their rollups can't be rebuilt.
+func (r *RollupRepository) Invalidate(websiteID int, from time.Time) error {
+	return r.db.Model(&model.RollupState{}).
+		Where("website_id = ? AND rolled_until > ?", websiteID, from).
+		Update("rolled_until", gorm.Expr("GREATEST(?, COALESCE(purged_until, ?))", from, from)).Error
+}
+
+// SetPurgedUntil records that the raw stats of a website before the day starting at until are
+// purged. Purged days stay purged when until is before an earlier purge.
+func (r *RollupRepository) SetPurgedUntil(websiteID int, until time.Time) error {
+	return r.db.Model(&model.RollupState{}).
+		Where("website_id = ?", websiteID).
+		Update("purged_until", gorm.Expr("GREATEST(COALESCE(purged_until, ?), ?)", until, until)).Error
+}
+
+// rolledUntil gets the start of the first day of a website that is not rolled up, zero if none is
+func rolledUntil(db *gorm.DB, websiteID int) (time.Time, error) {
+	var state model.RollupState
+	err := db.Where("website_id = ?", websiteID).Limit(1).Find(&state).Error
+	return state.RolledUntil, err
+}
@@ -0,0 +1,54 @@
+package repository
+
+import (
+	"aq3stat/internal/model"
+	"aq3stat/pkg/database"
+	"gorm.io/gorm"
+)
+
+// SegmentRepository handles database operations for segments
+type SegmentRepository struct {
+	db *gorm.DB
+}
+
+// NewSegmentRepository creates a new segment repository
+func NewSegmentRepository() *SegmentRepository {
+	return &SegmentRepository{
+		db: database.DB,
+	}
+}
+
+// Create creates a new segment
+func (r *SegmentRepository) Create(segment *model.Segment) error {
+	return r.db.Create(segment).Error
+}
+
+// FindByID finds a segment by ID
+func (r *SegmentRepository) FindByID(id int) (*model.Segment, error) {
+	var segment model.Segment
+	err := r.db.First(&segment, id).Error
+	if err != nil {
+		return nil, err
+	}
+	return &segment, nil
+}
+
+// Update updates a segment
+func (r *SegmentRepository) Update(segment *model.Segment) error {
+	return r.db.Save(segment).Error
+}
+
+// Delete deletes a segment
+func (r *SegmentRepository) Delete(id int) error {
+	return r.db.Delete(&model.Segment{}, id).Error
+}
+
+// ListByWebsiteID returns all segments of a website
+func (r *SegmentRepository) ListByWebsiteID(websiteID int) ([]model.Segment, error) {
+	var segments []model.Segment
+	err := r.db.Where("website_id = ?", websiteID).Order("id ASC").Find(&segments).Error
+	if err != nil {
+		return nil, err
+	}
+	return segments, nil
+}
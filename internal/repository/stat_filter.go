@@ -0,0 +1,164 @@
+package repository
+
+import (
+	"strings"
+	"time"
+
+	"aq3stat/internal/model"
+	"gorm.io/gorm"
+)
+
+// Dimensions derived from the stats columns, in addition to the rollup dimensions
+const (
+	DimensionDevice      = "device"       // Device type derived from the OS, see the device stats
+	DimensionVisitorType = "visitor_type" // new or returning
+)
+
+// Stat filter operators
+const (
+	FilterEquals      = "=="
+	FilterNotEquals   = "!="
+	FilterContains    = "=~"
+	FilterNotContains = "!~"
+)
+
+// StatFilter restricts stats to the visits whose value of a dimension matches
+type StatFilter struct {
+	Dimension string `json:"dimension"`
+	Operator  string `json:"operator"`
+	Value     string `json:"value"`
+}
+
+// statDimension describes how the value of a dimension is read from the raw stats and from
+// the day rollups
+type statDimension struct {
+	column          string // Expression on the stats table
+	rollupDimension string // Rollup dimension holding the values, empty if the dimension isn't rolled up
+	rollupValue     string // Expression on the value column of the rollups
+}
+
+// statDimensions holds the dimensions stats can be broken down by and filtered on
+var statDimensions = map[string]statDimension{
+	model.RollupDimensionChannel:      {refererChannelExpr("referer"), model.RollupDimensionChannel, "value"},
+	model.RollupDimensionBaseReferer:  {"COALESCE(base_referer, '')", model.RollupDimensionBaseReferer, "value"},
+	model.RollupDimensionSearchEngine: {"COALESCE(search_engine, '')", model.RollupDimensionSearchEngine, "value"},
+	model.RollupDimensionKeyword:      {"COALESCE(keyword, '')", model.RollupDimensionKeyword, "value"},
+	model.RollupDimensionBrowser:      {"COALESCE(browser, '')", model.RollupDimensionBrowser, "value"},
+	model.RollupDimensionOS:           {"COALESCE(os, '')", model.RollupDimensionOS, "value"},
+	model.RollupDimensionOSLang:       {"COALESCE(os_lang, '')", model.RollupDimensionOSLang, "value"},
+	model.RollupDimensionScreenSize:   {"COALESCE(screen_size, '')", model.RollupDimensionScreenSize, "value"},
+	model.RollupDimensionScreenColor:  {"COALESCE(CAST(screen_color AS CHAR), '')", model.RollupDimensionScreenColor, "value"},
+	model.RollupDimensionProvince:     {"COALESCE(province, '')", model.RollupDimensionProvince, "value"},
+	model.RollupDimensionISP:          {"COALESCE(isp, '')", model.RollupDimensionISP, "value"},
+	DimensionDevice:                   {deviceExpr("os"), model.RollupDimensionOS, deviceExpr("value")},
+	DimensionVisitorType:              {"CASE WHEN re_visit_times > 1 THEN 'returning' ELSE 'new' END", "", ""},
+}
+
+// IsStatDimension reports whether stats can be broken down by and filtered on a dimension
+func IsStatDimension(dimension string) bool {
+	_, ok := statDimensions[dimension]
+	return ok
+}
+
+// IsFilterOperator reports whether an operator is a known stat filter operator
+func IsFilterOperator(operator string) bool {
+	switch operator {
+	case FilterEquals, FilterNotEquals, FilterContains, FilterNotContains:
+		return true
+	}
+	return false
+}
+
+// rollsUpFilters reports whether all filters are on the given dimension and the dimension is
+// rolled up, in which case the filters can be applied to its rollups
+func rollsUpFilters(filters []StatFilter, dimension string) bool {
+	if statDimensions[dimension].rollupDimension == "" {
+		return false
+	}
+	for _, filter := range filters {
+		if filter.Dimension != dimension {
+			return false
+		}
+	}
+	return true
+}
+
+// statFilterCondition returns the SQL condition of a filter on the raw stats, or on the rollup
+// values when rollup is true, and its argument. The filter must be validated beforehand.
+func statFilterCondition(filter StatFilter, rollup bool) (string, interface{}) {
+	dimension := statDimensions[filter.Dimension]
+	expr := dimension.column
+	if rollup {
+		expr = dimension.rollupValue
+	}
+
+	switch filter.Operator {
+	case FilterNotEquals:
+		return expr + " != ?", filter.Value
+	case FilterContains:
+		return expr + " LIKE ?", "%" + escapeLike(filter.Value) + "%"
+	case FilterNotContains:
+		return expr + " NOT LIKE ?", "%" + escapeLike(filter.Value) + "%"
+	default:
+		return expr + " = ?", filter.Value
+	}
+}
+
+// statFilterSQL returns the SQL conditions of filters on the raw stats, or on the rollup values
+// when rollup is true, each prefixed with AND
+func statFilterSQL(filters []StatFilter, rollup bool) (string, []interface{}) {
+	var sql strings.Builder
+	var args []interface{}
+
+	for _, filter := range filters {
+		condition, arg := statFilterCondition(filter, rollup)
+		sql.WriteString(" AND " + condition)
+		args = append(args, arg)
+	}
+
+	return sql.String(), args
+}
+
+// filterStats restricts a query on the stats table to the visits matching the filters of the repository
+func (r *StatAnalyticsRepository) filterStats(query *gorm.DB) *gorm.DB {
+	for _, filter := range r.filters {
+		condition, arg := statFilterCondition(filter, false)
+		query = query.Where(condition, arg)
+	}
+	return query
+}
+
+// visitFilterSQL returns the SQL condition, prefixed with AND, restricting a stat ID column to
+// the visits of a website matching the filters of the repository, empty without filters
+func (r *StatAnalyticsRepository) visitFilterSQL(column string, websiteID int) (string, []interface{}) {
+	if len(r.filters) == 0 {
+		return "", nil
+	}
+
+	sql, args := statFilterSQL(r.filters, false)
+	return " AND " + column + " IN (SELECT id FROM stats WHERE website_id = ?" + sql + ")",
+		append([]interface{}{websiteID}, args...)
+}
+
+// filterVisits restricts a query on a table with a stat ID column to the visits matching the
+// filters of the repository
+func (r *StatAnalyticsRepository) filterVisits(query *gorm.DB, column string, websiteID int) *gorm.DB {
+	if sql, args := r.visitFilterSQL(column, websiteID); sql != "" {
+		query = query.Where(strings.TrimPrefix(sql, " AND "), args...)
+	}
+	return query
+}
+
+// rollupsUntil gets the start of the first day of a website not read from the rollups, zero
+// when the repository has filters since they can't be applied to the rollups
+func (r *StatAnalyticsRepository) rollupsUntil(websiteID int) (time.Time, error) {
+	if len(r.filters) > 0 {
+		return time.Time{}, nil
+	}
+	return rolledUntil(r.db, websiteID)
+}
+
+// escapeLike escapes the wildcards of a LIKE pattern
+func escapeLike(value string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(value)
+}
@@ -1,6 +1,7 @@
 package repository
 
 import (
+	"strconv"
 	"time"
 
 	"aq3stat/internal/model"
@@ -10,7 +11,8 @@ import (
 
 // StatAnalyticsRepository handles database operations for stat analytics
 type StatAnalyticsRepository struct {
-	db *gorm.DB
+	db      *gorm.DB
+	filters []StatFilter // Visits counted in the website stats, see WithFilters
 }
 
 // NewStatAnalyticsRepository creates a new stat analytics repository
@@ -20,42 +22,24 @@ func NewStatAnalyticsRepository() *StatAnalyticsRepository {
 	}
 }
 
-// GetTodayStats gets today's stats for a website
-func (r *StatAnalyticsRepository) GetTodayStats(websiteID int, today time.Time) (int64, int64, error) {
-	var ipCount, pvCount int64
-
-	// Get IP count (unique visitors)
-	err := r.db.Model(&model.Stat{}).Where("website_id = ? AND time >= ?", websiteID, today).Count(&ipCount).Error
-	if err != nil {
-		return 0, 0, err
-	}
-
-	// Get PV count (page views)
-	err = r.db.Model(&model.Stat{}).Where("website_id = ? AND time >= ?", websiteID, today).Select("COALESCE(SUM(count), 0)").Row().Scan(&pvCount)
-	if err != nil {
-		return 0, 0, err
+// WithFilters returns a copy of the repository whose website stats only count the visits
+// matching all filters, the filters must be validated beforehand. Rolled up days and imported
+// historical data can't be filtered, so they are left out unless noted otherwise.
+func (r *StatAnalyticsRepository) WithFilters(filters []StatFilter) *StatAnalyticsRepository {
+	return &StatAnalyticsRepository{
+		db:      r.db,
+		filters: filters,
 	}
+}
 
-	return ipCount, pvCount, nil
+// GetTodayStats gets today's stats for a website
+func (r *StatAnalyticsRepository) GetTodayStats(websiteID int, today time.Time) (int64, int64, error) {
+	return r.getPeriodStats(websiteID, today, time.Time{})
 }
 
 // GetYesterdayStats gets yesterday's stats for a website
 func (r *StatAnalyticsRepository) GetYesterdayStats(websiteID int, yesterday, today time.Time) (int64, int64, error) {
-	var ipCount, pvCount int64
-
-	// Get IP count (unique visitors)
-	err := r.db.Model(&model.Stat{}).Where("website_id = ? AND time >= ? AND time < ?", websiteID, yesterday, today).Count(&ipCount).Error
-	if err != nil {
-		return 0, 0, err
-	}
-
-	// Get PV count (page views)
-	err = r.db.Model(&model.Stat{}).Where("website_id = ? AND time >= ? AND time < ?", websiteID, yesterday, today).Select("COALESCE(SUM(count), 0)").Row().Scan(&pvCount)
-	if err != nil {
-		return 0, 0, err
-	}
-
-	return ipCount, pvCount, nil
+	return r.getPeriodStats(websiteID, yesterday, today)
 }
 
 // GetOnlineVisitors gets the number of online visitors in the last N minutes
@@ -64,7 +48,7 @@ func (r *StatAnalyticsRepository) GetOnlineVisitors(websiteID int, minutes int)
 
 	timeAgo := time.Now().Add(-time.Duration(minutes) * time.Minute)
 
-	err := r.db.Model(&model.Stat{}).
+	err := r.filterStats(r.db.Model(&model.Stat{})).
 		Where("website_id = ? AND leave_time >= ?", websiteID, timeAgo).
 		Distinct("ip").
 		Count(&count).Error
@@ -77,7 +61,7 @@ func (r *StatAnalyticsRepository) GetNewVsReturningVisitors(websiteID int, today
 	var newVisitors, returningVisitors int64
 
 	// New visitors (re_visit_times = 1)
-	err := r.db.Model(&model.Stat{}).
+	err := r.filterStats(r.db.Model(&model.Stat{})).
 		Where("website_id = ? AND time >= ? AND re_visit_times = 1", websiteID, today).
 		Count(&newVisitors).Error
 	if err != nil {
@@ -85,44 +69,43 @@ func (r *StatAnalyticsRepository) GetNewVsReturningVisitors(websiteID int, today
 	}
 
 	// Returning visitors (re_visit_times > 1)
-	err = r.db.Model(&model.Stat{}).
+	err = r.filterStats(r.db.Model(&model.Stat{})).
 		Where("website_id = ? AND time >= ? AND re_visit_times > 1", websiteID, today).
 		Count(&returningVisitors).Error
 
 	return newVisitors, returningVisitors, err
 }
 
-// GetWeekStats gets this week's stats for a website
+// GetWeekStats gets this week's stats for a website, counting an IP once for the week
 func (r *StatAnalyticsRepository) GetWeekStats(websiteID int, weekStart time.Time) (int64, int64, error) {
-	var ipCount, pvCount int64
-
-	// Get IP count (unique visitors)
-	err := r.db.Model(&model.Stat{}).Where("website_id = ? AND time >= ?", websiteID, weekStart).Count(&ipCount).Error
-	if err != nil {
-		return 0, 0, err
-	}
+	return r.getPeriodUniqueStats(websiteID, weekStart, time.Time{})
+}
 
-	// Get PV count (page views)
-	err = r.db.Model(&model.Stat{}).Where("website_id = ? AND time >= ?", websiteID, weekStart).Select("COALESCE(SUM(count), 0)").Row().Scan(&pvCount)
-	if err != nil {
-		return 0, 0, err
-	}
+// GetLastWeekStats gets last week's stats for a website, counting an IP once for the week
+func (r *StatAnalyticsRepository) GetLastWeekStats(websiteID int, lastWeekStart, thisWeekStart time.Time) (int64, int64, error) {
+	return r.getPeriodUniqueStats(websiteID, lastWeekStart, thisWeekStart)
+}
 
-	return ipCount, pvCount, nil
+// GetMonthStats gets this month's stats for a website, counting an IP once for the month
+func (r *StatAnalyticsRepository) GetMonthStats(websiteID int, monthStart time.Time) (int64, int64, error) {
+	return r.getPeriodUniqueStats(websiteID, monthStart, time.Time{})
 }
 
-// GetLastWeekStats gets last week's stats for a website
-func (r *StatAnalyticsRepository) GetLastWeekStats(websiteID int, lastWeekStart, thisWeekStart time.Time) (int64, int64, error) {
-	var ipCount, pvCount int64
+// GetTotalStats gets total stats for a website since the start of its first day, counting an
+// IP once per day
+func (r *StatAnalyticsRepository) GetTotalStats(websiteID int, startDay time.Time) (int64, int64, error) {
+	return r.getPeriodStats(websiteID, startDay, time.Time{})
+}
 
-	// Get IP count (unique visitors)
-	err := r.db.Model(&model.Stat{}).Where("website_id = ? AND time >= ? AND time < ?", websiteID, lastWeekStart, thisWeekStart).Count(&ipCount).Error
+// getPeriodUniqueStats gets the distinct IPs and the PV count of a website in a half-open range,
+// open-ended if to is zero, see GetUniqueVisitors
+func (r *StatAnalyticsRepository) getPeriodUniqueStats(websiteID int, from, to time.Time) (int64, int64, error) {
+	_, pvCount, err := r.getPeriodStats(websiteID, from, to)
 	if err != nil {
 		return 0, 0, err
 	}
 
-	// Get PV count (page views)
-	err = r.db.Model(&model.Stat{}).Where("website_id = ? AND time >= ? AND time < ?", websiteID, lastWeekStart, thisWeekStart).Select("COALESCE(SUM(count), 0)").Row().Scan(&pvCount)
+	ipCount, err := r.GetUniqueVisitors(websiteID, from, to)
 	if err != nil {
 		return 0, 0, err
 	}
@@ -130,69 +113,72 @@ func (r *StatAnalyticsRepository) GetLastWeekStats(websiteID int, lastWeekStart,
 	return ipCount, pvCount, nil
 }
 
-// GetMonthStats gets this month's stats for a website
-func (r *StatAnalyticsRepository) GetMonthStats(websiteID int, monthStart time.Time) (int64, int64, error) {
+// getPeriodStats gets the IP and PV counts of a website in a half-open range, open-ended if to
+// is zero. Rolled up days are read from the rollups and only the rest from the raw stats, so
+// from must be the start of a day in the website's time zone.
+func (r *StatAnalyticsRepository) getPeriodStats(websiteID int, from, to time.Time) (int64, int64, error) {
 	var ipCount, pvCount int64
 
-	// Get IP count (unique visitors)
-	err := r.db.Model(&model.Stat{}).Where("website_id = ? AND time >= ?", websiteID, monthStart).Count(&ipCount).Error
+	until, err := r.rollupsUntil(websiteID)
 	if err != nil {
 		return 0, 0, err
 	}
 
-	// Get PV count (page views)
-	err = r.db.Model(&model.Stat{}).Where("website_id = ? AND time >= ?", websiteID, monthStart).Select("COALESCE(SUM(count), 0)").Row().Scan(&pvCount)
-	if err != nil {
-		return 0, 0, err
-	}
+	if from.Before(until) {
+		rollupTo := until
+		if !to.IsZero() && to.Before(until) {
+			rollupTo = to
+		}
 
-	return ipCount, pvCount, nil
-}
+		err = r.db.Model(&model.StatRollup{}).
+			Where("website_id = ? AND period = ? AND dimension = '' AND bucket >= ? AND bucket < ?",
+				websiteID, model.RollupPeriodDay, dateOf(from), dateOf(rollupTo.In(from.Location()))).
+			Select("COALESCE(SUM(visitors), 0), COALESCE(SUM(page_views), 0)").
+			Row().Scan(&ipCount, &pvCount)
+		if err != nil {
+			return 0, 0, err
+		}
+		from = until
+	}
 
-// GetTotalStats gets total stats for a website since start
-func (r *StatAnalyticsRepository) GetTotalStats(websiteID int, startTime time.Time) (int64, int64, error) {
-	var ipCount, pvCount int64
+	if !to.IsZero() && !from.Before(to) {
+		return ipCount, pvCount, nil
+	}
 
-	// Get IP count (unique visitors)
-	err := r.db.Model(&model.Stat{}).Where("website_id = ? AND time >= ?", websiteID, startTime).Count(&ipCount).Error
-	if err != nil {
-		return 0, 0, err
+	// IP count (unique visitors per day) and PV count (page views) of the days not rolled up
+	query := r.filterStats(r.db.Model(&model.Stat{}).Where("website_id = ? AND time >= ?", websiteID, from))
+	if !to.IsZero() {
+		query = query.Where("time < ?", to)
 	}
 
-	// Get PV count (page views)
-	err = r.db.Model(&model.Stat{}).Where("website_id = ? AND time >= ?", websiteID, startTime).Select("COALESCE(SUM(count), 0)").Row().Scan(&pvCount)
+	var rawIPCount, rawPVCount int64
+	err = query.Select("COUNT(*), COALESCE(SUM(count), 0)").Row().Scan(&rawIPCount, &rawPVCount)
 	if err != nil {
 		return 0, 0, err
 	}
 
-	return ipCount, pvCount, nil
+	return ipCount + rawIPCount, pvCount + rawPVCount, nil
 }
 
 // GetSystemTodayStats gets system-wide today's statistics
 func (r *StatAnalyticsRepository) GetSystemTodayStats(date string) (int, int, error) {
 	var ipCount, pvCount int64
 
-	// Parse date
-	today, err := time.Parse("2006-01-02", date)
+	// Parse date in the server's time zone
+	today, err := time.ParseInLocation("2006-01-02", date, time.Local)
 	if err != nil {
 		return 0, 0, err
 	}
 	tomorrow := today.AddDate(0, 0, 1)
 
 	// Get IP count (unique visitors across all websites)
-	err = r.db.Model(&model.Stat{}).
-		Where("time >= ? AND time < ?", today, tomorrow).
-		Distinct("ip").
-		Count(&ipCount).Error
+	ipCount, err = r.GetSystemUniqueVisitors(today, tomorrow)
 	if err != nil {
 		return 0, 0, err
 	}
 
 	// Get PV count (page views across all websites)
-	err = r.db.Model(&model.Stat{}).
-		Where("time >= ? AND time < ?", today, tomorrow).
-		Select("COALESCE(SUM(count), 0)").
-		Row().Scan(&pvCount)
+	pvCount, err = r.getSystemPageViews(today, tomorrow)
 	if err != nil {
 		return 0, 0, err
 	}
@@ -202,20 +188,14 @@ func (r *StatAnalyticsRepository) GetSystemTodayStats(date string) (int, int, er
 
 // GetSystemTotalStats gets system-wide total statistics
 func (r *StatAnalyticsRepository) GetSystemTotalStats() (int, int, error) {
-	var ipCount, pvCount int64
-
 	// Get total unique IP count across all websites
-	err := r.db.Model(&model.Stat{}).
-		Distinct("ip").
-		Count(&ipCount).Error
+	ipCount, err := r.GetSystemUniqueVisitors(time.Time{}, time.Time{})
 	if err != nil {
 		return 0, 0, err
 	}
 
 	// Get total PV count across all websites
-	err = r.db.Model(&model.Stat{}).
-		Select("COALESCE(SUM(count), 0)").
-		Row().Scan(&pvCount)
+	pvCount, err := r.getSystemPageViews(time.Time{}, time.Time{})
 	if err != nil {
 		return 0, 0, err
 	}
@@ -223,6 +203,66 @@ func (r *StatAnalyticsRepository) GetSystemTotalStats() (int, int, error) {
 	return int(ipCount), int(pvCount), nil
 }
 
+// getSystemPageViews gets the page views of all websites in a half-open range of days of the
+// given time zone, open-ended if from or to is zero. Rolled up days are read from the day rollups
+// of the websites for their dates, so purged days are still counted, the other days from the raw
+// stats.
+func (r *StatAnalyticsRepository) getSystemPageViews(from, to time.Time) (int64, error) {
+	stats, err := r.systemRawStats(from, to)
+	if err != nil {
+		return 0, err
+	}
+
+	rollups := r.db.Model(&model.StatRollup{}).Where("period = ? AND dimension = ''", model.RollupPeriodDay)
+	if !from.IsZero() {
+		rollups = rollups.Where("bucket >= ?", dateOf(from))
+	}
+	if !to.IsZero() {
+		rollups = rollups.Where("bucket < ?", dateOf(to))
+	}
+
+	var rollupPV, rawPV int64
+	if err := rollups.Select("COALESCE(SUM(page_views), 0)").Row().Scan(&rollupPV); err != nil {
+		return 0, err
+	}
+	if err := stats.Select("COALESCE(SUM(stats.count), 0)").Row().Scan(&rawPV); err != nil {
+		return 0, err
+	}
+
+	return rollupPV + rawPV, nil
+}
+
+// systemRawStats returns the query of the raw stats of all websites in a half-open range that
+// aren't rolled up, open-ended if from or to is zero. The range is narrowed to the first day of
+// any website that isn't rolled up, so that the stats before aren't scanned.
+func (r *StatAnalyticsRepository) systemRawStats(from, to time.Time) (*gorm.DB, error) {
+	// Websites without a rollup state, e.g. deleted before the rollups, aren't rolled up at all
+	var unrolled int64
+	var rolledUntil *time.Time
+	err := r.db.Raw(`
+		SELECT COUNT(*) - COUNT(rollup_states.website_id), MIN(rollup_states.rolled_until)
+		FROM websites
+		LEFT JOIN rollup_states ON rollup_states.website_id = websites.id
+	`).Row().Scan(&unrolled, &rolledUntil)
+	if err != nil {
+		return nil, err
+	}
+	if unrolled == 0 && rolledUntil != nil && from.Before(*rolledUntil) {
+		from = *rolledUntil
+	}
+
+	stats := r.db.Model(&model.Stat{}).
+		Joins("LEFT JOIN rollup_states ON rollup_states.website_id = stats.website_id").
+		Where("(rollup_states.rolled_until IS NULL OR stats.time >= rollup_states.rolled_until)")
+	if !from.IsZero() {
+		stats = stats.Where("stats.time >= ?", from)
+	}
+	if !to.IsZero() {
+		stats = stats.Where("stats.time < ?", to)
+	}
+	return stats, nil
+}
+
 // DailyStatsData represents daily statistics data
 type DailyStatsData struct {
 	Date string `json:"date"`
@@ -242,25 +282,29 @@ func (r *StatAnalyticsRepository) GetSystemTrendData(days int) ([]DailyStatsData
 		dayStart := time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, date.Location())
 		dayEnd := dayStart.AddDate(0, 0, 1)
 
-		var ipCount, pvCount int64
-
 		// Get IP count for this day
-		err := r.db.Model(&model.Stat{}).
-			Where("time >= ? AND time < ?", dayStart, dayEnd).
-			Distinct("ip").
-			Count(&ipCount).Error
+		ipCount, err := r.GetSystemUniqueVisitors(dayStart, dayEnd)
 		if err != nil {
 			return nil, err
 		}
 
 		// Get PV count for this day
-		err = r.db.Model(&model.Stat{}).
-			Where("time >= ? AND time < ?", dayStart, dayEnd).
-			Select("COALESCE(SUM(count), 0)").
-			Row().Scan(&pvCount)
+		pvCount, err := r.getSystemPageViews(dayStart, dayEnd)
+		if err != nil {
+			return nil, err
+		}
+
+		// Add data imported from other analytics tools
+		var historicalIP, historicalPV int64
+		err = r.db.Model(&model.HistoricalStat{}).
+			Where("dimension = '' AND date >= ? AND date < ?", dateOf(dayStart), dateOf(dayEnd)).
+			Select("COALESCE(SUM(visitors), 0), COALESCE(SUM(page_views), 0)").
+			Row().Scan(&historicalIP, &historicalPV)
 		if err != nil {
 			return nil, err
 		}
+		ipCount += historicalIP
+		pvCount += historicalPV
 
 		results = append(results, DailyStatsData{
 			Date: dateStr,
@@ -284,26 +328,64 @@ type DeviceStatsData struct {
 	Value int64  `json:"value"`
 }
 
+// refererChannelExpr returns the SQL expression classifying a referer column into a channel
+func refererChannelExpr(column string) string {
+	return `CASE
+				WHEN ` + column + ` = '' OR ` + column + ` IS NULL THEN '直接访问'
+				WHEN ` + column + ` LIKE '%google%' OR ` + column + ` LIKE '%bing%' OR ` + column + ` LIKE '%baidu%' OR ` + column + ` LIKE '%yahoo%' THEN '搜索引擎'
+				WHEN ` + column + ` LIKE '%facebook%' OR ` + column + ` LIKE '%twitter%' OR ` + column + ` LIKE '%weibo%' OR ` + column + ` LIKE '%qq%' THEN '社交媒体'
+				ELSE '外部链接'
+			END`
+}
+
+// deviceExpr returns the SQL expression classifying an OS column into a device type
+func deviceExpr(column string) string {
+	return `CASE
+				WHEN ` + column + ` LIKE '%Windows%' OR ` + column + ` LIKE '%Mac%' OR ` + column + ` LIKE '%Linux%' THEN 'PC'
+				WHEN ` + column + ` LIKE '%Android%' OR ` + column + ` LIKE '%iOS%' OR ` + column + ` LIKE '%iPhone%' THEN '移动设备'
+				WHEN ` + column + ` LIKE '%iPad%' THEN '平板'
+				ELSE '其他'
+			END`
+}
+
 // GetRefererStats gets referer statistics for a website
 func (r *StatAnalyticsRepository) GetRefererStats(websiteID int) ([]RefererStatsData, error) {
+	return r.GetRefererStatsBetween(websiteID, time.Time{}, time.Time{})
+}
+
+// GetRefererStatsBetween gets referer statistics for a website in a half-open range of days in the
+// website's time zone, of all time when the range is zero
+func (r *StatAnalyticsRepository) GetRefererStatsBetween(websiteID int, from, to time.Time) ([]RefererStatsData, error) {
 	var results []RefererStatsData
 
-	// Query to get referer statistics
+	rawRange, rawArgs, rollupRange, rollupArgs, err := r.statRangeSQL(websiteID, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	// Query to get referer statistics, rolled up days come from the rollups when there are no filters
+	filterSQL, filterArgs := statFilterSQL(r.filters, false)
+	args := append(append([]interface{}{websiteID}, rawArgs...), filterArgs...)
+	args = append(append(args, websiteID, model.RollupPeriodDay, model.RollupDimensionChannel, len(r.filters) == 0), rollupArgs...)
 	rows, err := r.db.Raw(`
-		SELECT
-			CASE
-				WHEN referer = '' OR referer IS NULL THEN '直接访问'
-				WHEN referer LIKE '%google%' OR referer LIKE '%bing%' OR referer LIKE '%baidu%' OR referer LIKE '%yahoo%' THEN '搜索引擎'
-				WHEN referer LIKE '%facebook%' OR referer LIKE '%twitter%' OR referer LIKE '%weibo%' OR referer LIKE '%qq%' THEN '社交媒体'
-				ELSE '外部链接'
-			END as name,
-			SUM(count) as value
-		FROM stats
-		WHERE website_id = ?
+		SELECT name, SUM(value) as value
+		FROM (
+			SELECT
+				`+refererChannelExpr("referer")+` as name,
+				SUM(count) as value
+			FROM stats
+			WHERE website_id = ?`+rawRange+` AND deleted_at IS NULL`+filterSQL+`
+			GROUP BY name
+			UNION ALL
+			SELECT value as name, SUM(page_views) as value
+			FROM stat_rollups
+			WHERE website_id = ? AND period = ? AND dimension = ? AND ?`+rollupRange+`
+			GROUP BY value
+		) referers
 		GROUP BY name
 		ORDER BY value DESC
 		LIMIT 10
-	`, websiteID).Rows()
+	`, args...).Rows()
 
 	if err != nil {
 		return nil, err
@@ -323,23 +405,39 @@ func (r *StatAnalyticsRepository) GetRefererStats(websiteID int) ([]RefererStats
 
 // GetDeviceStats gets device statistics for a website
 func (r *StatAnalyticsRepository) GetDeviceStats(websiteID int) ([]DeviceStatsData, error) {
+	return r.GetDeviceStatsBetween(websiteID, time.Time{}, time.Time{})
+}
+
+// GetDeviceStatsBetween gets device statistics for a website in a half-open range of days in the
+// website's time zone, of all time when the range is zero
+func (r *StatAnalyticsRepository) GetDeviceStatsBetween(websiteID int, from, to time.Time) ([]DeviceStatsData, error) {
 	var results []DeviceStatsData
 
-	// Query to get device statistics based on browser and OS
+	rawRange, rawArgs, rollupRange, rollupArgs, err := r.statRangeSQL(websiteID, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	// Query to get device statistics based on the OS, rolled up days come from the rollups when there are no filters
+	filterSQL, filterArgs := statFilterSQL(r.filters, false)
+	args := append(append([]interface{}{websiteID}, rawArgs...), filterArgs...)
+	args = append(append(args, websiteID, model.RollupPeriodDay, model.RollupDimensionOS, len(r.filters) == 0), rollupArgs...)
 	rows, err := r.db.Raw(`
-		SELECT
-			CASE
-				WHEN os LIKE '%Windows%' OR os LIKE '%Mac%' OR os LIKE '%Linux%' THEN 'PC'
-				WHEN os LIKE '%Android%' OR os LIKE '%iOS%' OR os LIKE '%iPhone%' THEN '移动设备'
-				WHEN os LIKE '%iPad%' THEN '平板'
-				ELSE '其他'
-			END as name,
-			SUM(count) as value
-		FROM stats
-		WHERE website_id = ? AND os IS NOT NULL AND os != ''
+		SELECT `+deviceExpr("os")+` as name, SUM(value) as value
+		FROM (
+			SELECT os, SUM(count) as value
+			FROM stats
+			WHERE website_id = ?`+rawRange+` AND os IS NOT NULL AND os != '' AND deleted_at IS NULL`+filterSQL+`
+			GROUP BY os
+			UNION ALL
+			SELECT value as os, SUM(page_views) as value
+			FROM stat_rollups
+			WHERE website_id = ? AND period = ? AND dimension = ? AND value != '' AND ?`+rollupRange+`
+			GROUP BY value
+		) devices
 		GROUP BY name
 		ORDER BY value DESC
-	`, websiteID).Rows()
+	`, args...).Rows()
 
 	if err != nil {
 		return nil, err
@@ -355,4 +453,932 @@ func (r *StatAnalyticsRepository) GetDeviceStats(websiteID int) ([]DeviceStatsDa
 	}
 
 	return results, nil
-}
\ No newline at end of file
+}
+
+// statRangeSQL returns the SQL conditions, each prefixed with AND, restricting the raw stats and
+// the day rollups of a website to a half-open range of days in the website's time zone, and
+// their arguments. Rolled up days are left to the rollups. A zero range is all time.
+func (r *StatAnalyticsRepository) statRangeSQL(websiteID int, from, to time.Time) (string, []interface{}, string, []interface{}, error) {
+	until, err := r.rollupsUntil(websiteID)
+	if err != nil {
+		return "", nil, "", nil, err
+	}
+
+	if to.IsZero() {
+		return " AND time >= ?", []interface{}{until}, "", nil, nil
+	}
+
+	rawFrom, rollupTo := splitAtRollups(from, to, until)
+	return " AND time >= ? AND time < ?", []interface{}{rawFrom, to},
+		" AND bucket >= ? AND bucket < ?", []interface{}{dateOf(from), dateOf(rollupTo)}, nil
+}
+
+// EngagementStatsData represents content engagement statistics for a page path
+type EngagementStatsData struct {
+	Path            string  `json:"path"`
+	PageViews       int64   `json:"page_views"`
+	AvgScrollDepth  float64 `json:"avg_scroll_depth"`
+	ReadThroughRate float64 `json:"read_through_rate"`
+}
+
+// GetEngagementStats gets scroll depth statistics per page path for a website
+func (r *StatAnalyticsRepository) GetEngagementStats(websiteID int, from, to time.Time, limit int) ([]EngagementStatsData, error) {
+	var results []EngagementStatsData
+
+	// A page view is read through when the visitor scrolled to the bottom of the page
+	filterSQL, filterArgs := r.visitFilterSQL("stat_id", websiteID)
+	args := append([]interface{}{websiteID, from, to}, filterArgs...)
+	rows, err := r.db.Raw(`
+		SELECT
+			path,
+			COUNT(*) as page_views,
+			COALESCE(AVG(scroll_depth), 0) as avg_scroll_depth,
+			COALESCE(SUM(CASE WHEN scroll_depth >= 100 THEN 1 ELSE 0 END) / COUNT(*), 0) as read_through_rate
+		FROM page_views
+		WHERE website_id = ? AND time >= ? AND time < ? AND deleted_at IS NULL`+filterSQL+`
+		GROUP BY path
+		ORDER BY page_views DESC
+		LIMIT ?
+	`, append(args, limit)...).Rows()
+
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var item EngagementStatsData
+		if err := rows.Scan(&item.Path, &item.PageViews, &item.AvgScrollDepth, &item.ReadThroughRate); err != nil {
+			return nil, err
+		}
+		results = append(results, item)
+	}
+
+	return results, nil
+}
+
+// PagePathData represents the page views of a page path
+type PagePathData struct {
+	Path       string
+	PV         int64
+	Visitors   int64 // Distinct IPs
+	TimeOnPage int64 // Seconds spent on the timed page views
+	TimedPV    int64 // Page views with a leave time, the others are the last of their visit without unload ping
+}
+
+// GetPagePaths gets the page views and visitors of a website in a time range per page path
+func (r *StatAnalyticsRepository) GetPagePaths(websiteID int, from, to time.Time) ([]PagePathData, error) {
+	var results []PagePathData
+
+	filterSQL, filterArgs := r.visitFilterSQL("p.stat_id", websiteID)
+	args := append([]interface{}{websiteID, from, to}, filterArgs...)
+	rows, err := r.db.Raw(`
+		SELECT
+			p.path,
+			COUNT(*) as pv,
+			COUNT(DISTINCT s.ip) as visitors,
+			COALESCE(SUM(CASE WHEN p.leave_time > p.time THEN TIMESTAMPDIFF(SECOND, p.time, p.leave_time) ELSE 0 END), 0) as time_on_page,
+			COALESCE(SUM(CASE WHEN p.leave_time > p.time THEN 1 ELSE 0 END), 0) as timed_pv
+		FROM page_views p
+		JOIN stats s ON s.id = p.stat_id
+		WHERE p.website_id = ? AND p.time >= ? AND p.time < ? AND p.path != '' AND p.deleted_at IS NULL`+filterSQL+`
+		GROUP BY p.path
+	`, args...).Rows()
+
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var item PagePathData
+		if err := rows.Scan(&item.Path, &item.PV, &item.Visitors, &item.TimeOnPage, &item.TimedPV); err != nil {
+			return nil, err
+		}
+		results = append(results, item)
+	}
+
+	return results, nil
+}
+
+// GetPathsVisitors gets the number of distinct IPs that viewed any of the given page paths of a
+// website in a time range
+func (r *StatAnalyticsRepository) GetPathsVisitors(websiteID int, from, to time.Time, paths []string) (int64, error) {
+	var count int64
+
+	filterSQL, filterArgs := r.visitFilterSQL("p.stat_id", websiteID)
+	args := append([]interface{}{websiteID, from, to, paths}, filterArgs...)
+	err := r.db.Raw(`
+		SELECT COUNT(DISTINCT s.ip)
+		FROM page_views p
+		JOIN stats s ON s.id = p.stat_id
+		WHERE p.website_id = ? AND p.time >= ? AND p.time < ? AND p.path IN (?) AND p.deleted_at IS NULL`+filterSQL,
+		args...).Row().Scan(&count)
+	return count, err
+}
+
+// PageVisitData represents the visits entering or leaving a website on a page path
+type PageVisitData struct {
+	Path    string
+	Visits  int64
+	Bounces int64 // Visits with a single page view
+}
+
+// GetEntryPages gets the number of visits of a website in a time range per first page path
+func (r *StatAnalyticsRepository) GetEntryPages(websiteID int, from, to time.Time) ([]PageVisitData, error) {
+	return r.getBoundaryPages(websiteID, from, to, "first_id")
+}
+
+// GetExitPages gets the number of visits of a website in a time range per last page path
+func (r *StatAnalyticsRepository) GetExitPages(websiteID int, from, to time.Time) ([]PageVisitData, error) {
+	return r.getBoundaryPages(websiteID, from, to, "last_id")
+}
+
+// getBoundaryPages gets the number of visits per path of their first or last page view in a time range
+func (r *StatAnalyticsRepository) getBoundaryPages(websiteID int, from, to time.Time, column string) ([]PageVisitData, error) {
+	var results []PageVisitData
+
+	// Page views are created in time order, so the IDs give the first and last page of a visit
+	filterSQL, filterArgs := r.visitFilterSQL("stat_id", websiteID)
+	args := append([]interface{}{websiteID, from, to}, filterArgs...)
+	rows, err := r.db.Raw(`
+		SELECT p.path, COUNT(*) as visits, COALESCE(SUM(CASE WHEN v.page_views = 1 THEN 1 ELSE 0 END), 0) as bounces
+		FROM (
+			SELECT stat_id, MIN(id) as first_id, MAX(id) as last_id, COUNT(*) as page_views
+			FROM page_views
+			WHERE website_id = ? AND time >= ? AND time < ? AND path != '' AND deleted_at IS NULL`+filterSQL+`
+			GROUP BY stat_id
+		) v
+		JOIN page_views p ON p.id = v.`+column+`
+		GROUP BY p.path
+	`, args...).Rows()
+
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var item PageVisitData
+		if err := rows.Scan(&item.Path, &item.Visits, &item.Bounces); err != nil {
+			return nil, err
+		}
+		results = append(results, item)
+	}
+
+	return results, nil
+}
+
+// GetVisitCount gets the number of visits of a website in a time range of days
+func (r *StatAnalyticsRepository) GetVisitCount(websiteID int, from, to time.Time) (int64, error) {
+	count, _, err := r.getPeriodStats(websiteID, from, to)
+	return count, err
+}
+
+// GetConversionTotals gets the number of converted visits and the revenue of a website in a time range
+func (r *StatAnalyticsRepository) GetConversionTotals(websiteID int, from, to time.Time) (int64, float64, error) {
+	var conversions int64
+	var revenue float64
+
+	err := r.filterVisits(r.db.Model(&model.Conversion{}), "stat_id", websiteID).
+		Where("website_id = ? AND time >= ? AND time < ?", websiteID, from, to).
+		Select("COUNT(DISTINCT stat_id), COALESCE(SUM(revenue), 0)").
+		Row().Scan(&conversions, &revenue)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return conversions, revenue, nil
+}
+
+// ConversionStatsData represents conversion statistics of a goal, optionally for one dimension value
+type ConversionStatsData struct {
+	GoalID      int     `json:"goal_id"`
+	Name        string  `json:"name"`
+	Conversions int64   `json:"conversions"`
+	Revenue     float64 `json:"revenue"`
+}
+
+// GetConversionStats gets conversions and revenue per goal of a website
+func (r *StatAnalyticsRepository) GetConversionStats(websiteID int, from, to time.Time) ([]ConversionStatsData, error) {
+	return r.getConversionBreakdown(websiteID, from, to, "''")
+}
+
+// GetConversionChannelStats gets conversions and revenue per goal and referer channel
+func (r *StatAnalyticsRepository) GetConversionChannelStats(websiteID int, from, to time.Time) ([]ConversionStatsData, error) {
+	return r.getConversionBreakdown(websiteID, from, to, refererChannelExpr("s.referer"))
+}
+
+// GetConversionSearchEngineStats gets conversions and revenue per goal and search engine
+func (r *StatAnalyticsRepository) GetConversionSearchEngineStats(websiteID int, from, to time.Time) ([]ConversionStatsData, error) {
+	return r.getConversionBreakdown(websiteID, from, to, "CASE WHEN s.search_engine = '' OR s.search_engine IS NULL THEN '无' ELSE s.search_engine END")
+}
+
+// getConversionBreakdown groups the conversions of a website by goal and a dimension of the converted visit
+func (r *StatAnalyticsRepository) getConversionBreakdown(websiteID int, from, to time.Time, dimensionExpr string) ([]ConversionStatsData, error) {
+	var results []ConversionStatsData
+
+	filterSQL, filterArgs := r.visitFilterSQL("c.stat_id", websiteID)
+	rows, err := r.db.Raw(`
+		SELECT
+			c.goal_id,
+			`+dimensionExpr+` as name,
+			COUNT(*) as conversions,
+			COALESCE(SUM(c.revenue), 0) as revenue
+		FROM conversions c
+		JOIN stats s ON s.id = c.stat_id
+		WHERE c.website_id = ? AND c.time >= ? AND c.time < ? AND c.deleted_at IS NULL`+filterSQL+`
+		GROUP BY c.goal_id, name
+		ORDER BY conversions DESC
+	`, append([]interface{}{websiteID, from, to}, filterArgs...)...).Rows()
+
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var item ConversionStatsData
+		if err := rows.Scan(&item.GoalID, &item.Name, &item.Conversions, &item.Revenue); err != nil {
+			return nil, err
+		}
+		results = append(results, item)
+	}
+
+	return results, nil
+}
+
+// TimelineEntry represents a page view or custom event of a visitor
+type TimelineEntry struct {
+	Visitor string
+	Time    time.Time
+	Type    string // PAGE or EVENT
+	Value   string // Page path or event name
+}
+
+// EachTimelineEntry calls fn for every page view and custom event of a website in a time range,
+// ordered by visitor and time, without loading them all into memory
+func (r *StatAnalyticsRepository) EachTimelineEntry(websiteID int, from, to time.Time, fn func(entry *TimelineEntry) error) error {
+	pageFilterSQL, pageFilterArgs := r.visitFilterSQL("p.stat_id", websiteID)
+	eventFilterSQL, eventFilterArgs := r.visitFilterSQL("e.stat_id", websiteID)
+	args := append([]interface{}{websiteID, from, to}, pageFilterArgs...)
+	args = append(append(args, websiteID, from, to), eventFilterArgs...)
+	rows, err := r.db.Raw(`
+		SELECT s.ip as visitor, p.time, 'PAGE' as type, p.path as value
+		FROM page_views p
+		JOIN stats s ON s.id = p.stat_id
+		WHERE p.website_id = ? AND p.time >= ? AND p.time < ? AND p.deleted_at IS NULL`+pageFilterSQL+`
+		UNION ALL
+		SELECT s.ip as visitor, e.time, 'EVENT' as type, e.name as value
+		FROM events e
+		JOIN stats s ON s.id = e.stat_id
+		WHERE e.website_id = ? AND e.time >= ? AND e.time < ? AND e.deleted_at IS NULL`+eventFilterSQL+`
+		ORDER BY visitor, time
+	`, args...).Rows()
+
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var entry TimelineEntry
+		if err := rows.Scan(&entry.Visitor, &entry.Time, &entry.Type, &entry.Value); err != nil {
+			return err
+		}
+		if err := fn(&entry); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// VisitorActivity represents a visit of a visitor
+type VisitorActivity struct {
+	Visitor string
+	Time    time.Time
+}
+
+// GetFirstSeenVisitors gets the visitors of a website first seen in a time range with their first visit time
+func (r *StatAnalyticsRepository) GetFirstSeenVisitors(websiteID int, from, to time.Time) ([]VisitorActivity, error) {
+	var results []VisitorActivity
+
+	filterSQL, filterArgs := statFilterSQL(r.filters, false)
+	args := append([]interface{}{websiteID}, filterArgs...)
+	rows, err := r.db.Raw(`
+		SELECT ip as visitor, MIN(time) as first_seen
+		FROM stats
+		WHERE website_id = ? AND deleted_at IS NULL`+filterSQL+`
+		GROUP BY ip
+		HAVING first_seen >= ? AND first_seen < ?
+	`, append(args, from, to)...).Rows()
+
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var item VisitorActivity
+		if err := rows.Scan(&item.Visitor, &item.Time); err != nil {
+			return nil, err
+		}
+		results = append(results, item)
+	}
+
+	return results, nil
+}
+
+// GetVisitorActivity gets all visits of a website in a time range
+func (r *StatAnalyticsRepository) GetVisitorActivity(websiteID int, from, to time.Time) ([]VisitorActivity, error) {
+	var results []VisitorActivity
+
+	rows, err := r.filterStats(r.db.Model(&model.Stat{})).
+		Select("ip, time").
+		Where("website_id = ? AND time >= ? AND time < ?", websiteID, from, to).
+		Rows()
+
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var item VisitorActivity
+		if err := rows.Scan(&item.Visitor, &item.Time); err != nil {
+			return nil, err
+		}
+		results = append(results, item)
+	}
+
+	return results, nil
+}
+
+// CurrencyTotalsData represents the revenue and orders of a website in a currency
+type CurrencyTotalsData struct {
+	Currency      string  `json:"currency"`
+	Revenue       float64 `json:"revenue"`
+	Orders        int64   `json:"orders"`
+	AvgOrderValue float64 `json:"avg_order_value"`
+}
+
+// GetPurchaseTotals gets the revenue and order count of a website in a time range per currency,
+// by revenue. All currencies are included when currency is empty.
+func (r *StatAnalyticsRepository) GetPurchaseTotals(websiteID int, from, to time.Time, currency string) ([]CurrencyTotalsData, error) {
+	var results []CurrencyTotalsData
+
+	query := r.db.Model(&model.Purchase{}).Where("website_id = ? AND time >= ? AND time < ?", websiteID, from, to)
+	query = r.filterVisits(query, "stat_id", websiteID)
+	if currency != "" {
+		query = query.Where("currency = ?", currency)
+	}
+
+	rows, err := query.Select("COALESCE(currency, '') as currency, COALESCE(SUM(total), 0) as revenue, COUNT(*) as orders").
+		Group("currency").Order("revenue DESC").Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var item CurrencyTotalsData
+		if err := rows.Scan(&item.Currency, &item.Revenue, &item.Orders); err != nil {
+			return nil, err
+		}
+		if item.Orders > 0 {
+			item.AvgOrderValue = item.Revenue / float64(item.Orders)
+		}
+		results = append(results, item)
+	}
+
+	return results, nil
+}
+
+// ProductStatsData represents sales statistics of a product
+type ProductStatsData struct {
+	SKU      string  `json:"sku"`
+	Name     string  `json:"name"`
+	Category string  `json:"category"`
+	Currency string  `json:"currency"`
+	Quantity int64   `json:"quantity"`
+	Orders   int64   `json:"orders"`
+	Revenue  float64 `json:"revenue"`
+}
+
+// GetTopProducts gets the best selling products of a website by revenue, per currency since
+// revenue in different currencies can't be added up
+func (r *StatAnalyticsRepository) GetTopProducts(websiteID int, from, to time.Time, currency string, limit int) ([]ProductStatsData, error) {
+	var results []ProductStatsData
+
+	filterSQL, filterArgs := r.visitFilterSQL("p.stat_id", websiteID)
+	args := append([]interface{}{websiteID, from, to, currency, currency}, filterArgs...)
+	rows, err := r.db.Raw(`
+		SELECT
+			i.sku,
+			MAX(i.name) as name,
+			MAX(i.category) as category,
+			COALESCE(p.currency, '') as currency,
+			COALESCE(SUM(i.quantity), 0) as quantity,
+			COUNT(DISTINCT p.id) as orders,
+			COALESCE(SUM(i.price * i.quantity), 0) as revenue
+		FROM purchase_items i
+		JOIN purchases p ON p.id = i.purchase_id
+		WHERE p.website_id = ? AND p.time >= ? AND p.time < ? AND p.deleted_at IS NULL
+			AND (? = '' OR p.currency = ?)`+filterSQL+`
+		GROUP BY i.sku, p.currency
+		ORDER BY revenue DESC
+		LIMIT ?
+	`, append(args, limit)...).Rows()
+
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var item ProductStatsData
+		if err := rows.Scan(&item.SKU, &item.Name, &item.Category, &item.Currency, &item.Quantity, &item.Orders, &item.Revenue); err != nil {
+			return nil, err
+		}
+		results = append(results, item)
+	}
+
+	return results, nil
+}
+
+// RevenueSourceData represents the revenue attributed to a traffic source
+type RevenueSourceData struct {
+	Name     string  `json:"name"`
+	Currency string  `json:"currency"`
+	Orders   int64   `json:"orders"`
+	Revenue  float64 `json:"revenue"`
+}
+
+// GetRevenueByChannel gets the revenue of a website per referer channel
+func (r *StatAnalyticsRepository) GetRevenueByChannel(websiteID int, from, to time.Time, currency string, limit int) ([]RevenueSourceData, error) {
+	return r.getRevenueBySource(websiteID, from, to, currency, limit, refererChannelExpr("s.referer"))
+}
+
+// GetRevenueBySearchEngine gets the revenue of a website per search engine
+func (r *StatAnalyticsRepository) GetRevenueBySearchEngine(websiteID int, from, to time.Time, currency string, limit int) ([]RevenueSourceData, error) {
+	return r.getRevenueBySource(websiteID, from, to, currency, limit, "CASE WHEN s.search_engine = '' OR s.search_engine IS NULL THEN '无' ELSE s.search_engine END")
+}
+
+// GetRevenueByKeyword gets the revenue of a website per search keyword
+func (r *StatAnalyticsRepository) GetRevenueByKeyword(websiteID int, from, to time.Time, currency string, limit int) ([]RevenueSourceData, error) {
+	return r.getRevenueBySource(websiteID, from, to, currency, limit, "CASE WHEN s.keyword = '' OR s.keyword IS NULL THEN '无' ELSE s.keyword END")
+}
+
+// GetRevenueByReferer gets the revenue of a website per referring site
+func (r *StatAnalyticsRepository) GetRevenueByReferer(websiteID int, from, to time.Time, currency string, limit int) ([]RevenueSourceData, error) {
+	return r.getRevenueBySource(websiteID, from, to, currency, limit, "CASE WHEN s.base_referer = '' OR s.base_referer IS NULL THEN '直接访问' ELSE s.base_referer END")
+}
+
+// getRevenueBySource groups the purchases of a website by a dimension of the visit they were placed
+// in and by currency
+func (r *StatAnalyticsRepository) getRevenueBySource(websiteID int, from, to time.Time, currency string, limit int, dimensionExpr string) ([]RevenueSourceData, error) {
+	var results []RevenueSourceData
+
+	filterSQL, filterArgs := r.visitFilterSQL("p.stat_id", websiteID)
+	args := append([]interface{}{websiteID, from, to, currency, currency}, filterArgs...)
+	rows, err := r.db.Raw(`
+		SELECT
+			`+dimensionExpr+` as name,
+			COALESCE(p.currency, '') as currency,
+			COUNT(*) as orders,
+			COALESCE(SUM(p.total), 0) as revenue
+		FROM purchases p
+		JOIN stats s ON s.id = p.stat_id
+		WHERE p.website_id = ? AND p.time >= ? AND p.time < ? AND p.deleted_at IS NULL
+			AND (? = '' OR p.currency = ?)`+filterSQL+`
+		GROUP BY name, p.currency
+		ORDER BY revenue DESC
+		LIMIT ?
+	`, append(args, limit)...).Rows()
+
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var item RevenueSourceData
+		if err := rows.Scan(&item.Name, &item.Currency, &item.Orders, &item.Revenue); err != nil {
+			return nil, err
+		}
+		results = append(results, item)
+	}
+
+	return results, nil
+}
+
+// GetHistoricalStats gets the imported visitors and page views of a website in a range of days.
+// The range must be given in the website's time zone.
+func (r *StatAnalyticsRepository) GetHistoricalStats(websiteID int, from, to time.Time) (int64, int64, error) {
+	var ipCount, pvCount int64
+
+	if len(r.filters) > 0 {
+		return 0, 0, nil
+	}
+
+	err := r.db.Model(&model.HistoricalStat{}).
+		Where("website_id = ? AND dimension = '' AND date >= ? AND date < ?", websiteID, dateOf(from), dateOf(to)).
+		Select("COALESCE(SUM(visitors), 0), COALESCE(SUM(page_views), 0)").
+		Row().Scan(&ipCount, &pvCount)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return ipCount, pvCount, nil
+}
+
+// GetHistoricalTotalStats gets all imported visitors and page views of a website
+func (r *StatAnalyticsRepository) GetHistoricalTotalStats(websiteID int) (int64, int64, error) {
+	var ipCount, pvCount int64
+
+	if len(r.filters) > 0 {
+		return 0, 0, nil
+	}
+
+	err := r.db.Model(&model.HistoricalStat{}).
+		Where("website_id = ? AND dimension = ''", websiteID).
+		Select("COALESCE(SUM(visitors), 0), COALESCE(SUM(page_views), 0)").
+		Row().Scan(&ipCount, &pvCount)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return ipCount, pvCount, nil
+}
+
+// GetHistoricalStartDate gets the first day of imported data of a website as a UTC date, nil if there is none
+func (r *StatAnalyticsRepository) GetHistoricalStartDate(websiteID int) (*time.Time, error) {
+	var stat model.HistoricalStat
+	err := r.db.Where("website_id = ?", websiteID).Order("date").Limit(1).Find(&stat).Error
+	if err != nil || stat.ID == 0 {
+		return nil, err
+	}
+	return &stat.Date, nil
+}
+
+// GetDailyStats gets the IP and PV counts per day of a website, including imported historical data.
+// Days are those of the given time zone, the range must be given in it. A website ID of 0 gets the
+// counts of all websites, regardless of the filters. Days without data are omitted.
+func (r *StatAnalyticsRepository) GetDailyStats(websiteID int, from, to time.Time, loc *time.Location) ([]DailyStatsData, error) {
+	var results []DailyStatsData
+
+	websiteFilter := ""
+	filterSQL, filterArgs := "", []interface{}(nil)
+	var until time.Time
+	if websiteID != 0 {
+		websiteFilter = "AND website_id = ?"
+		filterSQL, filterArgs = statFilterSQL(r.filters, false)
+
+		var err error
+		until, err = r.rollupsUntil(websiteID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Rolled up days come from the rollups, the others from the raw stats
+	rawFrom, rollupTo := splitAtRollups(from, to, until)
+	args := []interface{}{rawFrom, to}
+	if websiteID != 0 {
+		args = append(args, websiteID)
+	}
+	args = append(args, filterArgs...)
+	args = append(args, websiteID, model.RollupPeriodDay, dateOf(from), dateOf(rollupTo), dateOf(from), dateOf(to))
+	if websiteID != 0 {
+		args = append(args, websiteID)
+	}
+	args = append(args, filterSQL == "")
+
+	rows, err := r.db.Raw(`
+		SELECT day, SUM(pv) as pv, SUM(ip) as ip
+		FROM (
+			SELECT DATE_FORMAT(`+localTimeExpr("time", loc, rawFrom, to)+`, '%Y-%m-%d') as day, SUM(count) as pv, COUNT(*) as ip
+			FROM stats
+			WHERE time >= ? AND time < ? `+websiteFilter+` AND deleted_at IS NULL`+filterSQL+`
+			GROUP BY day
+			UNION ALL
+			SELECT bucket as day, SUM(page_views) as pv, SUM(visitors) as ip
+			FROM stat_rollups
+			WHERE website_id = ? AND period = ? AND dimension = '' AND bucket >= ? AND bucket < ?
+			GROUP BY bucket
+			UNION ALL
+			SELECT DATE_FORMAT(date, '%Y-%m-%d') as day, SUM(page_views) as pv, SUM(visitors) as ip
+			FROM historical_stats
+			WHERE date >= ? AND date < ? `+websiteFilter+` AND dimension = '' AND deleted_at IS NULL AND ?
+			GROUP BY day
+		) daily
+		GROUP BY day
+		ORDER BY day
+	`, args...).Rows()
+
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var item DailyStatsData
+		if err := rows.Scan(&item.Date, &item.PV, &item.IP); err != nil {
+			return nil, err
+		}
+		results = append(results, item)
+	}
+
+	return results, nil
+}
+
+// splitAtRollups splits a half-open range of days at the start of the first day that is not
+// rolled up. It returns the start of the part read from the raw stats and the end of the part
+// read from the rollups, in the time zone of from.
+func splitAtRollups(from, to, until time.Time) (time.Time, time.Time) {
+	rawFrom, rollupTo := from, from
+	if from.Before(until) {
+		rawFrom, rollupTo = until.In(from.Location()), until.In(from.Location())
+		if to.Before(until) {
+			rawFrom, rollupTo = to, to
+		}
+	}
+	return rawFrom, rollupTo
+}
+
+// Time series intervals
+const (
+	IntervalHour  = "hour"
+	IntervalDay   = "day"
+	IntervalWeek  = "week"
+	IntervalMonth = "month"
+)
+
+// TimeSeriesData represents the counts of a time series bucket
+type TimeSeriesData struct {
+	Bucket        string // Start of the bucket, YYYY-MM-DD HH:00:00 for hours and YYYY-MM-DD otherwise
+	IP            int64  // Distinct IPs in the bucket
+	PV            int64
+	Visits        int64
+	Bounces       int64 // Visits with a single page view
+	TrackedVisits int64 // Visits collected by aq3stat, bounces are unknown for imported data
+}
+
+// timeBucketExpr returns the SQL expression of the bucket start of a local time column
+func timeBucketExpr(interval, column string, weekStart time.Weekday) string {
+	switch interval {
+	case IntervalHour:
+		return "DATE_FORMAT(" + column + ", '%Y-%m-%d %H:00:00')"
+	case IntervalWeek:
+		// DAYOFWEEK counts from 1 for Sunday
+		return "DATE_FORMAT(DATE_SUB(DATE(" + column + "), INTERVAL (DAYOFWEEK(" + column + ") + 6 - " +
+			strconv.Itoa(int(weekStart)) + ") % 7 DAY), '%Y-%m-%d')"
+	case IntervalMonth:
+		return "DATE_FORMAT(" + column + ", '%Y-%m-01')"
+	default:
+		return "DATE_FORMAT(" + column + ", '%Y-%m-%d')"
+	}
+}
+
+// GetTimeSeries gets the counts of a website per time bucket of the given time zone, in which the
+// range must be given. Imported historical data is included for daily and coarser intervals
+// when there are no filters.
+// Buckets without data are omitted.
+func (r *StatAnalyticsRepository) GetTimeSeries(websiteID int, from, to time.Time, interval string, loc *time.Location, weekStart time.Weekday) ([]TimeSeriesData, error) {
+	var results []TimeSeriesData
+
+	until, err := r.rollupsUntil(websiteID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Rolled up days come from the rollups, the others from the raw stats. Rollups count the
+	// visitors per day, the visitors of weeks and months with rolled up days are counted below.
+	rawFrom, rollupTo := splitAtRollups(from, to, until)
+	filterSQL, filterArgs := statFilterSQL(r.filters, false)
+	period := model.RollupPeriodDay
+	if interval == IntervalHour {
+		period = model.RollupPeriodHour
+	}
+
+	query := `
+		SELECT bucket, SUM(ip), SUM(historical_ip), SUM(pv), SUM(visits), SUM(bounces), SUM(tracked_visits)
+		FROM (
+			SELECT ` + timeBucketExpr(interval, localTimeExpr("time", loc, rawFrom, to), weekStart) + ` as bucket,
+				COUNT(DISTINCT ip) as ip, 0 as historical_ip, SUM(count) as pv, COUNT(*) as visits,
+				SUM(CASE WHEN count = 1 THEN 1 ELSE 0 END) as bounces, COUNT(*) as tracked_visits
+			FROM stats
+			WHERE website_id = ? AND time >= ? AND time < ? AND deleted_at IS NULL` + filterSQL + `
+			GROUP BY 1
+			UNION ALL
+			SELECT ` + timeBucketExpr(interval, "bucket", weekStart) + ` as bucket,
+				SUM(visitors) as ip, 0 as historical_ip, SUM(page_views) as pv, SUM(visitors) as visits,
+				SUM(bounces) as bounces, SUM(visitors) as tracked_visits
+			FROM stat_rollups
+			WHERE website_id = ? AND period = ? AND dimension = '' AND bucket >= ? AND bucket < ?
+			GROUP BY 1`
+	args := append([]interface{}{websiteID, rawFrom, to}, filterArgs...)
+	args = append(args, websiteID, period, dateOf(from), dateOf(rollupTo))
+
+	if interval != IntervalHour && len(r.filters) == 0 {
+		query += `
+			UNION ALL
+			SELECT ` + timeBucketExpr(interval, "date", weekStart) + ` as bucket,
+				0 as ip, SUM(visitors) as historical_ip, SUM(page_views) as pv, SUM(visits) as visits,
+				0 as bounces, 0 as tracked_visits
+			FROM historical_stats
+			WHERE website_id = ? AND date >= ? AND date < ? AND dimension = '' AND deleted_at IS NULL
+			GROUP BY bucket`
+		args = append(args, websiteID, dateOf(from), dateOf(to))
+	}
+
+	rows, err := r.db.Raw(query+`
+		) series
+		GROUP BY bucket
+		ORDER BY bucket`, args...).Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var historicalIPs []int64
+	for rows.Next() {
+		var item TimeSeriesData
+		var historicalIP int64
+		if err := rows.Scan(&item.Bucket, &item.IP, &historicalIP, &item.PV, &item.Visits, &item.Bounces, &item.TrackedVisits); err != nil {
+			return nil, err
+		}
+		results = append(results, item)
+		historicalIPs = append(historicalIPs, historicalIP)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	// A visitor coming back on several days of a week or month is counted once, by merging the
+	// sketches of the rolled up days with the raw IPs of the others
+	for i := range results {
+		item := &results[i]
+		item.IP += historicalIPs[i]
+		if interval != IntervalWeek && interval != IntervalMonth {
+			continue
+		}
+
+		bucketFrom, err := time.ParseInLocation("2006-01-02", item.Bucket, loc)
+		if err != nil {
+			return nil, err
+		}
+		bucketTo := bucketFrom.AddDate(0, 0, 7)
+		if interval == IntervalMonth {
+			bucketTo = bucketFrom.AddDate(0, 1, 0)
+		}
+		if bucketFrom.Before(from) {
+			bucketFrom = from
+		}
+		if to.Before(bucketTo) {
+			bucketTo = to
+		}
+		if !bucketFrom.Before(until) {
+			continue
+		}
+
+		sketch, unsketched, err := r.visitorSketch(websiteID, bucketFrom, bucketTo, until)
+		if err != nil {
+			return nil, err
+		}
+		item.IP = int64(sketch.Count()) + unsketched + historicalIPs[i]
+	}
+
+	return results, nil
+}
+
+// Breakdown sort keys
+const (
+	BreakdownSortValue  = "value"
+	BreakdownSortIP     = "ip"
+	BreakdownSortPV     = "pv"
+	BreakdownSortVisits = "visits"
+)
+
+// BreakdownData represents the counts of a dimension value
+type BreakdownData struct {
+	Value  string `json:"value"`
+	IP     int64  `json:"ip"`
+	PV     int64  `json:"pv"`
+	Visits int64  `json:"visits"`
+}
+
+// GetBreakdown gets a page of the counts of a website per value of a dimension in a half-open
+// range of days in the website's time zone, along with the number of distinct values. Unlike
+// the other stats, rolled up days are read from the rollups when all filters are on the broken
+// down dimension, which then count IP per day. Other filters need the raw stats, so purged
+// days are left out.
+func (r *StatAnalyticsRepository) GetBreakdown(websiteID int, dimension string, from, to time.Time, sort string, desc bool, limit, offset int) ([]BreakdownData, int64, error) {
+	values, args, err := r.breakdownQuery(websiteID, dimension, from, to)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var total int64
+	if err := r.db.Raw("SELECT COUNT(*) FROM ("+values+") breakdown_values", args...).Row().Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	order := BreakdownSortPV
+	switch sort {
+	case BreakdownSortValue, BreakdownSortIP, BreakdownSortVisits:
+		order = sort
+	}
+	if desc {
+		order += " DESC"
+	}
+
+	results, err := r.scanBreakdown(values+`
+		ORDER BY `+order+`, value
+		LIMIT ? OFFSET ?`, append(args, limit, offset)...)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return results, total, nil
+}
+
+// GetBreakdownValues gets the counts of a website for the given values of a dimension, like
+// GetBreakdown does. Values without visits are omitted.
+func (r *StatAnalyticsRepository) GetBreakdownValues(websiteID int, dimension string, from, to time.Time, values []string) ([]BreakdownData, error) {
+	if len(values) == 0 {
+		return nil, nil
+	}
+
+	query, args, err := r.breakdownQuery(websiteID, dimension, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	return r.scanBreakdown(`
+		SELECT value, ip, pv, visits
+		FROM (`+query+`) breakdown_values
+		WHERE value IN (?)`, append(args, values)...)
+}
+
+// breakdownQuery returns the query of the counts of a website per value of a dimension and its arguments
+func (r *StatAnalyticsRepository) breakdownQuery(websiteID int, dimension string, from, to time.Time) (string, []interface{}, error) {
+	d := statDimensions[dimension]
+	rawFilters, rawArgs := statFilterSQL(r.filters, false)
+
+	query := `
+		SELECT value, SUM(ip) as ip, SUM(pv) as pv, SUM(visits) as visits
+		FROM (
+			SELECT ` + d.column + ` as value, COUNT(DISTINCT ip) as ip, SUM(count) as pv, COUNT(*) as visits
+			FROM stats
+			WHERE website_id = ? AND time >= ? AND time < ? AND deleted_at IS NULL` + rawFilters + `
+			GROUP BY 1`
+	args := append([]interface{}{websiteID, from, to}, rawArgs...)
+
+	// Rolled up days come from the rollups when the filters can be applied to them
+	if rollsUpFilters(r.filters, dimension) {
+		until, err := rolledUntil(r.db, websiteID)
+		if err != nil {
+			return "", nil, err
+		}
+		rawFrom, rollupTo := splitAtRollups(from, to, until)
+		args[1] = rawFrom
+
+		rollupFilters, rollupArgs := statFilterSQL(r.filters, true)
+		query += `
+			UNION ALL
+			SELECT ` + d.rollupValue + ` as value, SUM(visitors) as ip, SUM(page_views) as pv, SUM(visitors) as visits
+			FROM stat_rollups
+			WHERE website_id = ? AND period = ? AND dimension = ? AND bucket >= ? AND bucket < ?` + rollupFilters + `
+			GROUP BY 1`
+		args = append(args, websiteID, model.RollupPeriodDay, d.rollupDimension, dateOf(from), dateOf(rollupTo))
+		args = append(args, rollupArgs...)
+	}
+
+	return query + `
+		) breakdown
+		GROUP BY value`, args, nil
+}
+
+// scanBreakdown runs a query of counts per dimension value
+func (r *StatAnalyticsRepository) scanBreakdown(query string, args ...interface{}) ([]BreakdownData, error) {
+	var results []BreakdownData
+
+	rows, err := r.db.Raw(query, args...).Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var item BreakdownData
+		if err := rows.Scan(&item.Value, &item.IP, &item.PV, &item.Visits); err != nil {
+			return nil, err
+		}
+		results = append(results, item)
+	}
+
+	return results, nil
+}
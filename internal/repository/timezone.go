@@ -0,0 +1,76 @@
+package repository
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// zoneTransition is a change of the UTC offset of a time zone
+type zoneTransition struct {
+	at     time.Time
+	offset int // Offset in seconds from the transition on
+}
+
+// zoneTransitions finds the offset changes of a time zone in a time range. Zones change
+// their offset at most once a day, so days are scanned and each change is bisected.
+func zoneTransitions(loc *time.Location, from, to time.Time) []zoneTransition {
+	var transitions []zoneTransition
+
+	_, offset := from.In(loc).Zone()
+	for t := from; t.Before(to); {
+		next := t.Add(24 * time.Hour)
+		if next.After(to) {
+			next = to
+		}
+
+		if _, nextOffset := next.In(loc).Zone(); nextOffset != offset {
+			lo, hi := t, next
+			for hi.Sub(lo) > time.Second {
+				mid := lo.Add(hi.Sub(lo) / 2)
+				if _, midOffset := mid.In(loc).Zone(); midOffset == offset {
+					lo = mid
+				} else {
+					hi = mid
+				}
+			}
+			transitions = append(transitions, zoneTransition{at: hi, offset: nextOffset})
+			offset = nextOffset
+		}
+
+		t = next
+	}
+
+	return transitions
+}
+
+// localTimeExpr returns the SQL expression converting a UTC datetime column into the wall clock
+// time of a time zone. Daylight saving changes within the queried range are taken into account
+// without relying on the time zone tables of MySQL.
+func localTimeExpr(column string, loc *time.Location, from, to time.Time) string {
+	_, offset := from.In(loc).Zone()
+	transitions := zoneTransitions(loc, from, to)
+
+	if len(transitions) == 0 {
+		if offset == 0 {
+			return column
+		}
+		return "DATE_ADD(" + column + ", INTERVAL " + strconv.Itoa(offset) + " SECOND)"
+	}
+
+	var expr strings.Builder
+	expr.WriteString("DATE_ADD(" + column + ", INTERVAL CASE")
+	for _, transition := range transitions {
+		expr.WriteString(" WHEN " + column + " < '" + transition.at.UTC().Format("2006-01-02 15:04:05") + "' THEN " + strconv.Itoa(offset))
+		offset = transition.offset
+	}
+	expr.WriteString(" ELSE " + strconv.Itoa(offset) + " END SECOND)")
+
+	return expr.String()
+}
+
+// dateOf formats the day of t for comparisons with DATE columns. Times must be in the zone
+// the dates are stored in.
+func dateOf(t time.Time) string {
+	return t.Format("2006-01-02")
+}
@@ -0,0 +1,268 @@
+package repository
+
+import (
+	"time"
+
+	"aq3stat/internal/model"
+	"aq3stat/pkg/hll"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// buildSketches builds the sketches of the IPs of a website per day of a half-open range, given
+// as day starts in the website's time zone. Days without stats have no sketch.
+func buildSketches(db *gorm.DB, websiteID int, from, to time.Time) (map[string]*hll.Sketch, error) {
+	sketches := make(map[string]*hll.Sketch)
+
+	rows, err := db.Raw(`
+		SELECT DATE_FORMAT(`+localTimeExpr("time", from.Location(), from, to)+`, '%Y-%m-%d') as sketch_date, ip
+		FROM stats
+		WHERE website_id = ? AND time >= ? AND time < ? AND deleted_at IS NULL
+		GROUP BY sketch_date, ip
+	`, websiteID, from, to).Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var date, ip string
+		if err := rows.Scan(&date, &ip); err != nil {
+			return nil, err
+		}
+		if sketches[date] == nil {
+			sketches[date] = hll.New()
+		}
+		sketches[date].AddString(ip)
+	}
+
+	return sketches, rows.Err()
+}
+
+// saveSketches replaces the sketches of a website for the dates in a half-open range and merges
+// them into the sketches of all websites. Merging only adds IPs, so rebuilt days never remove
+// IPs from the sketches of all websites.
+func saveSketches(tx *gorm.DB, websiteID int, fromDay, toDay string, sketches map[string]*hll.Sketch) error {
+	err := tx.Where("website_id = ? AND date >= ? AND date < ?", websiteID, fromDay, toDay).
+		Delete(&model.VisitorSketch{}).Error
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for date, sketch := range sketches {
+		data, err := sketch.MarshalBinary()
+		if err != nil {
+			return err
+		}
+		err = tx.Create(&model.VisitorSketch{WebsiteID: websiteID, Date: date, Sketch: data, UpdatedAt: now}).Error
+		if err != nil {
+			return err
+		}
+
+		// Lock the sketch of all websites, other websites may be rolled up concurrently
+		var system model.VisitorSketch
+		err = tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("website_id = ? AND date = ?", model.SystemSketchWebsiteID, date).
+			Limit(1).Find(&system).Error
+		if err != nil {
+			return err
+		}
+
+		merged := hll.New()
+		if len(system.Sketch) > 0 {
+			if err := merged.UnmarshalBinary(system.Sketch); err != nil {
+				return err
+			}
+		}
+		merged.Merge(sketch)
+		if data, err = merged.MarshalBinary(); err != nil {
+			return err
+		}
+
+		err = tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "website_id"}, {Name: "date"}},
+			DoUpdates: clause.AssignmentColumns([]string{"sketch", "updated_at"}),
+		}).Create(&model.VisitorSketch{WebsiteID: model.SystemSketchWebsiteID, Date: date, Sketch: data, UpdatedAt: now}).Error
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// GetUniqueVisitors gets the number of distinct IPs of a website in a half-open range, open-ended
+// if to is zero. Rolled up days are counted by merging their sketches, so the count is an
+// estimate within the error bound of the hll package, and from must be the start of a day in the
+// website's time zone. Rolled up days without a sketch, whose stats were purged before sketches
+// were built, add their daily visitors. Imported historical data is not included.
+func (r *StatAnalyticsRepository) GetUniqueVisitors(websiteID int, from, to time.Time) (int64, error) {
+	until, err := r.rollupsUntil(websiteID)
+	if err != nil {
+		return 0, err
+	}
+
+	// Count exactly when no day is rolled up
+	if !from.Before(until) {
+		query := r.filterStats(r.db.Model(&model.Stat{}).Where("website_id = ? AND time >= ?", websiteID, from))
+		if !to.IsZero() {
+			query = query.Where("time < ?", to)
+		}
+
+		var count int64
+		err := query.Distinct("ip").Count(&count).Error
+		return count, err
+	}
+
+	sketch, unsketched, err := r.visitorSketch(websiteID, from, to, until)
+	if err != nil {
+		return 0, err
+	}
+	return int64(sketch.Count()) + unsketched, nil
+}
+
+// GetVisitorSketch gets a sketch of the distinct IPs of a website in a half-open range, open-ended
+// if to is zero, and the daily visitors of the rolled up days without a sketch. Sketches of
+// adjacent ranges can be merged, e.g. to count closed days once and only add today's IPs. from
+// must be the start of a day in the website's time zone.
+func (r *StatAnalyticsRepository) GetVisitorSketch(websiteID int, from, to time.Time) (*hll.Sketch, int64, error) {
+	until, err := r.rollupsUntil(websiteID)
+	if err != nil {
+		return nil, 0, err
+	}
+	return r.visitorSketch(websiteID, from, to, until)
+}
+
+// visitorSketch merges the sketches of the days rolled up before until and adds the raw IPs
+// of the later days
+func (r *StatAnalyticsRepository) visitorSketch(websiteID int, from, to, until time.Time) (*hll.Sketch, int64, error) {
+	sketch := hll.New()
+	if !from.Before(until) {
+		query := r.filterStats(r.db.Model(&model.Stat{}).Where("website_id = ? AND time >= ?", websiteID, from))
+		if !to.IsZero() {
+			query = query.Where("time < ?", to)
+		}
+		return sketch, 0, addIPs(query.Distinct("ip"), sketch)
+	}
+
+	rollupTo := until
+	if !to.IsZero() && to.Before(until) {
+		rollupTo = to
+	}
+	fromDay, toDay := dateOf(from), dateOf(rollupTo.In(from.Location()))
+
+	err := mergeSketches(r.db.Model(&model.VisitorSketch{}).
+		Where("website_id = ? AND date >= ? AND date < ?", websiteID, fromDay, toDay), sketch)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var unsketched int64
+	err = r.db.Raw(`
+		SELECT COALESCE(SUM(visitors), 0)
+		FROM stat_rollups
+		WHERE website_id = ? AND period = ? AND dimension = '' AND bucket >= ? AND bucket < ?
+			AND bucket NOT IN (SELECT date FROM visitor_sketches WHERE website_id = ? AND date >= ? AND date < ?)
+	`, websiteID, model.RollupPeriodDay, fromDay, toDay, websiteID, fromDay, toDay).Row().Scan(&unsketched)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if to.IsZero() || until.Before(to) {
+		query := r.db.Model(&model.Stat{}).Where("website_id = ? AND time >= ?", websiteID, until)
+		if !to.IsZero() {
+			query = query.Where("time < ?", to)
+		}
+		if err := addIPs(query.Distinct("ip"), sketch); err != nil {
+			return nil, 0, err
+		}
+	}
+
+	return sketch, unsketched, nil
+}
+
+// GetSystemUniqueVisitors gets the number of distinct IPs of all websites in a half-open range of
+// days of the given time zone, open-ended if from or to is zero. Rolled up days are counted by
+// merging the sketches of all websites for their dates, the days of the websites that are not
+// rolled up from their raw stats.
+func (r *StatAnalyticsRepository) GetSystemUniqueVisitors(from, to time.Time) (int64, error) {
+	stats, err := r.systemRawStats(from, to)
+	if err != nil {
+		return 0, err
+	}
+
+	sketches := r.db.Model(&model.VisitorSketch{}).Where("website_id = ?", model.SystemSketchWebsiteID)
+	rollups := r.db.Model(&model.StatRollup{}).Where("period = ? AND dimension = ''", model.RollupPeriodDay)
+	if !from.IsZero() {
+		sketches = sketches.Where("date >= ?", dateOf(from))
+		rollups = rollups.Where("bucket >= ?", dateOf(from))
+	}
+	if !to.IsZero() {
+		sketches = sketches.Where("date < ?", dateOf(to))
+		rollups = rollups.Where("bucket < ?", dateOf(to))
+	}
+
+	// The queries are reused below
+	sketches = sketches.Session(&gorm.Session{})
+
+	sketch := hll.New()
+	if err := mergeSketches(sketches, sketch); err != nil {
+		return 0, err
+	}
+	if err := addIPs(stats.Select("DISTINCT stats.ip"), sketch); err != nil {
+		return 0, err
+	}
+
+	var unsketched int64
+	err = rollups.Where("bucket NOT IN (?)", sketches.Select("date")).
+		Select("COALESCE(SUM(visitors), 0)").Row().Scan(&unsketched)
+	if err != nil {
+		return 0, err
+	}
+
+	return int64(sketch.Count()) + unsketched, nil
+}
+
+// mergeSketches merges the sketches selected by a query into a sketch
+func mergeSketches(query *gorm.DB, sketch *hll.Sketch) error {
+	rows, err := query.Select("sketch").Rows()
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var data []byte
+		if err := rows.Scan(&data); err != nil {
+			return err
+		}
+
+		day := &hll.Sketch{}
+		if err := day.UnmarshalBinary(data); err != nil {
+			return err
+		}
+		sketch.Merge(day)
+	}
+
+	return rows.Err()
+}
+
+// addIPs adds the IPs selected by a query to a sketch
+func addIPs(query *gorm.DB, sketch *hll.Sketch) error {
+	rows, err := query.Rows()
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var ip string
+		if err := rows.Scan(&ip); err != nil {
+			return err
+		}
+		sketch.AddString(ip)
+	}
+
+	return rows.Err()
+}
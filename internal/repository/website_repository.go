@@ -36,6 +36,16 @@ func (r *WebsiteRepository) FindByID(id int) (*model.Website, error) {
 	return &website, nil
 }
 
+// FindByIDWithOwnerGroup finds a website by ID with its owner and the owner's group
+func (r *WebsiteRepository) FindByIDWithOwnerGroup(id int) (*model.Website, error) {
+	var website model.Website
+	err := r.db.Preload("User.Group").First(&website, id).Error
+	if err != nil {
+		return nil, err
+	}
+	return &website, nil
+}
+
 // Update updates a website
 func (r *WebsiteRepository) Update(website *model.Website) error {
 	return r.db.Save(website).Error
@@ -49,6 +59,16 @@ func (r *WebsiteRepository) Delete(id int) error {
 		return err
 	}
 
+	// And the rollups of the stats
+	err = r.db.Where("website_id = ?", id).Delete(&model.StatRollup{}).Error
+	if err != nil {
+		return err
+	}
+	err = r.db.Where("website_id = ?", id).Delete(&model.RollupState{}).Error
+	if err != nil {
+		return err
+	}
+
 	// Then delete the website
 	return r.db.Delete(&model.Website{}, id).Error
 }
@@ -63,6 +83,16 @@ func (r *WebsiteRepository) ListByUserID(userID int) ([]model.Website, error) {
 	return websites, nil
 }
 
+// ListAll returns all websites with their owners
+func (r *WebsiteRepository) ListAll() ([]model.Website, error) {
+	var websites []model.Website
+	err := r.db.Preload("User").Order("id").Find(&websites).Error
+	if err != nil {
+		return nil, err
+	}
+	return websites, nil
+}
+
 // ListPublic returns a list of public websites
 func (r *WebsiteRepository) ListPublic(page, pageSize int) ([]model.Website, int64, error) {
 	var websites []model.Website
@@ -134,10 +164,10 @@ func (r *StatRepository) Update(stat *model.Stat) error {
 	return r.db.Save(stat).Error
 }
 
-// FindByWebsiteIDAndIP finds a stat by website ID and IP for today
-func (r *StatRepository) FindByWebsiteIDAndIP(websiteID int, ip string, today time.Time) (*model.Stat, error) {
+// FindByWebsiteIDAndIP finds a stat by website ID and IP for a day
+func (r *StatRepository) FindByWebsiteIDAndIP(websiteID int, ip string, today, tomorrow time.Time) (*model.Stat, error) {
 	var stat model.Stat
-	err := r.db.Where("website_id = ? AND ip = ? AND time >= ?", websiteID, ip, today).First(&stat).Error
+	err := r.db.Where("website_id = ? AND ip = ? AND time >= ? AND time < ?", websiteID, ip, today, tomorrow).First(&stat).Error
 	if err != nil {
 		return nil, err
 	}
@@ -154,6 +184,38 @@ func (r *StatRepository) FindByWebsiteIDAndIPYesterday(websiteID int, ip string,
 	return &stat, nil
 }
 
+// CountByWebsiteIDAndTime counts the stats of a website in a time range
+func (r *StatRepository) CountByWebsiteIDAndTime(websiteID int, from, to time.Time) (int64, error) {
+	var count int64
+	err := r.db.Model(&model.Stat{}).Where("website_id = ? AND time >= ? AND time < ?", websiteID, from, to).Count(&count).Error
+	return count, err
+}
+
+// EachByWebsiteIDAndTime calls fn for every stat of a website in a time range in time order,
+// without loading them all into memory
+func (r *StatRepository) EachByWebsiteIDAndTime(websiteID int, from, to time.Time, fn func(stat *model.Stat) error) error {
+	rows, err := r.db.Model(&model.Stat{}).
+		Where("website_id = ? AND time >= ? AND time < ?", websiteID, from, to).
+		Order("time, id").
+		Rows()
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var stat model.Stat
+		if err := r.db.ScanRows(rows, &stat); err != nil {
+			return err
+		}
+		if err := fn(&stat); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
 // IPDataRepository handles database operations for IP data
 type IPDataRepository struct {
 	db *gorm.DB
@@ -0,0 +1,467 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"aq3stat/internal/model"
+	"aq3stat/internal/repository"
+	"aq3stat/pkg/notify"
+)
+
+// anomalyMaxCatchUp is the number of past hours analyzed when the analyzer didn't run for a while
+const anomalyMaxCatchUp = 24
+
+// anomalySettings holds the settings of the anomaly detection
+type anomalySettings struct {
+	baselineWeeks int     // Weeks the baseline of an hour is taken from
+	minSamples    int     // Weeks of history needed to analyze an hour
+	threshold     float64 // Deviations from the baseline an anomaly is at least away
+	minCount      int64   // Count a spike must reach, and a drop or zero traffic must be expected
+}
+
+// AnomalyService handles the traffic anomaly detection and its notification channels
+type AnomalyService struct {
+	websiteRepo *repository.WebsiteRepository
+	anomalyRepo *repository.AnomalyRepository
+	channelRepo *repository.AnomalyChannelRepository
+	rollupRepo  *repository.RollupRepository
+	settings    anomalySettings
+}
+
+// NewAnomalyService creates a new anomaly service with the settings of the ANOMALY_BASELINE_WEEKS,
+// ANOMALY_MIN_SAMPLES, ANOMALY_THRESHOLD and ANOMALY_MIN_COUNT environment variables
+func NewAnomalyService() *AnomalyService {
+	return &AnomalyService{
+		websiteRepo: repository.NewWebsiteRepository(),
+		anomalyRepo: repository.NewAnomalyRepository(),
+		channelRepo: repository.NewAnomalyChannelRepository(),
+		rollupRepo:  repository.NewRollupRepository(),
+		settings: anomalySettings{
+			baselineWeeks: getAnomalyInt("ANOMALY_BASELINE_WEEKS", 6),
+			minSamples:    getAnomalyInt("ANOMALY_MIN_SAMPLES", 3),
+			threshold:     getAnomalyFloat("ANOMALY_THRESHOLD", 3),
+			minCount:      int64(getAnomalyInt("ANOMALY_MIN_COUNT", 10)),
+		},
+	}
+}
+
+// AnomalyList represents a page of the anomalies of a website
+type AnomalyList struct {
+	Anomalies []model.Anomaly `json:"anomalies"`
+	Total     int64           `json:"total"`
+	Page      int             `json:"page"`
+	Size      int             `json:"size"`
+}
+
+// ListAnomalies lists the anomalies of a website in a time range, newest first, optionally of a type only
+func (s *AnomalyService) ListAnomalies(websiteID int, from, to time.Time, anomalyType string, page, pageSize int) (*AnomalyList, error) {
+	anomalies, total, err := s.anomalyRepo.List(websiteID, from, to, anomalyType, page, pageSize)
+	if err != nil {
+		return nil, err
+	}
+
+	if anomalies == nil {
+		anomalies = []model.Anomaly{}
+	}
+	return &AnomalyList{Anomalies: anomalies, Total: total, Page: page, Size: pageSize}, nil
+}
+
+// ListChannels lists the anomaly notification channels of a website
+func (s *AnomalyService) ListChannels(websiteID int) ([]model.AnomalyChannel, error) {
+	return s.channelRepo.ListByWebsiteID(websiteID)
+}
+
+// GetChannelByID gets an anomaly notification channel by ID
+func (s *AnomalyService) GetChannelByID(id int) (*model.AnomalyChannel, error) {
+	return s.channelRepo.FindByID(id)
+}
+
+// CreateChannel creates a new anomaly notification channel
+func (s *AnomalyService) CreateChannel(channel *model.AnomalyChannel) error {
+	if err := validateAnomalyChannel(channel); err != nil {
+		return err
+	}
+	return s.channelRepo.Create(channel)
+}
+
+// UpdateChannel updates an anomaly notification channel
+func (s *AnomalyService) UpdateChannel(channel *model.AnomalyChannel) error {
+	if err := validateAnomalyChannel(channel); err != nil {
+		return err
+	}
+	return s.channelRepo.Update(channel)
+}
+
+// DeleteChannel deletes an anomaly notification channel
+func (s *AnomalyService) DeleteChannel(id int) error {
+	return s.channelRepo.Delete(id)
+}
+
+// TestChannel sends a notification without anomalies on a channel of a website
+func (s *AnomalyService) TestChannel(website *model.Website, channel *model.AnomalyChannel) error {
+	err := sendAnomalyNotification(website, channel, nil)
+	if err != nil {
+		log.Printf("Failed to send test notification on anomaly channel %d: %v", channel.ID, err)
+	}
+	return err
+}
+
+// validateAnomalyChannel validates the definition of an anomaly notification channel
+func validateAnomalyChannel(channel *model.AnomalyChannel) error {
+	channel.Target = strings.TrimSpace(channel.Target)
+	if channel.Target == "" || len(channel.Target) > 1000 {
+		return errors.New("channel target must be between 1 and 1000 characters")
+	}
+	if len(channel.Secret) > 255 {
+		return errors.New("channel secret must not exceed 255 characters")
+	}
+
+	switch channel.Type {
+	case model.AnomalyChannelWebhook:
+		return notify.ValidateWebhookURL(channel.Target)
+	case model.AnomalyChannelEmail:
+		_, err := notify.ParseAddresses(channel.Target)
+		return err
+	default:
+		return errors.New("channel type must be WEBHOOK or EMAIL")
+	}
+}
+
+// StartAnomalyWorker analyzes the traffic of all websites in the background, right away and
+// then every ANOMALY_INTERVAL (10m by default)
+func (s *AnomalyService) StartAnomalyWorker() {
+	interval, err := time.ParseDuration(os.Getenv("ANOMALY_INTERVAL"))
+	if err != nil || interval <= 0 {
+		interval = 10 * time.Minute
+	}
+
+	go func() {
+		for {
+			if err := s.AnalyzeAll(); err != nil {
+				log.Printf("Failed to analyze traffic: %v", err)
+			}
+			time.Sleep(interval)
+		}
+	}()
+}
+
+// AnalyzeAll analyzes the hours of all websites that ended since they were last analyzed and
+// notifies the anomalies that weren't notified yet. Anomalies whose notification failed on all
+// channels are sent again on the next runs, up to anomalyMaxCatchUp hours after their hour.
+func (s *AnomalyService) AnalyzeAll() error {
+	websites, err := s.websiteRepo.ListAll()
+	if err != nil {
+		return err
+	}
+
+	for i := range websites {
+		website := &websites[i]
+		if _, err := s.AnalyzeWebsite(website); err != nil {
+			log.Printf("Failed to analyze traffic of website %d: %v", website.ID, err)
+		}
+
+		since := website.StartOfHour(website.Now()).Add(-anomalyMaxCatchUp * time.Hour)
+		anomalies, err := s.anomalyRepo.ListUnnotified(website.ID, since)
+		if err != nil {
+			log.Printf("Failed to list anomalies of website %d to notify: %v", website.ID, err)
+			continue
+		}
+		s.notifyAnomalies(website, anomalies)
+	}
+
+	return nil
+}
+
+// AnalyzeWebsite analyzes the hours of a website that ended since it was last analyzed, up to
+// a day back, and returns the anomalies found. The first analysis only covers the last hour.
+func (s *AnomalyService) AnalyzeWebsite(website *model.Website) ([]model.Anomaly, error) {
+	state, err := s.anomalyRepo.GetState(website.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	until := website.StartOfHour(website.Now())
+	from := until.Add(-time.Hour)
+	if !state.AnalyzedUntil.IsZero() {
+		from = website.StartOfHour(state.AnalyzedUntil)
+		if earliest := until.Add(-anomalyMaxCatchUp * time.Hour); from.Before(earliest) {
+			from = earliest
+		}
+	}
+
+	// The baseline only covers full hours with raw page views. Page views are only recorded
+	// since the upgrade adding them, the weeks before don't count as weeks without traffic.
+	firstPageView, err := s.anomalyRepo.GetFirstPageViewTime(website.ID)
+	if err != nil {
+		return nil, err
+	}
+	if firstPageView == nil {
+		return nil, s.anomalyRepo.SetAnalyzedUntil(website.ID, until)
+	}
+	validFrom := website.StartOfHour(*firstPageView).Add(time.Hour)
+	if validFrom.Before(website.StartTime) {
+		validFrom = website.StartTime
+	}
+	rollupState, err := s.rollupRepo.GetState(website.ID)
+	if err != nil {
+		return nil, err
+	}
+	if rollupState.PurgedUntil != nil && rollupState.PurgedUntil.After(validFrom) {
+		validFrom = *rollupState.PurgedUntil
+	}
+
+	var anomalies []model.Anomaly
+	for hour := from; hour.Before(until); hour = hour.Add(time.Hour) {
+		found, err := s.analyzeHour(website, hour, validFrom)
+		if err != nil {
+			return anomalies, err
+		}
+		anomalies = append(anomalies, found...)
+	}
+
+	return anomalies, s.anomalyRepo.SetAnalyzedUntil(website.ID, until)
+}
+
+// analyzeHour compares the PV and IP counts of an hour of a website to those of the same hour
+// of the week in the previous weeks since validFrom, and records the anomalies found. Anomalies
+// continuing an anomaly of the previous hour are recorded as notified, they aren't returned.
+func (s *AnomalyService) analyzeHour(website *model.Website, hour, validFrom time.Time) ([]model.Anomaly, error) {
+	if hour.Before(validFrom) {
+		return nil, nil
+	}
+
+	pv, ip, err := s.anomalyRepo.GetHourCounts(website.ID, hour, hour.Add(time.Hour))
+	if err != nil {
+		return nil, err
+	}
+
+	var pvSamples, ipSamples []int64
+	for week := 1; week <= s.settings.baselineWeeks; week++ {
+		start := hour.AddDate(0, 0, -7*week)
+		if start.Before(validFrom) {
+			break
+		}
+
+		samplePV, sampleIP, err := s.anomalyRepo.GetHourCounts(website.ID, start, start.Add(time.Hour))
+		if err != nil {
+			return nil, err
+		}
+		pvSamples = append(pvSamples, samplePV)
+		ipSamples = append(ipSamples, sampleIP)
+	}
+	if len(pvSamples) < s.settings.minSamples {
+		return nil, nil
+	}
+
+	metrics := []struct {
+		metric  string
+		value   int64
+		samples []int64
+	}{
+		{model.AnomalyMetricPV, pv, pvSamples},
+		{model.AnomalyMetricIP, ip, ipSamples},
+	}
+
+	var anomalies []model.Anomaly
+	for _, m := range metrics {
+		anomalyType, expected, deviation, score := s.settings.detect(m.value, m.samples)
+		if anomalyType == "" {
+			continue
+		}
+
+		anomaly := model.Anomaly{
+			WebsiteID: website.ID,
+			Hour:      hour,
+			Metric:    m.metric,
+			Type:      anomalyType,
+			Value:     m.value,
+			Expected:  expected,
+			Deviation: deviation,
+			Score:     score,
+		}
+
+		continued, err := s.anomalyRepo.Exists(website.ID, hour.Add(-time.Hour), m.metric, anomalyType)
+		if err != nil {
+			return nil, err
+		}
+		if continued {
+			now := time.Now()
+			anomaly.NotifiedAt = &now
+		}
+
+		created, err := s.anomalyRepo.Create(&anomaly)
+		if err != nil {
+			return nil, err
+		}
+		if created && !continued {
+			anomalies = append(anomalies, anomaly)
+		}
+	}
+
+	return anomalies, nil
+}
+
+// detect classifies a count against the counts of the same hour in previous weeks. The baseline
+// is their median and the usual deviation their median absolute deviation, scaled to estimate
+// the standard deviation. Neither is thrown off by a past anomaly among the samples.
+func (c anomalySettings) detect(value int64, samples []int64) (anomalyType string, expected, deviation, score float64) {
+	values := make([]float64, len(samples))
+	for i, sample := range samples {
+		values[i] = float64(sample)
+	}
+	expected = median(values)
+
+	for i := range values {
+		values[i] = math.Abs(values[i] - expected)
+	}
+	deviation = 1.4826 * median(values)
+
+	// Counts vary at least like a Poisson process, also when the samples happen to be equal
+	deviation = math.Max(deviation, math.Max(math.Sqrt(expected), 1))
+	score = (float64(value) - expected) / deviation
+
+	switch {
+	case value == 0 && expected >= float64(c.minCount):
+		anomalyType = model.AnomalyTypeZeroTraffic
+	case score >= c.threshold && value >= c.minCount:
+		anomalyType = model.AnomalyTypeSpike
+	case score <= -c.threshold && expected >= float64(c.minCount):
+		anomalyType = model.AnomalyTypeDrop
+	}
+	return anomalyType, expected, deviation, score
+}
+
+// notifyAnomalies sends the anomalies found on the enabled channels of a website and records
+// them as notified when a channel succeeded
+func (s *AnomalyService) notifyAnomalies(website *model.Website, anomalies []model.Anomaly) {
+	if len(anomalies) == 0 {
+		return
+	}
+
+	channels, err := s.channelRepo.ListByWebsiteID(website.ID)
+	if err != nil {
+		log.Printf("Failed to list anomaly channels of website %d: %v", website.ID, err)
+		return
+	}
+
+	notified := false
+	for i := range channels {
+		if !channels[i].IsEnabled {
+			continue
+		}
+		if err := sendAnomalyNotification(website, &channels[i], anomalies); err != nil {
+			log.Printf("Failed to notify anomalies of website %d on channel %d: %v", website.ID, channels[i].ID, err)
+			continue
+		}
+		notified = true
+	}
+	if !notified {
+		return
+	}
+
+	ids := make([]int, len(anomalies))
+	for i := range anomalies {
+		ids[i] = anomalies[i].ID
+	}
+	if err := s.anomalyRepo.MarkNotified(ids, time.Now()); err != nil {
+		log.Printf("Failed to mark anomalies of website %d as notified: %v", website.ID, err)
+	}
+}
+
+// AnomalyNotification is the body of the webhook requests of anomaly channels
+type AnomalyNotification struct {
+	Website   AnomalyWebsite  `json:"website"`
+	Anomalies []model.Anomaly `json:"anomalies"` // Empty for test notifications
+}
+
+// AnomalyWebsite identifies the website of an anomaly notification
+type AnomalyWebsite struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+	URL  string `json:"url"`
+}
+
+// sendAnomalyNotification sends anomalies of a website on a channel
+func sendAnomalyNotification(website *model.Website, channel *model.AnomalyChannel, anomalies []model.Anomaly) error {
+	switch channel.Type {
+	case model.AnomalyChannelWebhook:
+		if anomalies == nil {
+			anomalies = []model.Anomaly{}
+		}
+		return notify.PostWebhook(channel.Target, channel.Secret, AnomalyNotification{
+			Website:   AnomalyWebsite{ID: website.ID, Name: website.Name, URL: website.URL},
+			Anomalies: anomalies,
+		})
+	case model.AnomalyChannelEmail:
+		to, err := notify.ParseAddresses(channel.Target)
+		if err != nil {
+			return err
+		}
+		subject, body := anomalyEmail(website, anomalies)
+		return notify.SendEmail(to, subject, body)
+	default:
+		return errors.New("unknown channel type " + channel.Type)
+	}
+}
+
+// anomalyEmail returns the subject and body of the email notifying anomalies of a website
+func anomalyEmail(website *model.Website, anomalies []model.Anomaly) (string, string) {
+	if len(anomalies) == 0 {
+		return "aq3stat - Test notification for " + website.Name,
+			"Anomalies of the traffic of " + website.Name + " (" + website.URL + ") will be notified to this address.\n"
+	}
+
+	var body strings.Builder
+	body.WriteString("Unusual traffic was detected on " + website.Name + " (" + website.URL + "):\n\n")
+	for _, anomaly := range anomalies {
+		hour := anomaly.Hour.In(website.Location())
+		fmt.Fprintf(&body, "%s - %s  %s: %d, expected %.0f\n",
+			hour.Format("2006-01-02 15:04"), hour.Add(time.Hour).Format("15:04"),
+			anomalyLabel(anomaly), anomaly.Value, anomaly.Expected)
+	}
+	body.WriteString("\nBest regards,\nThe aq3stat Team\n")
+
+	return "aq3stat - Unusual traffic on " + website.Name, body.String()
+}
+
+// anomalyLabel describes the type and metric of an anomaly
+func anomalyLabel(anomaly model.Anomaly) string {
+	metric := "Page views"
+	if anomaly.Metric == model.AnomalyMetricIP {
+		metric = "Visitors"
+	}
+
+	switch anomaly.Type {
+	case model.AnomalyTypeSpike:
+		return metric + " spike"
+	case model.AnomalyTypeDrop:
+		return metric + " drop"
+	default:
+		return "No " + strings.ToLower(metric)
+	}
+}
+
+// getAnomalyInt reads a positive integer setting from an environment variable
+func getAnomalyInt(key string, defaultValue int) int {
+	value, err := strconv.Atoi(os.Getenv(key))
+	if err != nil || value <= 0 {
+		return defaultValue
+	}
+	return value
+}
+
+// getAnomalyFloat reads a positive number setting from an environment variable
+func getAnomalyFloat(key string, defaultValue float64) float64 {
+	value, err := strconv.ParseFloat(os.Getenv(key), 64)
+	if err != nil || value <= 0 {
+		return defaultValue
+	}
+	return value
+}
@@ -0,0 +1,184 @@
+package service
+
+import (
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+
+	"aq3stat/internal/repository"
+	"aq3stat/pkg/badge"
+)
+
+// badgeCacheTTL is how long the counters of a badge are cached
+const badgeCacheTTL = time.Minute
+
+// Badge metrics
+var badgeLabels = map[string]string{
+	"summary":      "visitors",
+	"today_ip":     "today ip",
+	"today_pv":     "today pv",
+	"yesterday_ip": "yesterday ip",
+	"yesterday_pv": "yesterday pv",
+	"total_ip":     "total ip",
+	"total_pv":     "total pv",
+	"online":       "online",
+}
+
+// BadgeStats represents the live counters of a website shown on badges and text counters
+type BadgeStats struct {
+	WebsiteID   int
+	IsPublic    bool // Counters may only be shown for public websites
+	HideIcon    bool // The owner's group hides the counter icon
+	TodayIP     int64
+	TodayPV     int64
+	YesterdayIP int64
+	YesterdayPV int64
+	TotalIP     int64
+	TotalPV     int64
+	Online      int64
+	expires     time.Time
+}
+
+// BadgeOptions defines how a badge is rendered
+type BadgeOptions struct {
+	Format string // svg or png
+	Metric string
+	Theme  string
+	Color  string // Overrides the value color of the theme
+	Label  string // Overrides the default label of the metric
+}
+
+// BadgeService handles live counter badges
+type BadgeService struct {
+	websiteRepo       *repository.WebsiteRepository
+	statAnalyticsRepo *repository.StatAnalyticsRepository
+	mu                sync.Mutex
+	cache             map[int]*BadgeStats
+}
+
+// NewBadgeService creates a new badge service
+func NewBadgeService() *BadgeService {
+	return &BadgeService{
+		websiteRepo:       repository.NewWebsiteRepository(),
+		statAnalyticsRepo: repository.NewStatAnalyticsRepository(),
+		cache:             make(map[int]*BadgeStats),
+	}
+}
+
+// IsValidBadgeMetric checks if a metric can be shown on a badge
+func IsValidBadgeMetric(metric string) bool {
+	_, ok := badgeLabels[metric]
+	return ok
+}
+
+// GetBadgeStats gets the live counters of a website, cached for a short time
+func (s *BadgeService) GetBadgeStats(websiteID int) (*BadgeStats, error) {
+	now := time.Now()
+
+	s.mu.Lock()
+	cached, ok := s.cache[websiteID]
+	s.mu.Unlock()
+	if ok && now.Before(cached.expires) {
+		return cached, nil
+	}
+
+	website, err := s.websiteRepo.FindByIDWithOwnerGroup(websiteID)
+	if err != nil {
+		return nil, err
+	}
+
+	stats := &BadgeStats{
+		WebsiteID: websiteID,
+		IsPublic:  website.IsPublic,
+		HideIcon:  website.User != nil && website.User.Group != nil && website.User.Group.HideIcon,
+		expires:   now.Add(badgeCacheTTL),
+	}
+
+	today := website.StartOfDay(now)
+	yesterday := today.AddDate(0, 0, -1)
+
+	stats.TodayIP, stats.TodayPV, err = s.statAnalyticsRepo.GetTodayStats(websiteID, today)
+	if err != nil {
+		return nil, err
+	}
+
+	stats.YesterdayIP, stats.YesterdayPV, err = s.statAnalyticsRepo.GetYesterdayStats(websiteID, yesterday, today)
+	if err != nil {
+		return nil, err
+	}
+
+	stats.TotalIP, stats.TotalPV, err = s.statAnalyticsRepo.GetTotalStats(websiteID, website.StartOfDay(website.StartTime))
+	if err != nil {
+		return nil, err
+	}
+
+	stats.Online, err = s.statAnalyticsRepo.GetOnlineVisitors(websiteID, 15)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	s.cache[websiteID] = stats
+	s.mu.Unlock()
+
+	return stats, nil
+}
+
+// RenderBadge renders the badge of a website. Counters of private websites are replaced by
+// a placeholder, and an error is returned when the owner's group hides the icon.
+func (s *BadgeService) RenderBadge(websiteID int, options *BadgeOptions) ([]byte, error) {
+	stats, err := s.GetBadgeStats(websiteID)
+	if err != nil {
+		return nil, err
+	}
+
+	if stats.HideIcon {
+		return nil, errors.New("badge is hidden")
+	}
+
+	style, ok := badge.Themes[options.Theme]
+	if !ok {
+		style = badge.Themes["flat"]
+	}
+	if badge.IsValidColor(options.Color) {
+		style.ValueColor = options.Color
+	}
+
+	label := options.Label
+	if label == "" {
+		label = badgeLabels[options.Metric]
+	}
+
+	value := "-"
+	if stats.IsPublic {
+		value = stats.metricValue(options.Metric)
+	}
+
+	if options.Format == "png" {
+		return badge.PNG(label, value, style)
+	}
+	return badge.SVG(label, value, style), nil
+}
+
+// metricValue formats a metric of the counters
+func (stats *BadgeStats) metricValue(metric string) string {
+	switch metric {
+	case "today_ip":
+		return strconv.FormatInt(stats.TodayIP, 10)
+	case "today_pv":
+		return strconv.FormatInt(stats.TodayPV, 10)
+	case "yesterday_ip":
+		return strconv.FormatInt(stats.YesterdayIP, 10)
+	case "yesterday_pv":
+		return strconv.FormatInt(stats.YesterdayPV, 10)
+	case "total_ip":
+		return strconv.FormatInt(stats.TotalIP, 10)
+	case "total_pv":
+		return strconv.FormatInt(stats.TotalPV, 10)
+	case "online":
+		return strconv.FormatInt(stats.Online, 10)
+	default:
+		return strconv.FormatInt(stats.TodayIP, 10) + " ip / " + strconv.FormatInt(stats.TodayPV, 10) + " pv"
+	}
+}
@@ -0,0 +1,227 @@
+package service
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"aq3stat/internal/model"
+	"aq3stat/internal/repository"
+)
+
+// breakdownMaxDays is the longest range of days of a breakdown
+const breakdownMaxDays = 731
+
+// filterOperators are the stat filter operators in the order they are looked for
+var filterOperators = []string{
+	repository.FilterEquals,
+	repository.FilterNotEquals,
+	repository.FilterContains,
+	repository.FilterNotContains,
+}
+
+// Breakdown represents a page of the metrics of a website per value of a dimension
+type Breakdown struct {
+	Dimension string                  `json:"dimension"`
+	Filters   []repository.StatFilter `json:"filters"`
+	Sort      string                  `json:"sort"`
+	Page      int                     `json:"page"`
+	Limit     int                     `json:"limit"`
+	Total     int64                   `json:"total"` // Number of distinct values
+	Rows      []BreakdownRow          `json:"rows"`
+	Segments  []SegmentBreakdown      `json:"segments,omitempty"` // Compared segments
+	Compare   *Comparison             `json:"compare,omitempty"`  // Compared range
+}
+
+// BreakdownRow represents the metrics of a value of a breakdown, along with the metrics of the
+// value in the compared range and their changes when the range is compared
+type BreakdownRow struct {
+	repository.BreakdownData
+	Compare *repository.BreakdownData `json:"compare,omitempty"`
+	Change  map[string]Change         `json:"change,omitempty"`
+}
+
+// SegmentBreakdown represents the metrics of the visits of a segment for the values of a breakdown page
+type SegmentBreakdown struct {
+	SegmentID int                        `json:"segment_id"`
+	Name      string                     `json:"name"`
+	Rows      []repository.BreakdownData `json:"rows"` // Same values in the same order as the page, zeros without visits
+}
+
+// ParseStatFilters parses a filter expression of conditions separated by semicolons, each a
+// dimension, an operator and a value, e.g. browser==Chrome;province=~广东. The operators are
+// == (equals), != (not equals), =~ (contains) and !~ (does not contain).
+func ParseStatFilters(expr string) ([]repository.StatFilter, error) {
+	filters := []repository.StatFilter{}
+
+	for _, condition := range strings.Split(expr, ";") {
+		if strings.TrimSpace(condition) == "" {
+			continue
+		}
+
+		// The first operator found splits the dimension from the value
+		index, operator := -1, ""
+		for _, op := range filterOperators {
+			if i := strings.Index(condition, op); i >= 0 && (index < 0 || i < index) {
+				index, operator = i, op
+			}
+		}
+		if index < 0 {
+			return nil, errors.New("invalid filter, expected dimension, operator and value: " + condition)
+		}
+
+		filter := repository.StatFilter{
+			Dimension: strings.TrimSpace(condition[:index]),
+			Operator:  operator,
+			Value:     condition[index+len(operator):],
+		}
+		if err := ValidateStatFilter(filter); err != nil {
+			return nil, err
+		}
+		filters = append(filters, filter)
+	}
+
+	return filters, nil
+}
+
+// ValidateStatFilter checks the dimension and operator of a filter
+func ValidateStatFilter(filter repository.StatFilter) error {
+	if !repository.IsStatDimension(filter.Dimension) {
+		return errors.New("unknown filter dimension: " + filter.Dimension)
+	}
+	if !repository.IsFilterOperator(filter.Operator) {
+		return errors.New("unknown filter operator: " + filter.Operator)
+	}
+	return nil
+}
+
+// ParseBreakdownSort parses a sort key optionally prefixed with - for descending order
+func ParseBreakdownSort(sort string) (string, bool, error) {
+	key := strings.TrimPrefix(sort, "-")
+	switch key {
+	case repository.BreakdownSortValue, repository.BreakdownSortIP, repository.BreakdownSortPV, repository.BreakdownSortVisits:
+		return key, key != sort, nil
+	}
+	return "", false, errors.New("sort must be value, ip, pv or visits, prefixed with - for descending order")
+}
+
+// ValidateBreakdown checks the dimension, range and sort of a breakdown request
+func ValidateBreakdown(dimension string, from, to time.Time, sort string) error {
+	if !repository.IsStatDimension(dimension) {
+		return errors.New("unknown dimension: " + dimension)
+	}
+
+	if to.Sub(from) > breakdownMaxDays*24*time.Hour {
+		return errors.New("date range is too long for a breakdown")
+	}
+
+	_, _, err := ParseBreakdownSort(sort)
+	return err
+}
+
+// GetBreakdown gets a page of the IP, PV and visits of a website per value of a dimension in a
+// half-open range of days, given in the website's time zone, for the visits matching all filters.
+// The visits of each segment are compared side by side for the values of the page. With a
+// comparison, the values of the page are compared to the same values in the compared range.
+func (s *StatService) GetBreakdown(websiteID int, dimension string, from, to time.Time, filters []repository.StatFilter, sort string, page, limit int, segments []model.Segment, comparison *Comparison) (*Breakdown, error) {
+	if err := ValidateBreakdown(dimension, from, to, sort); err != nil {
+		return nil, err
+	}
+	for _, filter := range filters {
+		if err := ValidateStatFilter(filter); err != nil {
+			return nil, err
+		}
+	}
+	if err := ValidateComparedSegments(segments); err != nil {
+		return nil, err
+	}
+
+	website, err := s.websiteRepo.FindByID(websiteID)
+	if err != nil {
+		return nil, err
+	}
+
+	key, desc, _ := ParseBreakdownSort(sort)
+	from, to = from.In(website.Location()), to.In(website.Location())
+	rows, total, err := s.statAnalyticsRepo.WithFilters(filters).GetBreakdown(websiteID, dimension, from, to, key, desc, limit, (page-1)*limit)
+	if err != nil {
+		return nil, err
+	}
+
+	if filters == nil {
+		filters = []repository.StatFilter{}
+	}
+
+	breakdown := &Breakdown{
+		Dimension: dimension,
+		Filters:   filters,
+		Sort:      sort,
+		Page:      page,
+		Limit:     limit,
+		Total:     total,
+		Rows:      make([]BreakdownRow, len(rows)),
+	}
+
+	values := make([]string, len(rows))
+	for i, row := range rows {
+		breakdown.Rows[i].BreakdownData = row
+		values[i] = row.Value
+	}
+
+	if comparison != nil {
+		compareFrom, compareTo := comparison.From.In(website.Location()), comparison.To.In(website.Location())
+		data, err := s.statAnalyticsRepo.WithFilters(filters).GetBreakdownValues(websiteID, dimension, compareFrom, compareTo, values)
+		if err != nil {
+			return nil, err
+		}
+
+		compared := alignBreakdown(data, values)
+		for i := range compared {
+			row := &breakdown.Rows[i]
+			row.Compare = &compared[i]
+			row.Change = map[string]Change{
+				MetricIP:     newChange(float64(row.IP), float64(compared[i].IP)),
+				MetricPV:     newChange(float64(row.PV), float64(compared[i].PV)),
+				MetricVisits: newChange(float64(row.Visits), float64(compared[i].Visits)),
+			}
+		}
+		breakdown.Compare = comparison
+	}
+
+	for i := range segments {
+		segmentFilters, err := SegmentFilters(&segments[i])
+		if err != nil {
+			return nil, err
+		}
+
+		repo := s.statAnalyticsRepo.WithFilters(append(append([]repository.StatFilter{}, filters...), segmentFilters...))
+		data, err := repo.GetBreakdownValues(websiteID, dimension, from, to, values)
+		if err != nil {
+			return nil, err
+		}
+
+		breakdown.Segments = append(breakdown.Segments, SegmentBreakdown{
+			SegmentID: segments[i].ID,
+			Name:      segments[i].Name,
+			Rows:      alignBreakdown(data, values),
+		})
+	}
+
+	return breakdown, nil
+}
+
+// alignBreakdown returns the breakdown data of the given values in the same order, with zeros
+// for the values without data
+func alignBreakdown(data []repository.BreakdownData, values []string) []repository.BreakdownData {
+	byValue := make(map[string]repository.BreakdownData, len(data))
+	for _, item := range data {
+		byValue[item.Value] = item
+	}
+
+	rows := make([]repository.BreakdownData, len(values))
+	for i, value := range values {
+		rows[i] = byValue[value]
+		rows[i].Value = value
+	}
+	return rows
+}
@@ -1,23 +1,36 @@
 package service
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"errors"
+	"log"
 	"net"
 	"net/url"
 	"strconv"
 	"strings"
 	"time"
+	"unicode/utf8"
 
 	"aq3stat/internal/model"
 	"aq3stat/internal/repository"
+	"aq3stat/pkg/live"
+	"aq3stat/pkg/sink"
 )
 
 // CollectorService handles data collection related business logic
 type CollectorService struct {
 	websiteRepo      *repository.WebsiteRepository
 	statRepo         *repository.StatRepository
+	pageViewRepo     *repository.PageViewRepository
+	eventRepo        *repository.EventRepository
+	goalRepo         *repository.GoalRepository
+	conversionRepo   *repository.ConversionRepository
+	purchaseRepo     *repository.PurchaseRepository
 	ipDataRepo       *repository.IPDataRepository
 	searchEngineRepo *repository.SearchEngineRepository
+	rollupRepo       *repository.RollupRepository
+	pageRuleRepo     *repository.PageRuleRepository
 }
 
 // NewCollectorService creates a new collector service
@@ -25,44 +38,166 @@ func NewCollectorService() *CollectorService {
 	return &CollectorService{
 		websiteRepo:      repository.NewWebsiteRepository(),
 		statRepo:         repository.NewStatRepository(),
+		pageViewRepo:     repository.NewPageViewRepository(),
+		eventRepo:        repository.NewEventRepository(),
+		goalRepo:         repository.NewGoalRepository(),
+		conversionRepo:   repository.NewConversionRepository(),
+		purchaseRepo:     repository.NewPurchaseRepository(),
 		ipDataRepo:       repository.NewIPDataRepository(),
 		searchEngineRepo: repository.NewSearchEngineRepository(),
+		rollupRepo:       repository.NewRollupRepository(),
+		pageRuleRepo:     repository.NewPageRuleRepository(),
 	}
 }
 
+// CollectRequest holds the parameters of a single tracking hit
+type CollectRequest struct {
+	WebsiteID   int
+	ClientIP    string
+	Referer     string
+	Location    string
+	ScreenColor string
+	ScreenSize  string
+	UserAgent   string
+	Language    string
+	PageViewID  string
+	Source      string // Page view source, see model.PageViewSource*
+	Campaign    string
+	ClientID    string
+	Time        time.Time // Time of the hit, zero means now. Set when replaying historical hits.
+}
+
+// ErrDuplicateHit is returned when a hit with the same page view ID was already collected
+var ErrDuplicateHit = errors.New("hit already collected")
+
 // CollectData collects visitor data
-func (s *CollectorService) CollectData(websiteID int, clientIP, referer, location, screenColor, screenSize, userAgent, language string) error {
+func (s *CollectorService) CollectData(req *CollectRequest) error {
 	// Check if website exists
-	_, err := s.websiteRepo.FindByID(websiteID)
+	website, err := s.websiteRepo.FindByID(req.WebsiteID)
 	if err != nil {
 		return errors.New("website not found")
 	}
 
 	// Parse IP address
-	ip := net.ParseIP(clientIP)
+	ip := net.ParseIP(req.ClientIP)
 	if ip == nil {
 		return errors.New("invalid IP address")
 	}
 
-	// Convert IP to uint32
-	ipUint := ipToUint(ip)
-
-	// Get current time
-	now := time.Now()
-	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
-	yesterday := today.AddDate(0, 0, -1)
+	// Replayed hits carry a page view ID derived from their source, skip the ones already collected
+	if req.PageViewID != "" {
+		if _, err := s.pageViewRepo.FindByPVID(req.WebsiteID, req.PageViewID); err == nil {
+			return ErrDuplicateHit
+		}
+	}
 
-	// Check if this IP has visited today
-	existingStat, err := s.statRepo.FindByWebsiteIDAndIP(websiteID, clientIP, today)
+	// Get time of the hit in the website's time zone
+	now := website.Now()
+	if !req.Time.IsZero() {
+		now = req.Time.In(now.Location())
+	}
+	today := website.StartOfDay(now)
 
-	if err == nil {
+	// Check if this IP has visited on the website's day of the hit
+	stat, err := s.statRepo.FindByWebsiteIDAndIP(req.WebsiteID, req.ClientIP, today, today.AddDate(0, 0, 1))
+	newVisit := err != nil
+	if !newVisit {
 		// Update existing stat
-		existingStat.LeaveTime = now
-		existingStat.Count++
-		return s.statRepo.Update(existingStat)
+		if now.After(stat.LeaveTime) {
+			stat.LeaveTime = now
+		}
+		stat.Count++
+		err = s.statRepo.Update(stat)
+	} else {
+		// This is a new visit for today
+		stat, err = s.createStat(req, ip, now, today)
+	}
+	if err != nil {
+		return err
 	}
 
-	// This is a new visit for today, create a new stat record
+	// Replayed hits of closed days make their rollups stale
+	closedDay := today.Before(website.StartOfDay(website.Now()))
+	if closedDay {
+		if err := s.rollupRepo.Invalidate(website.ID, today); err != nil {
+			return err
+		}
+	}
+
+	pageView, err := s.createPageView(req, stat, now)
+	if err != nil {
+		return err
+	}
+
+	s.checkGoals(stat, req.Location, "", 0)
+	if closedDay {
+		invalidateClosedStatsCache(website.ID)
+	}
+	sink.Publish(newSinkHit(req, stat, pageView, newVisit))
+
+	// Replayed hits aren't live
+	if req.Time.IsZero() {
+		live.Publish(stat.WebsiteID, stat.IP, newLiveHit(stat, pageView, newVisit))
+	}
+	return nil
+}
+
+// newSinkHit builds the hit published to the configured sinks
+func newSinkHit(req *CollectRequest, stat *model.Stat, pageView *model.PageView, newVisit bool) sink.Hit {
+	return sink.Hit{
+		WebsiteID:    stat.WebsiteID,
+		StatID:       stat.ID,
+		PageViewID:   pageView.PVID,
+		Time:         pageView.Time,
+		NewVisit:     newVisit,
+		IP:           stat.IP,
+		Location:     req.Location,
+		Path:         pageView.Path,
+		Referer:      stat.Referer,
+		BaseReferer:  stat.BaseReferer,
+		SearchEngine: stat.SearchEngine,
+		Keyword:      stat.Keyword,
+		Browser:      stat.Browser,
+		OS:           stat.OS,
+		OSLang:       stat.OSLang,
+		ScreenSize:   stat.ScreenSize,
+		ScreenColor:  stat.ScreenColor,
+		Address:      stat.Address,
+		Province:     stat.Province,
+		ISP:          stat.ISP,
+		ReVisitTimes: stat.ReVisitTimes,
+		Source:       pageView.Source,
+		Campaign:     pageView.Campaign,
+	}
+}
+
+// newLiveHit builds the hit streamed to live dashboards, without the IP, keyword and full referer
+func newLiveHit(stat *model.Stat, pageView *model.PageView, newVisit bool) live.Hit {
+	return live.Hit{
+		Time:         pageView.Time,
+		NewVisit:     newVisit,
+		Path:         pageView.Path,
+		Referer:      stat.BaseReferer,
+		SearchEngine: stat.SearchEngine,
+		Province:     stat.Province,
+		Browser:      stat.Browser,
+		OS:           stat.OS,
+		Device:       getDeviceType(stat.OS),
+		ScreenSize:   stat.ScreenSize,
+		Source:       pageView.Source,
+	}
+}
+
+// createStat creates the stat record for the first visit of an IP on a day
+func (s *CollectorService) createStat(req *CollectRequest, ip net.IP, now, today time.Time) (*model.Stat, error) {
+	websiteID := req.WebsiteID
+	clientIP := req.ClientIP
+	referer := req.Referer
+	userAgent := req.UserAgent
+
+	// Convert IP to uint32
+	ipUint := ipToUint(ip)
+	yesterday := today.AddDate(0, 0, -1)
 
 	// Parse referer
 	var baseReferer, searchEngine, keyword string
@@ -101,7 +236,7 @@ func (s *CollectorService) CollectData(websiteID int, clientIP, referer, locatio
 	}
 
 	// Parse screen color
-	screenColorInt, _ := strconv.Atoi(screenColor)
+	screenColorInt, _ := strconv.Atoi(req.ScreenColor)
 
 	// Check for Alexa toolbar
 	hasAlexaBar := strings.Contains(strings.ToLower(userAgent), "alexa")
@@ -129,7 +264,7 @@ func (s *CollectorService) CollectData(websiteID int, clientIP, referer, locatio
 	os := getOSType(userAgent)
 
 	// Determine OS language
-	osLang := getOSLang(language)
+	osLang := getOSLang(req.Language)
 
 	// Check if this IP visited yesterday to determine re-visit times
 	reVisitTimes := 1
@@ -149,9 +284,9 @@ func (s *CollectorService) CollectData(websiteID int, clientIP, referer, locatio
 		BaseReferer:  baseReferer,
 		SearchEngine: searchEngine,
 		Keyword:      keyword,
-		Location:     location,
+		Location:     req.Location,
 		ScreenColor:  screenColorInt,
-		ScreenSize:   screenSize,
+		ScreenSize:   req.ScreenSize,
 		Browser:      browser,
 		OS:           os,
 		OSLang:       osLang,
@@ -162,7 +297,362 @@ func (s *CollectorService) CollectData(websiteID int, clientIP, referer, locatio
 		ReVisitTimes: reVisitTimes,
 	}
 
-	return s.statRepo.Create(stat)
+	if err := s.statRepo.Create(stat); err != nil {
+		return nil, err
+	}
+
+	return stat, nil
+}
+
+// createPageView records a page view of the visit
+func (s *CollectorService) createPageView(req *CollectRequest, stat *model.Stat, now time.Time) (*model.PageView, error) {
+	pvid := req.PageViewID
+	if !isValidPageViewID(pvid) {
+		pvid = newPageViewID()
+	}
+
+	// Normalize the path with the page rules of the website, only the path is kept without rules
+	normalizer, err := loadPageNormalizer(s.pageRuleRepo, req.WebsiteID)
+	if err != nil {
+		log.Printf("Failed to load page rules of website %d: %v", req.WebsiteID, err)
+		normalizer = newPageNormalizer(nil)
+	}
+
+	pageView := &model.PageView{
+		WebsiteID: req.WebsiteID,
+		StatID:    stat.ID,
+		PVID:      pvid,
+		Time:      now,
+		LeaveTime: now,
+		Path:      normalizer.Normalize(getPageURI(req.Location)),
+		Source:    req.Source,
+		Campaign:  truncate(req.Campaign, 100),
+		ClientID:  truncate(req.ClientID, 64),
+	}
+
+	// Email opens don't happen on a page
+	if req.Source == model.PageViewSourceEmail {
+		pageView.Path = ""
+	}
+
+	if err := s.pageViewRepo.Create(pageView); err != nil {
+		return nil, err
+	}
+	return pageView, nil
+}
+
+// RecordPageLeave records the unload ping of a page view with the maximum scroll depth reached
+func (s *CollectorService) RecordPageLeave(websiteID int, pvid string, scrollDepth int) error {
+	if !isValidPageViewID(pvid) {
+		return errors.New("invalid page view ID")
+	}
+
+	pageView, err := s.pageViewRepo.FindByPVID(websiteID, pvid)
+	if err != nil {
+		return errors.New("page view not found")
+	}
+
+	// Pings may be sent several times per page view, keep the deepest one
+	depth := bucketScrollDepth(scrollDepth)
+	if depth > pageView.ScrollDepth {
+		pageView.ScrollDepth = depth
+	}
+	pageView.LeaveTime = time.Now()
+
+	err = s.pageViewRepo.Update(pageView)
+	if err != nil {
+		return err
+	}
+
+	// Extend the visit so that duration goals can be reached
+	stat, err := s.touchStat(pageView.StatID, pageView.LeaveTime)
+	if err != nil {
+		return err
+	}
+	live.Touch(stat.WebsiteID, stat.IP)
+
+	s.checkGoals(stat, "", "", 0)
+	return nil
+}
+
+// CollectEvent records a named custom event fired during a page view
+func (s *CollectorService) CollectEvent(websiteID int, pvid, name string, value float64) error {
+	if name == "" || len(name) > 100 {
+		return errors.New("event name must be between 1 and 100 characters")
+	}
+
+	if !isValidPageViewID(pvid) {
+		return errors.New("invalid page view ID")
+	}
+
+	pageView, err := s.pageViewRepo.FindByPVID(websiteID, pvid)
+	if err != nil {
+		return errors.New("page view not found")
+	}
+
+	now := time.Now()
+	stat, err := s.touchStat(pageView.StatID, now)
+	if err != nil {
+		return err
+	}
+
+	event := &model.Event{
+		WebsiteID:  websiteID,
+		StatID:     stat.ID,
+		PageViewID: pageView.ID,
+		Time:       now,
+		Name:       name,
+		Value:      value,
+	}
+
+	err = s.eventRepo.Create(event)
+	if err != nil {
+		return err
+	}
+
+	s.checkGoals(stat, "", name, value)
+	return nil
+}
+
+// PurchaseItemRequest holds a line item of a purchase event
+type PurchaseItemRequest struct {
+	SKU      string  `json:"sku"`
+	Name     string  `json:"name"`
+	Category string  `json:"category"`
+	Price    float64 `json:"price"`
+	Quantity int     `json:"quantity"`
+}
+
+// PurchaseRequest holds a purchase event sent by counter.js
+type PurchaseRequest struct {
+	OrderID  string                `json:"order_id"`
+	Total    float64               `json:"total"`
+	Currency string                `json:"currency"`
+	Items    []PurchaseItemRequest `json:"items"`
+}
+
+// PurchaseEventName is the custom event recorded for every purchase, usable in goals and funnels
+const PurchaseEventName = "purchase"
+
+// defaultCurrency is the currency of purchases sent without one, and of goal values and revenue
+const defaultCurrency = "CNY"
+
+// CollectPurchase records a purchase placed during a page view. Orders already recorded are ignored.
+func (s *CollectorService) CollectPurchase(websiteID int, pvid string, req *PurchaseRequest) error {
+	if req.OrderID == "" || len(req.OrderID) > 100 {
+		return errors.New("order ID must be between 1 and 100 characters")
+	}
+
+	if req.Total < 0 {
+		return errors.New("order total must not be negative")
+	}
+
+	currency := strings.ToUpper(strings.TrimSpace(req.Currency))
+	if currency == "" {
+		currency = defaultCurrency
+	}
+	if len(currency) != 3 {
+		return errors.New("currency must be a 3-letter ISO 4217 code")
+	}
+
+	if !isValidPageViewID(pvid) {
+		return errors.New("invalid page view ID")
+	}
+
+	// Deduplicate orders sent more than once, e.g. when the confirmation page is reloaded
+	if s.purchaseRepo.ExistsByOrderID(websiteID, req.OrderID) {
+		return nil
+	}
+
+	pageView, err := s.pageViewRepo.FindByPVID(websiteID, pvid)
+	if err != nil {
+		return errors.New("page view not found")
+	}
+
+	now := time.Now()
+	stat, err := s.touchStat(pageView.StatID, now)
+	if err != nil {
+		return err
+	}
+
+	purchase := &model.Purchase{
+		WebsiteID: websiteID,
+		StatID:    stat.ID,
+		OrderID:   req.OrderID,
+		Time:      now,
+		Total:     req.Total,
+		Currency:  currency,
+	}
+
+	for _, item := range req.Items {
+		quantity := item.Quantity
+		if quantity <= 0 {
+			quantity = 1
+		}
+		purchase.Items = append(purchase.Items, model.PurchaseItem{
+			SKU:      truncate(item.SKU, 100),
+			Name:     truncate(item.Name, 255),
+			Category: truncate(item.Category, 100),
+			Price:    item.Price,
+			Quantity: quantity,
+		})
+	}
+
+	err = s.purchaseRepo.Create(purchase)
+	if err != nil {
+		return err
+	}
+
+	// Record the purchase as a custom event so that goals can track its revenue
+	event := &model.Event{
+		WebsiteID:  websiteID,
+		StatID:     stat.ID,
+		PageViewID: pageView.ID,
+		Time:       now,
+		Name:       PurchaseEventName,
+		Value:      req.Total,
+	}
+	if err := s.eventRepo.Create(event); err != nil {
+		return err
+	}
+
+	// Goal revenue is summed up in the default currency, purchases in other currencies convert
+	// like events without a value
+	goalValue := req.Total
+	if currency != defaultCurrency {
+		goalValue = 0
+	}
+	s.checkGoals(stat, "", PurchaseEventName, goalValue)
+	return nil
+}
+
+// touchStat moves the leave time of a visit forward
+func (s *CollectorService) touchStat(statID int, now time.Time) (*model.Stat, error) {
+	stat, err := s.statRepo.FindByID(statID)
+	if err != nil {
+		return nil, errors.New("visit not found")
+	}
+
+	if now.After(stat.LeaveTime) {
+		stat.LeaveTime = now
+		err = s.statRepo.Update(stat)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return stat, nil
+}
+
+// checkGoals records a conversion for every goal of the website reached by the visit.
+// location and eventName are the page URL or the custom event of the current hit, if any.
+func (s *CollectorService) checkGoals(stat *model.Stat, location, eventName string, eventValue float64) {
+	goals, err := s.goalRepo.ListEnabledByWebsiteID(stat.WebsiteID)
+	if err != nil {
+		return
+	}
+
+	for _, goal := range goals {
+		reached := false
+		revenue := goal.Value
+
+		switch goal.Type {
+		case model.GoalTypeURL:
+			reached = location != "" && matchURLPattern(goal.Pattern, location)
+		case model.GoalTypeEvent:
+			reached = eventName != "" && goal.Pattern == eventName
+			if eventValue > 0 {
+				revenue = eventValue
+			}
+		case model.GoalTypeDuration:
+			reached = stat.LeaveTime.Sub(stat.Time) >= time.Duration(goal.Threshold)*time.Second
+		case model.GoalTypePages:
+			reached = stat.Count >= goal.Threshold
+		}
+
+		// A visit converts at most once per goal
+		if !reached || s.conversionRepo.Exists(goal.ID, stat.ID) {
+			continue
+		}
+
+		s.conversionRepo.Create(&model.Conversion{
+			GoalID:    goal.ID,
+			WebsiteID: stat.WebsiteID,
+			StatID:    stat.ID,
+			Time:      stat.LeaveTime,
+			Revenue:   revenue,
+		})
+	}
+}
+
+// Helper function to round a scroll percentage down to 0, 25, 50, 75 or 100
+func bucketScrollDepth(depth int) int {
+	if depth >= 100 {
+		return 100
+	}
+	if depth < 0 {
+		return 0
+	}
+	return depth / 25 * 25
+}
+
+// Helper function to extract the page path from a page URL
+func getPagePath(location string) string {
+	parsedURL, err := url.Parse(location)
+	if err != nil || parsedURL.Path == "" {
+		return "/"
+	}
+
+	return truncate(parsedURL.Path, 255)
+}
+
+// Helper function to extract the page path and query string from a page URL
+func getPageURI(location string) string {
+	parsedURL, err := url.Parse(location)
+	if err != nil {
+		return "/"
+	}
+
+	path := parsedURL.Path
+	if path == "" {
+		path = "/"
+	}
+	if parsedURL.RawQuery != "" {
+		path += "?" + parsedURL.RawQuery
+	}
+	return path
+}
+
+// Helper function to cut a string to a maximum length in bytes, without splitting a character
+func truncate(value string, max int) string {
+	if len(value) <= max {
+		return value
+	}
+	for max > 0 && !utf8.RuneStart(value[max]) {
+		max--
+	}
+	return value[:max]
+}
+
+// Helper function to check a client generated page view ID
+func isValidPageViewID(pvid string) bool {
+	if len(pvid) < 8 || len(pvid) > 32 {
+		return false
+	}
+	for _, c := range pvid {
+		// AMP page view IDs are base64url encoded
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '-' || c == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+// Helper function to generate a page view ID when the client didn't send one
+func newPageViewID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
 }
 
 // Helper function to convert IP to uint32
@@ -233,6 +723,22 @@ func getOSType(userAgent string) string {
 	}
 }
 
+// Helper function to determine the device type of an OS like the device stats do
+func getDeviceType(os string) string {
+	os = strings.ToLower(os)
+
+	switch {
+	case strings.Contains(os, "windows") || strings.Contains(os, "mac") || strings.Contains(os, "linux"):
+		return "PC"
+	case strings.Contains(os, "android") || strings.Contains(os, "ios") || strings.Contains(os, "iphone"):
+		return "移动设备"
+	case strings.Contains(os, "ipad"):
+		return "平板"
+	default:
+		return "其他"
+	}
+}
+
 // Helper function to determine OS language
 func getOSLang(language string) string {
 	language = strings.ToLower(language)
@@ -0,0 +1,31 @@
+package service
+
+import (
+	"testing"
+	"unicode/utf8"
+)
+
+func TestTruncate(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		max   int
+		want  string
+	}{
+		{"short", "/about", 10, "/about"},
+		{"exact", "/about", 6, "/about"},
+		{"ascii", "/about", 3, "/ab"},
+		{"character boundary", "/产品/列表", 7, "/产品"},
+		{"inside a character", "/产品/列表", 6, "/产"},
+		{"inside the first character", "产品", 2, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := truncate(tt.value, tt.max)
+			if got != tt.want || !utf8.ValidString(got) {
+				t.Errorf("truncate(%q, %d) = %q, want %q", tt.value, tt.max, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,71 @@
+package service
+
+import (
+	"errors"
+	"time"
+)
+
+// Comparison modes
+const (
+	ComparePreviousPeriod = "previous_period" // The same number of days right before the range
+	ComparePreviousYear   = "previous_year"   // The same days one year earlier
+	CompareCustom         = "custom"          // A range given by the client
+)
+
+// Comparison describes the range the metrics of a report are compared to
+type Comparison struct {
+	Mode string    `json:"mode"`
+	From time.Time `json:"from"`
+	To   time.Time `json:"to"` // Exclusive
+}
+
+// Change holds the difference between the value of a metric and its compared value. Percent is
+// relative to the compared value and nil when the compared value is zero.
+type Change struct {
+	Absolute float64  `json:"absolute"`
+	Percent  *float64 `json:"percent"`
+}
+
+// NewComparison returns the comparison of a half-open range of days. customFrom and customTo
+// are the compared range of the custom mode and ignored otherwise.
+func NewComparison(mode string, from, to, customFrom, customTo time.Time) (*Comparison, error) {
+	comparison := &Comparison{Mode: mode}
+
+	switch mode {
+	case ComparePreviousPeriod:
+		days := daysBetween(from, to)
+		comparison.From, comparison.To = from.AddDate(0, 0, -days), from
+	case ComparePreviousYear:
+		comparison.From, comparison.To = from.AddDate(-1, 0, 0), to.AddDate(-1, 0, 0)
+	case CompareCustom:
+		if customFrom.IsZero() || customTo.IsZero() {
+			return nil, errors.New("compare_from and compare_to are required to compare to a custom range")
+		}
+		if daysBetween(customFrom, customTo) > daysBetween(from, to) {
+			return nil, errors.New("compared range must not be longer than the range")
+		}
+		comparison.From, comparison.To = customFrom, customTo
+	default:
+		return nil, errors.New("compare must be previous_period, previous_year or custom")
+	}
+
+	return comparison, nil
+}
+
+// newChange returns the change from a compared value to a value
+func newChange(value, compared float64) Change {
+	change := Change{Absolute: value - compared}
+	if compared != 0 {
+		percent := change.Absolute / compared * 100
+		change.Percent = &percent
+	}
+	return change
+}
+
+// daysBetween returns the number of calendar days between the starts of two days, ignoring
+// daylight saving time changes
+func daysBetween(from, to time.Time) int {
+	start := time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, time.UTC)
+	end := time.Date(to.Year(), to.Month(), to.Day(), 0, 0, 0, 0, time.UTC)
+	return int(end.Sub(start).Hours() / 24)
+}
@@ -0,0 +1,417 @@
+package service
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"aq3stat/internal/model"
+	"aq3stat/internal/repository"
+)
+
+// RetentionPreview represents what a retention policy deletes when it is applied
+type RetentionPreview struct {
+	Policy    model.RetentionPolicy     `json:"policy"`
+	Websites  []RetentionWebsiteSummary `json:"websites"`
+	Stats     int64                     `json:"stats"`
+	PageViews int64                     `json:"page_views"`
+}
+
+// RetentionWebsiteSummary represents the raw hits of a website deleted by a retention policy
+type RetentionWebsiteSummary struct {
+	WebsiteID         int       `json:"website_id"`
+	Name              string    `json:"name"`
+	PolicyID          int       `json:"policy_id"`
+	PurgeBefore       time.Time `json:"purge_before"`
+	WaitingForRollups bool      `json:"waiting_for_rollups"` // Only the rolled up days are purged yet
+	Stats             int64     `json:"stats"`
+	PageViews         int64     `json:"page_views"`
+	ArchiveFiles      []string  `json:"archive_files,omitempty"`
+}
+
+// DataRetentionService handles the retention policies of raw hits
+type DataRetentionService struct {
+	websiteRepo   *repository.WebsiteRepository
+	groupRepo     *repository.GroupRepository
+	statRepo      *repository.StatRepository
+	retentionRepo *repository.RetentionRepository
+	rollupRepo    *repository.RollupRepository
+}
+
+// NewDataRetentionService creates a new data retention service
+func NewDataRetentionService() *DataRetentionService {
+	return &DataRetentionService{
+		websiteRepo:   repository.NewWebsiteRepository(),
+		groupRepo:     repository.NewGroupRepository(),
+		statRepo:      repository.NewStatRepository(),
+		retentionRepo: repository.NewRetentionRepository(),
+		rollupRepo:    repository.NewRollupRepository(),
+	}
+}
+
+// ListPolicies lists all retention policies
+func (s *DataRetentionService) ListPolicies() ([]model.RetentionPolicy, error) {
+	return s.retentionRepo.List()
+}
+
+// GetPolicy gets a retention policy by ID
+func (s *DataRetentionService) GetPolicy(id int) (*model.RetentionPolicy, error) {
+	return s.retentionRepo.FindByID(id)
+}
+
+// CreatePolicy creates a retention policy, there can be one per scope
+func (s *DataRetentionService) CreatePolicy(policy *model.RetentionPolicy) error {
+	if err := s.validatePolicy(policy); err != nil {
+		return err
+	}
+
+	if _, err := s.retentionRepo.FindByScope(policy.Scope, policy.ScopeID); err == nil {
+		return errors.New("a retention policy already exists for this scope")
+	}
+
+	return s.retentionRepo.Create(policy)
+}
+
+// UpdatePolicy updates a retention policy
+func (s *DataRetentionService) UpdatePolicy(policy *model.RetentionPolicy) error {
+	if err := s.validatePolicy(policy); err != nil {
+		return err
+	}
+
+	existing, err := s.retentionRepo.FindByScope(policy.Scope, policy.ScopeID)
+	if err == nil && existing.ID != policy.ID {
+		return errors.New("a retention policy already exists for this scope")
+	}
+
+	return s.retentionRepo.Update(policy)
+}
+
+// DeletePolicy deletes a retention policy
+func (s *DataRetentionService) DeletePolicy(id int) error {
+	return s.retentionRepo.Delete(id)
+}
+
+// validatePolicy checks the scope and limit of a retention policy
+func (s *DataRetentionService) validatePolicy(policy *model.RetentionPolicy) error {
+	switch policy.Scope {
+	case model.RetentionScopeGlobal:
+		policy.ScopeID = 0
+	case model.RetentionScopeGroup:
+		if _, err := s.groupRepo.FindByID(policy.ScopeID); err != nil {
+			return errors.New("group not found")
+		}
+	case model.RetentionScopeWebsite:
+		if _, err := s.websiteRepo.FindByID(policy.ScopeID); err != nil {
+			return errors.New("website not found")
+		}
+	default:
+		return errors.New("scope must be global, group or website")
+	}
+
+	if policy.RawDays < 0 {
+		return errors.New("raw days must not be negative")
+	}
+
+	return nil
+}
+
+// PreviewPolicies previews what every retention policy deletes when it is applied. A disabled
+// policy is previewed as if it were enabled.
+func (s *DataRetentionService) PreviewPolicies() ([]RetentionPreview, error) {
+	policies, err := s.retentionRepo.List()
+	if err != nil {
+		return nil, err
+	}
+
+	websites, err := s.websiteRepo.ListAll()
+	if err != nil {
+		return nil, err
+	}
+
+	previews := make([]RetentionPreview, 0, len(policies))
+	for _, policy := range policies {
+		preview, err := s.previewPolicy(policy, policies, websites)
+		if err != nil {
+			return nil, err
+		}
+		previews = append(previews, *preview)
+	}
+
+	return previews, nil
+}
+
+// PreviewPolicy previews what a retention policy deletes when it is applied
+func (s *DataRetentionService) PreviewPolicy(id int) (*RetentionPreview, error) {
+	policy, err := s.retentionRepo.FindByID(id)
+	if err != nil {
+		return nil, err
+	}
+
+	policies, err := s.retentionRepo.List()
+	if err != nil {
+		return nil, err
+	}
+
+	websites, err := s.websiteRepo.ListAll()
+	if err != nil {
+		return nil, err
+	}
+
+	return s.previewPolicy(*policy, policies, websites)
+}
+
+// previewPolicy counts the raw hits a policy deletes from the websites it applies to
+func (s *DataRetentionService) previewPolicy(policy model.RetentionPolicy, policies []model.RetentionPolicy, websites []model.Website) (*RetentionPreview, error) {
+	// Preview the policy as if it were enabled
+	policy.Enabled = true
+	candidates := make([]model.RetentionPolicy, len(policies))
+	copy(candidates, policies)
+	for i := range candidates {
+		if candidates[i].ID == policy.ID {
+			candidates[i] = policy
+		}
+	}
+
+	preview := &RetentionPreview{
+		Policy:   policy,
+		Websites: []RetentionWebsiteSummary{},
+	}
+
+	for i := range websites {
+		website := &websites[i]
+		applied := policyFor(website, candidates)
+		if applied == nil || applied.ID != policy.ID {
+			continue
+		}
+
+		summary, err := s.summarize(website, applied)
+		if err != nil {
+			return nil, err
+		}
+		if summary == nil {
+			continue
+		}
+
+		preview.Websites = append(preview.Websites, *summary)
+		preview.Stats += summary.Stats
+		preview.PageViews += summary.PageViews
+	}
+
+	return preview, nil
+}
+
+// StartRetentionWorker applies the enabled retention policies in the background, right away
+// and then every RETENTION_INTERVAL (24h by default)
+func (s *DataRetentionService) StartRetentionWorker() {
+	interval, err := time.ParseDuration(os.Getenv("RETENTION_INTERVAL"))
+	if err != nil || interval <= 0 {
+		interval = 24 * time.Hour
+	}
+
+	go func() {
+		for {
+			if _, err := s.ApplyPolicies(); err != nil {
+				log.Printf("Failed to apply retention policies: %v", err)
+			}
+			time.Sleep(interval)
+		}
+	}()
+}
+
+// ApplyPolicies purges the raw hits of all websites that are older than their enabled retention
+// policy allows and already rolled up. It returns what was purged per website.
+func (s *DataRetentionService) ApplyPolicies() ([]RetentionWebsiteSummary, error) {
+	policies, err := s.retentionRepo.List()
+	if err != nil {
+		return nil, err
+	}
+
+	websites, err := s.websiteRepo.ListAll()
+	if err != nil {
+		return nil, err
+	}
+
+	results := []RetentionWebsiteSummary{}
+	for i := range websites {
+		website := &websites[i]
+		policy := policyFor(website, policies)
+		if policy == nil {
+			continue
+		}
+
+		summary, err := s.purgeWebsite(website, policy)
+		if err != nil {
+			log.Printf("Failed to purge raw hits of website %d: %v", website.ID, err)
+			continue
+		}
+		if summary != nil && (summary.Stats > 0 || summary.PageViews > 0) {
+			log.Printf("Purged %d stats and %d page views of website %d before %s",
+				summary.Stats, summary.PageViews, website.ID, summary.PurgeBefore.Format("2006-01-02"))
+			results = append(results, *summary)
+		}
+	}
+
+	return results, nil
+}
+
+// purgeWebsite archives and purges the raw hits of a website a policy allows to purge
+func (s *DataRetentionService) purgeWebsite(website *model.Website, policy *model.RetentionPolicy) (*RetentionWebsiteSummary, error) {
+	summary, err := s.summarize(website, policy)
+	if err != nil || summary == nil || (summary.Stats == 0 && summary.PageViews == 0) {
+		return summary, err
+	}
+
+	if policy.Archive {
+		summary.ArchiveFiles, err = s.archiveRawHits(website, summary.PurgeBefore)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Record the purge first, so that the purged days are never rolled up again from the raw
+	// hits left when the purge stops half way
+	if err := s.rollupRepo.SetPurgedUntil(website.ID, summary.PurgeBefore); err != nil {
+		return nil, err
+	}
+
+	summary.Stats, summary.PageViews, err = s.retentionRepo.PurgeRawHits(website.ID, summary.PurgeBefore)
+	if err != nil {
+		return nil, err
+	}
+
+	return summary, nil
+}
+
+// summarize counts the raw hits of a website a policy allows to purge. Days are only purged
+// once they are rolled up, nil is returned when there is nothing to purge.
+func (s *DataRetentionService) summarize(website *model.Website, policy *model.RetentionPolicy) (*RetentionWebsiteSummary, error) {
+	if policy.RawDays <= 0 {
+		return nil, nil
+	}
+
+	state, err := s.rollupRepo.GetState(website.ID)
+	if err != nil {
+		return nil, err
+	}
+	if state.RolledUntil.IsZero() {
+		return nil, nil
+	}
+
+	summary := &RetentionWebsiteSummary{
+		WebsiteID:   website.ID,
+		Name:        website.Name,
+		PolicyID:    policy.ID,
+		PurgeBefore: website.StartOfDay(website.Now()).AddDate(0, 0, -policy.RawDays),
+	}
+
+	rolledUntil := state.RolledUntil.In(website.Location())
+	if rolledUntil.Before(summary.PurgeBefore) {
+		summary.PurgeBefore = rolledUntil
+		summary.WaitingForRollups = true
+	}
+
+	summary.Stats, summary.PageViews, err = s.retentionRepo.CountRawHits(website.ID, summary.PurgeBefore)
+	if err != nil {
+		return nil, err
+	}
+
+	return summary, nil
+}
+
+// archiveRawHits writes the stats and page views of a website before a time to gzip compressed
+// NDJSON files in the archive directory and returns their paths
+func (s *DataRetentionService) archiveRawHits(website *model.Website, before time.Time) ([]string, error) {
+	dir := filepath.Join(getArchiveDir(), strconv.Itoa(website.ID))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	name := func(table string) string {
+		return filepath.Join(dir, fmt.Sprintf("%s-before-%s-%d.ndjson.gz", table, before.Format("20060102"), time.Now().Unix()))
+	}
+
+	statsPath := name("stats")
+	err := writeArchive(statsPath, func(encoder *json.Encoder) error {
+		return s.statRepo.EachByWebsiteIDAndTime(website.ID, time.Unix(0, 0), before, func(stat *model.Stat) error {
+			return encoder.Encode(stat)
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	pageViewsPath := name("page_views")
+	err = writeArchive(pageViewsPath, func(encoder *json.Encoder) error {
+		return s.retentionRepo.EachPageView(website.ID, before, func(pageView *model.PageView) error {
+			return encoder.Encode(pageView)
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return []string{statsPath, pageViewsPath}, nil
+}
+
+// writeArchive writes a gzip compressed NDJSON file, removing it again when writing fails
+func writeArchive(path string, write func(encoder *json.Encoder) error) (err error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			os.Remove(path)
+		}
+	}()
+
+	gz := gzip.NewWriter(file)
+	if err := write(json.NewEncoder(gz)); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// policyFor returns the enabled policy applying to a website: its website policy, else the
+// policy of its owner's group, else the global policy. It returns nil if none applies.
+func policyFor(website *model.Website, policies []model.RetentionPolicy) *model.RetentionPolicy {
+	var group, global *model.RetentionPolicy
+	for i := range policies {
+		policy := &policies[i]
+		if !policy.Enabled {
+			continue
+		}
+
+		switch {
+		case policy.Scope == model.RetentionScopeWebsite && policy.ScopeID == website.ID:
+			return policy
+		case policy.Scope == model.RetentionScopeGroup && website.User != nil && policy.ScopeID == website.User.GroupID:
+			group = policy
+		case policy.Scope == model.RetentionScopeGlobal:
+			global = policy
+		}
+	}
+
+	if group != nil {
+		return group
+	}
+	return global
+}
+
+// getArchiveDir returns the directory of the raw hit archives
+func getArchiveDir() string {
+	dir := os.Getenv("RETENTION_ARCHIVE_DIR")
+	if dir == "" {
+		dir = "./data/archive"
+	}
+	return dir
+}
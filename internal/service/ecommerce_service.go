@@ -0,0 +1,87 @@
+package service
+
+import (
+	"time"
+
+	"aq3stat/internal/repository"
+)
+
+// EcommerceService handles e-commerce report related business logic
+type EcommerceService struct {
+	statAnalyticsRepo *repository.StatAnalyticsRepository
+}
+
+// NewEcommerceService creates a new e-commerce service
+func NewEcommerceService() *EcommerceService {
+	return &EcommerceService{
+		statAnalyticsRepo: repository.NewStatAnalyticsRepository(),
+	}
+}
+
+// EcommerceReport represents the sales of a website over a date range
+type EcommerceReport struct {
+	Currency        string                          `json:"currency"`        // Currency filter, empty for all currencies
+	Orders          int64                           `json:"orders"`          // Orders in all reported currencies
+	ConversionRate  float64                         `json:"conversion_rate"` // Orders relative to visits
+	Totals          []repository.CurrencyTotalsData `json:"totals"`          // Revenue per currency
+	TopProducts     []repository.ProductStatsData   `json:"top_products"`
+	ByChannel       []repository.RevenueSourceData  `json:"by_channel"`
+	BySearchEngine  []repository.RevenueSourceData  `json:"by_search_engine"`
+	ByKeyword       []repository.RevenueSourceData  `json:"by_keyword"`
+	ByRefererDomain []repository.RevenueSourceData  `json:"by_referer_domain"`
+}
+
+// GetEcommerceReport gets revenue, orders, average order value, top products and
+// revenue by source of a website. Revenue is never summed up across currencies: when currency
+// is empty, totals, products and sources are reported per currency.
+func (s *EcommerceService) GetEcommerceReport(websiteID int, from, to time.Time, currency string, limit int, filters []repository.StatFilter) (*EcommerceReport, error) {
+	var err error
+	repo := s.statAnalyticsRepo.WithFilters(filters)
+	report := &EcommerceReport{Currency: currency}
+
+	report.Totals, err = repo.GetPurchaseTotals(websiteID, from, to, currency)
+	if err != nil {
+		return nil, err
+	}
+	if report.Totals == nil {
+		report.Totals = []repository.CurrencyTotalsData{}
+	}
+	for _, totals := range report.Totals {
+		report.Orders += totals.Orders
+	}
+
+	visits, err := repo.GetVisitCount(websiteID, from, to)
+	if err != nil {
+		return nil, err
+	}
+	if visits > 0 {
+		report.ConversionRate = float64(report.Orders) / float64(visits)
+	}
+
+	report.TopProducts, err = repo.GetTopProducts(websiteID, from, to, currency, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	report.ByChannel, err = repo.GetRevenueByChannel(websiteID, from, to, currency, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	report.BySearchEngine, err = repo.GetRevenueBySearchEngine(websiteID, from, to, currency, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	report.ByKeyword, err = repo.GetRevenueByKeyword(websiteID, from, to, currency, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	report.ByRefererDomain, err = repo.GetRevenueByReferer(websiteID, from, to, currency, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	return report, nil
+}
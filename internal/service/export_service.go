@@ -0,0 +1,377 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"aq3stat/internal/model"
+	"aq3stat/internal/repository"
+	"aq3stat/pkg/export"
+)
+
+// ExportStreamMaxRows is the maximum number of rows of an export streamed directly,
+// larger exports must run as a background job
+const ExportStreamMaxRows = 100000
+
+// ExportRequest describes an export of a website's data
+type ExportRequest struct {
+	WebsiteID int
+	Report    string   // stats for raw hits, or a report name
+	Format    string   // csv, ndjson or parquet
+	Columns   []string // Empty for all columns
+	From      time.Time
+	To        time.Time // Exclusive
+}
+
+// exportReport defines the columns and rows of an exportable report
+type exportReport struct {
+	columns []export.Column
+	rows    func(s *ExportService, req *ExportRequest, emit func(row []interface{}) error) error
+}
+
+// exportReports are the exportable reports by name
+var exportReports = map[string]exportReport{
+	"stats": {
+		columns: []export.Column{
+			{Name: "id", Type: export.TypeInt},
+			{Name: "time", Type: export.TypeTime},
+			{Name: "leave_time", Type: export.TypeTime},
+			{Name: "ip", Type: export.TypeString},
+			{Name: "count", Type: export.TypeInt},
+			{Name: "referer", Type: export.TypeString},
+			{Name: "base_referer", Type: export.TypeString},
+			{Name: "search_engine", Type: export.TypeString},
+			{Name: "keyword", Type: export.TypeString},
+			{Name: "location", Type: export.TypeString},
+			{Name: "screen_color", Type: export.TypeInt},
+			{Name: "screen_size", Type: export.TypeString},
+			{Name: "browser", Type: export.TypeString},
+			{Name: "os", Type: export.TypeString},
+			{Name: "os_lang", Type: export.TypeString},
+			{Name: "address", Type: export.TypeString},
+			{Name: "province", Type: export.TypeString},
+			{Name: "isp", Type: export.TypeString},
+			{Name: "re_visit_times", Type: export.TypeInt},
+		},
+		rows: func(s *ExportService, req *ExportRequest, emit func(row []interface{}) error) error {
+			return s.statRepo.EachByWebsiteIDAndTime(req.WebsiteID, req.From, req.To, func(stat *model.Stat) error {
+				return emit([]interface{}{
+					stat.ID, stat.Time, stat.LeaveTime, stat.IP, stat.Count, stat.Referer, stat.BaseReferer,
+					stat.SearchEngine, stat.Keyword, stat.Location, stat.ScreenColor, stat.ScreenSize,
+					stat.Browser, stat.OS, stat.OSLang, stat.Address, stat.Province, stat.ISP, stat.ReVisitTimes,
+				})
+			})
+		},
+	},
+	"trend": {
+		columns: []export.Column{
+			{Name: "date", Type: export.TypeString},
+			{Name: "ip", Type: export.TypeInt},
+			{Name: "pv", Type: export.TypeInt},
+		},
+		rows: func(s *ExportService, req *ExportRequest, emit func(row []interface{}) error) error {
+			trend, err := s.statService.GetWebsiteTrendData(req.WebsiteID, req.From, req.To, nil)
+			if err != nil {
+				return err
+			}
+			for _, item := range trend {
+				if err := emit([]interface{}{item.Date, item.IP, item.PV}); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	},
+	"referers": {
+		columns: []export.Column{
+			{Name: "name", Type: export.TypeString},
+			{Name: "value", Type: export.TypeInt},
+		},
+		rows: func(s *ExportService, req *ExportRequest, emit func(row []interface{}) error) error {
+			stats, err := s.statService.GetWebsiteRefererStatsBetween(req.WebsiteID, req.From, req.To, nil)
+			if err != nil {
+				return err
+			}
+			for _, item := range stats {
+				if err := emit([]interface{}{item.Name, item.Value}); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	},
+	"devices": {
+		columns: []export.Column{
+			{Name: "name", Type: export.TypeString},
+			{Name: "value", Type: export.TypeInt},
+		},
+		rows: func(s *ExportService, req *ExportRequest, emit func(row []interface{}) error) error {
+			stats, err := s.statService.GetWebsiteDeviceStatsBetween(req.WebsiteID, req.From, req.To, nil)
+			if err != nil {
+				return err
+			}
+			for _, item := range stats {
+				if err := emit([]interface{}{item.Name, item.Value}); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	},
+	"engagement": {
+		columns: []export.Column{
+			{Name: "path", Type: export.TypeString},
+			{Name: "page_views", Type: export.TypeInt},
+			{Name: "avg_scroll_depth", Type: export.TypeFloat},
+			{Name: "read_through_rate", Type: export.TypeFloat},
+		},
+		rows: func(s *ExportService, req *ExportRequest, emit func(row []interface{}) error) error {
+			stats, err := s.statService.GetWebsiteEngagementStats(req.WebsiteID, req.From, req.To, ExportStreamMaxRows, nil)
+			if err != nil {
+				return err
+			}
+			for _, item := range stats {
+				if err := emit([]interface{}{item.Path, item.PageViews, item.AvgScrollDepth, item.ReadThroughRate}); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	},
+	"goals": {
+		columns: []export.Column{
+			{Name: "goal_id", Type: export.TypeInt},
+			{Name: "name", Type: export.TypeString},
+			{Name: "type", Type: export.TypeString},
+			{Name: "conversions", Type: export.TypeInt},
+			{Name: "conversion_rate", Type: export.TypeFloat},
+			{Name: "revenue", Type: export.TypeFloat},
+		},
+		rows: func(s *ExportService, req *ExportRequest, emit func(row []interface{}) error) error {
+			reports, err := s.goalService.GetGoalReport(req.WebsiteID, req.From, req.To, nil)
+			if err != nil {
+				return err
+			}
+			for _, item := range reports {
+				if err := emit([]interface{}{item.GoalID, item.Name, item.Type, item.Conversions, item.ConversionRate, item.Revenue}); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	},
+}
+
+// ExportService handles exports of raw hits and reports
+type ExportService struct {
+	statRepo      *repository.StatRepository
+	exportJobRepo *repository.ExportJobRepository
+	statService   *StatService
+	goalService   *GoalService
+}
+
+// NewExportService creates a new export service
+func NewExportService() *ExportService {
+	return &ExportService{
+		statRepo:      repository.NewStatRepository(),
+		exportJobRepo: repository.NewExportJobRepository(),
+		statService:   NewStatService(),
+		goalService:   NewGoalService(),
+	}
+}
+
+// ValidateExport checks the report, format and columns of an export
+func (s *ExportService) ValidateExport(req *ExportRequest) error {
+	_, _, err := selectExportColumns(req)
+	return err
+}
+
+// CountExportRows estimates the number of rows of an export. Only raw hits are counted,
+// reports are always small enough to be streamed.
+func (s *ExportService) CountExportRows(req *ExportRequest) (int64, error) {
+	if req.Report != "stats" {
+		return 0, nil
+	}
+	return s.statRepo.CountByWebsiteIDAndTime(req.WebsiteID, req.From, req.To)
+}
+
+// WriteExport writes an export to w and returns the number of rows written
+func (s *ExportService) WriteExport(req *ExportRequest, w io.Writer) (int64, error) {
+	columns, indexes, err := selectExportColumns(req)
+	if err != nil {
+		return 0, err
+	}
+
+	writer, err := export.NewWriter(req.Format, w, columns)
+	if err != nil {
+		return 0, err
+	}
+
+	var count int64
+	err = exportReports[req.Report].rows(s, req, func(row []interface{}) error {
+		projected := make([]interface{}, len(indexes))
+		for i, index := range indexes {
+			projected[i] = row[index]
+		}
+		count++
+		return writer.Write(projected)
+	})
+	if err != nil {
+		return count, err
+	}
+
+	return count, writer.Close()
+}
+
+// CreateExportJob creates an export job and runs it in the background
+func (s *ExportService) CreateExportJob(req *ExportRequest, userID int) (*model.ExportJob, error) {
+	if err := s.ValidateExport(req); err != nil {
+		return nil, err
+	}
+
+	job := &model.ExportJob{
+		WebsiteID: req.WebsiteID,
+		UserID:    userID,
+		Report:    req.Report,
+		Format:    req.Format,
+		Columns:   strings.Join(req.Columns, ","),
+		StartTime: req.From,
+		EndTime:   req.To,
+		Status:    model.ExportStatusPending,
+	}
+
+	if err := s.exportJobRepo.Create(job); err != nil {
+		return nil, err
+	}
+
+	go s.runExportJob(job, req)
+
+	return job, nil
+}
+
+// runExportJob writes the file of an export job
+func (s *ExportService) runExportJob(job *model.ExportJob, req *ExportRequest) {
+	job.Status = model.ExportStatusRunning
+	s.exportJobRepo.Update(job)
+
+	err := s.writeExportFile(job, req)
+
+	now := time.Now()
+	job.FinishedAt = &now
+	job.Status = model.ExportStatusDone
+	if err != nil {
+		log.Printf("Export job %d failed: %v", job.ID, err)
+		job.Status = model.ExportStatusFailed
+		job.Error = truncate(err.Error(), 500)
+		if job.FilePath != "" {
+			os.Remove(job.FilePath)
+			job.FilePath = ""
+		}
+	}
+
+	s.exportJobRepo.Update(job)
+}
+
+// writeExportFile writes an export into the export directory
+func (s *ExportService) writeExportFile(job *model.ExportJob, req *ExportRequest) error {
+	dir := getExportDir()
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	job.FilePath = filepath.Join(dir, fmt.Sprintf("export-%d.%s", job.ID, job.Format))
+	file, err := os.Create(job.FilePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	job.Rows, err = s.WriteExport(req, file)
+	if err != nil {
+		return err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	job.FileSize = info.Size()
+
+	return nil
+}
+
+// GetExportJob gets an export job by ID
+func (s *ExportService) GetExportJob(id int) (*model.ExportJob, error) {
+	return s.exportJobRepo.FindByID(id)
+}
+
+// ListExportJobs lists the export jobs of a website
+func (s *ExportService) ListExportJobs(websiteID int) ([]model.ExportJob, error) {
+	return s.exportJobRepo.ListByWebsiteID(websiteID)
+}
+
+// ExportFileName returns the download file name of an export
+func ExportFileName(websiteID int, report, format string, from, to time.Time) string {
+	return fmt.Sprintf("aq3stat-%d-%s-%s-%s.%s", websiteID, report,
+		from.Format("20060102"), to.AddDate(0, 0, -1).Format("20060102"), format)
+}
+
+// IsValidExportReport checks if a report can be exported
+func IsValidExportReport(report string) bool {
+	_, ok := exportReports[report]
+	return ok
+}
+
+// selectExportColumns returns the selected columns of an export and their indexes in the report rows
+func selectExportColumns(req *ExportRequest) ([]export.Column, []int, error) {
+	report, ok := exportReports[req.Report]
+	if !ok {
+		return nil, nil, errors.New("report must be stats, trend, referers, devices, engagement or goals")
+	}
+
+	if !export.IsValidFormat(req.Format) {
+		return nil, nil, errors.New("format must be csv, ndjson or parquet")
+	}
+
+	if len(req.Columns) == 0 {
+		indexes := make([]int, len(report.columns))
+		for i := range indexes {
+			indexes[i] = i
+		}
+		return report.columns, indexes, nil
+	}
+
+	var columns []export.Column
+	var indexes []int
+	for _, name := range req.Columns {
+		found := false
+		for i, column := range report.columns {
+			if column.Name == name {
+				columns = append(columns, column)
+				indexes = append(indexes, i)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, nil, errors.New("unknown column: " + name)
+		}
+	}
+
+	return columns, indexes, nil
+}
+
+// getExportDir returns the directory export files are written to
+func getExportDir() string {
+	dir := os.Getenv("EXPORT_DIR")
+	if dir == "" {
+		dir = "./data/exports"
+	}
+	return dir
+}
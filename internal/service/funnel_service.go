@@ -0,0 +1,194 @@
+package service
+
+import (
+	"errors"
+	"sort"
+	"time"
+
+	"aq3stat/internal/model"
+	"aq3stat/internal/repository"
+)
+
+// Funnel limits
+const (
+	minFunnelSteps = 2
+	maxFunnelSteps = 10
+)
+
+// FunnelService handles funnel related business logic
+type FunnelService struct {
+	funnelRepo        *repository.FunnelRepository
+	statAnalyticsRepo *repository.StatAnalyticsRepository
+}
+
+// NewFunnelService creates a new funnel service
+func NewFunnelService() *FunnelService {
+	return &FunnelService{
+		funnelRepo:        repository.NewFunnelRepository(),
+		statAnalyticsRepo: repository.NewStatAnalyticsRepository(),
+	}
+}
+
+// ListFunnels lists the funnels of a website
+func (s *FunnelService) ListFunnels(websiteID int) ([]model.Funnel, error) {
+	return s.funnelRepo.ListByWebsiteID(websiteID)
+}
+
+// GetFunnelByID gets a funnel by ID
+func (s *FunnelService) GetFunnelByID(id int) (*model.Funnel, error) {
+	return s.funnelRepo.FindByID(id)
+}
+
+// CreateFunnel creates a new funnel
+func (s *FunnelService) CreateFunnel(funnel *model.Funnel) error {
+	if err := validateFunnel(funnel); err != nil {
+		return err
+	}
+	return s.funnelRepo.Create(funnel)
+}
+
+// UpdateFunnel updates a funnel and its steps
+func (s *FunnelService) UpdateFunnel(funnel *model.Funnel) error {
+	if err := validateFunnel(funnel); err != nil {
+		return err
+	}
+	return s.funnelRepo.Update(funnel)
+}
+
+// DeleteFunnel deletes a funnel
+func (s *FunnelService) DeleteFunnel(id int) error {
+	return s.funnelRepo.Delete(id)
+}
+
+// validateFunnel validates a funnel definition and numbers its steps
+func validateFunnel(funnel *model.Funnel) error {
+	if funnel.Name == "" || len(funnel.Name) > 100 {
+		return errors.New("funnel name must be between 1 and 100 characters")
+	}
+
+	if len(funnel.Steps) < minFunnelSteps || len(funnel.Steps) > maxFunnelSteps {
+		return errors.New("funnel must have between 2 and 10 steps")
+	}
+
+	for i := range funnel.Steps {
+		step := &funnel.Steps[i]
+		if step.Type != model.FunnelStepPage && step.Type != model.FunnelStepEvent {
+			return errors.New("funnel step type must be PAGE or EVENT")
+		}
+		if step.Pattern == "" {
+			return errors.New("funnel step pattern is required")
+		}
+		step.Position = i + 1
+	}
+
+	return nil
+}
+
+// FunnelStepReport represents the visitors reaching a funnel step
+type FunnelStepReport struct {
+	Position       int     `json:"position"`
+	Name           string  `json:"name"`
+	Type           string  `json:"type"`
+	Pattern        string  `json:"pattern"`
+	Visitors       int64   `json:"visitors"`
+	DropOff        int64   `json:"drop_off"`        // Visitors of the previous step that didn't reach this one
+	DropOffRate    float64 `json:"drop_off_rate"`   // Drop off relative to the previous step
+	ConversionRate float64 `json:"conversion_rate"` // Visitors relative to the first step
+	MedianSeconds  float64 `json:"median_seconds"`  // Median time from the previous step
+}
+
+// FunnelReport represents the step-by-step report of a funnel over a date range
+type FunnelReport struct {
+	FunnelID int                `json:"funnel_id"`
+	Name     string             `json:"name"`
+	Steps    []FunnelStepReport `json:"steps"`
+}
+
+// GetFunnelReport counts the visitors reaching each step of a funnel in order
+func (s *FunnelService) GetFunnelReport(funnel *model.Funnel, from, to time.Time, filters []repository.StatFilter) (*FunnelReport, error) {
+	steps := funnel.Steps
+	counts := make([]int64, len(steps))
+	durations := make([][]float64, len(steps))
+
+	// Walk the timeline of each visitor as it is read and advance through the steps in order
+	visitor := ""
+	current := 0
+	var lastReached time.Time
+	err := s.statAnalyticsRepo.WithFilters(filters).EachTimelineEntry(funnel.WebsiteID, from, to, func(entry *repository.TimelineEntry) error {
+		if entry.Visitor != visitor {
+			visitor, current = entry.Visitor, 0
+		}
+		if current >= len(steps) || !matchFunnelStep(&steps[current], entry) {
+			return nil
+		}
+
+		counts[current]++
+		if current > 0 {
+			durations[current] = append(durations[current], entry.Time.Sub(lastReached).Seconds())
+		}
+		lastReached = entry.Time
+		current++
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	report := &FunnelReport{
+		FunnelID: funnel.ID,
+		Name:     funnel.Name,
+		Steps:    make([]FunnelStepReport, len(steps)),
+	}
+
+	for i, step := range steps {
+		item := FunnelStepReport{
+			Position: step.Position,
+			Name:     step.Name,
+			Type:     step.Type,
+			Pattern:  step.Pattern,
+			Visitors: counts[i],
+		}
+
+		if i > 0 {
+			item.DropOff = counts[i-1] - counts[i]
+			if counts[i-1] > 0 {
+				item.DropOffRate = float64(item.DropOff) / float64(counts[i-1])
+			}
+			item.MedianSeconds = median(durations[i])
+		}
+		if counts[0] > 0 {
+			item.ConversionRate = float64(counts[i]) / float64(counts[0])
+		}
+
+		report.Steps[i] = item
+	}
+
+	return report, nil
+}
+
+// matchFunnelStep checks if a timeline entry satisfies a funnel step
+func matchFunnelStep(step *model.FunnelStep, entry *repository.TimelineEntry) bool {
+	if step.Type != entry.Type {
+		return false
+	}
+	if step.Type == model.FunnelStepEvent {
+		return step.Pattern == entry.Value
+	}
+	return matchPattern(step.Pattern, entry.Value)
+}
+
+// median returns the median of a list of values, or 0 for an empty list
+func median(values []float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+
+	middle := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		return (sorted[middle-1] + sorted[middle]) / 2
+	}
+	return sorted[middle]
+}
@@ -0,0 +1,166 @@
+package service
+
+import (
+	"errors"
+	"time"
+
+	"aq3stat/internal/model"
+	"aq3stat/internal/repository"
+)
+
+// GoalService handles goal and conversion related business logic
+type GoalService struct {
+	goalRepo          *repository.GoalRepository
+	statAnalyticsRepo *repository.StatAnalyticsRepository
+}
+
+// NewGoalService creates a new goal service
+func NewGoalService() *GoalService {
+	return &GoalService{
+		goalRepo:          repository.NewGoalRepository(),
+		statAnalyticsRepo: repository.NewStatAnalyticsRepository(),
+	}
+}
+
+// ListGoals lists the goals of a website
+func (s *GoalService) ListGoals(websiteID int) ([]model.Goal, error) {
+	return s.goalRepo.ListByWebsiteID(websiteID)
+}
+
+// GetGoalByID gets a goal by ID
+func (s *GoalService) GetGoalByID(id int) (*model.Goal, error) {
+	return s.goalRepo.FindByID(id)
+}
+
+// CreateGoal creates a new goal
+func (s *GoalService) CreateGoal(goal *model.Goal) error {
+	if err := validateGoal(goal); err != nil {
+		return err
+	}
+	return s.goalRepo.Create(goal)
+}
+
+// UpdateGoal updates a goal
+func (s *GoalService) UpdateGoal(goal *model.Goal) error {
+	if err := validateGoal(goal); err != nil {
+		return err
+	}
+	return s.goalRepo.Update(goal)
+}
+
+// DeleteGoal deletes a goal
+func (s *GoalService) DeleteGoal(id int) error {
+	return s.goalRepo.Delete(id)
+}
+
+// validateGoal validates the definition of a goal
+func validateGoal(goal *model.Goal) error {
+	if goal.Name == "" || len(goal.Name) > 100 {
+		return errors.New("goal name must be between 1 and 100 characters")
+	}
+
+	switch goal.Type {
+	case model.GoalTypeURL, model.GoalTypeEvent:
+		if goal.Pattern == "" {
+			return errors.New("goal pattern is required")
+		}
+	case model.GoalTypeDuration, model.GoalTypePages:
+		if goal.Threshold <= 0 {
+			return errors.New("goal threshold must be greater than 0")
+		}
+	default:
+		return errors.New("goal type must be URL, EVENT, DURATION or PAGES")
+	}
+
+	if goal.Value < 0 {
+		return errors.New("goal value must not be negative")
+	}
+
+	return nil
+}
+
+// GoalBreakdownItem represents the conversions of a goal for one dimension value
+type GoalBreakdownItem struct {
+	Name        string  `json:"name"`
+	Conversions int64   `json:"conversions"`
+	Revenue     float64 `json:"revenue"`
+}
+
+// GoalReport represents the conversion report of a goal over a date range
+type GoalReport struct {
+	GoalID         int                 `json:"goal_id"`
+	Name           string              `json:"name"`
+	Type           string              `json:"type"`
+	Conversions    int64               `json:"conversions"`
+	ConversionRate float64             `json:"conversion_rate"`
+	Revenue        float64             `json:"revenue"`
+	Channels       []GoalBreakdownItem `json:"channels"`
+	SearchEngines  []GoalBreakdownItem `json:"search_engines"`
+}
+
+// GetGoalReport gets conversions, conversion rate and revenue per goal of a website
+func (s *GoalService) GetGoalReport(websiteID int, from, to time.Time, filters []repository.StatFilter) ([]GoalReport, error) {
+	goals, err := s.goalRepo.ListByWebsiteID(websiteID)
+	if err != nil {
+		return nil, err
+	}
+
+	repo := s.statAnalyticsRepo.WithFilters(filters)
+
+	visits, err := repo.GetVisitCount(websiteID, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	totals, err := repo.GetConversionStats(websiteID, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	channels, err := repo.GetConversionChannelStats(websiteID, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	searchEngines, err := repo.GetConversionSearchEngineStats(websiteID, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	reports := make([]GoalReport, len(goals))
+	index := make(map[int]*GoalReport, len(goals))
+	for i, goal := range goals {
+		reports[i] = GoalReport{
+			GoalID:        goal.ID,
+			Name:          goal.Name,
+			Type:          goal.Type,
+			Channels:      []GoalBreakdownItem{},
+			SearchEngines: []GoalBreakdownItem{},
+		}
+		index[goal.ID] = &reports[i]
+	}
+
+	for _, item := range totals {
+		if report, ok := index[item.GoalID]; ok {
+			report.Conversions = item.Conversions
+			report.Revenue = item.Revenue
+			if visits > 0 {
+				report.ConversionRate = float64(item.Conversions) / float64(visits)
+			}
+		}
+	}
+
+	for _, item := range channels {
+		if report, ok := index[item.GoalID]; ok {
+			report.Channels = append(report.Channels, GoalBreakdownItem{Name: item.Name, Conversions: item.Conversions, Revenue: item.Revenue})
+		}
+	}
+
+	for _, item := range searchEngines {
+		if report, ok := index[item.GoalID]; ok {
+			report.SearchEngines = append(report.SearchEngines, GoalBreakdownItem{Name: item.Name, Conversions: item.Conversions, Revenue: item.Revenue})
+		}
+	}
+
+	return reports, nil
+}
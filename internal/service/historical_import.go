@@ -0,0 +1,350 @@
+package service
+
+import (
+	"encoding/csv"
+	"errors"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"aq3stat/internal/model"
+)
+
+// historicalSources maps the accepted source tool names to their stored names
+var historicalSources = map[string]string{
+	"ga":               model.HistoricalSourceGA,
+	"google_analytics": model.HistoricalSourceGA,
+	"matomo":           model.HistoricalSourceMatomo,
+	"piwik":            model.HistoricalSourceMatomo,
+	"umami":            model.HistoricalSourceUmami,
+	"plausible":        model.HistoricalSourcePlausible,
+}
+
+// CSV columns understood by the importer
+const (
+	csvColumnDate      = "date"
+	csvColumnVisitors  = "visitors"
+	csvColumnPageViews = "pageviews"
+	csvColumnVisits    = "visits"
+)
+
+// csvColumnAliases maps the normalized column headers of the supported tools to importer columns
+// and dimensions
+var csvColumnAliases = map[string]string{
+	// Date
+	"date": csvColumnDate, "day": csvColumnDate, "period": csvColumnDate, "timestamp": csvColumnDate,
+
+	// Metrics
+	"visitors": csvColumnVisitors, "unique_visitors": csvColumnVisitors, "nb_uniq_visitors": csvColumnVisitors,
+	"users": csvColumnVisitors, "total_users": csvColumnVisitors, "active_users": csvColumnVisitors, "uniques": csvColumnVisitors,
+	"pageviews": csvColumnPageViews, "page_views": csvColumnPageViews, "nb_pageviews": csvColumnPageViews,
+	"views": csvColumnPageViews, "screen_page_views": csvColumnPageViews,
+	"visits": csvColumnVisits, "nb_visits": csvColumnVisits, "sessions": csvColumnVisits,
+
+	// Dimensions
+	"source": model.HistoricalDimensionReferer, "session_source": model.HistoricalDimensionReferer,
+	"referrer": model.HistoricalDimensionReferer, "referer": model.HistoricalDimensionReferer,
+	"referrer_source": model.HistoricalDimensionReferer, "first_user_source": model.HistoricalDimensionReferer,
+	"page": model.HistoricalDimensionPage, "pathname": model.HistoricalDimensionPage, "page_path": model.HistoricalDimensionPage,
+	"page_path_and_screen_class": model.HistoricalDimensionPage, "page_url": model.HistoricalDimensionPage, "url": model.HistoricalDimensionPage,
+	"country": model.HistoricalDimensionCountry, "country_code": model.HistoricalDimensionCountry,
+	"device": model.HistoricalDimensionDevice, "device_category": model.HistoricalDimensionDevice, "device_type": model.HistoricalDimensionDevice,
+	"browser": model.HistoricalDimensionBrowser, "browser_name": model.HistoricalDimensionBrowser,
+	"os": model.HistoricalDimensionOS, "operating_system": model.HistoricalDimensionOS, "os_name": model.HistoricalDimensionOS,
+}
+
+// csvDateLayouts are the date formats written by the supported tools
+var csvDateLayouts = []string{"2006-01-02", "20060102", "2006-01-02 15:04:05", time.RFC3339, "2006/01/02", "01/02/2006"}
+
+var csvHeaderPattern = regexp.MustCompile(`[^a-z0-9]+`)
+
+// csvLayout holds the column positions of a CSV export
+type csvLayout struct {
+	date, visitors, pageViews, visits int
+	dimension                         string
+	value                             int
+}
+
+// CreateCSVImport creates a pending import job for a daily aggregate CSV export of another
+// analytics tool (ga, matomo, umami or plausible)
+func (s *ImportService) CreateCSVImport(websiteID, userID int, source, fileName string, totalBytes int64) (*model.ImportJob, error) {
+	if _, err := s.websiteRepo.FindByID(websiteID); err != nil {
+		return nil, errors.New("website not found")
+	}
+
+	source, ok := historicalSources[strings.ToLower(source)]
+	if !ok {
+		return nil, errors.New("source must be ga, matomo, umami or plausible")
+	}
+
+	job := &model.ImportJob{
+		WebsiteID:  websiteID,
+		UserID:     userID,
+		Type:       model.ImportTypeCSV,
+		Format:     source,
+		FileName:   truncate(fileName, 255),
+		Status:     model.ImportStatusPending,
+		TotalBytes: totalBytes,
+	}
+
+	if err := s.importJobRepo.Create(job); err != nil {
+		return nil, err
+	}
+
+	return job, nil
+}
+
+// StartCSVImport runs a CSV import in the background. The file is removed afterwards.
+func (s *ImportService) StartCSVImport(job *model.ImportJob, path, dimension string) {
+	go func() {
+		defer os.Remove(path)
+
+		file, err := os.Open(path)
+		if err != nil {
+			s.finishImport(job, err)
+			return
+		}
+		defer file.Close()
+
+		if err := s.RunCSVImport(job, file, dimension); err != nil {
+			log.Printf("CSV import %d failed: %v", job.ID, err)
+		}
+	}()
+}
+
+// RunCSVImport stores the rows of a daily aggregate CSV export as historical stats. Columns are
+// recognized by their headers. A file holds either daily totals, or a breakdown by one dimension
+// which is detected from the headers unless given. Only days before tracking started are imported,
+// so imported data never overlaps collected data. Importing a file again replaces its rows.
+func (s *ImportService) RunCSVImport(job *model.ImportJob, r io.Reader, dimension string) error {
+	website, err := s.websiteRepo.FindByID(job.WebsiteID)
+	if err != nil {
+		return s.finishImport(job, errors.New("website not found"))
+	}
+
+	now := time.Now()
+	job.Status = model.ImportStatusRunning
+	job.StartedAt = &now
+	if err := s.importJobRepo.Update(job); err != nil {
+		return err
+	}
+
+	counter := &countingReader{r: r}
+	reader, err := decompressReader(counter)
+	if err != nil {
+		return s.finishImport(job, err)
+	}
+
+	csvReader := csv.NewReader(reader)
+	csvReader.FieldsPerRecord = -1
+	csvReader.LazyQuotes = true
+
+	header, err := csvReader.Read()
+	if err != nil {
+		return s.finishImport(job, errors.New("failed to read CSV header"))
+	}
+
+	layout, err := parseCSVLayout(header, dimension)
+	if err != nil {
+		return s.finishImport(job, err)
+	}
+
+	// Dates are days of the website's time zone, kept as UTC midnights like the date column
+	startDay := website.StartOfDay(website.StartTime)
+	trackingStart := time.Date(startDay.Year(), startDay.Month(), startDay.Day(), 0, 0, 0, 0, time.UTC)
+
+	// Rows of the same day and value are summed, exports may split them by further columns
+	rows := make(map[string]*model.HistoricalStat)
+	var keys []string
+
+	for {
+		record, err := csvReader.Read()
+		if err == io.EOF {
+			break
+		}
+		job.Lines++
+		if err != nil {
+			job.Failed++
+			continue
+		}
+
+		stat, ok := parseCSVRecord(record, layout)
+		if !ok {
+			job.Failed++
+			continue
+		}
+
+		if !stat.Date.Before(trackingStart) {
+			job.Filtered++
+			continue
+		}
+
+		key := stat.Date.Format("2006-01-02") + "\n" + stat.Value
+		if existing, ok := rows[key]; ok {
+			existing.Visitors += stat.Visitors
+			existing.PageViews += stat.PageViews
+			existing.Visits += stat.Visits
+		} else {
+			stat.WebsiteID = website.ID
+			stat.Source = job.Format
+			stat.ImportJobID = job.ID
+			rows[key] = stat
+			keys = append(keys, key)
+		}
+		job.Imported++
+	}
+
+	stats := make([]model.HistoricalStat, 0, len(keys))
+	for _, key := range keys {
+		stats = append(stats, *rows[key])
+	}
+
+	job.ReadBytes = counter.n
+	return s.finishImport(job, s.historicalStatRepo.Upsert(stats))
+}
+
+// parseCSVLayout finds the columns of a CSV export from its header
+func parseCSVLayout(header []string, dimension string) (*csvLayout, error) {
+	layout := &csvLayout{date: -1, visitors: -1, pageViews: -1, visits: -1, value: -1, dimension: dimension}
+
+	if dimension != "" && !isHistoricalDimension(dimension) {
+		return nil, errors.New("dimension must be referer, page, country, device, browser or os")
+	}
+
+	var unknown []int
+	for i, name := range header {
+		name = strings.Trim(csvHeaderPattern.ReplaceAllString(strings.ToLower(strings.TrimPrefix(strings.TrimSpace(name), "ga:")), "_"), "_")
+
+		switch column := csvColumnAliases[name]; column {
+		case csvColumnDate:
+			setColumn(&layout.date, i)
+		case csvColumnVisitors:
+			setColumn(&layout.visitors, i)
+		case csvColumnPageViews:
+			setColumn(&layout.pageViews, i)
+		case csvColumnVisits:
+			setColumn(&layout.visits, i)
+		case "":
+			unknown = append(unknown, i)
+		default:
+			if layout.value < 0 && (layout.dimension == "" || layout.dimension == column) {
+				layout.dimension = column
+				layout.value = i
+			}
+		}
+	}
+
+	// The value column of an explicit dimension may have a generic header such as label or name
+	if layout.dimension != "" && layout.value < 0 && len(unknown) > 0 {
+		layout.value = unknown[0]
+	}
+
+	if layout.date < 0 {
+		return nil, errors.New("CSV file has no date column")
+	}
+	if layout.visitors < 0 && layout.pageViews < 0 && layout.visits < 0 {
+		return nil, errors.New("CSV file has no visitors, pageviews or visits column")
+	}
+	if layout.dimension != "" && layout.value < 0 {
+		return nil, errors.New("CSV file has no " + layout.dimension + " column")
+	}
+
+	return layout, nil
+}
+
+// setColumn keeps the first column matching an importer column
+func setColumn(column *int, index int) {
+	if *column < 0 {
+		*column = index
+	}
+}
+
+// isHistoricalDimension checks if a dimension can be imported
+func isHistoricalDimension(dimension string) bool {
+	switch dimension {
+	case model.HistoricalDimensionReferer, model.HistoricalDimensionPage, model.HistoricalDimensionCountry,
+		model.HistoricalDimensionDevice, model.HistoricalDimensionBrowser, model.HistoricalDimensionOS:
+		return true
+	}
+	return false
+}
+
+// parseCSVRecord converts a CSV row into a historical stat
+func parseCSVRecord(record []string, layout *csvLayout) (*model.HistoricalStat, bool) {
+	field := func(index int) string {
+		if index < 0 || index >= len(record) {
+			return ""
+		}
+		return strings.TrimSpace(record[index])
+	}
+
+	date, ok := parseCSVDate(field(layout.date))
+	if !ok {
+		return nil, false
+	}
+
+	stat := &model.HistoricalStat{
+		Date:      date,
+		Dimension: layout.dimension,
+		Visitors:  parseCSVNumber(field(layout.visitors)),
+		PageViews: parseCSVNumber(field(layout.pageViews)),
+		Visits:    parseCSVNumber(field(layout.visits)),
+	}
+
+	if layout.dimension != "" {
+		stat.Value = truncate(normalizeHistoricalValue(layout.dimension, field(layout.value)), 255)
+	}
+
+	return stat, true
+}
+
+// parseCSVDate parses the date formats of the supported tools as a day at UTC midnight
+func parseCSVDate(value string) (time.Time, bool) {
+	for _, layout := range csvDateLayouts {
+		t, err := time.Parse(layout, value)
+		if err == nil {
+			return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC), true
+		}
+	}
+	return time.Time{}, false
+}
+
+// parseCSVNumber parses a count, ignoring thousands separators. Invalid values count as zero.
+func parseCSVNumber(value string) int64 {
+	value = strings.NewReplacer(",", "", " ", "").Replace(value)
+	if n, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return n
+	}
+	if f, err := strconv.ParseFloat(value, 64); err == nil {
+		return int64(f + 0.5)
+	}
+	return 0
+}
+
+// normalizeHistoricalValue maps a dimension value of another tool onto aq3stat's form
+func normalizeHistoricalValue(dimension, value string) string {
+	switch dimension {
+	case model.HistoricalDimensionReferer:
+		// aq3stat stores referer domains as scheme://host, direct traffic has none
+		switch strings.ToLower(value) {
+		case "", "(direct)", "direct", "direct / none", "direct entry", "(none)":
+			return ""
+		}
+		if !strings.Contains(value, "://") {
+			value = "http://" + value
+		}
+		if parsed, err := url.Parse(value); err == nil && parsed.Host != "" {
+			return parsed.Scheme + "://" + parsed.Host
+		}
+	case model.HistoricalDimensionPage:
+		return getPagePath(value)
+	case model.HistoricalDimensionDevice:
+		return strings.ToLower(value)
+	}
+	return value
+}
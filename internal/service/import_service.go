@@ -0,0 +1,260 @@
+package service
+
+import (
+	"bufio"
+	"compress/gzip"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"aq3stat/internal/model"
+	"aq3stat/internal/repository"
+	"aq3stat/pkg/accesslog"
+)
+
+// importProgressLines is the number of lines between two progress updates
+const importProgressLines = 1000
+
+// importMaxLineSize is the maximum length of a log line
+const importMaxLineSize = 1 << 20
+
+// ImportService handles imports of historical data
+type ImportService struct {
+	websiteRepo        *repository.WebsiteRepository
+	importJobRepo      *repository.ImportJobRepository
+	historicalStatRepo *repository.HistoricalStatRepository
+	collectorService   *CollectorService
+}
+
+// NewImportService creates a new import service
+func NewImportService() *ImportService {
+	return &ImportService{
+		websiteRepo:        repository.NewWebsiteRepository(),
+		importJobRepo:      repository.NewImportJobRepository(),
+		historicalStatRepo: repository.NewHistoricalStatRepository(),
+		collectorService:   NewCollectorService(),
+	}
+}
+
+// CreateAccessLogImport creates a pending access log import job. The format is the name of
+// a predefined format or a custom nginx log_format string.
+func (s *ImportService) CreateAccessLogImport(websiteID, userID int, format, fileName string, totalBytes int64) (*model.ImportJob, error) {
+	if _, err := s.websiteRepo.FindByID(websiteID); err != nil {
+		return nil, errors.New("website not found")
+	}
+
+	if format == "" {
+		format = "combined"
+	}
+	if _, err := accesslog.NewParser(format); err != nil {
+		return nil, errors.New("invalid log format: " + err.Error())
+	}
+
+	job := &model.ImportJob{
+		WebsiteID:  websiteID,
+		UserID:     userID,
+		Type:       model.ImportTypeAccessLog,
+		Format:     format,
+		FileName:   truncate(fileName, 255),
+		Status:     model.ImportStatusPending,
+		TotalBytes: totalBytes,
+	}
+
+	if err := s.importJobRepo.Create(job); err != nil {
+		return nil, err
+	}
+
+	return job, nil
+}
+
+// GetImportJob gets an import job by ID
+func (s *ImportService) GetImportJob(id int) (*model.ImportJob, error) {
+	return s.importJobRepo.FindByID(id)
+}
+
+// ListImportJobs lists the import jobs of a website
+func (s *ImportService) ListImportJobs(websiteID int) ([]model.ImportJob, error) {
+	return s.importJobRepo.ListByWebsiteID(websiteID)
+}
+
+// StartAccessLogImport runs an access log import in the background. The file is removed afterwards.
+func (s *ImportService) StartAccessLogImport(job *model.ImportJob, path string) {
+	go func() {
+		defer os.Remove(path)
+
+		file, err := os.Open(path)
+		if err != nil {
+			s.finishImport(job, err)
+			return
+		}
+		defer file.Close()
+
+		if err := s.RunAccessLogImport(job, file, nil); err != nil {
+			log.Printf("Access log import %d failed: %v", job.ID, err)
+		}
+	}()
+}
+
+// RunAccessLogImport replays the page views of an access log, optionally gzip compressed,
+// through the collector with their original time. Lines collected by an earlier run of the
+// same log are skipped, so an interrupted import can simply be run again. The progress
+// callback is called every few thousand lines.
+func (s *ImportService) RunAccessLogImport(job *model.ImportJob, r io.Reader, progress func(job *model.ImportJob)) error {
+	website, err := s.websiteRepo.FindByID(job.WebsiteID)
+	if err != nil {
+		return s.finishImport(job, errors.New("website not found"))
+	}
+
+	parser, err := accesslog.NewParser(job.Format)
+	if err != nil {
+		return s.finishImport(job, err)
+	}
+
+	siteURL, err := url.Parse(website.URL)
+	if err != nil || siteURL.Host == "" {
+		return s.finishImport(job, errors.New("website URL is invalid"))
+	}
+
+	now := time.Now()
+	job.Status = model.ImportStatusRunning
+	job.StartedAt = &now
+	if err := s.importJobRepo.Update(job); err != nil {
+		return err
+	}
+
+	counter := &countingReader{r: r}
+	reader, err := decompressReader(counter)
+	if err != nil {
+		return s.finishImport(job, err)
+	}
+
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), importMaxLineSize)
+
+	for scanner.Scan() {
+		line := scanner.Text()
+		job.Lines++
+
+		if strings.TrimSpace(line) != "" {
+			s.importLogLine(job, website, siteURL, parser, line)
+		}
+
+		if job.Lines%importProgressLines == 0 {
+			job.ReadBytes = counter.n
+			s.importJobRepo.Update(job)
+			if progress != nil {
+				progress(job)
+			}
+		}
+	}
+
+	job.ReadBytes = counter.n
+	err = s.finishImport(job, scanner.Err())
+	if progress != nil {
+		progress(job)
+	}
+	return err
+}
+
+// importLogLine collects a single log line and counts the outcome on the job
+func (s *ImportService) importLogLine(job *model.ImportJob, website *model.Website, siteURL *url.URL, parser *accesslog.Parser, line string) {
+	entry, err := parser.Parse(line)
+	if err != nil {
+		job.Failed++
+		return
+	}
+
+	// Logs of virtual hosts may contain requests of other sites
+	if entry.Host != "" && !sameHost(entry.Host, siteURL.Hostname()) {
+		job.Filtered++
+		return
+	}
+
+	if !entry.IsPageView() {
+		job.Filtered++
+		return
+	}
+
+	err = s.collectorService.CollectData(&CollectRequest{
+		WebsiteID:  website.ID,
+		ClientIP:   entry.RemoteAddr,
+		Referer:    entry.Referer,
+		Location:   siteURL.Scheme + "://" + siteURL.Host + entry.URI,
+		UserAgent:  entry.UserAgent,
+		Language:   entry.Language,
+		PageViewID: logLineID(website.ID, line),
+		Time:       entry.Time,
+	})
+	switch {
+	case err == nil:
+		job.Imported++
+	case errors.Is(err, ErrDuplicateHit):
+		job.Duplicates++
+	default:
+		job.Failed++
+	}
+}
+
+// finishImport marks an import job as done, or as failed if err is not nil
+func (s *ImportService) finishImport(job *model.ImportJob, err error) error {
+	now := time.Now()
+	job.FinishedAt = &now
+	job.Status = model.ImportStatusDone
+	if err != nil {
+		job.Status = model.ImportStatusFailed
+		job.Error = truncate(err.Error(), 500)
+	}
+	// Imported hits and history belong to past days, also when the import stopped half way
+	invalidateClosedStatsCache(job.WebsiteID)
+
+	if updateErr := s.importJobRepo.Update(job); updateErr != nil && err == nil {
+		return updateErr
+	}
+	return err
+}
+
+// logLineID derives a stable page view ID from a log line, so that importing
+// the same line twice is detected
+func logLineID(websiteID int, line string) string {
+	sum := sha1.Sum([]byte(strconv.Itoa(websiteID) + "\n" + line))
+	return hex.EncodeToString(sum[:])[:32]
+}
+
+// sameHost compares two host names, ignoring a port and a leading www.
+func sameHost(a, b string) bool {
+	if host, _, found := strings.Cut(a, ":"); found {
+		a = host
+	}
+	a = strings.TrimPrefix(strings.ToLower(a), "www.")
+	b = strings.TrimPrefix(strings.ToLower(b), "www.")
+	return a == b
+}
+
+// decompressReader transparently decompresses gzip input
+func decompressReader(r io.Reader) (io.Reader, error) {
+	buffered := bufio.NewReader(r)
+	magic, err := buffered.Peek(2)
+	if err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		return gzip.NewReader(buffered)
+	}
+	return buffered, nil
+}
+
+// countingReader counts the bytes read from the underlying reader
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
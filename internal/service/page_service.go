@@ -0,0 +1,467 @@
+package service
+
+import (
+	"errors"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"aq3stat/internal/model"
+	"aq3stat/internal/repository"
+)
+
+// PageService handles page report and page path normalization related business logic
+type PageService struct {
+	pageRuleRepo      *repository.PageRuleRepository
+	statAnalyticsRepo *repository.StatAnalyticsRepository
+}
+
+// NewPageService creates a new page service
+func NewPageService() *PageService {
+	return &PageService{
+		pageRuleRepo:      repository.NewPageRuleRepository(),
+		statAnalyticsRepo: repository.NewStatAnalyticsRepository(),
+	}
+}
+
+// ListPageRules lists the page rules of a website in the order they are applied
+func (s *PageService) ListPageRules(websiteID int) ([]model.PageRule, error) {
+	return s.pageRuleRepo.ListByWebsiteID(websiteID)
+}
+
+// GetPageRuleByID gets a page rule by ID
+func (s *PageService) GetPageRuleByID(id int) (*model.PageRule, error) {
+	return s.pageRuleRepo.FindByID(id)
+}
+
+// CreatePageRule creates a new page rule
+func (s *PageService) CreatePageRule(rule *model.PageRule) error {
+	if err := validatePageRule(rule); err != nil {
+		return err
+	}
+	if err := s.pageRuleRepo.Create(rule); err != nil {
+		return err
+	}
+	invalidatePageNormalizer(rule.WebsiteID)
+	return nil
+}
+
+// UpdatePageRule updates a page rule
+func (s *PageService) UpdatePageRule(rule *model.PageRule) error {
+	if err := validatePageRule(rule); err != nil {
+		return err
+	}
+	if err := s.pageRuleRepo.Update(rule); err != nil {
+		return err
+	}
+	invalidatePageNormalizer(rule.WebsiteID)
+	return nil
+}
+
+// DeletePageRule deletes a page rule
+func (s *PageService) DeletePageRule(id int) error {
+	rule, err := s.pageRuleRepo.FindByID(id)
+	if err != nil {
+		return err
+	}
+	if err := s.pageRuleRepo.Delete(id); err != nil {
+		return err
+	}
+	invalidatePageNormalizer(rule.WebsiteID)
+	return nil
+}
+
+// validatePageRule validates the definition of a page rule
+func validatePageRule(rule *model.PageRule) error {
+	switch rule.Type {
+	case model.PageRuleTypeRegex:
+		if rule.Pattern == "" {
+			return errors.New("page rule pattern is required")
+		}
+		if _, err := regexp.Compile(rule.Pattern); err != nil {
+			return errors.New("invalid page rule pattern: " + err.Error())
+		}
+	case model.PageRuleTypeStripQuery, model.PageRuleTypeTrailingSlash:
+	default:
+		return errors.New("page rule type must be REGEX, STRIP_QUERY or TRAILING_SLASH")
+	}
+
+	if len(rule.Pattern) > 255 || len(rule.Replacement) > 255 {
+		return errors.New("page rule pattern and replacement must not exceed 255 characters")
+	}
+
+	return nil
+}
+
+// pageNormalizer normalizes page paths with the page rules of a website
+type pageNormalizer struct {
+	rules   []model.PageRule
+	regexes []*regexp.Regexp // Compiled pattern of each rule, nil for the rules other than REGEX
+}
+
+// pageNormalizerTTL is how long a compiled normalizer is used before the rules are loaded again,
+// so that rule changes made through another instance are picked up
+const pageNormalizerTTL = time.Minute
+
+// cachedPageNormalizer is a compiled normalizer and the time its rules were loaded
+type cachedPageNormalizer struct {
+	normalizer *pageNormalizer
+	loadedAt   time.Time
+}
+
+// pageNormalizers caches the compiled normalizers by website ID
+var pageNormalizers sync.Map
+
+// loadPageNormalizer gets the normalizer of the page rules of a website, compiling the rules
+// only when they are not cached or changed
+func loadPageNormalizer(repo *repository.PageRuleRepository, websiteID int) (*pageNormalizer, error) {
+	if cached, ok := pageNormalizers.Load(websiteID); ok && time.Since(cached.(*cachedPageNormalizer).loadedAt) < pageNormalizerTTL {
+		return cached.(*cachedPageNormalizer).normalizer, nil
+	}
+
+	loadedAt := time.Now()
+	rules, err := repo.ListByWebsiteID(websiteID)
+	if err != nil {
+		return nil, err
+	}
+
+	normalizer := newPageNormalizer(rules)
+	pageNormalizers.Store(websiteID, &cachedPageNormalizer{normalizer: normalizer, loadedAt: loadedAt})
+	return normalizer, nil
+}
+
+// invalidatePageNormalizer makes the page rules of a website be loaded again
+func invalidatePageNormalizer(websiteID int) {
+	pageNormalizers.Delete(websiteID)
+}
+
+// newPageNormalizer compiles the page rules of a website. Rules with an invalid pattern are skipped.
+func newPageNormalizer(rules []model.PageRule) *pageNormalizer {
+	normalizer := &pageNormalizer{}
+	for _, rule := range rules {
+		var re *regexp.Regexp
+		if rule.Type == model.PageRuleTypeRegex {
+			var err error
+			if re, err = regexp.Compile(rule.Pattern); err != nil {
+				continue
+			}
+		}
+		normalizer.rules = append(normalizer.rules, rule)
+		normalizer.regexes = append(normalizer.regexes, re)
+	}
+	return normalizer
+}
+
+// Normalize applies the rules to a page path with an optional query string. Query strings are
+// dropped unless a STRIP_QUERY rule lists the parameters to strip, and regular expressions are
+// matched against the path without the query string.
+func (n *pageNormalizer) Normalize(path string) string {
+	query := ""
+	if index := strings.Index(path, "?"); index >= 0 {
+		path, query = path[:index], path[index+1:]
+	}
+
+	keepQuery := false
+	for i, rule := range n.rules {
+		switch rule.Type {
+		case model.PageRuleTypeRegex:
+			path = n.regexes[i].ReplaceAllString(path, rule.Replacement)
+		case model.PageRuleTypeStripQuery:
+			if strings.TrimSpace(rule.Pattern) == "" {
+				query = ""
+			} else {
+				query = stripQueryParams(query, rule.Pattern)
+				keepQuery = true
+			}
+		case model.PageRuleTypeTrailingSlash:
+			if len(path) > 1 {
+				path = strings.TrimRight(path, "/")
+			}
+		}
+	}
+
+	if path == "" {
+		path = "/"
+	}
+	if keepQuery && query != "" {
+		path += "?" + query
+	}
+	return truncate(path, 255)
+}
+
+// stripQueryParams removes the parameters whose name matches one of the comma separated
+// patterns (* wildcard) from a query string, keeping the order of the others
+func stripQueryParams(query, patterns string) string {
+	var kept []string
+	for _, param := range strings.Split(query, "&") {
+		if param == "" {
+			continue
+		}
+
+		name := param
+		if index := strings.Index(param, "="); index >= 0 {
+			name = param[:index]
+		}
+
+		stripped := false
+		for _, pattern := range strings.Split(patterns, ",") {
+			if pattern = strings.TrimSpace(pattern); pattern != "" && matchPattern(pattern, name) {
+				stripped = true
+				break
+			}
+		}
+		if !stripped {
+			kept = append(kept, param)
+		}
+	}
+	return strings.Join(kept, "&")
+}
+
+// pageMaxMergedPaths is the maximum number of collected paths whose visitors are counted once when
+// they are normalized to the same path, the visitors of more paths are summed up
+const pageMaxMergedPaths = 1000
+
+// PageStats represents the page views of a normalized page path, along with those of the path in
+// the compared range and their changes when a range is compared
+type PageStats struct {
+	Path     string            `json:"path"`
+	PV       int64             `json:"pv"`
+	Visitors int64             `json:"visitors"` // Distinct IPs, an upper bound for paths merged from more than pageMaxMergedPaths paths
+	AvgTime  float64           `json:"avg_time"` // Average seconds on the page, over the page views with a leave time
+	Compare  *PageStats        `json:"compare,omitempty"`
+	Change   map[string]Change `json:"change,omitempty"`
+}
+
+// EntryPageStats represents the visits entering a website on a normalized page path, along with
+// those of the path in the compared range and their changes when a range is compared
+type EntryPageStats struct {
+	Path       string            `json:"path"`
+	Entrances  int64             `json:"entrances"`
+	BounceRate float64           `json:"bounce_rate"` // Entrances without a second page view
+	Compare    *EntryPageStats   `json:"compare,omitempty"`
+	Change     map[string]Change `json:"change,omitempty"`
+}
+
+// ExitPageStats represents the visits leaving a website on a normalized page path, along with
+// those of the path in the compared range and their changes when a range is compared
+type ExitPageStats struct {
+	Path     string            `json:"path"`
+	Exits    int64             `json:"exits"`
+	ExitRate float64           `json:"exit_rate"` // Exits relative to the page views of the path
+	Compare  *ExitPageStats    `json:"compare,omitempty"`
+	Change   map[string]Change `json:"change,omitempty"`
+}
+
+// PageReport represents the top, entry and exit pages of a website over a date range
+type PageReport struct {
+	TopPages   []PageStats      `json:"top_pages"`
+	EntryPages []EntryPageStats `json:"entry_pages"`
+	ExitPages  []ExitPageStats  `json:"exit_pages"`
+	Compare    *Comparison      `json:"compare,omitempty"` // Compared range
+}
+
+// GetPageReport gets the top pages by PV and the top entry and exit pages of a website for the
+// visits matching the filters. The page rules of the website are applied to the collected paths,
+// so that paths collected before a rule was added are reported with the newer ones. With a
+// comparison, the reported paths are compared to the same paths in the compared range.
+func (s *PageService) GetPageReport(websiteID int, from, to time.Time, limit int, filters []repository.StatFilter, comparison *Comparison) (*PageReport, error) {
+	normalizer, err := loadPageNormalizer(s.pageRuleRepo, websiteID)
+	if err != nil {
+		return nil, err
+	}
+	repo := s.statAnalyticsRepo.WithFilters(filters)
+
+	report, err := buildPageReport(repo, normalizer, websiteID, from, to, limit, nil)
+	if err != nil || comparison == nil {
+		return report, err
+	}
+
+	reported := make(map[string]bool)
+	for _, page := range report.TopPages {
+		reported[page.Path] = true
+	}
+	for _, entry := range report.EntryPages {
+		reported[entry.Path] = true
+	}
+	for _, exit := range report.ExitPages {
+		reported[exit.Path] = true
+	}
+
+	compared, err := buildPageReport(repo, normalizer, websiteID, comparison.From, comparison.To, limit, reported)
+	if err != nil {
+		return nil, err
+	}
+
+	comparedPages := make(map[string]PageStats)
+	for _, page := range compared.TopPages {
+		comparedPages[page.Path] = page
+	}
+	for i := range report.TopPages {
+		page := &report.TopPages[i]
+		comparedPage := comparedPages[page.Path]
+		comparedPage.Path = page.Path
+		page.Compare = &comparedPage
+		page.Change = map[string]Change{
+			MetricPV:   newChange(float64(page.PV), float64(comparedPage.PV)),
+			"visitors": newChange(float64(page.Visitors), float64(comparedPage.Visitors)),
+		}
+	}
+
+	comparedEntries := make(map[string]EntryPageStats)
+	for _, entry := range compared.EntryPages {
+		comparedEntries[entry.Path] = entry
+	}
+	for i := range report.EntryPages {
+		entry := &report.EntryPages[i]
+		comparedEntry := comparedEntries[entry.Path]
+		comparedEntry.Path = entry.Path
+		entry.Compare = &comparedEntry
+		entry.Change = map[string]Change{
+			"entrances": newChange(float64(entry.Entrances), float64(comparedEntry.Entrances)),
+		}
+	}
+
+	comparedExits := make(map[string]ExitPageStats)
+	for _, exit := range compared.ExitPages {
+		comparedExits[exit.Path] = exit
+	}
+	for i := range report.ExitPages {
+		exit := &report.ExitPages[i]
+		comparedExit := comparedExits[exit.Path]
+		comparedExit.Path = exit.Path
+		exit.Compare = &comparedExit
+		exit.Change = map[string]Change{
+			"exits": newChange(float64(exit.Exits), float64(comparedExit.Exits)),
+		}
+	}
+
+	report.Compare = comparison
+	return report, nil
+}
+
+// buildPageReport gets the top, entry and exit pages of a website in a time range, limited to
+// the given paths instead of the top ones when only isn't nil
+func buildPageReport(repo *repository.StatAnalyticsRepository, normalizer *pageNormalizer, websiteID int, from, to time.Time, limit int, only map[string]bool) (*PageReport, error) {
+	paths, err := repo.GetPagePaths(websiteID, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	// Merge the paths normalized to the same path. Paths are normalized when collected, so only
+	// paths collected before a rule was added or changed are merged.
+	pages := make(map[string]*PageStats)
+	mergedPaths := make(map[string][]string)
+	timeOnPage := make(map[string]int64)
+	timedPV := make(map[string]int64)
+	for _, item := range paths {
+		path := normalizer.Normalize(item.Path)
+		page, ok := pages[path]
+		if !ok {
+			page = &PageStats{Path: path}
+			pages[path] = page
+		}
+
+		page.PV += item.PV
+		page.Visitors += item.Visitors
+		mergedPaths[path] = append(mergedPaths[path], item.Path)
+		timeOnPage[path] += item.TimeOnPage
+		timedPV[path] += item.TimedPV
+	}
+
+	report := &PageReport{
+		TopPages:   []PageStats{},
+		EntryPages: []EntryPageStats{},
+		ExitPages:  []ExitPageStats{},
+	}
+
+	for path, page := range pages {
+		if only != nil && !only[path] {
+			continue
+		}
+		if timedPV[path] > 0 {
+			page.AvgTime = float64(timeOnPage[path]) / float64(timedPV[path])
+		}
+		report.TopPages = append(report.TopPages, *page)
+	}
+	sort.Slice(report.TopPages, func(i, j int) bool {
+		if report.TopPages[i].PV != report.TopPages[j].PV {
+			return report.TopPages[i].PV > report.TopPages[j].PV
+		}
+		return report.TopPages[i].Path < report.TopPages[j].Path
+	})
+	if only == nil && len(report.TopPages) > limit {
+		report.TopPages = report.TopPages[:limit]
+	}
+
+	// Visitors of merged paths are summed up above, count them once for the reported pages
+	for i := range report.TopPages {
+		page := &report.TopPages[i]
+		if merged := mergedPaths[page.Path]; len(merged) > 1 && len(merged) <= pageMaxMergedPaths {
+			if page.Visitors, err = repo.GetPathsVisitors(websiteID, from, to, merged); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	entries, err := repo.GetEntryPages(websiteID, from, to)
+	if err != nil {
+		return nil, err
+	}
+	for _, item := range mergePageVisits(normalizer, entries, limit, only) {
+		entry := EntryPageStats{Path: item.Path, Entrances: item.Visits}
+		if item.Visits > 0 {
+			entry.BounceRate = float64(item.Bounces) / float64(item.Visits)
+		}
+		report.EntryPages = append(report.EntryPages, entry)
+	}
+
+	exits, err := repo.GetExitPages(websiteID, from, to)
+	if err != nil {
+		return nil, err
+	}
+	for _, item := range mergePageVisits(normalizer, exits, limit, only) {
+		exit := ExitPageStats{Path: item.Path, Exits: item.Visits}
+		if page, ok := pages[item.Path]; ok && page.PV > 0 {
+			exit.ExitRate = float64(item.Visits) / float64(page.PV)
+		}
+		report.ExitPages = append(report.ExitPages, exit)
+	}
+
+	return report, nil
+}
+
+// mergePageVisits normalizes the paths of page visits, sums the visits of the same path and
+// returns the paths with the most visits, or the given paths when only isn't nil
+func mergePageVisits(normalizer *pageNormalizer, data []repository.PageVisitData, limit int, only map[string]bool) []repository.PageVisitData {
+	byPath := make(map[string]*repository.PageVisitData)
+	var results []repository.PageVisitData
+	for _, item := range data {
+		path := normalizer.Normalize(item.Path)
+		if only != nil && !only[path] {
+			continue
+		}
+		merged, ok := byPath[path]
+		if !ok {
+			merged = &repository.PageVisitData{Path: path}
+			byPath[path] = merged
+		}
+		merged.Visits += item.Visits
+		merged.Bounces += item.Bounces
+	}
+
+	for _, item := range byPath {
+		results = append(results, *item)
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Visits != results[j].Visits {
+			return results[i].Visits > results[j].Visits
+		}
+		return results[i].Path < results[j].Path
+	})
+	if only == nil && len(results) > limit {
+		results = results[:limit]
+	}
+	return results
+}
@@ -0,0 +1,51 @@
+package service
+
+import (
+	"net/url"
+	"strings"
+)
+
+// matchPattern matches a value against a pattern where * matches any sequence of characters
+func matchPattern(pattern, value string) bool {
+	parts := strings.Split(pattern, "*")
+	if len(parts) == 1 {
+		return pattern == value
+	}
+
+	if !strings.HasPrefix(value, parts[0]) {
+		return false
+	}
+	value = value[len(parts[0]):]
+
+	for _, part := range parts[1 : len(parts)-1] {
+		index := strings.Index(value, part)
+		if index < 0 {
+			return false
+		}
+		value = value[index+len(part):]
+	}
+
+	return strings.HasSuffix(value, parts[len(parts)-1])
+}
+
+// matchURLPattern matches a page URL against a pattern. Patterns containing a scheme are
+// matched against the whole URL, all others against the URL path only.
+func matchURLPattern(pattern, location string) bool {
+	if strings.Contains(pattern, "://") {
+		if index := strings.Index(location, "#"); index >= 0 {
+			location = location[:index]
+		}
+		return matchPattern(pattern, location)
+	}
+
+	parsedURL, err := url.Parse(location)
+	if err != nil {
+		return false
+	}
+
+	path := parsedURL.Path
+	if path == "" {
+		path = "/"
+	}
+	return matchPattern(pattern, path)
+}
@@ -0,0 +1,142 @@
+package service
+
+import (
+	"errors"
+	"time"
+
+	"aq3stat/internal/model"
+	"aq3stat/internal/repository"
+)
+
+// Retention periods
+const (
+	RetentionPeriodWeek  = "week"
+	RetentionPeriodMonth = "month"
+)
+
+// RetentionCohort represents the visitors first seen in a period and how many of them came back
+type RetentionCohort struct {
+	Start     string    `json:"start"`
+	Size      int       `json:"size"`
+	Returning []int     `json:"returning"` // Returning visitors per following period, index 0 is the cohort period
+	Retention []float64 `json:"retention"` // Returning visitors in percent of the cohort size
+}
+
+// RetentionReport represents a cohort retention table
+type RetentionReport struct {
+	Period  string            `json:"period"`
+	Cohorts []RetentionCohort `json:"cohorts"`
+}
+
+// GetRetentionReport groups the visitors of a website by first-seen week or month and
+// computes the percentage that came back in each following period
+func (s *StatService) GetRetentionReport(websiteID int, period string, cohorts int, filters []repository.StatFilter) (*RetentionReport, error) {
+	if period != RetentionPeriodWeek && period != RetentionPeriodMonth {
+		return nil, errors.New("period must be week or month")
+	}
+
+	website, err := s.websiteRepo.FindByID(websiteID)
+	if err != nil {
+		return nil, err
+	}
+
+	current := periodStart(website, website.Now(), period)
+	first := addPeriods(current, period, -(cohorts - 1))
+	end := addPeriods(current, period, 1)
+
+	repo := s.statAnalyticsRepo.WithFilters(filters)
+	firstSeen, err := repo.GetFirstSeenVisitors(websiteID, first, end)
+	if err != nil {
+		return nil, err
+	}
+
+	activity, err := repo.GetVisitorActivity(websiteID, first, end)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &RetentionReport{
+		Period:  period,
+		Cohorts: make([]RetentionCohort, cohorts),
+	}
+
+	starts := make([]time.Time, cohorts)
+	for i := range report.Cohorts {
+		starts[i] = addPeriods(first, period, i)
+		report.Cohorts[i] = RetentionCohort{
+			Start:     starts[i].Format("2006-01-02"),
+			Returning: make([]int, cohorts-i),
+			Retention: make([]float64, cohorts-i),
+		}
+	}
+
+	// Assign every new visitor to the cohort of its first visit
+	cohortOf := make(map[string]int, len(firstSeen))
+	for _, visitor := range firstSeen {
+		index := periodIndex(starts, visitor.Time)
+		if index < 0 {
+			continue
+		}
+		cohortOf[visitor.Visitor] = index
+		report.Cohorts[index].Size++
+	}
+
+	// Count every visitor at most once per period
+	seen := make(map[string]bool)
+	for _, visit := range activity {
+		cohort, ok := cohortOf[visit.Visitor]
+		if !ok {
+			continue
+		}
+
+		index := periodIndex(starts, visit.Time)
+		if index < cohort {
+			continue
+		}
+
+		key := visit.Visitor + "|" + starts[index].Format("2006-01-02")
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		report.Cohorts[cohort].Returning[index-cohort]++
+	}
+
+	for i := range report.Cohorts {
+		cohort := &report.Cohorts[i]
+		if cohort.Size == 0 {
+			continue
+		}
+		for j, count := range cohort.Returning {
+			cohort.Retention[j] = float64(count) * 100 / float64(cohort.Size)
+		}
+	}
+
+	return report, nil
+}
+
+// periodStart returns the start of the website's week or month containing t
+func periodStart(website *model.Website, t time.Time, period string) time.Time {
+	if period == RetentionPeriodMonth {
+		return website.StartOfMonth(t)
+	}
+	return website.StartOfWeek(t)
+}
+
+// addPeriods adds n weeks or months to a period start
+func addPeriods(t time.Time, period string, n int) time.Time {
+	if period == RetentionPeriodMonth {
+		return t.AddDate(0, n, 0)
+	}
+	return t.AddDate(0, 0, 7*n)
+}
+
+// periodIndex returns the index of the period containing t, or -1 if t is before the first one
+func periodIndex(starts []time.Time, t time.Time) int {
+	for i := len(starts) - 1; i >= 0; i-- {
+		if !t.Before(starts[i]) {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,115 @@
+package service
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"aq3stat/internal/model"
+	"aq3stat/internal/repository"
+)
+
+// RollupService keeps the hourly and daily stat rollups of the websites up to date. Only closed
+// days of a website's time zone are rolled up, the current day is always read from the raw stats.
+type RollupService struct {
+	websiteRepo *repository.WebsiteRepository
+	rollupRepo  *repository.RollupRepository
+}
+
+// NewRollupService creates a new rollup service
+func NewRollupService() *RollupService {
+	return &RollupService{
+		websiteRepo: repository.NewWebsiteRepository(),
+		rollupRepo:  repository.NewRollupRepository(),
+	}
+}
+
+// StartRollupWorker rolls up the stats of all websites in the background, right away and then
+// every ROLLUP_INTERVAL (10m by default)
+func (s *RollupService) StartRollupWorker() {
+	interval, err := time.ParseDuration(os.Getenv("ROLLUP_INTERVAL"))
+	if err != nil || interval <= 0 {
+		interval = 10 * time.Minute
+	}
+
+	go func() {
+		for {
+			if err := s.RollupAll(); err != nil {
+				log.Printf("Failed to roll up stats: %v", err)
+			}
+			time.Sleep(interval)
+		}
+	}()
+}
+
+// RollupAll rolls up the closed days of all websites that are not rolled up yet
+func (s *RollupService) RollupAll() error {
+	websites, err := s.websiteRepo.ListAll()
+	if err != nil {
+		return err
+	}
+
+	for i := range websites {
+		if _, err := s.RollupWebsite(&websites[i]); err != nil {
+			log.Printf("Failed to roll up stats of website %d: %v", websites[i].ID, err)
+		}
+	}
+
+	return nil
+}
+
+// RollupWebsite rolls up the closed days of a website that are not rolled up yet, a few days
+// per transaction. It returns the number of days rolled up.
+func (s *RollupService) RollupWebsite(website *model.Website) (int, error) {
+	state, err := s.rollupRepo.GetState(website.ID)
+	if err != nil {
+		return 0, err
+	}
+
+	// Start with the first day of data when nothing is rolled up yet
+	until := state.RolledUntil
+	if until.IsZero() {
+		first, err := s.rollupRepo.GetFirstStatTime(website.ID)
+		if err != nil || first == nil {
+			return 0, err
+		}
+		until = *first
+	}
+
+	// Purged days can't be rolled up again
+	if state.PurgedUntil != nil && until.Before(*state.PurgedUntil) {
+		until = *state.PurgedUntil
+	}
+
+	from := website.StartOfDay(until)
+	today := website.StartOfDay(website.Now())
+	batchDays := getRollupBatchDays()
+
+	days := 0
+	for from.Before(today) {
+		to, n := from, 0
+		for n < batchDays && to.Before(today) {
+			to = to.AddDate(0, 0, 1)
+			n++
+		}
+
+		if err := s.rollupRepo.Rollup(website.ID, from, to); err != nil {
+			return days, err
+		}
+
+		days += n
+		from = to
+	}
+
+	return days, nil
+}
+
+// getRollupBatchDays returns the number of days rolled up per transaction
+func getRollupBatchDays() int {
+	days, err := strconv.Atoi(os.Getenv("ROLLUP_BATCH_DAYS"))
+	if err != nil || days <= 0 {
+		return 7
+	}
+	return days
+}
@@ -0,0 +1,89 @@
+package service
+
+import (
+	"errors"
+	"strconv"
+
+	"aq3stat/internal/model"
+	"aq3stat/internal/repository"
+)
+
+// MaxComparedSegments is the maximum number of segments compared side by side in a report
+const MaxComparedSegments = 3
+
+// SegmentService handles segment related business logic
+type SegmentService struct {
+	segmentRepo *repository.SegmentRepository
+}
+
+// NewSegmentService creates a new segment service
+func NewSegmentService() *SegmentService {
+	return &SegmentService{
+		segmentRepo: repository.NewSegmentRepository(),
+	}
+}
+
+// ListSegments lists the segments of a website
+func (s *SegmentService) ListSegments(websiteID int) ([]model.Segment, error) {
+	return s.segmentRepo.ListByWebsiteID(websiteID)
+}
+
+// GetSegmentByID gets a segment by ID
+func (s *SegmentService) GetSegmentByID(id int) (*model.Segment, error) {
+	return s.segmentRepo.FindByID(id)
+}
+
+// CreateSegment creates a new segment
+func (s *SegmentService) CreateSegment(segment *model.Segment) error {
+	if err := validateSegment(segment); err != nil {
+		return err
+	}
+	return s.segmentRepo.Create(segment)
+}
+
+// UpdateSegment updates a segment
+func (s *SegmentService) UpdateSegment(segment *model.Segment) error {
+	if err := validateSegment(segment); err != nil {
+		return err
+	}
+	return s.segmentRepo.Update(segment)
+}
+
+// DeleteSegment deletes a segment
+func (s *SegmentService) DeleteSegment(id int) error {
+	return s.segmentRepo.Delete(id)
+}
+
+// SegmentFilters parses the filter expression of a segment
+func SegmentFilters(segment *model.Segment) ([]repository.StatFilter, error) {
+	return ParseStatFilters(segment.Filters)
+}
+
+// ValidateComparedSegments checks the number of segments compared side by side
+func ValidateComparedSegments(segments []model.Segment) error {
+	if len(segments) > MaxComparedSegments {
+		return errors.New("at most " + strconv.Itoa(MaxComparedSegments) + " segments can be compared")
+	}
+	return nil
+}
+
+// validateSegment validates the name and filter expression of a segment
+func validateSegment(segment *model.Segment) error {
+	if segment.Name == "" || len(segment.Name) > 100 {
+		return errors.New("segment name must be between 1 and 100 characters")
+	}
+
+	if len(segment.Filters) > 1000 {
+		return errors.New("segment filters must not exceed 1000 characters")
+	}
+
+	filters, err := SegmentFilters(segment)
+	if err != nil {
+		return err
+	}
+	if len(filters) == 0 {
+		return errors.New("segment must have at least one filter")
+	}
+
+	return nil
+}
@@ -0,0 +1,115 @@
+package service
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"aq3stat/internal/repository"
+	"aq3stat/pkg/cache"
+)
+
+// Stats cache periods. Live periods include today and change with every hit, so their results
+// are cached for a short TTL. Closed periods only change when past hits are replayed or history
+// is imported, which drops their results.
+const (
+	cachePeriodLive   = "live"
+	cachePeriodClosed = "closed"
+)
+
+// systemCacheID is the website ID of the system-wide stats in the cache keys
+const systemCacheID = 0
+
+// statsCache caches query results of a website as JSON. Keys contain a generation per website
+// and period, so that invalidating all entries of a website only takes a new generation.
+type statsCache struct {
+	cache     cache.Cache
+	liveTTL   time.Duration
+	closedTTL time.Duration
+}
+
+// newStatsCache creates a stats cache on the configured cache backend with the TTLs of the
+// CACHE_LIVE_TTL and CACHE_CLOSED_TTL environment variables
+func newStatsCache() *statsCache {
+	return &statsCache{
+		cache:     cache.Default(),
+		liveTTL:   cacheTTL("CACHE_LIVE_TTL", time.Minute),
+		closedTTL: cacheTTL("CACHE_CLOSED_TTL", 24*time.Hour),
+	}
+}
+
+// load fills value with the cached result of a query of a website, or runs the query, which
+// must fill value, and caches its result for the TTL of the period
+func (c *statsCache) load(websiteID int, period, name string, value interface{}, query func() error) error {
+	key := "aq3stat:stats:" + strconv.Itoa(websiteID) + ":" + period + ":" + c.generation(websiteID, period) + ":" + name
+	if data, ok, err := c.cache.Get(key); err != nil {
+		log.Printf("Failed to read stats cache: %v", err)
+	} else if ok && json.Unmarshal(data, value) == nil {
+		return nil
+	}
+
+	if err := query(); err != nil {
+		return err
+	}
+
+	ttl := c.liveTTL
+	if period == cachePeriodClosed {
+		ttl = c.closedTTL
+	}
+	if data, err := json.Marshal(value); err == nil {
+		if err := c.cache.Set(key, data, ttl); err != nil {
+			log.Printf("Failed to write stats cache: %v", err)
+		}
+	}
+	return nil
+}
+
+// generation returns the current generation of the cached results of a website for a period.
+// A missing generation, e.g. after an eviction, starts a new one so that no older entry is reused.
+func (c *statsCache) generation(websiteID int, period string) string {
+	key := generationKey(websiteID, period)
+	if data, ok, err := c.cache.Get(key); err == nil && ok {
+		return string(data)
+	}
+
+	generation := strconv.FormatInt(time.Now().UnixNano(), 36)
+	c.cache.Set(key, []byte(generation), 0)
+	return generation
+}
+
+// invalidateClosedStatsCache drops the cached closed results of a website after hits of past days
+// were written or imported. Live results aren't dropped on hits but expire after their TTL.
+func invalidateClosedStatsCache(websiteID int) {
+	if err := cache.Default().Delete(generationKey(websiteID, cachePeriodClosed)); err != nil {
+		log.Printf("Failed to invalidate stats cache of website %d: %v", websiteID, err)
+	}
+}
+
+// generationKey returns the cache key of the generation of a website and period
+func generationKey(websiteID int, period string) string {
+	return "aq3stat:stats:" + strconv.Itoa(websiteID) + ":" + period + ":generation"
+}
+
+// filtersCacheKey returns a short key identifying a list of filters
+func filtersCacheKey(filters []repository.StatFilter) string {
+	if len(filters) == 0 {
+		return "all"
+	}
+
+	data, _ := json.Marshal(filters)
+	sum := sha1.Sum(data)
+	return hex.EncodeToString(sum[:8])
+}
+
+// cacheTTL reads a TTL from an environment variable
+func cacheTTL(key string, defaultValue time.Duration) time.Duration {
+	value, err := time.ParseDuration(os.Getenv(key))
+	if err != nil {
+		return defaultValue
+	}
+	return value
+}
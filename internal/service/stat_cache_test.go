@@ -0,0 +1,126 @@
+package service
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"aq3stat/pkg/cache"
+)
+
+// newTestStatsCache creates a stats cache on a fresh in-process default cache, which
+// invalidateClosedStatsCache also uses
+func newTestStatsCache(t *testing.T, liveTTL, closedTTL time.Duration) *statsCache {
+	t.Setenv("CACHE", "lru")
+	cache.Init()
+	return &statsCache{cache: cache.Default(), liveTTL: liveTTL, closedTTL: closedTTL}
+}
+
+// countingQuery returns a query storing the number of times it ran into value
+func countingQuery(value *int, calls *int) func() error {
+	return func() error {
+		*calls++
+		*value = *calls
+		return nil
+	}
+}
+
+func TestStatsCacheLoad(t *testing.T) {
+	c := newTestStatsCache(t, time.Hour, time.Hour)
+
+	var value, calls int
+	for i := 0; i < 2; i++ {
+		if err := c.load(1, cachePeriodLive, "stats", &value, countingQuery(&value, &calls)); err != nil {
+			t.Fatalf("load() error = %v", err)
+		}
+	}
+	if calls != 1 || value != 1 {
+		t.Errorf("query ran %d times, value %d, want a cached result", calls, value)
+	}
+
+	// Other websites, periods and names are cached separately
+	c.load(2, cachePeriodLive, "stats", &value, countingQuery(&value, &calls))
+	c.load(1, cachePeriodClosed, "stats", &value, countingQuery(&value, &calls))
+	c.load(1, cachePeriodLive, "other", &value, countingQuery(&value, &calls))
+	if calls != 4 {
+		t.Errorf("query ran %d times, want 4", calls)
+	}
+
+	// Failed queries aren't cached
+	queryErr := errors.New("database down")
+	if err := c.load(3, cachePeriodLive, "stats", &value, func() error { return queryErr }); err != queryErr {
+		t.Errorf("load() error = %v, want %v", err, queryErr)
+	}
+	c.load(3, cachePeriodLive, "stats", &value, countingQuery(&value, &calls))
+	if calls != 5 {
+		t.Errorf("failed query was cached")
+	}
+}
+
+func TestStatsCacheTTL(t *testing.T) {
+	tests := []struct {
+		period    string
+		wantCalls int
+	}{
+		{cachePeriodLive, 2},
+		{cachePeriodClosed, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.period, func(t *testing.T) {
+			c := newTestStatsCache(t, 10*time.Millisecond, time.Hour)
+
+			var value, calls int
+			c.load(1, tt.period, "stats", &value, countingQuery(&value, &calls))
+			time.Sleep(20 * time.Millisecond)
+			c.load(1, tt.period, "stats", &value, countingQuery(&value, &calls))
+
+			if calls != tt.wantCalls {
+				t.Errorf("query ran %d times, want %d", calls, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestInvalidateClosedStatsCache(t *testing.T) {
+	c := newTestStatsCache(t, time.Hour, time.Hour)
+
+	var value, calls int
+	load := func(websiteID int, period string) {
+		t.Helper()
+		if err := c.load(websiteID, period, "stats", &value, countingQuery(&value, &calls)); err != nil {
+			t.Fatalf("load() error = %v", err)
+		}
+	}
+	load(1, cachePeriodLive)
+	load(1, cachePeriodClosed)
+	load(2, cachePeriodClosed)
+
+	// Only the closed results of the website are queried again
+	invalidateClosedStatsCache(1)
+	calls = 0
+	load(1, cachePeriodLive)
+	load(2, cachePeriodClosed)
+	if calls != 0 {
+		t.Errorf("query ran %d times for results that weren't invalidated", calls)
+	}
+	load(1, cachePeriodClosed)
+	if calls != 1 {
+		t.Errorf("query ran %d times for invalidated results, want 1", calls)
+	}
+}
+
+func TestStatsCacheEvictedGeneration(t *testing.T) {
+	c := newTestStatsCache(t, time.Hour, time.Hour)
+
+	var value, calls int
+	c.load(1, cachePeriodClosed, "stats", &value, countingQuery(&value, &calls))
+
+	// A lost generation starts a new one instead of reusing the entries of the old one
+	c.cache.Delete(generationKey(1, cachePeriodClosed))
+	time.Sleep(time.Microsecond)
+	c.load(1, cachePeriodClosed, "stats", &value, countingQuery(&value, &calls))
+	if calls != 2 || value != 2 {
+		t.Errorf("query ran %d times, value %d, want a new result", calls, value)
+	}
+}
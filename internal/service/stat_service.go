@@ -1,15 +1,19 @@
 package service
 
 import (
+	"strconv"
 	"time"
 
+	"aq3stat/internal/model"
 	"aq3stat/internal/repository"
+	"aq3stat/pkg/hll"
 )
 
 // StatService handles statistics related business logic
 type StatService struct {
 	websiteRepo       *repository.WebsiteRepository
 	statAnalyticsRepo *repository.StatAnalyticsRepository
+	statsCache        *statsCache
 }
 
 // NewStatService creates a new stat service
@@ -17,6 +21,7 @@ func NewStatService() *StatService {
 	return &StatService{
 		websiteRepo:       repository.NewWebsiteRepository(),
 		statAnalyticsRepo: repository.NewStatAnalyticsRepository(),
+		statsCache:        newStatsCache(),
 	}
 }
 
@@ -63,83 +68,178 @@ type WebsiteStats struct {
 	NewVisitors       int64 `json:"new_visitors"`
 	ReturningVisitors int64 `json:"returning_visitors"`
 
+	// Today conversions
+	TodayConversions    int64   `json:"today_conversions"`
+	TodayConversionRate float64 `json:"today_conversion_rate"`
+	TodayRevenue        float64 `json:"today_revenue"` // Goal revenue in CNY
+
 	// Days since start
 	DaysSinceStart int `json:"days_since_start"`
 }
 
 // GetWebsiteStats gets comprehensive stats for a website
-func (s *StatService) GetWebsiteStats(websiteID int) (*WebsiteStats, error) {
+func (s *StatService) GetWebsiteStats(websiteID int, filters []repository.StatFilter) (*WebsiteStats, error) {
 	// Get website to check start time
 	website, err := s.websiteRepo.FindByID(websiteID)
 	if err != nil {
 		return nil, err
 	}
 
-	// Calculate time periods
-	now := time.Now()
-	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	// Only count the visits matching the filters
+	repo := s.statAnalyticsRepo.WithFilters(filters)
+
+	// Calculate time periods in the website's time zone
+	now := website.Now()
+	today := website.StartOfDay(now)
 	yesterday := today.AddDate(0, 0, -1)
 
-	// Calculate week start (Monday)
-	weekday := int(now.Weekday())
-	if weekday == 0 { // Sunday
-		weekday = 7
-	}
-	thisWeekStart := today.AddDate(0, 0, -(weekday - 1))
+	// Calculate week start
+	thisWeekStart := website.StartOfWeek(now)
 	lastWeekStart := thisWeekStart.AddDate(0, 0, -7)
 
 	// Calculate month start
-	thisMonthStart := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+	thisMonthStart := website.StartOfMonth(now)
 	_ = thisMonthStart.AddDate(0, -1, 0) // lastMonthStart - reserved for future use
 
-	// Calculate days since start
-	daysSinceStart := int(now.Sub(website.StartTime).Hours() / 24)
-	if daysSinceStart < 1 {
-		daysSinceStart = 1 // Avoid division by zero
-	}
-
-	// Initialize stats object
-	stats := &WebsiteStats{
-		DaysSinceStart: daysSinceStart,
+	// Yesterday and last week are closed, the other periods are cached for a short time only
+	closed := &closedWebsiteStats{}
+	closedKey := "website_stats:" + yesterday.Format("2006-01-02") + ":" + thisWeekStart.Format("2006-01-02") + ":" + filtersCacheKey(filters)
+	err = s.statsCache.load(websiteID, cachePeriodClosed, closedKey, closed, func() error {
+		return getClosedWebsiteStats(repo, closed, websiteID, website.StartOfDay(website.StartTime), yesterday, today, lastWeekStart, thisWeekStart)
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	// Get today stats
-	stats.TodayIPCount, stats.TodayPVCount, err = s.statAnalyticsRepo.GetTodayStats(websiteID, today)
+	stats := &WebsiteStats{}
+	liveKey := "website_stats:" + now.Format("2006-01-02") + ":" + filtersCacheKey(filters)
+	err = s.statsCache.load(websiteID, cachePeriodLive, liveKey, stats, func() error {
+		return getLiveWebsiteStats(repo, stats, closed, website, now, today, thisWeekStart, thisMonthStart)
+	})
 	if err != nil {
 		return nil, err
 	}
 
+	stats.YesterdayIPCount, stats.YesterdayPVCount = closed.YesterdayIPCount, closed.YesterdayPVCount
+	stats.LastWeekIPCount, stats.LastWeekPVCount = closed.LastWeekIPCount, closed.LastWeekPVCount
+	return stats, nil
+}
+
+// closedWebsiteStats holds the stats of the closed periods of a website
+type closedWebsiteStats struct {
+	YesterdayIPCount int64 `json:"yesterday_ip_count"`
+	YesterdayPVCount int64 `json:"yesterday_pv_count"`
+	LastWeekIPCount  int64 `json:"last_week_ip_count"`
+	LastWeekPVCount  int64 `json:"last_week_pv_count"`
+
+	// The IPs of the days before today, counted with today's IPs for the total
+	ClosedVisitors   []byte `json:"closed_visitors"`   // Marshaled sketch
+	ClosedUnsketched int64  `json:"closed_unsketched"` // Daily visitors of the days without a sketch
+}
+
+// getClosedWebsiteStats gets the yesterday and last week stats of a website, including imported
+// history, and the visitors of the days from the first one to today
+func getClosedWebsiteStats(repo *repository.StatAnalyticsRepository, stats *closedWebsiteStats, websiteID int, firstDay, yesterday, today, lastWeekStart, thisWeekStart time.Time) error {
+	var err error
+
+	// Merge the sketches of the closed days once, so that live stats only add today's IPs
+	if firstDay.Before(today) {
+		sketch, unsketched, err := repo.GetVisitorSketch(websiteID, firstDay, today)
+		if err != nil {
+			return err
+		}
+		if stats.ClosedVisitors, err = sketch.MarshalBinary(); err != nil {
+			return err
+		}
+		stats.ClosedUnsketched = unsketched
+	}
+
 	// Get yesterday stats
-	stats.YesterdayIPCount, stats.YesterdayPVCount, err = s.statAnalyticsRepo.GetYesterdayStats(websiteID, yesterday, today)
+	stats.YesterdayIPCount, stats.YesterdayPVCount, err = repo.GetYesterdayStats(websiteID, yesterday, today)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	// Get this week stats
-	stats.ThisWeekIPCount, stats.ThisWeekPVCount, err = s.statAnalyticsRepo.GetWeekStats(websiteID, thisWeekStart)
+	// Get last week stats
+	stats.LastWeekIPCount, stats.LastWeekPVCount, err = repo.GetLastWeekStats(websiteID, lastWeekStart, thisWeekStart)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	// Get last week stats
-	stats.LastWeekIPCount, stats.LastWeekPVCount, err = s.statAnalyticsRepo.GetLastWeekStats(websiteID, lastWeekStart, thisWeekStart)
+	// Add data imported from other analytics tools
+	return addHistoricalStats(repo, websiteID, []historicalPeriod{
+		{yesterday, today, &stats.YesterdayIPCount, &stats.YesterdayPVCount},
+		{lastWeekStart, thisWeekStart, &stats.LastWeekIPCount, &stats.LastWeekPVCount},
+	})
+}
+
+// getLiveWebsiteStats gets the stats of a website of the periods including today, the totals,
+// averages, online visitors and today's conversions
+func getLiveWebsiteStats(repo *repository.StatAnalyticsRepository, stats *WebsiteStats, closed *closedWebsiteStats, website *model.Website, now, today, thisWeekStart, thisMonthStart time.Time) error {
+	websiteID := website.ID
+
+	// Calculate days since start, imported history extends the start
+	startTime := website.StartTime
+	historicalStart, err := repo.GetHistoricalStartDate(websiteID)
 	if err != nil {
-		return nil, err
+		return err
+	}
+	if historicalStart != nil {
+		// Imported dates are days of the website's time zone
+		historicalStartDay := time.Date(historicalStart.Year(), historicalStart.Month(), historicalStart.Day(), 0, 0, 0, 0, now.Location())
+		if historicalStartDay.Before(startTime) {
+			startTime = historicalStartDay
+		}
+	}
+	daysSinceStart := int(now.Sub(startTime).Hours() / 24)
+	if daysSinceStart < 1 {
+		daysSinceStart = 1 // Avoid division by zero
+	}
+	stats.DaysSinceStart = daysSinceStart
+
+	// Get today stats
+	stats.TodayIPCount, stats.TodayPVCount, err = repo.GetTodayStats(websiteID, today)
+	if err != nil {
+		return err
+	}
+
+	// Get this week stats
+	stats.ThisWeekIPCount, stats.ThisWeekPVCount, err = repo.GetWeekStats(websiteID, thisWeekStart)
+	if err != nil {
+		return err
 	}
 
 	// Get this month stats
-	stats.ThisMonthIPCount, stats.ThisMonthPVCount, err = s.statAnalyticsRepo.GetMonthStats(websiteID, thisMonthStart)
+	stats.ThisMonthIPCount, stats.ThisMonthPVCount, err = repo.GetMonthStats(websiteID, thisMonthStart)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
 	// Get total stats
-	stats.TotalIPCount, stats.TotalPVCount, err = s.statAnalyticsRepo.GetTotalStats(websiteID, website.StartTime)
+	stats.TotalIPCount, stats.TotalPVCount, err = repo.GetTotalStats(websiteID, website.StartOfDay(website.StartTime))
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	// Calculate average stats
+	// Add data imported from other analytics tools
+	tomorrow := today.AddDate(0, 0, 1)
+	err = addHistoricalStats(repo, websiteID, []historicalPeriod{
+		{today, tomorrow, &stats.TodayIPCount, &stats.TodayPVCount},
+		{thisWeekStart, tomorrow, &stats.ThisWeekIPCount, &stats.ThisWeekPVCount},
+		{thisMonthStart, tomorrow, &stats.ThisMonthIPCount, &stats.ThisMonthPVCount},
+	})
+	if err != nil {
+		return err
+	}
+
+	ip, pv, err := repo.GetHistoricalTotalStats(websiteID)
+	if err != nil {
+		return err
+	}
+	stats.TotalIPCount += ip
+	stats.TotalPVCount += pv
+
+	// Calculate average stats, of the IPs counted once per day
 	stats.AvgDailyIPCount = float64(stats.TotalIPCount) / float64(daysSinceStart)
 	stats.AvgDailyPVCount = float64(stats.TotalPVCount) / float64(daysSinceStart)
 	stats.AvgWeeklyIPCount = stats.AvgDailyIPCount * 7
@@ -147,46 +247,105 @@ func (s *StatService) GetWebsiteStats(websiteID int) (*WebsiteStats, error) {
 	stats.AvgMonthlyIPCount = stats.AvgDailyIPCount * 30
 	stats.AvgMonthlyPVCount = stats.AvgDailyPVCount * 30
 
+	// Count each IP once for the total, imported visitors can't be told apart. The IPs of the
+	// closed days are cached, only today's IPs are added.
+	firstDay := website.StartOfDay(website.StartTime)
+	if firstDay.Before(today) {
+		firstDay = today
+	}
+	sketch, unsketched, err := repo.GetVisitorSketch(websiteID, firstDay, time.Time{})
+	if err != nil {
+		return err
+	}
+	if len(closed.ClosedVisitors) > 0 {
+		closedSketch := &hll.Sketch{}
+		if err := closedSketch.UnmarshalBinary(closed.ClosedVisitors); err != nil {
+			return err
+		}
+		sketch.Merge(closedSketch)
+	}
+	stats.TotalIPCount = int64(sketch.Count()) + unsketched + closed.ClosedUnsketched + ip
+
 	// Get online visitors
-	stats.OnlineVisitors1Min, err = s.statAnalyticsRepo.GetOnlineVisitors(websiteID, 1)
+	stats.OnlineVisitors1Min, err = repo.GetOnlineVisitors(websiteID, 1)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	stats.OnlineVisitors5Min, err = s.statAnalyticsRepo.GetOnlineVisitors(websiteID, 5)
+	stats.OnlineVisitors5Min, err = repo.GetOnlineVisitors(websiteID, 5)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	stats.OnlineVisitors15Min, err = s.statAnalyticsRepo.GetOnlineVisitors(websiteID, 15)
+	stats.OnlineVisitors15Min, err = repo.GetOnlineVisitors(websiteID, 15)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
 	// Get new vs returning visitors
-	stats.NewVisitors, stats.ReturningVisitors, err = s.statAnalyticsRepo.GetNewVsReturningVisitors(websiteID, today)
+	stats.NewVisitors, stats.ReturningVisitors, err = repo.GetNewVsReturningVisitors(websiteID, today)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	return stats, nil
+	// Get today conversions
+	stats.TodayConversions, stats.TodayRevenue, err = repo.GetConversionTotals(websiteID, today, tomorrow)
+	if err != nil {
+		return err
+	}
+	if stats.TodayIPCount > 0 {
+		stats.TodayConversionRate = float64(stats.TodayConversions) / float64(stats.TodayIPCount)
+	}
+
+	return nil
+}
+
+// historicalPeriod is a period of the website stats with the counts imported history is added to
+type historicalPeriod struct {
+	from, to time.Time
+	ip, pv   *int64
+}
+
+// addHistoricalStats adds the imported historical data of a website to the counts of periods
+func addHistoricalStats(repo *repository.StatAnalyticsRepository, websiteID int, periods []historicalPeriod) error {
+	for _, period := range periods {
+		ip, pv, err := repo.GetHistoricalStats(websiteID, period.from, period.to)
+		if err != nil {
+			return err
+		}
+		*period.ip += ip
+		*period.pv += pv
+	}
+
+	return nil
 }
 
-// RefererStatsData represents referer statistics data
+// RefererStatsData represents referer statistics data, along with the value in the compared
+// range and its change when a range is compared
 type RefererStatsData struct {
-	Name  string `json:"name"`
-	Value int64  `json:"value"`
+	Name    string  `json:"name"`
+	Value   int64   `json:"value"`
+	Compare *int64  `json:"compare,omitempty"`
+	Change  *Change `json:"change,omitempty"`
 }
 
-// DeviceStatsData represents device statistics data
+// DeviceStatsData represents device statistics data, along with the value in the compared
+// range and its change when a range is compared
 type DeviceStatsData struct {
-	Name  string `json:"name"`
-	Value int64  `json:"value"`
+	Name    string  `json:"name"`
+	Value   int64   `json:"value"`
+	Compare *int64  `json:"compare,omitempty"`
+	Change  *Change `json:"change,omitempty"`
 }
 
 // GetWebsiteRefererStats gets referer statistics for a website
-func (s *StatService) GetWebsiteRefererStats(websiteID int) ([]RefererStatsData, error) {
-	repoData, err := s.statAnalyticsRepo.GetRefererStats(websiteID)
+func (s *StatService) GetWebsiteRefererStats(websiteID int, filters []repository.StatFilter) ([]RefererStatsData, error) {
+	var repoData []repository.RefererStatsData
+	err := s.statsCache.load(websiteID, cachePeriodLive, "referer_stats:"+filtersCacheKey(filters), &repoData, func() error {
+		var err error
+		repoData, err = s.statAnalyticsRepo.WithFilters(filters).GetRefererStats(websiteID)
+		return err
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -204,8 +363,8 @@ func (s *StatService) GetWebsiteRefererStats(websiteID int) ([]RefererStatsData,
 }
 
 // GetWebsiteDeviceStats gets device statistics for a website
-func (s *StatService) GetWebsiteDeviceStats(websiteID int) ([]DeviceStatsData, error) {
-	repoData, err := s.statAnalyticsRepo.GetDeviceStats(websiteID)
+func (s *StatService) GetWebsiteDeviceStats(websiteID int, filters []repository.StatFilter) ([]DeviceStatsData, error) {
+	repoData, err := s.statAnalyticsRepo.WithFilters(filters).GetDeviceStats(websiteID)
 	if err != nil {
 		return nil, err
 	}
@@ -222,17 +381,211 @@ func (s *StatService) GetWebsiteDeviceStats(websiteID int) ([]DeviceStatsData, e
 	return result, nil
 }
 
+// GetWebsiteRefererStatsBetween gets the referer statistics of a website in a half-open range
+// of days in its time zone
+func (s *StatService) GetWebsiteRefererStatsBetween(websiteID int, from, to time.Time, filters []repository.StatFilter) ([]RefererStatsData, error) {
+	website, err := s.websiteRepo.FindByID(websiteID)
+	if err != nil {
+		return nil, err
+	}
+
+	loc := website.Location()
+	repoData, err := s.statAnalyticsRepo.WithFilters(filters).GetRefererStatsBetween(websiteID, from.In(loc), to.In(loc))
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]RefererStatsData, len(repoData))
+	for i, item := range repoData {
+		result[i] = RefererStatsData{Name: item.Name, Value: item.Value}
+	}
+
+	return result, nil
+}
+
+// GetWebsiteDeviceStatsBetween gets the device statistics of a website in a half-open range of
+// days in its time zone
+func (s *StatService) GetWebsiteDeviceStatsBetween(websiteID int, from, to time.Time, filters []repository.StatFilter) ([]DeviceStatsData, error) {
+	website, err := s.websiteRepo.FindByID(websiteID)
+	if err != nil {
+		return nil, err
+	}
+
+	loc := website.Location()
+	repoData, err := s.statAnalyticsRepo.WithFilters(filters).GetDeviceStatsBetween(websiteID, from.In(loc), to.In(loc))
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]DeviceStatsData, len(repoData))
+	for i, item := range repoData {
+		result[i] = DeviceStatsData{Name: item.Name, Value: item.Value}
+	}
+
+	return result, nil
+}
+
+// CompareWebsiteRefererStats gets the referer statistics of a website in a half-open range of
+// days and compares each referer to its page views in the compared range
+func (s *StatService) CompareWebsiteRefererStats(websiteID int, from, to time.Time, filters []repository.StatFilter, comparison *Comparison) ([]RefererStatsData, error) {
+	stats, err := s.GetWebsiteRefererStatsBetween(websiteID, from, to, filters)
+	if err != nil {
+		return nil, err
+	}
+	compared, err := s.GetWebsiteRefererStatsBetween(websiteID, comparison.From, comparison.To, filters)
+	if err != nil {
+		return nil, err
+	}
+
+	comparedValues := make(map[string]int64, len(compared))
+	for _, item := range compared {
+		comparedValues[item.Name] = item.Value
+	}
+
+	for i := range stats {
+		value := comparedValues[stats[i].Name]
+		change := newChange(float64(stats[i].Value), float64(value))
+		stats[i].Compare, stats[i].Change = &value, &change
+	}
+
+	return stats, nil
+}
+
+// CompareWebsiteDeviceStats gets the device statistics of a website in a half-open range of days
+// and compares each device type to its page views in the compared range
+func (s *StatService) CompareWebsiteDeviceStats(websiteID int, from, to time.Time, filters []repository.StatFilter, comparison *Comparison) ([]DeviceStatsData, error) {
+	stats, err := s.GetWebsiteDeviceStatsBetween(websiteID, from, to, filters)
+	if err != nil {
+		return nil, err
+	}
+	compared, err := s.GetWebsiteDeviceStatsBetween(websiteID, comparison.From, comparison.To, filters)
+	if err != nil {
+		return nil, err
+	}
+
+	comparedValues := make(map[string]int64, len(compared))
+	for _, item := range compared {
+		comparedValues[item.Name] = item.Value
+	}
+
+	for i := range stats {
+		value := comparedValues[stats[i].Name]
+		change := newChange(float64(stats[i].Value), float64(value))
+		stats[i].Compare, stats[i].Change = &value, &change
+	}
+
+	return stats, nil
+}
+
+// systemCounts holds system-wide IP and PV counts
+type systemCounts struct {
+	IP int `json:"ip"`
+	PV int `json:"pv"`
+}
+
 // GetSystemTodayStats gets system-wide today's statistics
 func (s *StatService) GetSystemTodayStats(date string) (int, int, error) {
-	return s.statAnalyticsRepo.GetSystemTodayStats(date)
+	var counts systemCounts
+	err := s.statsCache.load(systemCacheID, cachePeriodLive, "system_today_stats:"+date, &counts, func() error {
+		var err error
+		counts.IP, counts.PV, err = s.statAnalyticsRepo.GetSystemTodayStats(date)
+		return err
+	})
+	return counts.IP, counts.PV, err
 }
 
 // GetSystemTotalStats gets system-wide total statistics
 func (s *StatService) GetSystemTotalStats() (int, int, error) {
-	return s.statAnalyticsRepo.GetSystemTotalStats()
+	var counts systemCounts
+	err := s.statsCache.load(systemCacheID, cachePeriodLive, "system_total_stats", &counts, func() error {
+		var err error
+		counts.IP, counts.PV, err = s.statAnalyticsRepo.GetSystemTotalStats()
+		return err
+	})
+	return counts.IP, counts.PV, err
 }
 
 // GetSystemTrendData gets system-wide trend data for the last N days
 func (s *StatService) GetSystemTrendData(days int) ([]repository.DailyStatsData, error) {
-	return s.statAnalyticsRepo.GetSystemTrendData(days)
+	var trend []repository.DailyStatsData
+	err := s.statsCache.load(systemCacheID, cachePeriodLive, "system_trend:"+time.Now().Format("2006-01-02")+":"+strconv.Itoa(days), &trend, func() error {
+		var err error
+		trend, err = s.statAnalyticsRepo.GetSystemTrendData(days)
+		return err
+	})
+	return trend, err
+}
+
+// GetWebsiteEngagementStats gets average scroll depth and read-through rate per page path
+func (s *StatService) GetWebsiteEngagementStats(websiteID int, from, to time.Time, limit int, filters []repository.StatFilter) ([]repository.EngagementStatsData, error) {
+	return s.statAnalyticsRepo.WithFilters(filters).GetEngagementStats(websiteID, from, to, limit)
+}
+
+// GetWebsiteTrendData gets the IP and PV counts per day of a website in a half-open range of days,
+// including imported historical data when there are no filters. The days are those of the website's time zone, in which
+// the range must be given. Days without data are filled with zeros.
+func (s *StatService) GetWebsiteTrendData(websiteID int, from, to time.Time, filters []repository.StatFilter) ([]repository.DailyStatsData, error) {
+	website, err := s.websiteRepo.FindByID(websiteID)
+	if err != nil {
+		return nil, err
+	}
+
+	from, to = from.In(website.Location()), to.In(website.Location())
+	daily, err := s.statAnalyticsRepo.WithFilters(filters).GetDailyStats(websiteID, from, to, website.Location())
+	if err != nil {
+		return nil, err
+	}
+
+	byDate := make(map[string]repository.DailyStatsData, len(daily))
+	for _, item := range daily {
+		byDate[item.Date] = item
+	}
+
+	var results []repository.DailyStatsData
+	for day := from; day.Before(to); day = day.AddDate(0, 0, 1) {
+		date := day.Format("2006-01-02")
+		item, ok := byDate[date]
+		if !ok {
+			item = repository.DailyStatsData{Date: date}
+		}
+		results = append(results, item)
+	}
+
+	return results, nil
+}
+
+// TrendPoint represents the IP and PV counts of a day of a trend, along with the counts of the
+// day at the same position in the compared range and their changes
+type TrendPoint struct {
+	repository.DailyStatsData
+	Compare *repository.DailyStatsData `json:"compare,omitempty"`
+	Change  map[string]Change          `json:"change,omitempty"`
+}
+
+// CompareWebsiteTrendData gets the trend of a website like GetWebsiteTrendData and compares
+// each day to the day at the same position in the compared range
+func (s *StatService) CompareWebsiteTrendData(websiteID int, from, to time.Time, filters []repository.StatFilter, comparison *Comparison) ([]TrendPoint, error) {
+	trend, err := s.GetWebsiteTrendData(websiteID, from, to, filters)
+	if err != nil {
+		return nil, err
+	}
+
+	compared, err := s.GetWebsiteTrendData(websiteID, comparison.From, comparison.To, filters)
+	if err != nil {
+		return nil, err
+	}
+
+	points := make([]TrendPoint, len(trend))
+	for i := range trend {
+		points[i].DailyStatsData = trend[i]
+		if i < len(compared) {
+			points[i].Compare = &compared[i]
+			points[i].Change = map[string]Change{
+				MetricIP: newChange(float64(trend[i].IP), float64(compared[i].IP)),
+				MetricPV: newChange(float64(trend[i].PV), float64(compared[i].PV)),
+			}
+		}
+	}
+
+	return points, nil
 }
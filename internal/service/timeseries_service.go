@@ -0,0 +1,252 @@
+package service
+
+import (
+	"errors"
+	"time"
+
+	"aq3stat/internal/model"
+	"aq3stat/internal/repository"
+)
+
+// Time series metrics
+const (
+	MetricIP         = "ip"
+	MetricPV         = "pv"
+	MetricVisits     = "visits"
+	MetricBounceRate = "bounce_rate"
+)
+
+// timeSeriesMaxDays is the longest range of days allowed per interval
+var timeSeriesMaxDays = map[string]int{
+	repository.IntervalHour:  31,
+	repository.IntervalDay:   731,
+	repository.IntervalWeek:  1830,
+	repository.IntervalMonth: 3660,
+}
+
+// TimeSeries represents the metrics of a website over a date range
+type TimeSeries struct {
+	Interval string              `json:"interval"`
+	Metrics  []string            `json:"metrics"`
+	Series   []TimeSeriesPoint   `json:"series"`
+	Segments []SegmentTimeSeries `json:"segments,omitempty"` // Compared segments
+	Compare  *Comparison         `json:"compare,omitempty"`  // Compared range
+}
+
+// SegmentTimeSeries represents the metrics of the visits of a segment over the same buckets
+type SegmentTimeSeries struct {
+	SegmentID int               `json:"segment_id"`
+	Name      string            `json:"name"`
+	Series    []TimeSeriesPoint `json:"series"`
+}
+
+// TimeSeriesPoint holds the time of a bucket and the value of each requested metric. When the
+// range is compared, it also holds the compared point under compare and the Change of each
+// metric under change.
+type TimeSeriesPoint map[string]interface{}
+
+// ValidateTimeSeries checks the range, interval and metrics of a time series request
+func ValidateTimeSeries(from, to time.Time, interval string, metrics []string) error {
+	maxDays, ok := timeSeriesMaxDays[interval]
+	if !ok {
+		return errors.New("interval must be hour, day, week or month")
+	}
+
+	if to.Sub(from) > time.Duration(maxDays)*24*time.Hour {
+		return errors.New("date range is too long for interval " + interval)
+	}
+
+	if len(metrics) == 0 {
+		return errors.New("at least one metric is required")
+	}
+	for _, metric := range metrics {
+		switch metric {
+		case MetricIP, MetricPV, MetricVisits, MetricBounceRate:
+		default:
+			return errors.New("unknown metric: " + metric)
+		}
+	}
+
+	return nil
+}
+
+// GetTimeSeries gets metrics of a website per hour, day, week or month in a half-open range.
+// Every bucket of the range is returned, buckets without traffic hold zeros. The first week or
+// month bucket starts before the range when the range doesn't start at a bucket boundary,
+// but only counts traffic within the range. Buckets are those of the website's time zone and
+// week start, the range must be given in its time zone. Only the visits matching the filters
+// are counted, and the visits of each segment are compared side by side. With a comparison,
+// each bucket is compared to the bucket at the same position in the compared range.
+func (s *StatService) GetTimeSeries(websiteID int, from, to time.Time, interval string, metrics []string, filters []repository.StatFilter, segments []model.Segment, comparison *Comparison) (*TimeSeries, error) {
+	if err := ValidateTimeSeries(from, to, interval, metrics); err != nil {
+		return nil, err
+	}
+	if err := ValidateComparedSegments(segments); err != nil {
+		return nil, err
+	}
+
+	website, err := s.websiteRepo.FindByID(websiteID)
+	if err != nil {
+		return nil, err
+	}
+
+	from, to = from.In(website.Location()), to.In(website.Location())
+	series := &TimeSeries{
+		Interval: interval,
+		Metrics:  metrics,
+	}
+
+	series.Series, err = s.getTimeSeriesPoints(website, from, to, interval, metrics, filters)
+	if err != nil {
+		return nil, err
+	}
+
+	if comparison != nil {
+		compared, err := s.getTimeSeriesPoints(website, comparison.From.In(website.Location()), comparison.To.In(website.Location()), interval, metrics, filters)
+		if err != nil {
+			return nil, err
+		}
+		compareTimeSeries(series.Series, compared, metrics)
+		series.Compare = comparison
+	}
+
+	for i := range segments {
+		segmentFilters, err := SegmentFilters(&segments[i])
+		if err != nil {
+			return nil, err
+		}
+
+		points, err := s.getTimeSeriesPoints(website, from, to, interval, metrics, append(append([]repository.StatFilter{}, filters...), segmentFilters...))
+		if err != nil {
+			return nil, err
+		}
+
+		series.Segments = append(series.Segments, SegmentTimeSeries{
+			SegmentID: segments[i].ID,
+			Name:      segments[i].Name,
+			Series:    points,
+		})
+	}
+
+	return series, nil
+}
+
+// getTimeSeriesPoints gets the metrics of a website for every bucket of a range given in its
+// time zone, counting the visits matching the filters
+func (s *StatService) getTimeSeriesPoints(website *model.Website, from, to time.Time, interval string, metrics []string, filters []repository.StatFilter) ([]TimeSeriesPoint, error) {
+	data, err := s.statAnalyticsRepo.WithFilters(filters).GetTimeSeries(website.ID, from, to, interval, website.Location(), website.WeekStart())
+	if err != nil {
+		return nil, err
+	}
+
+	byBucket := make(map[string]repository.TimeSeriesData, len(data))
+	for _, item := range data {
+		byBucket[item.Bucket] = item
+	}
+
+	points := []TimeSeriesPoint{}
+	for _, label := range bucketLabels(from, to, interval, website.WeekStart()) {
+		item := byBucket[label]
+
+		point := TimeSeriesPoint{"time": label}
+		for _, metric := range metrics {
+			switch metric {
+			case MetricIP:
+				point[metric] = item.IP
+			case MetricPV:
+				point[metric] = item.PV
+			case MetricVisits:
+				point[metric] = item.Visits
+			case MetricBounceRate:
+				bounceRate := 0.0
+				if item.TrackedVisits > 0 {
+					bounceRate = float64(item.Bounces) / float64(item.TrackedVisits)
+				}
+				point[metric] = bounceRate
+			}
+		}
+		points = append(points, point)
+	}
+
+	return points, nil
+}
+
+// compareTimeSeries adds the compared point and the change of each metric to the points, by
+// position. Points past the end of the compared series are left as they are.
+func compareTimeSeries(points, compared []TimeSeriesPoint, metrics []string) {
+	for i, point := range points {
+		if i >= len(compared) {
+			break
+		}
+
+		change := make(map[string]Change, len(metrics))
+		for _, metric := range metrics {
+			change[metric] = newChange(metricValue(point[metric]), metricValue(compared[i][metric]))
+		}
+		point["compare"] = compared[i]
+		point["change"] = change
+	}
+}
+
+// metricValue returns the value of a metric of a time series point as a float
+func metricValue(value interface{}) float64 {
+	switch v := value.(type) {
+	case int64:
+		return float64(v)
+	case float64:
+		return v
+	}
+	return 0
+}
+
+// bucketLabels returns the labels of the buckets of a range, in the time zone of the range.
+// Hours are counted by wall-clock time, so the hour repeated when daylight saving time ends is a
+// single bucket holding the visits of both.
+func bucketLabels(from, to time.Time, interval string, weekStart time.Weekday) []string {
+	var labels []string
+	for bucket := bucketStart(from, interval, weekStart); bucket.Before(to); bucket = nextBucket(bucket, interval) {
+		label := bucketLabel(bucket, interval)
+		if len(labels) > 0 && labels[len(labels)-1] == label {
+			continue
+		}
+		labels = append(labels, label)
+	}
+	return labels
+}
+
+// bucketStart returns the start of the bucket containing t, in the time zone of t
+func bucketStart(t time.Time, interval string, weekStart time.Weekday) time.Time {
+	switch interval {
+	case repository.IntervalHour:
+		return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), 0, 0, 0, t.Location())
+	case repository.IntervalWeek:
+		day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+		return day.AddDate(0, 0, -((int(t.Weekday()) - int(weekStart) + 7) % 7))
+	case repository.IntervalMonth:
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, t.Location())
+	default:
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	}
+}
+
+// nextBucket returns the start of the bucket following the one starting at t
+func nextBucket(t time.Time, interval string) time.Time {
+	switch interval {
+	case repository.IntervalHour:
+		return t.Add(time.Hour)
+	case repository.IntervalWeek:
+		return t.AddDate(0, 0, 7)
+	case repository.IntervalMonth:
+		return t.AddDate(0, 1, 0)
+	default:
+		return t.AddDate(0, 0, 1)
+	}
+}
+
+// bucketLabel formats the start of a bucket like the time series query does
+func bucketLabel(t time.Time, interval string) string {
+	if interval == repository.IntervalHour {
+		return t.Format("2006-01-02 15:00:00")
+	}
+	return t.Format("2006-01-02")
+}
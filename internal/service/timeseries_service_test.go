@@ -0,0 +1,69 @@
+package service
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"aq3stat/internal/repository"
+)
+
+func TestBucketLabels(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skipf("time zone data not available: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		from     time.Time
+		to       time.Time
+		interval string
+		want     []string
+	}{
+		{
+			name:     "hours",
+			from:     time.Date(2024, 3, 1, 0, 0, 0, 0, newYork),
+			to:       time.Date(2024, 3, 1, 3, 0, 0, 0, newYork),
+			interval: repository.IntervalHour,
+			want:     []string{"2024-03-01 00:00:00", "2024-03-01 01:00:00", "2024-03-01 02:00:00"},
+		},
+		{
+			name:     "repeated hour at the end of daylight saving time",
+			from:     time.Date(2024, 11, 3, 0, 0, 0, 0, newYork),
+			to:       time.Date(2024, 11, 3, 3, 0, 0, 0, newYork),
+			interval: repository.IntervalHour,
+			want:     []string{"2024-11-03 00:00:00", "2024-11-03 01:00:00", "2024-11-03 02:00:00"},
+		},
+		{
+			name:     "skipped hour at the start of daylight saving time",
+			from:     time.Date(2024, 3, 10, 0, 0, 0, 0, newYork),
+			to:       time.Date(2024, 3, 10, 4, 0, 0, 0, newYork),
+			interval: repository.IntervalHour,
+			want:     []string{"2024-03-10 00:00:00", "2024-03-10 01:00:00", "2024-03-10 03:00:00"},
+		},
+		{
+			name:     "days across the end of daylight saving time",
+			from:     time.Date(2024, 11, 2, 0, 0, 0, 0, newYork),
+			to:       time.Date(2024, 11, 5, 0, 0, 0, 0, newYork),
+			interval: repository.IntervalDay,
+			want:     []string{"2024-11-02", "2024-11-03", "2024-11-04"},
+		},
+		{
+			name:     "weeks starting on Monday",
+			from:     time.Date(2024, 1, 3, 0, 0, 0, 0, newYork),
+			to:       time.Date(2024, 1, 16, 0, 0, 0, 0, newYork),
+			interval: repository.IntervalWeek,
+			want:     []string{"2024-01-01", "2024-01-08", "2024-01-15"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := bucketLabels(tt.from, tt.to, tt.interval, time.Monday)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("bucketLabels() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -2,7 +2,12 @@ package service
 
 import (
 	"errors"
+	"html"
+	"net/url"
+	"os"
+	"strconv"
 	"strings"
+	"time"
 
 	"aq3stat/internal/model"
 	"aq3stat/internal/repository"
@@ -11,12 +16,14 @@ import (
 // WebsiteService handles website related business logic
 type WebsiteService struct {
 	websiteRepo *repository.WebsiteRepository
+	rollupRepo  *repository.RollupRepository
 }
 
 // NewWebsiteService creates a new website service
 func NewWebsiteService() *WebsiteService {
 	return &WebsiteService{
 		websiteRepo: repository.NewWebsiteRepository(),
+		rollupRepo:  repository.NewRollupRepository(),
 	}
 }
 
@@ -37,6 +44,16 @@ func (s *WebsiteService) CreateWebsite(website *model.Website) error {
 		return errors.New("website description must be less than 255 characters")
 	}
 
+	// Validate time zone and week start
+	if website.TimeZone != "" {
+		if _, err := time.LoadLocation(website.TimeZone); err != nil {
+			return errors.New("unknown time zone " + website.TimeZone)
+		}
+	}
+	if website.FirstDayOfWeek < 0 || website.FirstDayOfWeek > 7 {
+		return errors.New("first day of week must be between 1 (Monday) and 7 (Sunday)")
+	}
+
 	return s.websiteRepo.Create(website)
 }
 
@@ -62,7 +79,59 @@ func (s *WebsiteService) UpdateWebsite(website *model.Website) error {
 		return errors.New("website description must be less than 255 characters")
 	}
 
-	return s.websiteRepo.Update(website)
+	// Validate time zone and week start
+	if website.TimeZone != "" {
+		if _, err := time.LoadLocation(website.TimeZone); err != nil {
+			return errors.New("unknown time zone " + website.TimeZone)
+		}
+	}
+	if website.FirstDayOfWeek < 0 || website.FirstDayOfWeek > 7 {
+		return errors.New("first day of week must be between 1 (Monday) and 7 (Sunday)")
+	}
+
+	existing, err := s.websiteRepo.FindByID(website.ID)
+	if err != nil {
+		return err
+	}
+	zoneChanged := existing.Location().String() != website.Location().String()
+	weekChanged := existing.WeekStart() != website.WeekStart()
+
+	// Rollups and visitor sketches are kept per day of the time zone, purged days can't be
+	// counted again in another one
+	state, err := s.rollupRepo.GetState(website.ID)
+	if err != nil {
+		return err
+	}
+	if zoneChanged && state.PurgedUntil != nil {
+		return errors.New("time zone can't be changed once raw hits have been purged")
+	}
+
+	if err := s.websiteRepo.Update(website); err != nil {
+		return err
+	}
+
+	// Day boundaries move with the time zone, so the closed days are rolled up again. Rollups are
+	// kept per hour and day, weeks are summed up from the days when queried.
+	if zoneChanged {
+		first, err := s.rollupRepo.GetFirstStatTime(website.ID)
+		if err != nil {
+			return err
+		}
+		if first != nil {
+			// Starting a couple of days early also replaces the rollups of the first days of the
+			// old time zone, whose dates may come before the first day of the new one
+			from := website.StartOfDay(*first).AddDate(0, 0, -2)
+			if err := s.rollupRepo.Invalidate(website.ID, from); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Cached stats of closed weeks start on the old first day of the week
+	if zoneChanged || weekChanged {
+		invalidateClosedStatsCache(website.ID)
+	}
+	return nil
 }
 
 // DeleteWebsite deletes a website
@@ -85,9 +154,19 @@ func (s *WebsiteService) UpdateClickInTime(id int) error {
 	return s.websiteRepo.UpdateClickInTime(id)
 }
 
-// GenerateTrackingCode generates the JavaScript tracking code for a website
+// getBaseURL returns the public base URL of the aq3stat server
+func getBaseURL() string {
+	baseURL := os.Getenv("BASE_URL")
+	if baseURL == "" {
+		baseURL = "http://localhost:8080"
+	}
+	return strings.TrimRight(baseURL, "/")
+}
+
+// GenerateTrackingCode generates the JavaScript tracking code for a website,
+// with an image fallback for visitors without JavaScript
 func (s *WebsiteService) GenerateTrackingCode(website *model.Website, iconType string) string {
-	baseURL := "http://localhost:8080" // Replace with actual base URL from config
+	baseURL := getBaseURL()
 
 	var code strings.Builder
 
@@ -99,9 +178,9 @@ func (s *WebsiteService) GenerateTrackingCode(website *model.Website, iconType s
 	code.WriteString("  hs.async = true;\n")
 
 	// Generate URL for the counter script
-	counterURL := baseURL + "/counter.js?id=" + string(website.ID)
+	counterURL := baseURL + "/counter.js?id=" + strconv.Itoa(website.ID)
 	if iconType != "" {
-		counterURL += "&icon=" + iconType
+		counterURL += "&icon=" + url.QueryEscape(iconType)
 	}
 
 	code.WriteString("  hs.src = '" + counterURL + "';\n")
@@ -110,9 +189,79 @@ func (s *WebsiteService) GenerateTrackingCode(website *model.Website, iconType s
 	code.WriteString("})();\n")
 	code.WriteString("</script>\n")
 
+	// Count visitors with JavaScript disabled
+	pixelURL := baseURL + "/pixel.gif?id=" + strconv.Itoa(website.ID)
+	code.WriteString("<noscript><img src=\"" + html.EscapeString(pixelURL) + "\" width=\"1\" height=\"1\" alt=\"\" style=\"border:0\" /></noscript>\n")
+
 	return code.String()
 }
 
+// GenerateEmailTrackingCode generates the image tag tracking the opens of an email newsletter
+func (s *WebsiteService) GenerateEmailTrackingCode(website *model.Website, campaign string) string {
+	pixelURL := getBaseURL() + "/pixel.gif?id=" + strconv.Itoa(website.ID) + "&t=email"
+	if campaign != "" {
+		pixelURL += "&c=" + url.QueryEscape(campaign)
+	}
+
+	return "<img src=\"" + html.EscapeString(pixelURL) + "\" width=\"1\" height=\"1\" alt=\"\" style=\"display:block;border:0\" />\n"
+}
+
+// GenerateAMPTrackingCode generates the amp-analytics snippet for AMP pages of a website
+func (s *WebsiteService) GenerateAMPTrackingCode(website *model.Website) string {
+	configURL := getBaseURL() + "/amp/config.json?id=" + strconv.Itoa(website.ID)
+
+	var code strings.Builder
+
+	code.WriteString("<!-- In <head> -->\n")
+	code.WriteString("<script async custom-element=\"amp-analytics\" src=\"https://cdn.ampproject.org/v0/amp-analytics-0.1.js\"></script>\n")
+	code.WriteString("<!-- In <body> -->\n")
+	code.WriteString("<amp-analytics config=\"" + html.EscapeString(configURL) + "\" data-credentials=\"include\"></amp-analytics>\n")
+
+	return code.String()
+}
+
+// GenerateAMPConfig generates the amp-analytics configuration of a website.
+// Page views are sent when the page becomes visible and scroll depth at 25/50/75/100%.
+func (s *WebsiteService) GenerateAMPConfig(website *model.Website) map[string]interface{} {
+	baseURL := getBaseURL()
+	id := strconv.Itoa(website.ID)
+
+	return map[string]interface{}{
+		"requests": map[string]string{
+			"pageview": baseURL + "/amp/collect?id=" + id +
+				"&pvid=${pageViewId64}" +
+				"&cid=${clientId(aq3stat)}" +
+				"&url=${canonicalUrl}" +
+				"&referer=${documentReferrer}" +
+				"&vw=${viewportWidth}&vh=${viewportHeight}" +
+				"&sw=${screenWidth}&sh=${screenHeight}" +
+				"&color=${screenColorDepth}" +
+				"&lang=${browserLanguage}",
+			"scroll": baseURL + "/collect/ping?id=" + id +
+				"&pvid=${pageViewId64}" +
+				"&depth=${verticalScrollBoundary}",
+		},
+		"triggers": map[string]interface{}{
+			"trackPageview": map[string]interface{}{
+				"on":      "visible",
+				"request": "pageview",
+			},
+			"trackScroll": map[string]interface{}{
+				"on":      "scroll",
+				"request": "scroll",
+				"scrollSpec": map[string]interface{}{
+					"verticalBoundaries": []int{25, 50, 75, 100},
+				},
+			},
+		},
+		"transport": map[string]bool{
+			"beacon":  true,
+			"xhrpost": false,
+			"image":   true,
+		},
+	}
+}
+
 // GetWebsiteCount gets total website count
 func (s *WebsiteService) GetWebsiteCount() (int, error) {
 	return s.websiteRepo.GetCount()
@@ -5,16 +5,40 @@ import (
 
 	"aq3stat/internal/model"
 	"aq3stat/pkg/database"
+	"gorm.io/gorm"
 )
 
 // Migrate runs all database migrations
 func Migrate() {
+	// Rollups made before visitor sketches existed need to be rebuilt with them
+	hadVisitorSketches := database.DB.Migrator().HasTable(&model.VisitorSketch{})
+
 	// Auto migrate all models
 	err := database.DB.AutoMigrate(
 		&model.Group{},     // Migrate groups first
 		&model.User{},      // Then users
 		&model.Website{},
 		&model.Stat{},
+		&model.PageView{},
+		&model.Event{},
+		&model.Goal{},
+		&model.Conversion{},
+		&model.Funnel{},
+		&model.FunnelStep{},
+		&model.Segment{},
+		&model.PageRule{},
+		&model.Purchase{},
+		&model.PurchaseItem{},
+		&model.ImportJob{},
+		&model.HistoricalStat{},
+		&model.ExportJob{},
+		&model.StatRollup{},
+		&model.RollupState{},
+		&model.VisitorSketch{},
+		&model.Anomaly{},
+		&model.AnomalyChannel{},
+		&model.AnomalyState{},
+		&model.RetentionPolicy{},
 		&model.IPData{},
 		&model.Email{},
 		&model.EmailConfig{},
@@ -28,6 +52,10 @@ func Migrate() {
 	// Add foreign key constraints manually
 	addForeignKeyConstraints()
 
+	if !hadVisitorSketches {
+		rebuildRollups()
+	}
+
 	log.Println("Database migration completed")
 
 	// Seed initial data
@@ -63,6 +91,23 @@ func addForeignKeyConstraints() {
 	log.Println("Foreign key constraints added")
 }
 
+// rebuildRollups marks all days as not rolled up, so that the rollup worker rolls them up
+// again. Purged days stay rolled up, their raw stats are gone.
+func rebuildRollups() {
+	err := database.DB.Where("purged_until IS NULL").Delete(&model.RollupState{}).Error
+	if err == nil {
+		err = database.DB.Model(&model.RollupState{}).
+			Where("purged_until IS NOT NULL").
+			Update("rolled_until", gorm.Expr("purged_until")).Error
+	}
+	if err != nil {
+		log.Printf("Warning: Failed to reset rollups for visitor sketches: %v", err)
+		return
+	}
+
+	log.Println("Rollups reset, days are rolled up again with visitor sketches in the background")
+}
+
 // SeedData seeds initial data into the database
 func SeedData() {
 	// Seed default user groups
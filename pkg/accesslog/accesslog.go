@@ -0,0 +1,223 @@
+// Package accesslog parses web server access logs in nginx log_format syntax.
+package accesslog
+
+import (
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Predefined log formats. Apache's combined and common formats produce the same lines as nginx's.
+var Formats = map[string]string{
+	"combined": `$remote_addr - $remote_user [$time_local] "$request" $status $body_bytes_sent "$http_referer" "$http_user_agent"`,
+	"common":   `$remote_addr - $remote_user [$time_local] "$request" $status $body_bytes_sent`,
+	"vhost":    `$host $remote_addr - $remote_user [$time_local] "$request" $status $body_bytes_sent "$http_referer" "$http_user_agent"`,
+}
+
+// Entry represents a parsed access log line
+type Entry struct {
+	RemoteAddr string
+	Host       string
+	Time       time.Time
+	Method     string
+	URI        string
+	Protocol   string
+	Status     int
+	BodyBytes  int64
+	Referer    string
+	UserAgent  string
+	Language   string
+}
+
+// Parser parses log lines of one format
+type Parser struct {
+	pattern *regexp.Regexp
+	fields  []string
+}
+
+var variablePattern = regexp.MustCompile(`\$[a-z_0-9]+`)
+
+// NewParser creates a parser for a predefined format name or a custom nginx log_format string
+func NewParser(format string) (*Parser, error) {
+	if predefined, ok := Formats[format]; ok {
+		format = predefined
+	}
+
+	var pattern strings.Builder
+	var fields []string
+	pattern.WriteString("^")
+
+	last := 0
+	for _, loc := range variablePattern.FindAllStringIndex(format, -1) {
+		literal := format[last:loc[0]]
+		pattern.WriteString(regexp.QuoteMeta(literal))
+
+		name := format[loc[0]+1 : loc[1]]
+		fields = append(fields, name)
+
+		// Variables are delimited by the character following them
+		switch {
+		case name == "time_local":
+			pattern.WriteString(`([^\]]+)`)
+		case strings.HasSuffix(literal, `"`):
+			pattern.WriteString(`((?:[^"\\]|\\.)*)`)
+		default:
+			pattern.WriteString(`(\S*)`)
+		}
+		last = loc[1]
+	}
+	pattern.WriteString(regexp.QuoteMeta(format[last:]))
+
+	if len(fields) == 0 {
+		return nil, errors.New("log format contains no variables")
+	}
+
+	compiled, err := regexp.Compile(pattern.String())
+	if err != nil {
+		return nil, err
+	}
+
+	return &Parser{pattern: compiled, fields: fields}, nil
+}
+
+// Parse parses a single log line
+func (p *Parser) Parse(line string) (*Entry, error) {
+	matches := p.pattern.FindStringSubmatch(line)
+	if matches == nil {
+		return nil, errors.New("line does not match the log format")
+	}
+
+	entry := &Entry{}
+	for i, name := range p.fields {
+		value := unescape(matches[i+1])
+		if value == "-" {
+			value = ""
+		}
+
+		switch name {
+		case "remote_addr", "http_x_forwarded_for", "http_x_real_ip":
+			// A forwarded address wins over the proxy address, the first one is the client
+			if value != "" && (entry.RemoteAddr == "" || name != "remote_addr") {
+				entry.RemoteAddr = strings.TrimSpace(strings.Split(value, ",")[0])
+			}
+		case "host", "server_name":
+			entry.Host = value
+		case "time_local":
+			t, err := time.Parse("02/Jan/2006:15:04:05 -0700", value)
+			if err != nil {
+				return nil, err
+			}
+			entry.Time = t
+		case "time_iso8601":
+			t, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				return nil, err
+			}
+			entry.Time = t
+		case "request":
+			parts := strings.Split(value, " ")
+			if len(parts) >= 2 {
+				entry.Method = parts[0]
+				entry.URI = parts[1]
+			}
+			if len(parts) >= 3 {
+				entry.Protocol = parts[2]
+			}
+		case "request_method":
+			entry.Method = value
+		case "request_uri":
+			entry.URI = value
+		case "status":
+			entry.Status, _ = strconv.Atoi(value)
+		case "body_bytes_sent", "bytes_sent":
+			entry.BodyBytes, _ = strconv.ParseInt(value, 10, 64)
+		case "http_referer":
+			entry.Referer = value
+		case "http_user_agent":
+			entry.UserAgent = value
+		case "http_accept_language":
+			entry.Language = value
+		}
+	}
+
+	if entry.Time.IsZero() {
+		return nil, errors.New("line has no time")
+	}
+
+	return entry, nil
+}
+
+// unescape decodes the \xHH and \" escapes nginx writes into quoted variables
+func unescape(value string) string {
+	if !strings.Contains(value, `\`) {
+		return value
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		if value[i] == '\\' && i+3 < len(value) && value[i+1] == 'x' {
+			if c, err := strconv.ParseUint(value[i+2:i+4], 16, 8); err == nil {
+				b.WriteByte(byte(c))
+				i += 3
+				continue
+			}
+		}
+		if value[i] == '\\' && i+1 < len(value) {
+			i++
+		}
+		b.WriteByte(value[i])
+	}
+	return b.String()
+}
+
+// staticExtensions are the file extensions of assets that are not page views
+var staticExtensions = map[string]bool{
+	".css": true, ".js": true, ".map": true, ".json": true, ".xml": true, ".txt": true,
+	".png": true, ".jpg": true, ".jpeg": true, ".gif": true, ".svg": true, ".ico": true, ".webp": true, ".bmp": true,
+	".woff": true, ".woff2": true, ".ttf": true, ".eot": true, ".otf": true,
+	".mp3": true, ".mp4": true, ".webm": true, ".avi": true, ".flv": true,
+	".zip": true, ".gz": true, ".rar": true, ".7z": true, ".pdf": true, ".exe": true, ".apk": true,
+}
+
+// IsStaticAsset checks if a request URI points to a static asset
+func IsStaticAsset(uri string) bool {
+	path := uri
+	if index := strings.IndexAny(path, "?#"); index >= 0 {
+		path = path[:index]
+	}
+
+	dot := strings.LastIndex(path, ".")
+	if dot < 0 || dot < strings.LastIndex(path, "/") {
+		return false
+	}
+	return staticExtensions[strings.ToLower(path[dot:])]
+}
+
+// botMarkers are user agent fragments of crawlers, monitors and scripts
+var botMarkers = []string{
+	"bot", "spider", "crawl", "slurp", "fetcher", "scanner", "monitor", "preview",
+	"curl", "wget", "python", "java/", "go-http-client", "okhttp", "httpclient", "headless", "phantomjs",
+}
+
+// IsBot checks if a user agent belongs to a bot. Empty user agents are considered bots.
+func IsBot(userAgent string) bool {
+	if userAgent == "" {
+		return true
+	}
+
+	userAgent = strings.ToLower(userAgent)
+	for _, marker := range botMarkers {
+		if strings.Contains(userAgent, marker) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsPageView checks if an entry is a successful page request by a human visitor
+func (e *Entry) IsPageView() bool {
+	return e.Method == "GET" && e.Status >= 200 && e.Status < 300 &&
+		!IsStaticAsset(e.URI) && !IsBot(e.UserAgent)
+}
@@ -0,0 +1,139 @@
+package badge
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"strconv"
+	"unicode/utf8"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+)
+
+// Style defines the colors of a badge
+type Style struct {
+	LabelColor string // Background of the label part, e.g. #555555
+	ValueColor string // Background of the value part
+	TextColor  string
+}
+
+// Themes contains the predefined badge styles
+var Themes = map[string]Style{
+	"flat":  {LabelColor: "#555555", ValueColor: "#4c9be8", TextColor: "#ffffff"},
+	"green": {LabelColor: "#555555", ValueColor: "#4c1", TextColor: "#ffffff"},
+	"dark":  {LabelColor: "#222222", ValueColor: "#444444", TextColor: "#ffffff"},
+	"light": {LabelColor: "#eeeeee", ValueColor: "#ffffff", TextColor: "#333333"},
+}
+
+const (
+	height  = 20
+	padding = 6
+)
+
+// textWidth estimates the rendered width of a text in the 11px SVG font.
+// Wide (CJK) characters count double.
+func textWidth(text string) int {
+	width := 0
+	for _, r := range text {
+		if r > 0x2e80 {
+			width += 12
+		} else {
+			width += 7
+		}
+	}
+	return width
+}
+
+// SVG renders a badge as an SVG image
+func SVG(label, value string, style Style) []byte {
+	labelWidth := textWidth(label) + 2*padding
+	valueWidth := textWidth(value) + 2*padding
+	width := labelWidth + valueWidth
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" role="img" aria-label="%s: %s">`,
+		width, height, html.EscapeString(label), html.EscapeString(value))
+	fmt.Fprintf(&buf, `<title>%s: %s</title>`, html.EscapeString(label), html.EscapeString(value))
+	fmt.Fprintf(&buf, `<rect width="%d" height="%d" rx="3" fill="%s"/>`, width, height, html.EscapeString(style.ValueColor))
+	fmt.Fprintf(&buf, `<rect width="%d" height="%d" rx="3" fill="%s"/>`, labelWidth, height, html.EscapeString(style.LabelColor))
+	fmt.Fprintf(&buf, `<g fill="%s" font-family="Verdana,Geneva,DejaVu Sans,sans-serif" font-size="11" text-anchor="middle">`, html.EscapeString(style.TextColor))
+	fmt.Fprintf(&buf, `<text x="%d" y="14">%s</text>`, labelWidth/2, html.EscapeString(label))
+	fmt.Fprintf(&buf, `<text x="%d" y="14">%s</text>`, labelWidth+valueWidth/2, html.EscapeString(value))
+	buf.WriteString(`</g></svg>`)
+
+	return buf.Bytes()
+}
+
+// PNG renders a badge as a PNG image. The built-in bitmap font only covers ASCII,
+// other characters are drawn as '?'.
+func PNG(label, value string, style Style) ([]byte, error) {
+	face := basicfont.Face7x13
+	label = asciiOnly(label)
+	value = asciiOnly(value)
+
+	labelWidth := utf8.RuneCountInString(label)*face.Advance + 2*padding
+	valueWidth := utf8.RuneCountInString(value)*face.Advance + 2*padding
+
+	img := image.NewRGBA(image.Rect(0, 0, labelWidth+valueWidth, height))
+	draw.Draw(img, image.Rect(0, 0, labelWidth, height), image.NewUniform(parseColor(style.LabelColor)), image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(labelWidth, 0, labelWidth+valueWidth, height), image.NewUniform(parseColor(style.ValueColor)), image.Point{}, draw.Src)
+
+	drawer := &font.Drawer{
+		Dst:  img,
+		Src:  image.NewUniform(parseColor(style.TextColor)),
+		Face: face,
+	}
+	drawer.Dot = fixed.P(padding, 14)
+	drawer.DrawString(label)
+	drawer.Dot = fixed.P(labelWidth+padding, 14)
+	drawer.DrawString(value)
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// asciiOnly replaces the characters the bitmap font can't draw
+func asciiOnly(text string) string {
+	runes := []rune(text)
+	for i, r := range runes {
+		if r < 0x20 || r > 0x7e {
+			runes[i] = '?'
+		}
+	}
+	return string(runes)
+}
+
+// parseColor parses a #rgb or #rrggbb color, falling back to gray
+func parseColor(value string) color.RGBA {
+	if len(value) > 0 && value[0] == '#' {
+		value = value[1:]
+	}
+	if len(value) == 3 {
+		value = string([]byte{value[0], value[0], value[1], value[1], value[2], value[2]})
+	}
+
+	rgb, err := strconv.ParseUint(value, 16, 32)
+	if len(value) != 6 || err != nil {
+		return color.RGBA{0x55, 0x55, 0x55, 0xff}
+	}
+
+	return color.RGBA{uint8(rgb >> 16), uint8(rgb >> 8), uint8(rgb), 0xff}
+}
+
+// IsValidColor checks that a color is a #rgb or #rrggbb hex color
+func IsValidColor(value string) bool {
+	if len(value) != 4 && len(value) != 7 || value[0] != '#' {
+		return false
+	}
+	_, err := strconv.ParseUint(value[1:], 16, 32)
+	return err == nil
+}
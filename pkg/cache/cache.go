@@ -0,0 +1,94 @@
+// Package cache caches query results in process or in Redis.
+//
+// The backend is configured with the CACHE environment variable: lru (the default) keeps up to
+// CACHE_SIZE entries in process, redis shares them between instances through the server given
+// by the REDIS_* variables, and none disables caching. Failing backends are logged and treated
+// as misses, so the database remains the source of truth.
+package cache
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Cache stores values by key
+type Cache interface {
+	// Get returns the value of a key, false when it is missing or expired
+	Get(key string) ([]byte, bool, error)
+	// Set stores the value of a key for ttl, without expiry when ttl is zero
+	Set(key string, value []byte, ttl time.Duration) error
+	// Delete removes keys
+	Delete(keys ...string) error
+}
+
+// noCache caches nothing
+type noCache struct{}
+
+func (noCache) Get(key string) ([]byte, bool, error)                  { return nil, false, nil }
+func (noCache) Set(key string, value []byte, ttl time.Duration) error { return nil }
+func (noCache) Delete(keys ...string) error                           { return nil }
+
+var (
+	defaultCache Cache = noCache{}
+	mu           sync.RWMutex
+)
+
+// Init creates the cache backend selected by the CACHE environment variable
+func Init() {
+	mu.Lock()
+	defer mu.Unlock()
+
+	switch backend := getEnv("CACHE", "lru"); backend {
+	case "lru":
+		defaultCache = NewLRUCache(getEnvInt("CACHE_SIZE", 10000))
+		log.Printf("Caching stats in process")
+	case "redis":
+		defaultCache = NewRedisCache(RedisOptions{
+			Addr:     getEnv("REDIS_HOST", "localhost") + ":" + getEnv("REDIS_PORT", "6379"),
+			Password: os.Getenv("REDIS_PASSWORD"),
+			DB:       getEnvInt("REDIS_DB", 0),
+			PoolSize: getEnvInt("REDIS_POOL_SIZE", 10),
+			Timeout:  getEnvDuration("REDIS_TIMEOUT", time.Second),
+		})
+		log.Printf("Caching stats in Redis")
+	case "none":
+		defaultCache = noCache{}
+	default:
+		log.Printf("Unknown cache %q ignored, caching disabled", backend)
+		defaultCache = noCache{}
+	}
+}
+
+// Default returns the cache created by Init, a cache storing nothing before
+func Default() Cache {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	return defaultCache
+}
+
+func getEnv(key, defaultValue string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return defaultValue
+}
+
+func getEnvInt(key string, defaultValue int) int {
+	value, err := strconv.Atoi(os.Getenv(key))
+	if err != nil {
+		return defaultValue
+	}
+	return value
+}
+
+func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
+	value, err := time.ParseDuration(os.Getenv(key))
+	if err != nil {
+		return defaultValue
+	}
+	return value
+}
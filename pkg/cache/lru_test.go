@@ -0,0 +1,117 @@
+package cache
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLRUCacheEviction(t *testing.T) {
+	tests := []struct {
+		name    string
+		size    int
+		actions func(c *LRUCache)
+		present []string
+		missing []string
+	}{
+		{
+			name: "oldest entry evicted",
+			size: 2,
+			actions: func(c *LRUCache) {
+				c.Set("a", []byte("1"), 0)
+				c.Set("b", []byte("2"), 0)
+				c.Set("c", []byte("3"), 0)
+			},
+			present: []string{"b", "c"},
+			missing: []string{"a"},
+		},
+		{
+			name: "read entry kept",
+			size: 2,
+			actions: func(c *LRUCache) {
+				c.Set("a", []byte("1"), 0)
+				c.Set("b", []byte("2"), 0)
+				c.Get("a")
+				c.Set("c", []byte("3"), 0)
+			},
+			present: []string{"a", "c"},
+			missing: []string{"b"},
+		},
+		{
+			name: "overwritten entry kept",
+			size: 2,
+			actions: func(c *LRUCache) {
+				c.Set("a", []byte("1"), 0)
+				c.Set("b", []byte("2"), 0)
+				c.Set("a", []byte("4"), 0)
+				c.Set("c", []byte("3"), 0)
+			},
+			present: []string{"a", "c"},
+			missing: []string{"b"},
+		},
+		{
+			name: "deleted entries",
+			size: 3,
+			actions: func(c *LRUCache) {
+				c.Set("a", []byte("1"), 0)
+				c.Set("b", []byte("2"), 0)
+				c.Set("c", []byte("3"), 0)
+				c.Delete("a", "c", "missing")
+			},
+			present: []string{"b"},
+			missing: []string{"a", "c"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewLRUCache(tt.size)
+			tt.actions(c)
+
+			for _, key := range tt.present {
+				if _, ok, _ := c.Get(key); !ok {
+					t.Errorf("key %q missing", key)
+				}
+			}
+			for _, key := range tt.missing {
+				if _, ok, _ := c.Get(key); ok {
+					t.Errorf("key %q present", key)
+				}
+			}
+			if c.order.Len() != len(c.entries) || c.order.Len() > tt.size {
+				t.Errorf("list has %d entries, index %d, size %d", c.order.Len(), len(c.entries), tt.size)
+			}
+		})
+	}
+}
+
+func TestLRUCacheTTL(t *testing.T) {
+	tests := []struct {
+		name   string
+		ttl    time.Duration
+		wait   time.Duration
+		wantOK bool
+	}{
+		{"no expiry", 0, 20 * time.Millisecond, true},
+		{"not expired", time.Hour, 20 * time.Millisecond, true},
+		{"expired", 10 * time.Millisecond, 20 * time.Millisecond, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewLRUCache(10)
+			c.Set("key", []byte("value"), tt.ttl)
+			time.Sleep(tt.wait)
+
+			value, ok, err := c.Get("key")
+			if err != nil || ok != tt.wantOK {
+				t.Fatalf("Get() = %q, %v, %v, want ok %v", value, ok, err, tt.wantOK)
+			}
+			if ok && string(value) != "value" {
+				t.Errorf("Get() = %q, want %q", value, "value")
+			}
+			if !ok && len(c.entries) != 0 {
+				t.Error("expired entry not removed")
+			}
+		})
+	}
+}
@@ -0,0 +1,218 @@
+package cache
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+)
+
+// RedisOptions configures the connection to a Redis server
+type RedisOptions struct {
+	Addr     string
+	Password string
+	DB       int
+	PoolSize int           // Idle connections kept open
+	Timeout  time.Duration // Dial, read and write timeout of a command
+}
+
+// RedisCache shares entries between instances through a Redis server, speaking RESP over a
+// small pool of connections
+type RedisCache struct {
+	options RedisOptions
+	pool    chan *redisConn
+}
+
+// redisConn is a connection to the Redis server
+type redisConn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+// redisError is an error reply of the Redis server
+type redisError string
+
+func (e redisError) Error() string {
+	return "redis: " + string(e)
+}
+
+// NewRedisCache creates a cache connecting to a Redis server on first use
+func NewRedisCache(options RedisOptions) *RedisCache {
+	if options.PoolSize <= 0 {
+		options.PoolSize = 10
+	}
+	if options.Timeout <= 0 {
+		options.Timeout = time.Second
+	}
+
+	return &RedisCache{
+		options: options,
+		pool:    make(chan *redisConn, options.PoolSize),
+	}
+}
+
+// Get returns the value of a key, false when it is missing or expired
+func (c *RedisCache) Get(key string) ([]byte, bool, error) {
+	reply, err := c.do("GET", key)
+	if err != nil || reply == nil {
+		return nil, false, err
+	}
+
+	value, ok := reply.([]byte)
+	if !ok {
+		return nil, false, fmt.Errorf("redis: unexpected reply to GET: %v", reply)
+	}
+	return value, true, nil
+}
+
+// Set stores the value of a key for ttl, without expiry when ttl is zero
+func (c *RedisCache) Set(key string, value []byte, ttl time.Duration) error {
+	if ttl > 0 {
+		_, err := c.do("SET", key, string(value), "PX", strconv.FormatInt(ttl.Milliseconds(), 10))
+		return err
+	}
+
+	_, err := c.do("SET", key, string(value))
+	return err
+}
+
+// Delete removes keys
+func (c *RedisCache) Delete(keys ...string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+
+	_, err := c.do("DEL", keys...)
+	return err
+}
+
+// do sends a command on a pooled connection and reads its reply. Connections are closed after
+// network errors and returned to the pool otherwise.
+func (c *RedisCache) do(command string, args ...string) (interface{}, error) {
+	conn, err := c.get()
+	if err != nil {
+		return nil, err
+	}
+
+	reply, err := conn.do(c.options.Timeout, command, args...)
+	if _, ok := err.(redisError); err != nil && !ok {
+		conn.conn.Close()
+		return nil, err
+	}
+
+	c.put(conn)
+	return reply, err
+}
+
+// get takes an idle connection from the pool or opens a new one
+func (c *RedisCache) get() (*redisConn, error) {
+	select {
+	case conn := <-c.pool:
+		return conn, nil
+	default:
+	}
+
+	netConn, err := net.DialTimeout("tcp", c.options.Addr, c.options.Timeout)
+	if err != nil {
+		return nil, err
+	}
+	conn := &redisConn{conn: netConn, reader: bufio.NewReader(netConn)}
+
+	if c.options.Password != "" {
+		if _, err := conn.do(c.options.Timeout, "AUTH", c.options.Password); err != nil {
+			netConn.Close()
+			return nil, err
+		}
+	}
+	if c.options.DB != 0 {
+		if _, err := conn.do(c.options.Timeout, "SELECT", strconv.Itoa(c.options.DB)); err != nil {
+			netConn.Close()
+			return nil, err
+		}
+	}
+
+	return conn, nil
+}
+
+// put returns a connection to the pool, closing it when the pool is full
+func (c *RedisCache) put(conn *redisConn) {
+	select {
+	case c.pool <- conn:
+	default:
+		conn.conn.Close()
+	}
+}
+
+// do writes a command as an array of bulk strings and reads the reply
+func (c *redisConn) do(timeout time.Duration, command string, args ...string) (interface{}, error) {
+	c.conn.SetDeadline(time.Now().Add(timeout))
+
+	buf := []byte("*" + strconv.Itoa(len(args)+1) + "\r\n")
+	for _, arg := range append([]string{command}, args...) {
+		buf = append(buf, "$"+strconv.Itoa(len(arg))+"\r\n"...)
+		buf = append(buf, arg...)
+		buf = append(buf, "\r\n"...)
+	}
+	if _, err := c.conn.Write(buf); err != nil {
+		return nil, err
+	}
+
+	return c.readReply()
+}
+
+// readReply reads a RESP reply: nil for null replies, []byte for strings, int64 for integers
+// and []interface{} for arrays
+func (c *redisConn) readReply() (interface{}, error) {
+	line, err := c.reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, errors.New("redis: malformed reply")
+	}
+	line = line[:len(line)-2]
+
+	switch line[0] {
+	case '+':
+		return []byte(line[1:]), nil
+	case '-':
+		return nil, redisError(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		length, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, err
+		}
+		if length < 0 {
+			return nil, nil
+		}
+
+		value := make([]byte, length+2)
+		if _, err := io.ReadFull(c.reader, value); err != nil {
+			return nil, err
+		}
+		return value[:length], nil
+	case '*':
+		length, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, err
+		}
+		if length < 0 {
+			return nil, nil
+		}
+
+		values := make([]interface{}, length)
+		for i := range values {
+			if values[i], err = c.readReply(); err != nil {
+				return nil, err
+			}
+		}
+		return values, nil
+	}
+
+	return nil, errors.New("redis: unknown reply type " + line[:1])
+}
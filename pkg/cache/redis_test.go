@@ -0,0 +1,117 @@
+package cache
+
+import (
+	"bufio"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+func TestRedisReadReply(t *testing.T) {
+	tests := []struct {
+		name    string
+		reply   string
+		want    interface{}
+		wantErr string
+	}{
+		{"simple string", "+OK\r\n", []byte("OK"), ""},
+		{"bulk string", "$5\r\nhello\r\n", []byte("hello"), ""},
+		{"bulk string with CRLF", "$7\r\nhel\r\nlo\r\n", []byte("hel\r\nlo"), ""},
+		{"empty bulk string", "$0\r\n\r\n", []byte{}, ""},
+		{"nil bulk string", "$-1\r\n", nil, ""},
+		{"integer", ":42\r\n", int64(42), ""},
+		{"error", "-ERR wrong type\r\n", nil, "redis: ERR wrong type"},
+		{"array", "*3\r\n$1\r\na\r\n:1\r\n$-1\r\n", []interface{}{[]byte("a"), int64(1), nil}, ""},
+		{"nil array", "*-1\r\n", nil, ""},
+		{"nested array", "*1\r\n*1\r\n+x\r\n", []interface{}{[]interface{}{[]byte("x")}}, ""},
+		{"malformed", "+OK\n", nil, "redis: malformed reply"},
+		{"unknown type", "?x\r\n", nil, "redis: unknown reply type ?"},
+		{"truncated bulk string", "$5\r\nhel", nil, "unexpected EOF"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn := &redisConn{reader: bufio.NewReader(strings.NewReader(tt.reply))}
+			got, err := conn.readReply()
+
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("readReply() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("readReply() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("readReply() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRedisCacheRoundTrip(t *testing.T) {
+	server := miniredis.RunT(t)
+	server.RequireAuth("secret")
+	c := NewRedisCache(RedisOptions{Addr: server.Addr(), Password: "secret", DB: 2, PoolSize: 1})
+
+	if _, ok, err := c.Get("missing"); ok || err != nil {
+		t.Fatalf("Get(missing) = %v, %v, want a miss", ok, err)
+	}
+
+	if err := c.Set("a", []byte("1"), 0); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	if err := c.Set("b", []byte("2\r\n"), time.Minute); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	if value, ok, err := c.Get("b"); !ok || err != nil || string(value) != "2\r\n" {
+		t.Fatalf("Get(b) = %q, %v, %v", value, ok, err)
+	}
+
+	// Keys are written to the selected database with the TTL
+	server.Select(2)
+	if ttl := server.TTL("b"); ttl != time.Minute {
+		t.Errorf("TTL(b) = %v, want %v", ttl, time.Minute)
+	}
+	if ttl := server.TTL("a"); ttl != 0 {
+		t.Errorf("TTL(a) = %v, want no expiry", ttl)
+	}
+	server.FastForward(2 * time.Minute)
+	if _, ok, err := c.Get("b"); ok || err != nil {
+		t.Errorf("Get(b) after TTL = %v, %v, want a miss", ok, err)
+	}
+
+	if err := c.Delete("a", "b"); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if _, ok, err := c.Get("a"); ok || err != nil {
+		t.Errorf("Get(a) after Delete = %v, %v, want a miss", ok, err)
+	}
+}
+
+func TestRedisCacheErrors(t *testing.T) {
+	server := miniredis.RunT(t)
+	c := NewRedisCache(RedisOptions{Addr: server.Addr(), PoolSize: 1})
+
+	// Error replies keep the connection, which is reused for the next command
+	server.Lpush("list", "x")
+	if _, _, err := c.Get("list"); err == nil {
+		t.Fatal("Get() of a list succeeded")
+	}
+	if err := c.Set("a", []byte("1"), 0); err != nil {
+		t.Fatalf("Set() after an error reply error = %v", err)
+	}
+	if server.TotalConnectionCount() != 1 {
+		t.Errorf("opened %d connections, want 1", server.TotalConnectionCount())
+	}
+
+	// A stopped server is reported as an error, not as a miss
+	server.Close()
+	if _, _, err := c.Get("a"); err == nil {
+		t.Error("Get() on a stopped server succeeded")
+	}
+}
@@ -25,7 +25,7 @@ func InitDB() {
 	dbName := os.Getenv("DB_NAME")
 
 	// Create DSN (Data Source Name)
-	dsn := fmt.Sprintf("%s:%s@tcp(%s:%s)/%s?charset=utf8mb4&parseTime=True&loc=Local",
+	dsn := fmt.Sprintf("%s:%s@tcp(%s:%s)/%s?charset=utf8mb4&parseTime=True&loc=UTC",
 		dbUser, dbPassword, dbHost, dbPort, dbName)
 
 	// Configure GORM logger
@@ -0,0 +1,220 @@
+// Package export writes tabular data as CSV, NDJSON or Parquet.
+package export
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/xitongsys/parquet-go/writer"
+)
+
+// Export formats
+const (
+	FormatCSV     = "csv"
+	FormatNDJSON  = "ndjson"
+	FormatParquet = "parquet"
+)
+
+// Column types
+const (
+	TypeString = "string"
+	TypeInt    = "int"   // int64
+	TypeFloat  = "float" // float64
+	TypeBool   = "bool"
+	TypeTime   = "time" // time.Time
+)
+
+// Column describes a column of exported data
+type Column struct {
+	Name string
+	Type string
+}
+
+// Writer writes rows with one value per column. Values must match the column types, nil is written as null.
+type Writer interface {
+	Write(row []interface{}) error
+	// Close flushes the buffered rows. It does not close the underlying writer.
+	Close() error
+}
+
+// IsValidFormat checks if a format is supported
+func IsValidFormat(format string) bool {
+	return format == FormatCSV || format == FormatNDJSON || format == FormatParquet
+}
+
+// ContentType returns the MIME type of a format
+func ContentType(format string) string {
+	switch format {
+	case FormatCSV:
+		return "text/csv; charset=utf-8"
+	case FormatNDJSON:
+		return "application/x-ndjson"
+	default:
+		return "application/octet-stream"
+	}
+}
+
+// NewWriter creates a writer of a format
+func NewWriter(format string, w io.Writer, columns []Column) (Writer, error) {
+	switch format {
+	case FormatCSV:
+		return newCSVWriter(w, columns)
+	case FormatNDJSON:
+		return newNDJSONWriter(w, columns), nil
+	case FormatParquet:
+		return newParquetWriter(w, columns)
+	}
+	return nil, errors.New("unsupported export format: " + format)
+}
+
+// csvWriter writes a header row followed by one row per record
+type csvWriter struct {
+	w      *csv.Writer
+	record []string
+}
+
+func newCSVWriter(w io.Writer, columns []Column) (*csvWriter, error) {
+	header := make([]string, len(columns))
+	for i, column := range columns {
+		header[i] = column.Name
+	}
+
+	cw := &csvWriter{w: csv.NewWriter(w), record: make([]string, len(columns))}
+	if err := cw.w.Write(header); err != nil {
+		return nil, err
+	}
+	return cw, nil
+}
+
+func (c *csvWriter) Write(row []interface{}) error {
+	for i, value := range row {
+		c.record[i] = formatValue(value)
+	}
+	return c.w.Write(c.record)
+}
+
+func (c *csvWriter) Close() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+// ndjsonWriter writes one JSON object per line with the keys in column order
+type ndjsonWriter struct {
+	w       *bufio.Writer
+	columns []Column
+	keys    [][]byte
+}
+
+func newNDJSONWriter(w io.Writer, columns []Column) *ndjsonWriter {
+	keys := make([][]byte, len(columns))
+	for i, column := range columns {
+		key, _ := json.Marshal(column.Name)
+		keys[i] = append(key, ':')
+	}
+	return &ndjsonWriter{w: bufio.NewWriter(w), columns: columns, keys: keys}
+}
+
+func (n *ndjsonWriter) Write(row []interface{}) error {
+	n.w.WriteByte('{')
+	for i, value := range row {
+		if i > 0 {
+			n.w.WriteByte(',')
+		}
+		n.w.Write(n.keys[i])
+
+		if t, ok := value.(time.Time); ok {
+			value = t.Format(time.RFC3339)
+		}
+		data, err := json.Marshal(value)
+		if err != nil {
+			return err
+		}
+		n.w.Write(data)
+	}
+	n.w.WriteByte('}')
+	return n.w.WriteByte('\n')
+}
+
+func (n *ndjsonWriter) Close() error {
+	return n.w.Flush()
+}
+
+// parquetWriter writes all rows into a Parquet file with optional columns
+type parquetWriter struct {
+	w *writer.CSVWriter
+}
+
+func newParquetWriter(w io.Writer, columns []Column) (*parquetWriter, error) {
+	schema := make([]string, len(columns))
+	for i, column := range columns {
+		var parquetType string
+		switch column.Type {
+		case TypeInt:
+			parquetType = "type=INT64"
+		case TypeFloat:
+			parquetType = "type=DOUBLE"
+		case TypeBool:
+			parquetType = "type=BOOLEAN"
+		case TypeTime:
+			parquetType = "type=INT64, convertedtype=TIMESTAMP_MILLIS"
+		default:
+			parquetType = "type=BYTE_ARRAY, convertedtype=UTF8"
+		}
+		schema[i] = fmt.Sprintf("name=%s, %s, repetitiontype=OPTIONAL", column.Name, parquetType)
+	}
+
+	pw, err := writer.NewCSVWriterFromWriter(schema, w, 1)
+	if err != nil {
+		return nil, err
+	}
+	pw.RowGroupSize = 64 * 1024 * 1024
+
+	return &parquetWriter{w: pw}, nil
+}
+
+func (p *parquetWriter) Write(row []interface{}) error {
+	// Rows are buffered until the row group is flushed, so each needs its own slice
+	values := make([]interface{}, len(row))
+	for i, value := range row {
+		switch v := value.(type) {
+		case time.Time:
+			values[i] = v.UnixMilli()
+		case int:
+			values[i] = int64(v)
+		default:
+			values[i] = v
+		}
+	}
+	return p.w.Write(values)
+}
+
+func (p *parquetWriter) Close() error {
+	return p.w.WriteStop()
+}
+
+// formatValue formats a value for text formats
+func formatValue(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case int:
+		return strconv.Itoa(v)
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	case time.Time:
+		return v.Format(time.RFC3339)
+	}
+	return fmt.Sprint(value)
+}
@@ -0,0 +1,200 @@
+// Package hll counts distinct values with HyperLogLog sketches.
+//
+// A sketch has 2^14 registers and estimates cardinalities with a standard error of
+// 1.04/sqrt(2^14) ≈ 0.81%: about 68% of the estimates are within ±0.81% of the true count,
+// 95% within ±1.6% and 99.7% within ±2.4%. Small counts are close to exact. Sketches merge
+// without loss, so the distinct values of several sketches are counted as accurately as
+// those of one, and a value added to several of them is only counted once.
+//
+// Values are hashed with a fixed 64-bit hash, sketches may be stored and merged across
+// processes. The cardinality is computed with the estimator of Otmar Ertl, "New cardinality
+// estimation algorithms for HyperLogLog sketches" (2017), which needs no bias correction.
+package hll
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/fnv"
+	"math"
+	"math/bits"
+)
+
+const (
+	precision = 14
+	registers = 1 << precision
+	maxRank   = 64 - precision + 1
+)
+
+// Encodings of a marshaled sketch, after a byte with the precision
+const (
+	encodingDense  = 1 // One byte per register
+	encodingSparse = 2 // Index and value of the registers that are set, 3 bytes each
+)
+
+// Sketch estimates the number of distinct values added to it. The zero value is not usable,
+// create sketches with New.
+type Sketch struct {
+	registers []uint8
+}
+
+// New creates an empty sketch
+func New() *Sketch {
+	return &Sketch{registers: make([]uint8, registers)}
+}
+
+// Add adds a value
+func (s *Sketch) Add(value []byte) {
+	h := fnv.New64a()
+	h.Write(value)
+	s.addHash(mix(h.Sum64()))
+}
+
+// AddString adds a string value
+func (s *Sketch) AddString(value string) {
+	s.Add([]byte(value))
+}
+
+// addHash sets the register selected by the first bits of a hash to the rank of the first one
+// bit of the remaining bits, if it is higher
+func (s *Sketch) addHash(hash uint64) {
+	index := hash >> (64 - precision)
+	rank := uint8(bits.LeadingZeros64(hash<<precision|1<<(precision-1))) + 1
+	if rank > s.registers[index] {
+		s.registers[index] = rank
+	}
+}
+
+// Merge adds the values of another sketch
+func (s *Sketch) Merge(other *Sketch) {
+	for i, rank := range other.registers {
+		if rank > s.registers[i] {
+			s.registers[i] = rank
+		}
+	}
+}
+
+// Count estimates the number of distinct values added
+func (s *Sketch) Count() uint64 {
+	var histogram [maxRank + 1]int
+	for _, rank := range s.registers {
+		histogram[rank]++
+	}
+
+	m := float64(registers)
+	z := m * tau(1-float64(histogram[maxRank])/m)
+	for k := maxRank - 1; k >= 1; k-- {
+		z = 0.5 * (z + float64(histogram[k]))
+	}
+	z += m * sigma(float64(histogram[0])/m)
+
+	return uint64(math.Round(m * m / (2 * math.Ln2) / z))
+}
+
+// MarshalBinary encodes the sketch, sparsely when few registers are set
+func (s *Sketch) MarshalBinary() ([]byte, error) {
+	set := 0
+	for _, rank := range s.registers {
+		if rank != 0 {
+			set++
+		}
+	}
+
+	if 3*set >= registers {
+		return append([]byte{precision, encodingDense}, s.registers...), nil
+	}
+
+	data := make([]byte, 2, 2+3*set)
+	data[0], data[1] = precision, encodingSparse
+	for i, rank := range s.registers {
+		if rank != 0 {
+			data = binary.BigEndian.AppendUint16(data, uint16(i))
+			data = append(data, rank)
+		}
+	}
+	return data, nil
+}
+
+// UnmarshalBinary decodes a sketch encoded by MarshalBinary
+func (s *Sketch) UnmarshalBinary(data []byte) error {
+	if len(data) < 2 || data[0] != precision {
+		return errors.New("hll: unsupported sketch")
+	}
+
+	registers := make([]uint8, registers)
+	switch data[1] {
+	case encodingDense:
+		if len(data) != 2+len(registers) {
+			return errors.New("hll: malformed dense sketch")
+		}
+		copy(registers, data[2:])
+	case encodingSparse:
+		if (len(data)-2)%3 != 0 {
+			return errors.New("hll: malformed sparse sketch")
+		}
+		for i := 2; i < len(data); i += 3 {
+			index := binary.BigEndian.Uint16(data[i:])
+			if int(index) >= len(registers) {
+				return errors.New("hll: malformed sparse sketch")
+			}
+			registers[index] = data[i+2]
+		}
+	default:
+		return errors.New("hll: unknown sketch encoding")
+	}
+
+	for _, rank := range registers {
+		if rank > maxRank {
+			return errors.New("hll: malformed sketch")
+		}
+	}
+
+	s.registers = registers
+	return nil
+}
+
+// mix spreads the bits of a FNV hash, whose high bits depend poorly on the last bytes of
+// short values (the finalizer of MurmurHash3)
+func mix(h uint64) uint64 {
+	h ^= h >> 33
+	h *= 0xff51afd7ed558ccd
+	h ^= h >> 33
+	h *= 0xc4ceb9fe1a85ec53
+	h ^= h >> 33
+	return h
+}
+
+// sigma corrects the estimate for the registers that are still zero
+func sigma(x float64) float64 {
+	if x == 1 {
+		return math.Inf(1)
+	}
+
+	y, z := 1.0, x
+	for {
+		x *= x
+		previous := z
+		z += x * y
+		y += y
+		if z == previous {
+			return z
+		}
+	}
+}
+
+// tau corrects the estimate for the registers at the maximum rank
+func tau(x float64) float64 {
+	if x == 0 || x == 1 {
+		return 0
+	}
+
+	y, z := 1.0, 1-x
+	for {
+		x = math.Sqrt(x)
+		previous := z
+		y *= 0.5
+		z -= (1 - x) * (1 - x) * y
+		if z == previous {
+			return z / 3
+		}
+	}
+}
@@ -0,0 +1,328 @@
+// Package live streams collected hits and online visitor counts to dashboards.
+//
+// The collector publishes every hit to an in-process hub, which fans it out to the subscribers
+// of the website. Publishing never blocks: every subscriber has a bounded queue, hits that don't
+// fit are dropped and counted, and subscribers whose queue stays full for too long are closed.
+// The online count is the number of distinct visitors seen within a rolling window, tracked in
+// memory by a salted hash of their IP, so it only covers the hits collected by this process.
+package live
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Event types
+const (
+	EventHit    = "hit"
+	EventOnline = "online"
+)
+
+// Hit represents a collected page view without personal data
+type Hit struct {
+	Time         time.Time `json:"time"`
+	NewVisit     bool      `json:"new_visit"` // First hit of the visitor on the website that day
+	Path         string    `json:"path"`
+	Referer      string    `json:"referer"` // Scheme and host of the referer only
+	SearchEngine string    `json:"search_engine"`
+	Province     string    `json:"province"`
+	Browser      string    `json:"browser"`
+	OS           string    `json:"os"`
+	Device       string    `json:"device"`
+	ScreenSize   string    `json:"screen_size"`
+	Source       string    `json:"source"`
+}
+
+// Online represents the number of visitors seen within the online window
+type Online struct {
+	Online int `json:"online"`
+	Window int `json:"window"` // Seconds
+}
+
+// Event is a hit or an online count delivered to a subscriber
+type Event struct {
+	Type string
+	Data interface{}
+}
+
+// Options configures the queues of the subscribers and the online count
+type Options struct {
+	BufferSize        int           // Events queued per subscriber before hits are dropped
+	SlowClientTimeout time.Duration // Time a subscriber's queue may stay full before it is closed
+	MaxSubscribers    int           // Subscribers per website
+	OnlineWindow      time.Duration // Visitors seen within the window are online
+	OnlineInterval    time.Duration // Interval between online counts
+}
+
+// ErrTooManySubscribers is returned when a website already has the maximum number of subscribers
+var ErrTooManySubscribers = errors.New("too many live subscribers for this website")
+
+// Subscriber receives the events of a website
+type Subscriber struct {
+	websiteID int
+	events    chan Event
+	done      chan struct{}
+	dropped   int64
+	slowSince time.Time // Time the queue became full, zero while events are delivered
+}
+
+// Events returns the queued events
+func (s *Subscriber) Events() <-chan Event {
+	return s.events
+}
+
+// Done is closed when the subscriber was too slow to keep up and no more events are delivered
+func (s *Subscriber) Done() <-chan struct{} {
+	return s.done
+}
+
+// Dropped returns the number of hits dropped since the previous call
+func (s *Subscriber) Dropped() int64 {
+	return atomic.SwapInt64(&s.dropped, 0)
+}
+
+// Hub fans the hits of each website out to its subscribers and counts its online visitors
+type Hub struct {
+	mu          sync.Mutex
+	options     Options
+	salt        []byte
+	subscribers map[int]map[*Subscriber]bool
+	visitors    map[int]map[string]time.Time // Last time each visitor hash was seen per website
+}
+
+// NewHub creates a hub
+func NewHub(options Options) *Hub {
+	if options.BufferSize <= 0 {
+		options.BufferSize = 100
+	}
+	if options.SlowClientTimeout <= 0 {
+		options.SlowClientTimeout = 30 * time.Second
+	}
+	if options.MaxSubscribers <= 0 {
+		options.MaxSubscribers = 100
+	}
+	if options.OnlineWindow <= 0 {
+		options.OnlineWindow = 5 * time.Minute
+	}
+	if options.OnlineInterval <= 0 {
+		options.OnlineInterval = 5 * time.Second
+	}
+
+	salt := make([]byte, 16)
+	rand.Read(salt)
+
+	return &Hub{
+		options:     options,
+		salt:        salt,
+		subscribers: make(map[int]map[*Subscriber]bool),
+		visitors:    make(map[int]map[string]time.Time),
+	}
+}
+
+// Publish delivers a hit to the subscribers of its website and marks the visitor with the IP online
+func (h *Hub) Publish(websiteID int, ip string, hit Hit) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.see(websiteID, ip, hit.Time)
+	for subscriber := range h.subscribers[websiteID] {
+		h.deliver(subscriber, Event{Type: EventHit, Data: hit}, true)
+	}
+}
+
+// Touch marks the visitor with the IP online, e.g. when a page sends a ping
+func (h *Hub) Touch(websiteID int, ip string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.see(websiteID, ip, time.Now())
+}
+
+// Subscribe subscribes to the events of a website, starting with its online count
+func (h *Hub) Subscribe(websiteID int) (*Subscriber, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if len(h.subscribers[websiteID]) >= h.options.MaxSubscribers {
+		return nil, ErrTooManySubscribers
+	}
+
+	subscriber := &Subscriber{
+		websiteID: websiteID,
+		events:    make(chan Event, h.options.BufferSize),
+		done:      make(chan struct{}),
+	}
+	if h.subscribers[websiteID] == nil {
+		h.subscribers[websiteID] = make(map[*Subscriber]bool)
+	}
+	h.subscribers[websiteID][subscriber] = true
+
+	h.deliver(subscriber, h.onlineEvent(websiteID, time.Now()), false)
+	return subscriber, nil
+}
+
+// Unsubscribe stops delivering events to a subscriber
+func (h *Hub) Unsubscribe(subscriber *Subscriber) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.remove(subscriber)
+}
+
+// Run sends the online count to the subscribers of every website at the online interval and
+// forgets the visitors who left the online window. It doesn't return.
+func (h *Hub) Run() {
+	ticker := time.NewTicker(h.options.OnlineInterval)
+	defer ticker.Stop()
+
+	for now := range ticker.C {
+		h.mu.Lock()
+		h.prune(now)
+		for websiteID, subscribers := range h.subscribers {
+			event := h.onlineEvent(websiteID, now)
+			for subscriber := range subscribers {
+				h.deliver(subscriber, event, false)
+			}
+		}
+		h.mu.Unlock()
+	}
+}
+
+// deliver queues an event without blocking. When the queue is full, dropped hits are counted
+// and the subscriber is closed once its queue stayed full for longer than the slow client timeout.
+func (h *Hub) deliver(subscriber *Subscriber, event Event, countDropped bool) {
+	select {
+	case subscriber.events <- event:
+		subscriber.slowSince = time.Time{}
+		return
+	default:
+	}
+
+	if countDropped {
+		atomic.AddInt64(&subscriber.dropped, 1)
+	}
+
+	now := time.Now()
+	if subscriber.slowSince.IsZero() {
+		subscriber.slowSince = now
+	} else if now.Sub(subscriber.slowSince) > h.options.SlowClientTimeout {
+		h.remove(subscriber)
+	}
+}
+
+// remove removes a subscriber and closes its done channel, once
+func (h *Hub) remove(subscriber *Subscriber) {
+	subscribers := h.subscribers[subscriber.websiteID]
+	if !subscribers[subscriber] {
+		return
+	}
+
+	delete(subscribers, subscriber)
+	if len(subscribers) == 0 {
+		delete(h.subscribers, subscriber.websiteID)
+	}
+	close(subscriber.done)
+}
+
+// see records the time a visitor was seen
+func (h *Hub) see(websiteID int, ip string, t time.Time) {
+	if h.visitors[websiteID] == nil {
+		h.visitors[websiteID] = make(map[string]time.Time)
+	}
+
+	key := h.visitorKey(ip)
+	if t.After(h.visitors[websiteID][key]) {
+		h.visitors[websiteID][key] = t
+	}
+}
+
+// prune forgets the visitors not seen within the online window
+func (h *Hub) prune(now time.Time) {
+	since := now.Add(-h.options.OnlineWindow)
+	for websiteID, visitors := range h.visitors {
+		for key, seen := range visitors {
+			if seen.Before(since) {
+				delete(visitors, key)
+			}
+		}
+		if len(visitors) == 0 {
+			delete(h.visitors, websiteID)
+		}
+	}
+}
+
+// onlineEvent counts the visitors of a website seen within the online window
+func (h *Hub) onlineEvent(websiteID int, now time.Time) Event {
+	since := now.Add(-h.options.OnlineWindow)
+	online := 0
+	for _, seen := range h.visitors[websiteID] {
+		if !seen.Before(since) {
+			online++
+		}
+	}
+
+	return Event{Type: EventOnline, Data: Online{Online: online, Window: int(h.options.OnlineWindow / time.Second)}}
+}
+
+// visitorKey hashes an IP with the salt of the process, so that IPs aren't kept in memory
+func (h *Hub) visitorKey(ip string) string {
+	sum := sha256.Sum256(append(append([]byte{}, h.salt...), ip...))
+	return hex.EncodeToString(sum[:16])
+}
+
+var defaultHub = NewHub(Options{})
+
+// Start configures the hub from the LIVE_* environment variables and starts sending online counts
+func Start() {
+	defaultHub = NewHub(Options{
+		BufferSize:        getEnvInt("LIVE_BUFFER_SIZE", 100),
+		SlowClientTimeout: getEnvDuration("LIVE_SLOW_CLIENT_TIMEOUT", 30*time.Second),
+		MaxSubscribers:    getEnvInt("LIVE_MAX_SUBSCRIBERS", 100),
+		OnlineWindow:      getEnvDuration("LIVE_ONLINE_WINDOW", 5*time.Minute),
+		OnlineInterval:    getEnvDuration("LIVE_ONLINE_INTERVAL", 5*time.Second),
+	})
+	go defaultHub.Run()
+}
+
+// Publish delivers a hit to the subscribers of its website
+func Publish(websiteID int, ip string, hit Hit) {
+	defaultHub.Publish(websiteID, ip, hit)
+}
+
+// Touch marks a visitor of a website online
+func Touch(websiteID int, ip string) {
+	defaultHub.Touch(websiteID, ip)
+}
+
+// Subscribe subscribes to the events of a website
+func Subscribe(websiteID int) (*Subscriber, error) {
+	return defaultHub.Subscribe(websiteID)
+}
+
+// Unsubscribe stops delivering events to a subscriber
+func Unsubscribe(subscriber *Subscriber) {
+	defaultHub.Unsubscribe(subscriber)
+}
+
+func getEnvInt(key string, defaultValue int) int {
+	value, err := strconv.Atoi(os.Getenv(key))
+	if err != nil {
+		return defaultValue
+	}
+	return value
+}
+
+func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
+	value, err := time.ParseDuration(os.Getenv(key))
+	if err != nil {
+		return defaultValue
+	}
+	return value
+}
@@ -0,0 +1,250 @@
+// Package notify delivers notifications by webhook or email.
+//
+// Webhooks are JSON POST requests, signed like the requests of the webhook hit sink when a secret
+// is given. Emails are sent through the SMTP server given by the SMTP_HOST, SMTP_PORT, SMTP_USER,
+// SMTP_PASSWORD and SMTP_FROM environment variables, using STARTTLS when the server offers it.
+// Requests time out after NOTIFY_TIMEOUT (10s by default).
+//
+// Webhook URLs are given by users, so webhooks are only posted to public addresses and redirects
+// aren't followed, unless NOTIFY_ALLOW_PRIVATE is true for webhooks on the internal network.
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/mail"
+	"net/smtp"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrPrivateAddress is returned when a webhook host resolves to an address that isn't public
+var ErrPrivateAddress = errors.New("webhook host resolves to a private address")
+
+// ValidateWebhookURL checks that a webhook URL is an http or https URL
+func ValidateWebhookURL(endpoint string) error {
+	parsed, err := url.Parse(endpoint)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return errors.New("webhook URL must be an http or https URL")
+	}
+	return nil
+}
+
+// ParseAddresses parses a comma separated list of email addresses
+func ParseAddresses(list string) ([]string, error) {
+	parsed, err := mail.ParseAddressList(list)
+	if err != nil {
+		return nil, errors.New("invalid email address list")
+	}
+
+	addresses := make([]string, len(parsed))
+	for i, address := range parsed {
+		addresses[i] = address.Address
+	}
+	return addresses, nil
+}
+
+// PostWebhook posts a payload as JSON to an endpoint. With a secret, the request carries an
+// X-Aq3stat-Signature header with the hex HMAC-SHA256 of the body. Any response other than 2xx
+// is an error.
+func PostWebhook(endpoint, secret string, payload interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "aq3stat-webhook")
+
+	if secret != "" {
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write(body)
+		req.Header.Set("X-Aq3stat-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	resp, err := webhookClient().Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook responded with status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// webhookClient returns a client posting to public addresses only, without following redirects,
+// which could lead to private addresses
+func webhookClient() *http.Client {
+	dialer := &net.Dialer{Timeout: getTimeout()}
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			if allowPrivate() {
+				return dialer.DialContext(ctx, network, addr)
+			}
+
+			host, port, err := net.SplitHostPort(addr)
+			if err != nil {
+				return nil, err
+			}
+			addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+			if err != nil {
+				return nil, err
+			}
+
+			// Refuse the host if any of its addresses is private, and dial the checked addresses
+			// instead of resolving the host again
+			for _, ip := range addrs {
+				if !isPublicIP(ip.IP) {
+					return nil, ErrPrivateAddress
+				}
+			}
+			for _, ip := range addrs {
+				var conn net.Conn
+				if conn, err = dialer.DialContext(ctx, network, net.JoinHostPort(ip.String(), port)); err == nil {
+					return conn, nil
+				}
+			}
+			return nil, err
+		},
+		TLSHandshakeTimeout: getTimeout(),
+	}
+
+	return &http.Client{
+		Transport: transport,
+		Timeout:   getTimeout(),
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// isPublicIP reports whether an IP address is routable on the internet
+func isPublicIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified() {
+		return false
+	}
+
+	// Carrier-grade NAT addresses are shared by the hosts behind the NAT
+	if ip4 := ip.To4(); ip4 != nil && ip4[0] == 100 && ip4[1]&0xc0 == 64 {
+		return false
+	}
+	return true
+}
+
+// allowPrivate reports whether webhooks may be posted to private addresses
+func allowPrivate() bool {
+	allow, _ := strconv.ParseBool(os.Getenv("NOTIFY_ALLOW_PRIVATE"))
+	return allow
+}
+
+// SendEmail sends a plain text email
+func SendEmail(to []string, subject, body string) error {
+	host := os.Getenv("SMTP_HOST")
+	if host == "" {
+		return errors.New("SMTP is not configured")
+	}
+	port := os.Getenv("SMTP_PORT")
+	if port == "" {
+		port = "587"
+	}
+
+	from := os.Getenv("SMTP_FROM")
+	if from == "" {
+		from = os.Getenv("SMTP_USER")
+	}
+	sender, err := mail.ParseAddress(from)
+	if err != nil {
+		return errors.New("invalid SMTP_FROM address")
+	}
+
+	var message bytes.Buffer
+	message.WriteString("From: " + sender.String() + "\r\n")
+	message.WriteString("To: " + strings.Join(to, ", ") + "\r\n")
+	message.WriteString("Subject: " + mime.QEncoding.Encode("utf-8", subject) + "\r\n")
+	message.WriteString("Date: " + time.Now().Format(time.RFC1123Z) + "\r\n")
+	message.WriteString("MIME-Version: 1.0\r\n")
+	message.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	message.WriteString("Content-Transfer-Encoding: 8bit\r\n\r\n")
+	message.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+
+	return sendMail(net.JoinHostPort(host, port), host, sender.Address, to, message.Bytes())
+}
+
+// sendMail delivers a message like smtp.SendMail, with a timeout
+func sendMail(addr, host, from string, to []string, message []byte) error {
+	conn, err := net.DialTimeout("tcp", addr, getTimeout())
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(getTimeout()))
+
+	client, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		if err := client.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return err
+		}
+	}
+	if user := os.Getenv("SMTP_USER"); user != "" {
+		if err := client.Auth(smtp.PlainAuth("", user, os.Getenv("SMTP_PASSWORD"), host)); err != nil {
+			return err
+		}
+	}
+
+	if err := client.Mail(from); err != nil {
+		return err
+	}
+	for _, address := range to {
+		if err := client.Rcpt(address); err != nil {
+			return err
+		}
+	}
+
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(message); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+// getTimeout returns the timeout of a notification
+func getTimeout() time.Duration {
+	timeout, err := time.ParseDuration(os.Getenv("NOTIFY_TIMEOUT"))
+	if err != nil || timeout <= 0 {
+		return 10 * time.Second
+	}
+	return timeout
+}
@@ -0,0 +1,113 @@
+package sink
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// FileSink appends hits to local NDJSON files. A new file is started every day
+// and whenever the current file reaches the maximum size.
+type FileSink struct {
+	mu      sync.Mutex
+	dir     string
+	maxSize int64
+	file    *os.File
+	size    int64
+	day     string
+}
+
+// NewFileSink creates a file sink writing into dir
+func NewFileSink(dir string, maxSize int64) (*FileSink, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	if maxSize <= 0 {
+		return nil, errors.New("maximum file size must be positive")
+	}
+	return &FileSink{dir: dir, maxSize: maxSize}, nil
+}
+
+// Name returns the name of the sink
+func (f *FileSink) Name() string {
+	return "file"
+}
+
+// Write appends a batch of hits, one JSON object per line
+func (f *FileSink) Write(hits []Hit) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.rotate(); err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(f.file)
+	encoder := json.NewEncoder(w)
+	for _, hit := range hits {
+		if err := encoder.Encode(hit); err != nil {
+			return err
+		}
+	}
+
+	size := int64(w.Buffered())
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	f.size += size
+	return nil
+}
+
+// rotate opens a new file when the day has changed or the current file is full
+func (f *FileSink) rotate() error {
+	now := time.Now()
+	day := now.Format("20060102")
+	if f.file != nil && f.day == day && f.size < f.maxSize {
+		return nil
+	}
+
+	if f.file != nil {
+		f.file.Close()
+		f.file = nil
+	}
+
+	// Files are named by their creation time, a counter separates files of the same second
+	name := "hits-" + now.Format("20060102-150405")
+	path := filepath.Join(f.dir, name+".ndjson")
+	for i := 1; fileExists(path); i++ {
+		path = filepath.Join(f.dir, fmt.Sprintf("%s-%d.ndjson", name, i))
+	}
+
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+
+	f.file = file
+	f.size = 0
+	f.day = day
+	return nil
+}
+
+// Close closes the current file
+func (f *FileSink) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
@@ -0,0 +1,86 @@
+package sink
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// MessageWriter writes messages to Kafka. It is implemented by *kafka.Writer
+// and can be replaced by a mock in tests.
+type MessageWriter interface {
+	WriteMessages(ctx context.Context, messages ...kafka.Message) error
+	Close() error
+}
+
+// KafkaSink produces hits as JSON messages to a Kafka topic, keyed by website ID
+// so that the hits of a website stay in order within a partition
+type KafkaSink struct {
+	writer  MessageWriter
+	timeout time.Duration
+}
+
+// NewKafkaSink creates a Kafka sink producing to a topic on the given brokers
+func NewKafkaSink(brokers []string, topic string) (*KafkaSink, error) {
+	var addrs []string
+	for _, broker := range brokers {
+		if broker = strings.TrimSpace(broker); broker != "" {
+			addrs = append(addrs, broker)
+		}
+	}
+	if len(addrs) == 0 {
+		return nil, errors.New("no Kafka brokers configured")
+	}
+	if topic == "" {
+		return nil, errors.New("no Kafka topic configured")
+	}
+
+	return NewKafkaSinkWithWriter(&kafka.Writer{
+		Addr:         kafka.TCP(addrs...),
+		Topic:        topic,
+		Balancer:     &kafka.Hash{},
+		RequiredAcks: kafka.RequireAll,
+		MaxAttempts:  1, // Retries are handled by the dispatcher
+		BatchTimeout: 10 * time.Millisecond,
+	}), nil
+}
+
+// NewKafkaSinkWithWriter creates a Kafka sink on top of a message writer
+func NewKafkaSinkWithWriter(writer MessageWriter) *KafkaSink {
+	return &KafkaSink{writer: writer, timeout: 30 * time.Second}
+}
+
+// Name returns the name of the sink
+func (k *KafkaSink) Name() string {
+	return "kafka"
+}
+
+// Write produces a batch of hits
+func (k *KafkaSink) Write(hits []Hit) error {
+	messages := make([]kafka.Message, len(hits))
+	for i, hit := range hits {
+		value, err := json.Marshal(hit)
+		if err != nil {
+			return err
+		}
+		messages[i] = kafka.Message{
+			Key:   []byte(strconv.Itoa(hit.WebsiteID)),
+			Value: value,
+			Time:  hit.Time,
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), k.timeout)
+	defer cancel()
+	return k.writer.WriteMessages(ctx, messages...)
+}
+
+// Close flushes and closes the producer
+func (k *KafkaSink) Close() error {
+	return k.writer.Close()
+}
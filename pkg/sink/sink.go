@@ -0,0 +1,321 @@
+// Package sink publishes collected hits to external systems besides MySQL.
+//
+// Every configured sink gets its own queue, batching, retries and dead-letter file, so a slow
+// or failing sink never blocks collection or the other sinks. Sinks are configured with the
+// SINKS environment variable, a comma separated list of file, webhook and kafka.
+package sink
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Hit represents an enriched page view hit
+type Hit struct {
+	WebsiteID    int       `json:"website_id"`
+	StatID       int       `json:"stat_id"`
+	PageViewID   string    `json:"pvid"`
+	Time         time.Time `json:"time"`
+	NewVisit     bool      `json:"new_visit"` // First hit of the IP on the website that day
+	IP           string    `json:"ip"`
+	Location     string    `json:"location"`
+	Path         string    `json:"path"`
+	Referer      string    `json:"referer"`
+	BaseReferer  string    `json:"base_referer"`
+	SearchEngine string    `json:"search_engine"`
+	Keyword      string    `json:"keyword"`
+	Browser      string    `json:"browser"`
+	OS           string    `json:"os"`
+	OSLang       string    `json:"os_lang"`
+	ScreenSize   string    `json:"screen_size"`
+	ScreenColor  int       `json:"screen_color"`
+	Address      string    `json:"address"`
+	Province     string    `json:"province"`
+	ISP          string    `json:"isp"`
+	ReVisitTimes int       `json:"re_visit_times"`
+	Source       string    `json:"source"`
+	Campaign     string    `json:"campaign"`
+}
+
+// Sink delivers batches of hits to an external system
+type Sink interface {
+	// Name identifies the sink in logs and dead-letter files
+	Name() string
+	// Write delivers a batch of hits. A returned error makes the batch be retried.
+	Write(hits []Hit) error
+	// Close releases the resources of the sink
+	Close() error
+}
+
+// Options configures the buffering, retry and dead-letter handling of a sink
+type Options struct {
+	BufferSize    int           // Hits queued before new hits go to the dead-letter file
+	BatchSize     int           // Maximum hits per write
+	FlushInterval time.Duration // Maximum time a hit waits for its batch to fill up
+	MaxRetries    int           // Retries of a failed batch before it is dead-lettered
+	RetryBackoff  time.Duration // Delay before the first retry, doubled for every further retry
+	DeadLetterDir string        // Directory of the dead-letter files
+}
+
+// Dispatcher queues hits for a sink and writes them in batches from its own goroutine
+type Dispatcher struct {
+	sink       Sink
+	options    Options
+	queue      chan Hit
+	done       chan struct{}
+	deadLetter *deadLetter
+}
+
+// NewDispatcher creates a dispatcher for a sink and starts delivering
+func NewDispatcher(sink Sink, options Options) *Dispatcher {
+	if options.BufferSize <= 0 {
+		options.BufferSize = 10000
+	}
+	if options.BatchSize <= 0 {
+		options.BatchSize = 100
+	}
+	if options.FlushInterval <= 0 {
+		options.FlushInterval = time.Second
+	}
+	if options.RetryBackoff <= 0 {
+		options.RetryBackoff = time.Second
+	}
+
+	d := &Dispatcher{
+		sink:       sink,
+		options:    options,
+		queue:      make(chan Hit, options.BufferSize),
+		done:       make(chan struct{}),
+		deadLetter: newDeadLetter(filepath.Join(options.DeadLetterDir, sink.Name()+".ndjson")),
+	}
+	go d.run()
+	return d
+}
+
+// Publish queues a hit without blocking. Hits that don't fit into the queue are dead-lettered.
+func (d *Dispatcher) Publish(hit Hit) {
+	select {
+	case d.queue <- hit:
+	default:
+		d.deadLetter.write([]Hit{hit}, "queue full")
+	}
+}
+
+// Close delivers the queued hits and closes the sink
+func (d *Dispatcher) Close() error {
+	close(d.queue)
+	<-d.done
+	return d.sink.Close()
+}
+
+// run collects hits into batches and writes them
+func (d *Dispatcher) run() {
+	defer close(d.done)
+
+	ticker := time.NewTicker(d.options.FlushInterval)
+	defer ticker.Stop()
+
+	batch := make([]Hit, 0, d.options.BatchSize)
+	for {
+		select {
+		case hit, ok := <-d.queue:
+			if !ok {
+				d.flush(batch)
+				return
+			}
+			batch = append(batch, hit)
+			if len(batch) >= d.options.BatchSize {
+				d.flush(batch)
+				batch = make([]Hit, 0, d.options.BatchSize)
+			}
+		case <-ticker.C:
+			if len(batch) > 0 {
+				d.flush(batch)
+				batch = make([]Hit, 0, d.options.BatchSize)
+			}
+		}
+	}
+}
+
+// flush writes a batch, retrying with exponential backoff before dead-lettering it
+func (d *Dispatcher) flush(batch []Hit) {
+	if len(batch) == 0 {
+		return
+	}
+
+	backoff := d.options.RetryBackoff
+	var err error
+	for attempt := 0; attempt <= d.options.MaxRetries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+
+		if err = d.sink.Write(batch); err == nil {
+			return
+		}
+		log.Printf("Sink %s failed to write %d hits (attempt %d): %v", d.sink.Name(), len(batch), attempt+1, err)
+	}
+
+	d.deadLetter.write(batch, err.Error())
+}
+
+// deadLetter appends undeliverable hits to an NDJSON file
+type deadLetter struct {
+	mu   sync.Mutex
+	path string
+}
+
+// deadLetterRecord is a line of a dead-letter file
+type deadLetterRecord struct {
+	Time  time.Time `json:"time"`
+	Error string    `json:"error"`
+	Hit   Hit       `json:"hit"`
+}
+
+func newDeadLetter(path string) *deadLetter {
+	return &deadLetter{path: path}
+}
+
+func (d *deadLetter) write(hits []Hit, reason string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if err := os.MkdirAll(filepath.Dir(d.path), 0755); err != nil {
+		log.Printf("Failed to dead-letter %d hits: %v", len(hits), err)
+		return
+	}
+
+	file, err := os.OpenFile(d.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		log.Printf("Failed to dead-letter %d hits: %v", len(hits), err)
+		return
+	}
+	defer file.Close()
+
+	encoder := json.NewEncoder(file)
+	now := time.Now()
+	for _, hit := range hits {
+		encoder.Encode(deadLetterRecord{Time: now, Error: reason, Hit: hit})
+	}
+}
+
+var (
+	dispatchers []*Dispatcher
+	mu          sync.RWMutex
+)
+
+// InitSinks creates the sinks listed in the SINKS environment variable
+func InitSinks() {
+	mu.Lock()
+	defer mu.Unlock()
+
+	for _, name := range strings.Split(os.Getenv("SINKS"), ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+
+		var sink Sink
+		var err error
+		switch name {
+		case "file":
+			sink, err = NewFileSink(
+				getEnv("SINK_FILE_DIR", "./data/hits"),
+				int64(getEnvInt("SINK_FILE_MAX_SIZE", 100*1024*1024)),
+			)
+		case "webhook":
+			sink, err = NewWebhookSink(
+				os.Getenv("SINK_WEBHOOK_URL"),
+				os.Getenv("SINK_WEBHOOK_SECRET"),
+				getEnvDuration("SINK_WEBHOOK_TIMEOUT", 10*time.Second),
+			)
+		case "kafka":
+			sink, err = NewKafkaSink(
+				strings.Split(os.Getenv("SINK_KAFKA_BROKERS"), ","),
+				getEnv("SINK_KAFKA_TOPIC", "aq3stat-hits"),
+			)
+		default:
+			log.Printf("Unknown sink %q ignored", name)
+			continue
+		}
+		if err != nil {
+			log.Fatalf("Failed to create %s sink: %v", name, err)
+		}
+
+		dispatchers = append(dispatchers, NewDispatcher(sink, loadOptions(name)))
+		log.Printf("Publishing hits to %s sink", name)
+	}
+}
+
+// Publish queues a hit for all sinks. It does nothing when no sink is configured.
+func Publish(hit Hit) {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	for _, dispatcher := range dispatchers {
+		dispatcher.Publish(hit)
+	}
+}
+
+// CloseSinks delivers the queued hits and closes all sinks
+func CloseSinks() {
+	mu.Lock()
+	defer mu.Unlock()
+
+	for _, dispatcher := range dispatchers {
+		if err := dispatcher.Close(); err != nil {
+			log.Printf("Failed to close sink %s: %v", dispatcher.sink.Name(), err)
+		}
+	}
+	dispatchers = nil
+}
+
+// loadOptions reads the options of a sink. SINK_<NAME>_<OPTION> overrides SINK_<OPTION>.
+func loadOptions(name string) Options {
+	prefix := "SINK_" + strings.ToUpper(name) + "_"
+	option := func(key string) string {
+		if value := os.Getenv(prefix + key); value != "" {
+			return prefix + key
+		}
+		return "SINK_" + key
+	}
+
+	return Options{
+		BufferSize:    getEnvInt(option("BUFFER_SIZE"), 10000),
+		BatchSize:     getEnvInt(option("BATCH_SIZE"), 100),
+		FlushInterval: getEnvDuration(option("FLUSH_INTERVAL"), time.Second),
+		MaxRetries:    getEnvInt(option("MAX_RETRIES"), 5),
+		RetryBackoff:  getEnvDuration(option("RETRY_BACKOFF"), time.Second),
+		DeadLetterDir: getEnv(option("DEAD_LETTER_DIR"), "./data/dead-letter"),
+	}
+}
+
+func getEnv(key, defaultValue string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return defaultValue
+}
+
+func getEnvInt(key string, defaultValue int) int {
+	value, err := strconv.Atoi(os.Getenv(key))
+	if err != nil {
+		return defaultValue
+	}
+	return value
+}
+
+func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
+	value, err := time.ParseDuration(os.Getenv(key))
+	if err != nil {
+		return defaultValue
+	}
+	return value
+}
@@ -0,0 +1,314 @@
+package sink
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// fakeWriter records the messages written to it instead of producing them to Kafka
+type fakeWriter struct {
+	mu       sync.Mutex
+	messages []kafka.Message
+	failures int // Writes that fail before writes succeed
+	calls    int
+	closed   bool
+}
+
+func (w *fakeWriter) WriteMessages(ctx context.Context, messages ...kafka.Message) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.calls++
+	if w.failures > 0 {
+		w.failures--
+		return errors.New("broker unavailable")
+	}
+	w.messages = append(w.messages, messages...)
+	return nil
+}
+
+func (w *fakeWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.closed = true
+	return nil
+}
+
+// recordingSink records the batches written to it
+type recordingSink struct {
+	mu      sync.Mutex
+	batches [][]Hit
+	err     error
+	calls   int
+	closed  bool
+}
+
+func (s *recordingSink) Name() string {
+	return "recording"
+}
+
+func (s *recordingSink) Write(hits []Hit) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.calls++
+	if s.err != nil {
+		return s.err
+	}
+	s.batches = append(s.batches, append([]Hit(nil), hits...))
+	return nil
+}
+
+func (s *recordingSink) Close() error {
+	s.closed = true
+	return nil
+}
+
+func testOptions(t *testing.T) Options {
+	return Options{
+		BufferSize:    100,
+		BatchSize:     2,
+		FlushInterval: time.Hour, // Batches are only flushed when full or on close
+		MaxRetries:    2,
+		RetryBackoff:  time.Millisecond,
+		DeadLetterDir: t.TempDir(),
+	}
+}
+
+func TestKafkaSinkWrite(t *testing.T) {
+	writer := &fakeWriter{}
+	kafkaSink := NewKafkaSinkWithWriter(writer)
+
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	hits := []Hit{
+		{WebsiteID: 1, StatID: 10, Time: now, Path: "/a"},
+		{WebsiteID: 2, StatID: 20, Time: now.Add(time.Second), Path: "/b"},
+	}
+	if err := kafkaSink.Write(hits); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+
+	if len(writer.messages) != len(hits) {
+		t.Fatalf("got %d messages, want %d", len(writer.messages), len(hits))
+	}
+	for i, message := range writer.messages {
+		if want := []string{"1", "2"}[i]; string(message.Key) != want {
+			t.Errorf("message %d key = %q, want %q", i, message.Key, want)
+		}
+		if !message.Time.Equal(hits[i].Time) {
+			t.Errorf("message %d time = %v, want %v", i, message.Time, hits[i].Time)
+		}
+
+		var hit Hit
+		if err := json.Unmarshal(message.Value, &hit); err != nil {
+			t.Fatalf("message %d value is not a hit: %v", i, err)
+		}
+		if hit.StatID != hits[i].StatID || hit.Path != hits[i].Path {
+			t.Errorf("message %d value = %+v, want %+v", i, hit, hits[i])
+		}
+	}
+
+	if err := kafkaSink.Close(); err != nil || !writer.closed {
+		t.Errorf("Close() error = %v, writer closed = %v", err, writer.closed)
+	}
+}
+
+func TestNewKafkaSinkConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		brokers []string
+		topic   string
+		wantErr bool
+	}{
+		{"valid", []string{"localhost:9092", " localhost:9093 "}, "hits", false},
+		{"no brokers", []string{"", " "}, "hits", true},
+		{"no topic", []string{"localhost:9092"}, "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kafkaSink, err := NewKafkaSink(tt.brokers, tt.topic)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NewKafkaSink() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if kafkaSink != nil {
+				kafkaSink.Close()
+			}
+		})
+	}
+}
+
+func TestDispatcherBatches(t *testing.T) {
+	recorder := &recordingSink{}
+	dispatcher := NewDispatcher(recorder, testOptions(t))
+
+	for i := 1; i <= 5; i++ {
+		dispatcher.Publish(Hit{StatID: i})
+	}
+	if err := dispatcher.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	// Full batches are written as they fill up, the rest on close
+	wantSizes := []int{2, 2, 1}
+	if len(recorder.batches) != len(wantSizes) {
+		t.Fatalf("got %d batches, want %d", len(recorder.batches), len(wantSizes))
+	}
+	next := 1
+	for i, batch := range recorder.batches {
+		if len(batch) != wantSizes[i] {
+			t.Errorf("batch %d has %d hits, want %d", i, len(batch), wantSizes[i])
+		}
+		for _, hit := range batch {
+			if hit.StatID != next {
+				t.Errorf("hit %d delivered out of order", hit.StatID)
+			}
+			next++
+		}
+	}
+	if !recorder.closed {
+		t.Error("sink not closed")
+	}
+}
+
+func TestDispatcherFlushInterval(t *testing.T) {
+	recorder := &recordingSink{}
+	options := testOptions(t)
+	options.BatchSize = 100
+	options.FlushInterval = 10 * time.Millisecond
+	dispatcher := NewDispatcher(recorder, options)
+	defer dispatcher.Close()
+
+	dispatcher.Publish(Hit{StatID: 1})
+
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		recorder.mu.Lock()
+		delivered := len(recorder.batches)
+		recorder.mu.Unlock()
+		if delivered > 0 {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatal("partial batch not flushed after the flush interval")
+}
+
+func TestDispatcherRetries(t *testing.T) {
+	writer := &fakeWriter{failures: 2}
+	options := testOptions(t)
+	dispatcher := NewDispatcher(NewKafkaSinkWithWriter(writer), options)
+
+	dispatcher.Publish(Hit{WebsiteID: 1, StatID: 1})
+	if err := dispatcher.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	if writer.calls != 3 || len(writer.messages) != 1 {
+		t.Errorf("got %d writes and %d messages, want 3 writes and 1 message", writer.calls, len(writer.messages))
+	}
+	if _, err := os.Stat(filepath.Join(options.DeadLetterDir, "kafka.ndjson")); !os.IsNotExist(err) {
+		t.Error("delivered hit was dead-lettered")
+	}
+}
+
+func TestDispatcherDeadLetters(t *testing.T) {
+	recorder := &recordingSink{err: errors.New("sink down")}
+	options := testOptions(t)
+	dispatcher := NewDispatcher(recorder, options)
+
+	dispatcher.Publish(Hit{StatID: 1})
+	dispatcher.Publish(Hit{StatID: 2})
+	dispatcher.Publish(Hit{StatID: 3})
+	if err := dispatcher.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	// Both batches are tried once and retried MaxRetries times
+	if want := 2 * (options.MaxRetries + 1); recorder.calls != want {
+		t.Errorf("got %d writes, want %d", recorder.calls, want)
+	}
+
+	records := readDeadLetters(t, filepath.Join(options.DeadLetterDir, "recording.ndjson"))
+	if len(records) != 3 {
+		t.Fatalf("got %d dead letters, want 3", len(records))
+	}
+	for i, record := range records {
+		if record.Hit.StatID != i+1 || record.Error != "sink down" {
+			t.Errorf("dead letter %d = %+v", i, record)
+		}
+	}
+}
+
+func TestDispatcherQueueFull(t *testing.T) {
+	recorder := &recordingSink{}
+	options := testOptions(t)
+	options.BufferSize = 1
+	options.BatchSize = 1
+
+	// The dispatcher is blocked writing the first hit, so only one more hit fits into the queue
+	block := make(chan struct{})
+	blocking := &blockingSink{recordingSink: recorder, block: block, writing: make(chan struct{})}
+	dispatcher := NewDispatcher(blocking, options)
+
+	dispatcher.Publish(Hit{StatID: 1})
+	<-blocking.writing
+	dispatcher.Publish(Hit{StatID: 2}) // Queued
+	dispatcher.Publish(Hit{StatID: 3}) // Dead-lettered
+	close(block)
+	if err := dispatcher.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	records := readDeadLetters(t, filepath.Join(options.DeadLetterDir, "recording.ndjson"))
+	if len(records) != 1 || records[0].Hit.StatID != 3 || records[0].Error != "queue full" {
+		t.Errorf("dead letters = %+v, want hit 3 with queue full", records)
+	}
+}
+
+// blockingSink blocks its first write until block is closed
+type blockingSink struct {
+	*recordingSink
+	block   chan struct{}
+	writing chan struct{}
+	once    sync.Once
+}
+
+func (s *blockingSink) Write(hits []Hit) error {
+	s.once.Do(func() {
+		close(s.writing)
+		<-s.block
+	})
+	return s.recordingSink.Write(hits)
+}
+
+func readDeadLetters(t *testing.T, path string) []deadLetterRecord {
+	t.Helper()
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("failed to open dead-letter file: %v", err)
+	}
+	defer file.Close()
+
+	var records []deadLetterRecord
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var record deadLetterRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			t.Fatalf("invalid dead-letter line %q: %v", scanner.Text(), err)
+		}
+		records = append(records, record)
+	}
+	return records
+}
@@ -0,0 +1,86 @@
+package sink
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// WebhookSink posts batches of hits as JSON to an HTTP endpoint
+type WebhookSink struct {
+	url    string
+	secret string
+	client *http.Client
+}
+
+// webhookPayload is the body of a webhook request
+type webhookPayload struct {
+	Hits []Hit `json:"hits"`
+}
+
+// NewWebhookSink creates a webhook sink. With a secret, requests carry an
+// X-Aq3stat-Signature header with the hex HMAC-SHA256 of the body.
+func NewWebhookSink(endpoint, secret string, timeout time.Duration) (*WebhookSink, error) {
+	parsed, err := url.Parse(endpoint)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") {
+		return nil, errors.New("webhook URL must be an http or https URL")
+	}
+
+	return &WebhookSink{
+		url:    endpoint,
+		secret: secret,
+		client: &http.Client{Timeout: timeout},
+	}, nil
+}
+
+// Name returns the name of the sink
+func (w *WebhookSink) Name() string {
+	return "webhook"
+}
+
+// Write posts a batch of hits. Any response other than 2xx fails the batch.
+func (w *WebhookSink) Write(hits []Hit) error {
+	body, err := json.Marshal(webhookPayload{Hits: hits})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "aq3stat-webhook")
+
+	if w.secret != "" {
+		mac := hmac.New(sha256.New, []byte(w.secret))
+		mac.Write(body)
+		req.Header.Set("X-Aq3stat-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook responded with status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// Close releases idle connections
+func (w *WebhookSink) Close() error {
+	w.client.CloseIdleConnections()
+	return nil
+}
@@ -84,3 +84,87 @@ export function getSystemStats() {
     method: 'get'
   })
 }
+
+// 上传访问日志并导入
+export function importAccessLog(websiteId, data) {
+  return request({
+    url: `/admin/websites/${websiteId}/imports/access-log`,
+    method: 'post',
+    data
+  })
+}
+
+// 获取网站的导入任务
+export function getImportJobs(websiteId) {
+  return request({
+    url: `/admin/websites/${websiteId}/imports`,
+    method: 'get'
+  })
+}
+
+// 获取导入任务进度
+export function getImportJob(id) {
+  return request({
+    url: `/admin/imports/${id}`,
+    method: 'get'
+  })
+}
+
+// 上传其他统计工具导出的CSV并导入历史数据
+export function importHistoricalCSV(websiteId, data) {
+  return request({
+    url: `/admin/websites/${websiteId}/imports/csv`,
+    method: 'post',
+    data
+  })
+}
+
+// 获取原始数据保留策略
+export function getRetentionPolicies() {
+  return request({
+    url: '/admin/retention-policies',
+    method: 'get'
+  })
+}
+
+// 创建保留策略
+export function createRetentionPolicy(data) {
+  return request({
+    url: '/admin/retention-policies',
+    method: 'post',
+    data
+  })
+}
+
+// 更新保留策略
+export function updateRetentionPolicy(id, data) {
+  return request({
+    url: `/admin/retention-policies/${id}`,
+    method: 'put',
+    data
+  })
+}
+
+// 删除保留策略
+export function deleteRetentionPolicy(id) {
+  return request({
+    url: `/admin/retention-policies/${id}`,
+    method: 'delete'
+  })
+}
+
+// 预览各保留策略将删除的数据量
+export function previewRetentionPolicies() {
+  return request({
+    url: '/admin/retention-policies/preview',
+    method: 'get'
+  })
+}
+
+// 立即执行已启用的保留策略
+export function applyRetentionPolicies() {
+  return request({
+    url: '/admin/retention-policies/apply',
+    method: 'post'
+  })
+}
@@ -1,4 +1,5 @@
 import request from '@/utils/request'
+import store from '@/store'
 
 // 获取用户的网站列表
 export function getWebsites() {
@@ -51,6 +52,24 @@ export function getTrackingCode(id, iconType = '1') {
   })
 }
 
+// 获取AMP页面统计代码
+export function getAMPTrackingCode(id) {
+  return request({
+    url: `/websites/${id}/tracking-code`,
+    method: 'get',
+    params: { type: 'amp' }
+  })
+}
+
+// 获取邮件打开统计代码
+export function getEmailTrackingCode(id, campaign = '') {
+  return request({
+    url: `/websites/${id}/tracking-code`,
+    method: 'get',
+    params: { type: 'email', campaign }
+  })
+}
+
 // 获取网站统计数据
 export function getWebsiteStats(id) {
   return request({
@@ -59,6 +78,15 @@ export function getWebsiteStats(id) {
   })
 }
 
+// 获取网站每日访问趋势（含导入的历史数据）
+export function getWebsiteTrend(id, params) {
+  return request({
+    url: `/websites/${id}/trend`,
+    method: 'get',
+    params
+  })
+}
+
 // 获取网站访问来源统计
 export function getWebsiteRefererStats(id) {
   return request({
@@ -83,3 +111,338 @@ export function getPublicWebsites(page = 1, pageSize = 10) {
     params: { page, page_size: pageSize }
   })
 }
+
+// 获取网站内容阅读深度统计
+export function getWebsiteEngagementStats(id, params) {
+  return request({
+    url: `/websites/${id}/engagement-stats`,
+    method: 'get',
+    params
+  })
+}
+
+// 获取网站转化目标列表
+export function getGoals(id) {
+  return request({
+    url: `/websites/${id}/goals`,
+    method: 'get'
+  })
+}
+
+// 创建转化目标
+export function createGoal(id, data) {
+  return request({
+    url: `/websites/${id}/goals`,
+    method: 'post',
+    data
+  })
+}
+
+// 更新转化目标
+export function updateGoal(id, goalId, data) {
+  return request({
+    url: `/websites/${id}/goals/${goalId}`,
+    method: 'put',
+    data
+  })
+}
+
+// 删除转化目标
+export function deleteGoal(id, goalId) {
+  return request({
+    url: `/websites/${id}/goals/${goalId}`,
+    method: 'delete'
+  })
+}
+
+// 获取转化目标报表
+export function getGoalReport(id, params) {
+  return request({
+    url: `/websites/${id}/goals/report`,
+    method: 'get',
+    params
+  })
+}
+
+// 获取网站漏斗列表
+export function getFunnels(id) {
+  return request({
+    url: `/websites/${id}/funnels`,
+    method: 'get'
+  })
+}
+
+// 创建漏斗
+export function createFunnel(id, data) {
+  return request({
+    url: `/websites/${id}/funnels`,
+    method: 'post',
+    data
+  })
+}
+
+// 更新漏斗
+export function updateFunnel(id, funnelId, data) {
+  return request({
+    url: `/websites/${id}/funnels/${funnelId}`,
+    method: 'put',
+    data
+  })
+}
+
+// 删除漏斗
+export function deleteFunnel(id, funnelId) {
+  return request({
+    url: `/websites/${id}/funnels/${funnelId}`,
+    method: 'delete'
+  })
+}
+
+// 获取漏斗分析报表
+export function getFunnelReport(id, funnelId, params) {
+  return request({
+    url: `/websites/${id}/funnels/${funnelId}/report`,
+    method: 'get',
+    params
+  })
+}
+
+// 获取网站访客留存报表
+export function getWebsiteRetention(id, params) {
+  return request({
+    url: `/websites/${id}/retention`,
+    method: 'get',
+    params
+  })
+}
+
+// 获取网站电商销售统计
+export function getWebsiteEcommerceStats(id, params) {
+  return request({
+    url: `/websites/${id}/ecommerce-stats`,
+    method: 'get',
+    params
+  })
+}
+
+// 创建数据导出任务
+export function createExport(id, data) {
+  return request({
+    url: `/websites/${id}/exports`,
+    method: 'post',
+    data
+  })
+}
+
+// 获取数据导出任务列表
+export function getExports(id) {
+  return request({
+    url: `/websites/${id}/exports`,
+    method: 'get'
+  })
+}
+
+// 获取数据导出任务状态
+export function getExport(id, exportId) {
+  return request({
+    url: `/websites/${id}/exports/${exportId}`,
+    method: 'get'
+  })
+}
+
+// 获取网站指定时间范围的时间序列数据
+export function getWebsiteTimeSeries(id, params) {
+  return request({
+    url: `/websites/${id}/timeseries`,
+    method: 'get',
+    params
+  })
+}
+
+// 获取网站按维度（浏览器、操作系统、地区等）细分的统计数据
+export function getWebsiteBreakdown(id, params) {
+  return request({
+    url: `/websites/${id}/breakdown`,
+    method: 'get',
+    params
+  })
+}
+
+// 获取网站的访客细分列表
+export function getSegments(id) {
+  return request({
+    url: `/websites/${id}/segments`,
+    method: 'get'
+  })
+}
+
+// 创建访客细分
+export function createSegment(id, data) {
+  return request({
+    url: `/websites/${id}/segments`,
+    method: 'post',
+    data
+  })
+}
+
+// 更新访客细分
+export function updateSegment(id, segmentId, data) {
+  return request({
+    url: `/websites/${id}/segments/${segmentId}`,
+    method: 'put',
+    data
+  })
+}
+
+// 删除访客细分
+export function deleteSegment(id, segmentId) {
+  return request({
+    url: `/websites/${id}/segments/${segmentId}`,
+    method: 'delete'
+  })
+}
+
+// 获取网站受访页面、入口页面和退出页面排行
+export function getPageReport(id, params) {
+  return request({
+    url: `/websites/${id}/pages`,
+    method: 'get',
+    params
+  })
+}
+
+// 获取网站的页面路径规范化规则
+export function getPageRules(id) {
+  return request({
+    url: `/websites/${id}/page-rules`,
+    method: 'get'
+  })
+}
+
+// 创建页面路径规范化规则
+export function createPageRule(id, data) {
+  return request({
+    url: `/websites/${id}/page-rules`,
+    method: 'post',
+    data
+  })
+}
+
+// 更新页面路径规范化规则
+export function updatePageRule(id, ruleId, data) {
+  return request({
+    url: `/websites/${id}/page-rules/${ruleId}`,
+    method: 'put',
+    data
+  })
+}
+
+// 删除页面路径规范化规则
+export function deletePageRule(id, ruleId) {
+  return request({
+    url: `/websites/${id}/page-rules/${ruleId}`,
+    method: 'delete'
+  })
+}
+
+// 订阅网站实时访问流（Server-Sent Events），onEvent(type, data) 接收 hit、online、dropped 和 error 事件
+// EventSource 无法携带 Authorization 头，因此使用 fetch 读取事件流，返回的 AbortController 用于取消订阅
+export function subscribeWebsiteLive(id, onEvent) {
+  const controller = new AbortController()
+
+  fetch(`${process.env.VUE_APP_BASE_API}/websites/${id}/live`, {
+    headers: { Authorization: 'Bearer ' + store.getters.token },
+    signal: controller.signal
+  }).then(async response => {
+    if (!response.ok) {
+      const body = await response.json().catch(() => ({}))
+      onEvent('error', { error: body.error || response.statusText })
+      return
+    }
+
+    const reader = response.body.getReader()
+    const decoder = new TextDecoder()
+    let buffer = ''
+    for (;;) {
+      const { done, value } = await reader.read()
+      if (done) {
+        break
+      }
+
+      buffer += decoder.decode(value, { stream: true })
+      const messages = buffer.split('\n\n')
+      buffer = messages.pop()
+      messages.forEach(message => {
+        let type = 'message'
+        let data = ''
+        message.split('\n').forEach(line => {
+          if (line.startsWith('event:')) {
+            type = line.slice(6).trim()
+          } else if (line.startsWith('data:')) {
+            data += line.slice(5).trim()
+          }
+        })
+        if (data) {
+          onEvent(type, JSON.parse(data))
+        }
+      })
+    }
+  }).catch(error => {
+    if (error.name !== 'AbortError') {
+      onEvent('error', { error: error.message })
+    }
+  })
+
+  return controller
+}
+
+// 获取网站的流量异常
+export function getWebsiteAnomalies(id, params) {
+  return request({
+    url: `/websites/${id}/anomalies`,
+    method: 'get',
+    params
+  })
+}
+
+// 获取网站的异常通知渠道
+export function getAnomalyChannels(id) {
+  return request({
+    url: `/websites/${id}/anomaly-channels`,
+    method: 'get'
+  })
+}
+
+// 创建异常通知渠道
+export function createAnomalyChannel(id, data) {
+  return request({
+    url: `/websites/${id}/anomaly-channels`,
+    method: 'post',
+    data
+  })
+}
+
+// 更新异常通知渠道
+export function updateAnomalyChannel(id, channelId, data) {
+  return request({
+    url: `/websites/${id}/anomaly-channels/${channelId}`,
+    method: 'put',
+    data
+  })
+}
+
+// 删除异常通知渠道
+export function deleteAnomalyChannel(id, channelId) {
+  return request({
+    url: `/websites/${id}/anomaly-channels/${channelId}`,
+    method: 'delete'
+  })
+}
+
+// 发送测试通知
+export function testAnomalyChannel(id, channelId) {
+  return request({
+    url: `/websites/${id}/anomaly-channels/${channelId}/test`,
+    method: 'post'
+  })
+}